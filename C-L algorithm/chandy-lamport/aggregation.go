@@ -0,0 +1,220 @@
+package chandy_lamport
+
+import (
+	"fmt"
+)
+
+// How the local states recorded by the servers reach the simulator
+type CollectionMode int
+
+const (
+	// Every server hands its local state over to the simulator as soon as it
+	// completes the snapshot
+	DirectCollection CollectionMode = iota
+	// The local states are aggregated up a spanning tree rooted at the
+	// initiator: every server sends its own state, along with the states
+	// received from its children, to its parent once it has them all, and the
+	// initiator hands them over to the simulator. A snapshot is not collected
+	// until the states reach the root, which takes messages and time steps of
+	// their own.
+	TreeAggregation
+)
+
+func (m CollectionMode) String() string {
+	switch m {
+	case DirectCollection:
+		return "direct"
+	case TreeAggregation:
+		return "tree"
+	}
+	return "unknown mode"
+}
+
+// Select how the local states of the snapshots started from now on are
+// collected. With `TreeAggregation`, the tree of a snapshot is built when it
+// starts from the shortest paths to the initiator, or to the lowest ID for a
+// census; servers that cannot reach it, or that join later, report their
+// state directly. A server that crashes before forwarding the states it holds
+// drops out of the tree: the simulator detects the crash, collects what it
+// held and its children report their states directly. States in flight to it
+// are collected once it recovers, and states lost on lossy links are never
+// collected.
+func (sim *Simulator) SetCollectionMode(mode CollectionMode) {
+	sim.collectionMode = mode
+}
+
+// A message carrying local states up the aggregation tree of a snapshot. It is
+// not recorded by snapshots.
+type AggregateMessage struct {
+	snapshotId int
+	states     []*SnapshotState
+}
+
+func (m AggregateMessage) String() string {
+	return fmt.Sprintf("aggregate(%v, %v states)", m.snapshotId, len(m.states))
+}
+
+// The aggregation tree of a snapshot, while its states are collected
+type aggregationTree struct {
+	root     string
+	parent   map[string]string   // server -> parent, for the servers other than the root
+	children map[string][]string // server -> sorted children
+	// server -> states held, its own and those received from its children
+	held map[string][]*SnapshotState
+	// server -> if its own state is held
+	recorded map[string]bool
+	// server -> number of children whose states arrived, or dropped out
+	received map[string]int
+	// server -> if it forwarded the states it held
+	sent map[string]bool
+	// server -> if it dropped out of the tree
+	failed map[string]bool
+}
+
+// Build the aggregation tree of a snapshot over the given servers that have not
+// crashed, by a breadth-first search from the root along the inbound links
+func (sim *Simulator) newAggregationTree(snapshotId int, serverIds []string) {
+	participants := make(map[string]bool)
+	for _, serverId := range serverIds {
+		if !sim.servers[serverId].crashed {
+			participants[serverId] = true
+		}
+	}
+	root := sim.initiators[snapshotId]
+	if root == "" {
+		sorted := getSortedKeys(participants)
+		if len(sorted) == 0 {
+			return
+		}
+		root = sorted[0]
+	}
+	if !participants[root] {
+		return
+	}
+	tree := &aggregationTree{
+		root:     root,
+		parent:   make(map[string]string),
+		children: make(map[string][]string),
+		held:     make(map[string][]*SnapshotState),
+		recorded: make(map[string]bool),
+		received: make(map[string]int),
+		sent:     make(map[string]bool),
+		failed:   make(map[string]bool),
+	}
+	queue := []string{root}
+	for len(queue) > 0 {
+		serverId := queue[0]
+		queue = queue[1:]
+		for _, src := range getSortedKeys(sim.servers[serverId].inboundLinks) {
+			if _, ok := tree.parent[src]; ok || src == root || !participants[src] {
+				continue
+			}
+			tree.parent[src] = serverId
+			tree.children[serverId] = append(tree.children[serverId], src)
+			queue = append(queue, src)
+		}
+	}
+	sim.trees[snapshotId] = tree
+}
+
+// Return true if the server aggregates its state up the tree
+func (tree *aggregationTree) contains(serverId string) bool {
+	_, ok := tree.parent[serverId]
+	return ok || serverId == tree.root
+}
+
+// Hand the local state of a server over to the aggregation tree of the
+// snapshot, and return false if the server is not in the tree
+func (sim *Simulator) aggregateSnapshot(serverId string, snapshotId int, snap *SnapshotState) bool {
+	tree, ok := sim.trees[snapshotId]
+	if !ok || !tree.contains(serverId) || tree.failed[serverId] || sim.servers[serverId].crashed {
+		return false
+	}
+	tree.held[serverId] = append(tree.held[serverId], snap)
+	tree.recorded[serverId] = true
+	sim.forwardAggregate(snapshotId, serverId)
+	return true
+}
+
+// Keep the states received from a child in the aggregation tree
+func (server *Server) handleAggregate(message AggregateMessage) {
+	sim := server.sim
+	tree, ok := sim.trees[message.snapshotId]
+	if !ok {
+		return
+	}
+	tree.held[server.Id] = append(tree.held[server.Id], message.states...)
+	tree.received[server.Id]++
+	sim.forwardAggregate(message.snapshotId, server.Id)
+}
+
+// Send the states held by a server to its parent once it holds its own and
+// those of all its children. The root, and servers whose parent dropped out
+// of the tree, hand them over to the simulator instead.
+func (sim *Simulator) forwardAggregate(snapshotId int, serverId string) {
+	tree, ok := sim.trees[snapshotId]
+	if !ok || tree.sent[serverId] {
+		return
+	}
+	held := tree.held[serverId]
+	if tree.failed[serverId] {
+		// States that reached a server after it dropped out of the tree
+		delete(tree.held, serverId)
+		sim.reportHeld(snapshotId, held)
+		return
+	}
+	if !tree.recorded[serverId] || tree.received[serverId] < len(tree.children[serverId]) {
+		return
+	}
+	delete(tree.held, serverId)
+	tree.sent[serverId] = true
+	parent := tree.parent[serverId]
+	server := sim.servers[serverId]
+	link, ok := server.outboundLinks[parent]
+	// The link to the parent may have been removed since the tree was built
+	if serverId == tree.root || tree.failed[parent] || !ok {
+		sim.reportHeld(snapshotId, held)
+		return
+	}
+	message := AggregateMessage{snapshotId, held}
+	sim.logger.RecordEvent(server, SentMessageEvent{serverId, parent, message})
+	sim.enqueue(link, SendMessageEvent{
+		serverId,
+		parent,
+		message,
+		sim.receiveTimeOn(link),
+		sim.time,
+		server.lamport})
+}
+
+// Hand states over to the collector of the snapshot, and release the tree once
+// every participant has reported
+func (sim *Simulator) reportHeld(snapshotId int, held []*SnapshotState) {
+	c, ok := sim.collectors[snapshotId]
+	if !ok {
+		return
+	}
+	for _, snap := range held {
+		sim.collect(snapshotId, c, snap)
+	}
+	if c.complete() {
+		delete(sim.trees, snapshotId)
+	}
+}
+
+// Take a crashed server out of the aggregation tree of the snapshot, if it has
+// not forwarded the states it holds yet
+func (sim *Simulator) failAggregation(serverId string, snapshotId int) {
+	tree, ok := sim.trees[snapshotId]
+	if !ok || !tree.contains(serverId) || tree.sent[serverId] || tree.failed[serverId] {
+		return
+	}
+	tree.failed[serverId] = true
+	held := tree.held[serverId]
+	delete(tree.held, serverId)
+	sim.reportHeld(snapshotId, held)
+	if parent, ok := tree.parent[serverId]; ok && !tree.failed[parent] {
+		tree.received[parent]++
+		sim.forwardAggregate(snapshotId, parent)
+	}
+}
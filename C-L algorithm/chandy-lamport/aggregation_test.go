@@ -0,0 +1,105 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Number of aggregate messages sent for a snapshot
+func countAggregates(sim *Simulator) int {
+	count := 0
+	for _, line := range sim.EventLog() {
+		if strings.Contains(line, "sent") && strings.Contains(line, "aggregate(") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestTreeAggregation(t *testing.T) {
+	for _, algorithm := range []SnapshotAlgorithm{ChandyLamport, LaiYang, Mattern} {
+		rand.Seed(8053172852482175524)
+		sim := NewSimulator()
+		sim.SetSnapshotAlgorithm(algorithm)
+		sim.SetLatencyModel(NewConstantLatency(1))
+		sim.SetCollectionMode(TreeAggregation)
+		readTopology("8nodes.top", sim)
+		sim.InjectEvent(PassTokenEvent{"N1", "N5", 3})
+		sim.StartSnapshot("N1")
+		expected := map[string]string{
+			"N2": "N1", "N3": "N2", "N4": "N1", "N5": "N4", "N6": "N5", "N7": "N6", "N8": "N5",
+		}
+		if parent := sim.trees[0].parent; !reflect.DeepEqual(parent, expected) {
+			t.Fatalf("%v: expected the tree %v, got %v\n", algorithm, expected, parent)
+		}
+		for sim.finishedMap[0] < sim.numParticipants(0) {
+			sim.Tick()
+		}
+		if _, ok := sim.TryCollectSnapshot(0); ok {
+			t.Fatalf("%v: expected the snapshot not to be collected before the states reach N1\n", algorithm)
+		}
+		sim.Drain()
+		snap, ok := sim.TryCollectSnapshot(0)
+		if !ok {
+			t.Fatalf("%v: expected the snapshot to be collected once drained\n", algorithm)
+		}
+		if len(snap.tokens) != 8 {
+			t.Fatalf("%v: expected the tokens of every server, got %v\n", algorithm, snap.tokens)
+		}
+		if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+			t.Fatalf("%v: %v\n", algorithm, err)
+		}
+		if count := countAggregates(sim); count != 7 {
+			t.Fatalf("%v: expected an aggregate message per server but the root, got %v\n", algorithm, count)
+		}
+		if len(sim.trees) != 0 {
+			t.Fatalf("%v: expected the tree to be released once collected\n", algorithm)
+		}
+	}
+}
+
+func TestTreeAggregationCensus(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetCollectionMode(TreeAggregation)
+	readTopology("3nodes.top", sim)
+	snap, err := sim.Census(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"N1": 10, "N2": 3, "N3": 0}
+	if !reflect.DeepEqual(snap.tokens, expected) {
+		t.Fatalf("Expected the census to record %v, got %v\n", expected, snap.tokens)
+	}
+	if count := countAggregates(sim); count != 2 {
+		t.Fatalf("Expected N2 and N3 to send their states to N1, got %v messages\n", count)
+	}
+}
+
+func TestTreeAggregationCrash(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetCollectionMode(TreeAggregation)
+	readTopology("8nodes.top", sim)
+	sim.StartSnapshot("N1")
+	// N5 drops out of the tree, and N6 and N8 report their states directly
+	sim.CrashServer("N5")
+	sim.servers["N4"].SetSnapshotDeadline(0, 30)
+	sim.servers["N6"].SetSnapshotDeadline(0, 30)
+	sim.servers["N8"].SetSnapshotDeadline(0, 30)
+	for i := 0; i < 40; i++ {
+		sim.Tick()
+	}
+	snap, ok := sim.TryCollectSnapshot(0)
+	if !ok {
+		t.Fatalf("Expected the snapshot to be collected without N5\n")
+	}
+	if crashed := snap.CrashedServers(); !reflect.DeepEqual(crashed, []string{"N5"}) {
+		t.Fatalf("Expected N5 to be excluded from the snapshot, got %v\n", crashed)
+	}
+	if len(snap.tokens) != 7 {
+		t.Fatalf("Expected the tokens of the other 7 servers, got %v\n", snap.tokens)
+	}
+}
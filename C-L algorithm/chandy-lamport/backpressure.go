@@ -0,0 +1,86 @@
+package chandy_lamport
+
+// What a link with a bounded capacity does with a message sent while it is full
+type BackpressurePolicy int
+
+const (
+	// Hold the message at the sender until a message is delivered on the
+	// link. Held messages enter the link in the order they were sent.
+	BlockSender BackpressurePolicy = iota
+	// Drop the message being sent
+	DropNewest
+	// Drop the oldest message on the link to make room for the one being sent
+	DropOldest
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BlockSender:
+		return "block sender"
+	case DropNewest:
+		return "drop newest"
+	case DropOldest:
+		return "drop oldest"
+	}
+	return "unknown policy"
+}
+
+// Bound the number of messages queued on this link, e.g. to simulate a
+// congested channel. A capacity of 0 makes the link unbounded again, and the
+// messages held at the sender enter it on the next tick. Markers are subject
+// to the policy like any other message, so dropping them can keep snapshots
+// from completing.
+func (link *Link) SetCapacity(capacity int, policy BackpressurePolicy) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	link.capacity = capacity
+	link.backpressure = policy
+}
+
+// Return true if the link cannot take another message
+func (link *Link) full() bool {
+	return link.capacity > 0 && link.events.Len() >= link.capacity
+}
+
+// Queue a message sent on the link, applying the backpressure policy of the
+// link if it is full
+func (sim *Simulator) enqueue(link *Link, e SendMessageEvent) {
+	sim.notifyMarkers(MarkerSent, e.message, e.src, e.dest)
+	// Keep FIFO order behind the messages already held at the sender
+	if !link.backlog.Empty() {
+		link.backlog.Push(e)
+		return
+	}
+	if !link.full() {
+		link.events.Push(sim.transmit(link, e))
+		return
+	}
+	switch link.backpressure {
+	case BlockSender:
+		link.backlog.Push(e)
+	case DropNewest:
+		sim.dropAtSender(e)
+	case DropOldest:
+		sim.dropAtSender(link.events.Pop())
+		link.events.Push(sim.transmit(link, e))
+	}
+}
+
+// Let the messages held at the sender enter the link as room frees up. They
+// are delivered after the delay of the link, counting from when they enter it.
+func (sim *Simulator) admitBacklog(link *Link) {
+	for !link.backlog.Empty() && !link.full() {
+		e := link.backlog.Pop()
+		e.receiveTime = sim.receiveTimeOn(link)
+		e.sendTime = sim.time
+		link.events.Push(sim.transmit(link, e))
+	}
+}
+
+func (sim *Simulator) dropAtSender(e SendMessageEvent) {
+	sim.logger.RecordEvent(sim.servers[e.src], DroppedMessageEvent{e.src, e.dest, e.message})
+	if message, isToken := tokenMessage(e.message); isToken {
+		sim.lostTokens += message.numTokens
+	}
+}
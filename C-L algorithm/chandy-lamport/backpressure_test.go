@@ -0,0 +1,89 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Bound every link of the simulation to the given capacity
+func setCapacity(sim *Simulator, capacity int, policy BackpressurePolicy) {
+	for _, src := range getSortedKeys(sim.servers) {
+		for _, dest := range getSortedKeys(sim.servers[src].outboundLinks) {
+			mustGetLink(sim, src, dest).SetCapacity(capacity, policy)
+		}
+	}
+}
+
+func TestBlockSender(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	setCapacity(sim, 1, BlockSender)
+	for i := 1; i <= 4; i++ {
+		sim.InjectEvent(PassTokenEvent{"N1", "N2", i})
+	}
+	link := mustGetLink(sim, "N1", "N2")
+	if link.events.Len() != 1 || link.backlog.Len() != 3 {
+		t.Fatalf("Expected 1 message on the link and 3 held back, got %v and %v\n",
+			link.events.Len(), link.backlog.Len())
+	}
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	// Tokens held back at the sender were sent, so they are recorded on the channel
+	snap := sim.CollectSnapshot(0)
+	recorded := 0
+	for _, msg := range snap.messages {
+		recorded += msg.message.(TokenMessage).numTokens
+	}
+	if snap.tokens["N1"] != 0 || snap.tokens["N2"]+recorded != 13 {
+		t.Fatalf("Expected the tokens of N1 to be on N2 or its inbound channel, got %v\n", snap)
+	}
+	if sim.servers["N2"].Tokens != 13 || sim.LostTokens() != 0 {
+		t.Fatalf("Expected every token to reach N2, got %v on N2 and %v lost\n",
+			sim.servers["N2"].Tokens, sim.LostTokens())
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy   BackpressurePolicy
+		received int
+	}{
+		{DropNewest, 1},
+		{DropOldest, 3},
+	} {
+		rand.Seed(8053172852482175524)
+		sim := NewSimulator()
+		readTopology("3nodes.top", sim)
+		mustGetLink(sim, "N1", "N2").SetCapacity(1, test.policy)
+		for i := 1; i <= 3; i++ {
+			sim.InjectEvent(PassTokenEvent{"N1", "N2", i})
+		}
+		sim.Drain()
+		if received := sim.servers["N2"].Tokens - 3; received != test.received {
+			t.Fatalf("Policy %v: expected N2 to receive %v token(s), got %v\n",
+				test.policy, test.received, received)
+		}
+		if lost := sim.LostTokens(); lost != 6-test.received {
+			t.Fatalf("Policy %v: expected %v lost token(s), got %v\n", test.policy, 6-test.received, lost)
+		}
+	}
+}
+
+func TestDroppedMarker(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	mustGetLink(sim, "N1", "N2").SetCapacity(1, DropNewest)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	if !sim.servers["N3"].completedSnapshot[0] {
+		t.Fatalf("Expected N3 to complete the snapshot\n")
+	}
+	// N2 still records its state on the marker from N3, but waits forever for
+	// the one from N1
+	if !sim.servers["N2"].receivedSnapshot[0] || sim.servers["N2"].completedSnapshot[0] {
+		t.Fatalf("Expected N2 to be stuck recording the snapshot\n")
+	}
+}
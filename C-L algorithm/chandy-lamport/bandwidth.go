@@ -0,0 +1,80 @@
+package chandy_lamport
+
+// A message that tells its own size, see `MessageSize`. Application messages
+// implement it to take more of the bandwidth of a link than other messages.
+type Sized interface {
+	Size() int
+}
+
+// Return the size of a message, in the units of the bandwidth of links, see
+// `Link.SetBandwidth`. Messages of the application have the size they tell
+// if they implement `Sized`, and tokens, markers and the other control
+// messages have size 1. Messages carrying other messages, e.g. piggybacked
+// markers or Lai-Yang colors, add 1 to the size of the message they carry,
+// and messages carrying recorded states add 1 per server and message recorded.
+func MessageSize(message interface{}) int {
+	switch m := message.(type) {
+	case Sized:
+		if size := m.Size(); size > 1 {
+			return size
+		}
+		return 1
+	case ColoredMessage:
+		return 1 + MessageSize(m.message)
+	case TimestampedMessage:
+		return 1 + MessageSize(m.message)
+	case PiggybackedMessage:
+		return len(m.snapshotIds) + MessageSize(m.message)
+	case duplicateCopy:
+		return MessageSize(m.message)
+	case AggregateMessage:
+		size := 1
+		for _, snap := range m.states {
+			size += recordedSize(snap)
+		}
+		return size
+	case RecordedStateMessage:
+		return 1 + recordedSize(m.state)
+	}
+	return 1
+}
+
+// Number of servers and messages recorded by a state
+func recordedSize(snap *SnapshotState) int {
+	return len(snap.tokens) + len(snap.messages) + len(snap.aggregated)
+}
+
+// Limit the size of the messages this link transmits per time step, e.g. to
+// study how large application messages delay the markers behind them. A
+// message is received once it has been transmitted, after the messages sent
+// on the link before it, plus the delay of the link, which then stands for the
+// propagation delay. A message that fits in the bandwidth left on an idle link
+// is received after the delay alone, as on a link without a limit. A
+// bandwidth of 0 removes the limit.
+func (link *Link) SetBandwidth(unitsPerTick int) {
+	if unitsPerTick < 0 {
+		unitsPerTick = 0
+	}
+	link.bandwidth = unitsPerTick
+}
+
+// Delay the receive time of a message entering the link until it has been
+// transmitted. The link counts the units it has to transmit from the start of
+// the simulation, so the messages entering it in the same time step share its
+// bandwidth.
+func (sim *Simulator) transmit(link *Link, e SendMessageEvent) SendMessageEvent {
+	if link.bandwidth == 0 {
+		return e
+	}
+	delay := e.receiveTime - sim.time
+	start := sim.time * link.bandwidth
+	if link.transmitted > start {
+		start = link.transmitted
+	}
+	end := start + MessageSize(e.message)
+	link.transmitted = end
+	// The last time step in which the message is still being transmitted
+	last := (end+link.bandwidth-1)/link.bandwidth - 1
+	e.receiveTime = last + delay
+	return e
+}
@@ -0,0 +1,72 @@
+package chandy_lamport
+
+import (
+	"testing"
+)
+
+// An application message that takes the given size on a link
+type blobMessage struct {
+	size int
+}
+
+func (m blobMessage) Size() int {
+	return m.size
+}
+
+func TestMessageSize(t *testing.T) {
+	sizes := []struct {
+		message interface{}
+		size    int
+	}{
+		{TokenMessage{5, 0, DefaultTokenType}, 1},
+		{MarkerMessage{0}, 1},
+		{blobMessage{10}, 10},
+		{blobMessage{0}, 1},
+		{PiggybackedMessage{[]int{0, 1}, blobMessage{10}}, 12},
+		{ColoredMessage{TokenMessage{1, 0, DefaultTokenType}, nil}, 2},
+	}
+	for _, s := range sizes {
+		if size := MessageSize(s.message); size != s.size {
+			t.Fatalf("Expected %v to have size %v, got %v\n", s.message, s.size, size)
+		}
+	}
+}
+
+// Return the time step at which N2 receives the marker of N1, after N1 sent it
+// a message of the given size on a link of the given bandwidth
+func markerArrival(t *testing.T, bandwidth, size int) int {
+	sim := NewSimulator()
+	sim.SetLatencyModel(NewConstantLatency(1))
+	sim.RegisterMessageHandler(blobMessage{}, func(*Server, string, interface{}) {})
+	readTopology("3nodes.top", sim)
+	link, _ := sim.GetLink("N1", "N2")
+	link.SetBandwidth(bandwidth)
+	arrival := -1
+	sim.OnSnapshotEvent(func(ev SnapshotProgressEvent) {
+		if ev.Kind == MarkerReceived && ev.ServerId == "N2" && ev.Peer == "N1" {
+			arrival = ev.Time
+		}
+	})
+	if err := sim.servers["N1"].SendMessage(blobMessage{size}, "N2"); err != nil {
+		t.Fatal(err)
+	}
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	return arrival
+}
+
+func TestBandwidth(t *testing.T) {
+	// A link delivers a message per time step, so the marker arrives right
+	// after the message
+	if arrival := markerArrival(t, 0, 10); arrival != 2 {
+		t.Fatalf("Expected the marker to arrive after the message, got time %v\n", arrival)
+	}
+	// The marker fits in the bandwidth left by the message
+	if arrival := markerArrival(t, 20, 10); arrival != 2 {
+		t.Fatalf("Expected the marker to share the bandwidth with the message, got time %v\n", arrival)
+	}
+	// Transmitting the message takes 5 time steps, then the marker a sixth one
+	if arrival := markerArrival(t, 2, 10); arrival != 6 {
+		t.Fatalf("Expected the marker to wait for the message to be transmitted, got time %v\n", arrival)
+	}
+}
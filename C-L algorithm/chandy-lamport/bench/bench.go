@@ -0,0 +1,150 @@
+// Package bench measures how fast the simulator runs, so that performance
+// regressions in its hot paths, e.g. the link queues, the event log and the
+// handling of packets by the servers, show up in numbers. A run is described
+// by a `Config`, the number of servers, links, token transfers and snapshots,
+// and its benchmarks are run with the usual tooling:
+//
+//	go test -bench . -benchmem ./bench
+//
+// Every benchmark reports the ticks simulated per second along with the
+// allocations. Runs are generated from a seed, so two runs of the same
+// configuration do exactly the same work.
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	chandy_lamport "chandy-lamport"
+)
+
+// The size of a benchmarked run
+type Config struct {
+	// Number of servers, linked in a bidirectional ring
+	Servers int
+	// Number of links from each server to random servers, besides the ring
+	Links int
+	// Number of token transfers, and of snapshots started during the run
+	Messages  int
+	Snapshots int
+}
+
+// The configurations benchmarked by the benchmarks of this package, from a
+// small run to one with many servers, links and concurrent snapshots
+var Configs = []Config{
+	{Servers: 8, Links: 0, Messages: 100, Snapshots: 1},
+	{Servers: 8, Links: 4, Messages: 1000, Snapshots: 10},
+	{Servers: 64, Links: 2, Messages: 1000, Snapshots: 10},
+	{Servers: 64, Links: 8, Messages: 5000, Snapshots: 50},
+	{Servers: 256, Links: 4, Messages: 10000, Snapshots: 10},
+}
+
+// Return the name of the configuration, as used for its sub-benchmark
+func (c Config) String() string {
+	return fmt.Sprintf("servers=%v/links=%v/messages=%v/snapshots=%v",
+		c.Servers, c.Links, c.Messages, c.Snapshots)
+}
+
+// Number of tokens every server starts with
+const tokensPerServer = 1000
+
+// Return the topology and the scenario of the run of the configuration
+// generated from the seed. The transfers and snapshots are spread over as
+// many time steps as there are transfers per server, and a transfer never
+// sends more tokens than its sender has left of the tokens it started with.
+func Generate(c Config, seed int64) (*chandy_lamport.Topology, *chandy_lamport.Scenario) {
+	random := rand.New(rand.NewSource(seed))
+	ids := make([]string, c.Servers)
+	topology := chandy_lamport.NewTopology()
+	for i := range ids {
+		ids[i] = fmt.Sprintf("N%v", i+1)
+		topology.AddServer(ids[i], tokensPerServer)
+	}
+	neighbors := make(map[string][]string)
+	link := func(src, dest string) {
+		for _, neighbor := range neighbors[src] {
+			if neighbor == dest {
+				return
+			}
+		}
+		topology.AddLink(src, dest)
+		neighbors[src] = append(neighbors[src], dest)
+	}
+	if c.Servers > 1 {
+		for i := range ids {
+			link(ids[i], ids[(i+1)%c.Servers])
+			link(ids[(i+1)%c.Servers], ids[i])
+			for j := 0; j < c.Links; j++ {
+				if dest := ids[random.Intn(c.Servers)]; dest != ids[i] {
+					link(ids[i], dest)
+				}
+			}
+		}
+	}
+	scenario := chandy_lamport.NewScenario()
+	if c.Servers == 0 {
+		return topology, scenario
+	}
+	duration := 1 + c.Messages/c.Servers
+	balances := make(map[string]int)
+	for _, id := range ids {
+		balances[id] = tokensPerServer
+	}
+	for i := 0; i < c.Messages; i++ {
+		src := ids[random.Intn(c.Servers)]
+		if balances[src] == 0 || len(neighbors[src]) == 0 {
+			continue
+		}
+		dest := neighbors[src][random.Intn(len(neighbors[src]))]
+		maxTokens := 10
+		if balances[src] < maxTokens {
+			maxTokens = balances[src]
+		}
+		numTokens := 1 + random.Intn(maxTokens)
+		balances[src] -= numTokens
+		scenario.Send(random.Intn(duration), src, dest, numTokens)
+	}
+	for i := 0; i < c.Snapshots; i++ {
+		scenario.Snapshot(random.Intn(duration), ids[random.Intn(c.Servers)])
+	}
+	return topology, scenario
+}
+
+// Simulate the run of the configuration generated from the seed until all its
+// events have been injected and all the messages delivered, and return the
+// simulator
+func Run(c Config, seed int64) (*chandy_lamport.Simulator, error) {
+	topology, scenario := Generate(c, seed)
+	sim, err := topology.BuildWithSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	if err := sim.RunScenario(scenario); err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+// Benchmark the simulation of the run of the configuration, reporting the
+// ticks simulated per second and the allocations. The topology and scenario
+// are generated once, outside of the timer.
+func Benchmark(b *testing.B, c Config) {
+	topology, scenario := Generate(c, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	ticks := 0
+	for i := 0; i < b.N; i++ {
+		sim, err := topology.BuildWithSeed(1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := sim.RunScenario(scenario); err != nil {
+			b.Fatal(err)
+		}
+		ticks += sim.Time()
+	}
+	if seconds := b.Elapsed().Seconds(); seconds > 0 {
+		b.ReportMetric(float64(ticks)/seconds, "ticks/s")
+	}
+}
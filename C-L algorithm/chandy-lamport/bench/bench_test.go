@@ -0,0 +1,72 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+
+	chandy_lamport "chandy-lamport"
+)
+
+func BenchmarkSimulation(b *testing.B) {
+	for _, c := range Configs {
+		b.Run(c.String(), func(b *testing.B) {
+			Benchmark(b, c)
+		})
+	}
+}
+
+func BenchmarkQueue(b *testing.B) {
+	b.ReportAllocs()
+	q := chandy_lamport.NewQueue[int]()
+	for i := 0; i < b.N; i++ {
+		q.Push(i)
+		if q.Len() > 64 {
+			q.Pop()
+		}
+	}
+}
+
+func BenchmarkLogger(b *testing.B) {
+	b.ReportAllocs()
+	logger := chandy_lamport.NewLogger()
+	logger.SetMaxEvents(1024)
+	sim := chandy_lamport.NewSimulator()
+	server := chandy_lamport.NewServer("N1", 10, sim)
+	for i := 0; i < b.N; i++ {
+		if i%16 == 0 {
+			logger.NewEpoch()
+		}
+		logger.RecordEvent(server, chandy_lamport.SentMessageEvent{})
+	}
+}
+
+func TestRun(t *testing.T) {
+	c := Config{Servers: 6, Links: 2, Messages: 50, Snapshots: 3}
+	sim, err := Run(c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotIds := sim.SnapshotIds()
+	if len(snapshotIds) != c.Snapshots {
+		t.Fatalf("Expected %v snapshots, got %v\n", c.Snapshots, snapshotIds)
+	}
+	for _, snapshotId := range snapshotIds {
+		if _, ok := sim.TryCollectSnapshot(snapshotId); !ok {
+			t.Fatalf("Snapshot %v did not complete\n", snapshotId)
+		}
+		if err := sim.ValidateSnapshot(snapshotId, sim.InitialTokens()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	for _, c := range Configs {
+		topology1, scenario1 := Generate(c, 7)
+		topology2, scenario2 := Generate(c, 7)
+		if !reflect.DeepEqual(topology1.Links(), topology2.Links()) ||
+			!reflect.DeepEqual(scenario1, scenario2) {
+			t.Fatalf("%v: expected the same run from the same seed\n", c)
+		}
+	}
+}
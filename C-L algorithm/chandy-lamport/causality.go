@@ -0,0 +1,127 @@
+package chandy_lamport
+
+import "fmt"
+
+// The happened-before relation between the sends and receives logged so far,
+// see `Simulator.CausalGraph`. Events are identified by their index in
+// `Events`. Every event is given a vector clock, computed from the order of the
+// events of its server and from the send matched with each receive, so one
+// event happened before another exactly when its clock is smaller.
+type CausalGraph struct {
+	events []LogEvent
+	// Time step of each event
+	times  []int
+	clocks []VectorClock
+	// Index of the send matched with each receive, or -1 for the other events
+	// and for the receives of messages sent before the first retained event
+	sends []int
+}
+
+// A send on a channel, identified by the Lamport clock of its sender
+type sendId struct {
+	channel ChannelId
+	lamport int
+}
+
+// Build the happened-before relation between the sends and receives retained
+// by the logger, see `Logger.SetMaxEvents`. A receive is matched with its send
+// through the Lamport clock the message carries, so the copies of a message
+// delivered more than once are all matched with the same send.
+func (sim *Simulator) CausalGraph() *CausalGraph {
+	g := &CausalGraph{}
+	sends := make(map[sendId]int)
+	last := make(map[string]int) // server ID -> index of its last event
+	for epoch, events := range sim.logger.events {
+		for _, event := range events {
+			send := -1
+			switch evt := event.event.(type) {
+			case SentMessageEvent:
+				sends[sendId{ChannelId{evt.src, evt.dest}, event.lamport}] = len(g.events)
+			case ReceivedMessageEvent:
+				if i, ok := sends[sendId{ChannelId{evt.src, evt.dest}, evt.lamport}]; ok {
+					send = i
+				}
+			default:
+				continue
+			}
+			clock := NewVectorClock()
+			if i, ok := last[event.serverId]; ok {
+				clock = g.clocks[i].Copy()
+			}
+			if send >= 0 {
+				clock.Merge(g.clocks[send])
+			}
+			clock.Increment(event.serverId)
+			last[event.serverId] = len(g.events)
+			g.events = append(g.events, event)
+			g.times = append(g.times, sim.logger.firstEpoch+epoch)
+			g.clocks = append(g.clocks, clock)
+			g.sends = append(g.sends, send)
+		}
+	}
+	return g
+}
+
+// Return the events of the graph, one per line, prefixed with their time step
+func (g *CausalGraph) Events() []string {
+	lines := make([]string, 0)
+	for i, event := range g.events {
+		lines = append(lines, fmt.Sprintf("Time %v: %v", g.times[i], event))
+	}
+	return lines
+}
+
+// Return true if event a happened before event b
+func (g *CausalGraph) IsCausallyBefore(a, b int) bool {
+	return g.clocks[a].HappenedBefore(g.clocks[b])
+}
+
+// Return the indices of the events that neither happened before nor after the
+// given event
+func (g *CausalGraph) ConcurrentWith(event int) []int {
+	concurrent := make([]int, 0)
+	for i := range g.events {
+		if i != event && !g.IsCausallyBefore(i, event) && !g.IsCausallyBefore(event, i) {
+			concurrent = append(concurrent, i)
+		}
+	}
+	return concurrent
+}
+
+// Return the Lamport clock of the last event of the server before it records
+// its state. A receive that makes the server record its state, e.g. of a
+// marker, comes after the state it records.
+func (server *Server) cutLamport() int {
+	if server.receivedAt > 0 {
+		return server.receivedAt - 1
+	}
+	return server.lamport
+}
+
+// Verify that the cut of a collected snapshot is consistent with the
+// happened-before relation between the logged events: no message is received
+// before the cut of its receiver but sent after the cut of its sender. Servers
+// that did not record their state, e.g. because they crashed, are not checked.
+func (sim *Simulator) AssertCausallyConsistent(snapshotId int) error {
+	snap, err := sim.collectedSnapshot(snapshotId)
+	if err != nil {
+		return err
+	}
+	g := sim.CausalGraph()
+	for i, event := range g.events {
+		receive, ok := event.event.(ReceivedMessageEvent)
+		if !ok || g.sends[i] < 0 {
+			continue
+		}
+		receiverCut, ok1 := snap.cut[receive.dest]
+		senderCut, ok2 := snap.cut[receive.src]
+		if !ok1 || !ok2 || event.lamport > receiverCut {
+			continue
+		}
+		if send := g.events[g.sends[i]]; send.lamport > senderCut {
+			return fmt.Errorf("Snapshot %v: %v at time %v is in the cut, but not its send at time %v",
+				snapshotId, receive, g.times[i], g.times[g.sends[i]])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Return the index of the only event of the graph with the given suffix
+func mustFindEvent(t *testing.T, g *CausalGraph, suffix string) int {
+	found := -1
+	for i, line := range g.Events() {
+		if strings.HasSuffix(line, suffix) {
+			if found >= 0 {
+				t.Fatalf("Expected a single event %q\n", suffix)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		t.Fatalf("Expected an event %q in\n%v\n", suffix, strings.Join(g.Events(), "\n"))
+	}
+	return found
+}
+
+func TestCausalGraph(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.InjectEvent(PassTokenEvent{"N2", "N3", 3})
+	sim.Drain()
+	sim.InjectEvent(PassTokenEvent{"N2", "N1", 1})
+	sim.Drain()
+	g := sim.CausalGraph()
+	sent := mustFindEvent(t, g, "N1 sent 1 tokens to N2")
+	received := mustFindEvent(t, g, "N2 received 1 tokens from N1")
+	forwarded := mustFindEvent(t, g, "N2 sent 1 tokens to N1")
+	other := mustFindEvent(t, g, "N3 received 3 tokens from N2")
+	if !g.IsCausallyBefore(sent, received) || !g.IsCausallyBefore(sent, forwarded) {
+		t.Fatalf("Expected the send of N1 to happen before the events of N2 after its receive\n")
+	}
+	if g.IsCausallyBefore(received, sent) || g.IsCausallyBefore(sent, sent) {
+		t.Fatalf("Expected happened-before to be a strict order\n")
+	}
+	concurrent := g.ConcurrentWith(sent)
+	found := false
+	for _, i := range concurrent {
+		found = found || i == other
+		if i == received || i == forwarded {
+			t.Fatalf("Expected %v not to be concurrent with the send\n", g.Events()[i])
+		}
+	}
+	if !found {
+		t.Fatalf("Expected the receive of N3 to be concurrent with the send of N1\n")
+	}
+}
+
+func TestCausallyConsistentCut(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.Drain()
+	sim.StartSnapshot("N3")
+	sim.InjectEvent(PassTokenEvent{"N2", "N3", 2})
+	sim.Drain()
+	snap := sim.CollectSnapshot(0)
+	if err := sim.AssertCausallyConsistent(0); err != nil {
+		t.Fatal(err)
+	}
+	// A cut before the send of N1 but after its receive by N2 is inconsistent
+	snap.cut["N1"] = 0
+	err := sim.AssertCausallyConsistent(0)
+	if err == nil || !strings.Contains(err.Error(), "N2 received 1 tokens from N1") {
+		t.Fatalf("Expected the receive of N2 to be reported, got %v\n", err)
+	}
+}
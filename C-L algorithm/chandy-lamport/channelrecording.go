@@ -0,0 +1,127 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How the servers record the token messages in flight on their inbound channels
+type ChannelRecordingMode int
+
+const (
+	// Record every token message
+	Full ChannelRecordingMode = iota
+	// Record only the number of token messages on each channel and the number
+	// of tokens of each type they carry, so the memory used by a snapshot does
+	// not grow with the number of messages in flight. The messages of other
+	// kinds, e.g. wait-for requests and grants, are still recorded in full.
+	// The hop counts and Lamport clocks of the token messages are not kept,
+	// and a restored snapshot sends the tokens of each channel in a single
+	// message of each type, see `Simulator.RestoreFromSnapshot`.
+	Aggregate
+)
+
+func (m ChannelRecordingMode) String() string {
+	switch m {
+	case Full:
+		return "full"
+	case Aggregate:
+		return "aggregate"
+	}
+	return "unknown mode"
+}
+
+// Select how the snapshots started from now on record the token messages on
+// the channels. The limit set by `SetMaxRecordedPerChannel` only applies to
+// the messages recorded in full.
+func (sim *Simulator) SetChannelRecordingMode(mode ChannelRecordingMode) {
+	sim.channelRecording = mode
+}
+
+// The token messages recorded on a channel in the `Aggregate` mode
+type channelAggregate struct {
+	messages int
+	tokens   map[string]int // token type -> num tokens
+	// Number of the messages that were copies of messages delivered more than
+	// once, see `AssertNoDoubleCounting`
+	duplicates int
+}
+
+func (a channelAggregate) String() string {
+	types := make([]string, 0)
+	for _, tokenType := range getSortedKeys(a.tokens) {
+		types = append(types, fmt.Sprintf("%v %v", a.tokens[tokenType], tokenType))
+	}
+	return fmt.Sprintf("%v messages(%v)", a.messages, strings.Join(types, ", "))
+}
+
+// Add a token message to the aggregate of its channel
+func (s *SnapshotState) aggregate(channel ChannelId, message TokenMessage, duplicate bool) {
+	if s.aggregated == nil {
+		s.aggregated = make(map[ChannelId]*channelAggregate)
+	}
+	a, ok := s.aggregated[channel]
+	if !ok {
+		a = &channelAggregate{tokens: make(map[string]int)}
+		s.aggregated[channel] = a
+	}
+	a.messages++
+	a.tokens[message.tokenType] += message.numTokens
+	if duplicate {
+		a.duplicates++
+	}
+}
+
+// Add the aggregates of another snapshot to the ones of this snapshot
+func (s *SnapshotState) mergeAggregates(other *SnapshotState) {
+	for channel, a := range other.aggregated {
+		if s.aggregated == nil {
+			s.aggregated = make(map[ChannelId]*channelAggregate)
+		}
+		merged, ok := s.aggregated[channel]
+		if !ok {
+			merged = &channelAggregate{tokens: make(map[string]int)}
+			s.aggregated[channel] = merged
+		}
+		merged.messages += a.messages
+		merged.duplicates += a.duplicates
+		for tokenType, numTokens := range a.tokens {
+			merged.tokens[tokenType] += numTokens
+		}
+	}
+}
+
+// Return the number of tokens of the given type recorded on the channel in
+// aggregate
+func (s *SnapshotState) aggregatedTokens(channel ChannelId, tokenType string) int {
+	if a, ok := s.aggregated[channel]; ok {
+		return a.tokens[tokenType]
+	}
+	return 0
+}
+
+// Return the channels recorded in aggregate, sorted by source then destination
+func (s *SnapshotState) aggregatedChannels() []ChannelId {
+	channels := make([]ChannelId, 0, len(s.aggregated))
+	for channel := range s.aggregated {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].String() < channels[j].String()
+	})
+	return channels
+}
+
+// Return the number of messages recorded on each channel, whether they were
+// recorded in full or in aggregate
+func (s *SnapshotState) RecordedMessageCount() map[ChannelId]int {
+	counts := make(map[ChannelId]int)
+	for _, msg := range s.messages {
+		counts[ChannelId{msg.src, msg.dest}]++
+	}
+	for channel, a := range s.aggregated {
+		counts[channel] += a.messages
+	}
+	return counts
+}
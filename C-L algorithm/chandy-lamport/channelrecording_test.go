@@ -0,0 +1,104 @@
+package chandy_lamport
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Collect the snapshot of 3nodes-bidirectional-messages.events, which records
+// tokens in flight, with the given recording mode
+func collectRecorded(mode ChannelRecordingMode) (*Simulator, *SnapshotState) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetChannelRecordingMode(mode)
+	readTopology("3nodes.top", sim)
+	injectEvents("3nodes-bidirectional-messages.events", sim)
+	return sim, sim.CollectSnapshot(0)
+}
+
+func TestAggregateRecording(t *testing.T) {
+	_, full := collectRecorded(Full)
+	sim, aggregated := collectRecorded(Aggregate)
+	if len(aggregated.messages) != 0 {
+		t.Fatalf("Expected no message to be recorded in full, got %v\n", len(aggregated.messages))
+	}
+	if !reflect.DeepEqual(aggregated.ChannelSummary(), full.ChannelSummary()) {
+		t.Fatalf("Expected the tokens on the channels to be %v, got %v\n",
+			full.ChannelSummary(), aggregated.ChannelSummary())
+	}
+	if !reflect.DeepEqual(aggregated.RecordedMessageCount(), full.RecordedMessageCount()) {
+		t.Fatalf("Expected the messages on the channels to be counted as %v, got %v\n",
+			full.RecordedMessageCount(), aggregated.RecordedMessageCount())
+	}
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySnapshot(aggregated, sim.Topology()); err != nil {
+		t.Fatal(err)
+	}
+	if diff := DiffSnapshots(full, aggregated); diff.Empty() {
+		t.Fatalf("Expected the aggregates to differ from the messages recorded in full\n")
+	}
+	b, err := json.Marshal(aggregated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &SnapshotState{}
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if diff := DiffSnapshots(aggregated, decoded); !diff.Empty() {
+		t.Fatalf("Expected the aggregates to survive JSON encoding:\n%v\n", diff)
+	}
+}
+
+func TestRestoreAggregatedSnapshot(t *testing.T) {
+	sim, snap := collectRecorded(Aggregate)
+	recorded := 0
+	for _, numTokens := range snap.ChannelSummary() {
+		recorded += numTokens
+	}
+	if recorded == 0 {
+		t.Fatalf("Expected the snapshot to record tokens in flight\n")
+	}
+	sim.Drain()
+	if err := sim.RestoreFromSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if inFlight := sim.Metrics().TokensInFlight; inFlight != recorded {
+		t.Fatalf("Expected the %v recorded token(s) to be in flight again, got %v\n", recorded, inFlight)
+	}
+	if err := sim.AssertConservedAfterDrain(13); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAggregateDoubleCounting(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetChannelRecordingMode(Aggregate)
+	readTopology("3nodes.top", sim)
+	sim.InjectDuplication("N1", "N2", 1)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 2})
+	sim.StartSnapshot("N2")
+	sim.FreezeLink("N2", "N1")
+	sim.FreezeLink("N2", "N3")
+	for sim.DuplicatedTokens() == 0 {
+		sim.Tick()
+	}
+	sim.InjectDuplication("N1", "N2", 0)
+	for mustGetLink(sim, "N1", "N2").events.Len() > 0 {
+		sim.Tick()
+	}
+	sim.UnfreezeLink("N2", "N1")
+	sim.UnfreezeLink("N2", "N3")
+	sim.Drain()
+	sim.CollectSnapshot(0)
+	err := sim.AssertNoDoubleCounting(0)
+	if err == nil || !strings.Contains(err.Error(), "1 copied message(s)") {
+		t.Fatalf("Expected the copy to be flagged, got %v\n", err)
+	}
+}
@@ -0,0 +1,116 @@
+package chandy_lamport
+
+// Number of periodic snapshots kept by default, see `SetRetainedCheckpoints`
+const defaultRetainedCheckpoints = 3
+
+// Bookkeeping of the snapshots started by `EnablePeriodicSnapshots`
+type periodicSnapshots struct {
+	everyNTicks int
+	// Number of collected snapshots kept
+	retain int
+	// Number of snapshots started so far, which selects the next initiator
+	started int
+	// IDs of the snapshots started but not collected yet, in order
+	pending []int
+	// IDs of the collected snapshots kept, oldest first
+	retained []int
+}
+
+// Start a new snapshot every `everyNTicks` ticks, to model a system that keeps
+// checkpointing itself. The initiator rotates over the servers that have not
+// crashed, in sorted ID order. Each snapshot is collected by the simulator as
+// soon as it completes, and only the most recent ones are kept, see
+// `SetRetainedCheckpoints` and `Checkpoints`: older snapshots are released by
+// the servers and the simulator, and can no longer be collected. A value of 0
+// or less stops starting new snapshots.
+func (sim *Simulator) EnablePeriodicSnapshots(everyNTicks int) {
+	if everyNTicks < 0 {
+		everyNTicks = 0
+	}
+	sim.checkpoints().everyNTicks = everyNTicks
+}
+
+// Keep the last k periodic snapshots, at least 1
+func (sim *Simulator) SetRetainedCheckpoints(k int) {
+	if k < 1 {
+		k = 1
+	}
+	sim.checkpoints().retain = k
+	sim.discardCheckpoints()
+}
+
+// Return the bookkeeping of the periodic snapshots, creating it if needed
+func (sim *Simulator) checkpoints() *periodicSnapshots {
+	if sim.periodic == nil {
+		sim.periodic = &periodicSnapshots{0, defaultRetainedCheckpoints, 0, make([]int, 0), make([]int, 0)}
+	}
+	return sim.periodic
+}
+
+// Return the periodic snapshots kept, oldest first
+func (sim *Simulator) Checkpoints() []*SnapshotState {
+	checkpoints := make([]*SnapshotState, 0)
+	if sim.periodic == nil {
+		return checkpoints
+	}
+	for _, snapshotId := range sim.periodic.retained {
+		snap, _ := sim.collected.Load(snapshotId)
+		checkpoints = append(checkpoints, snap)
+	}
+	return checkpoints
+}
+
+// Start the periodic snapshot due at this tick, if any, and collect the ones
+// that completed
+func (sim *Simulator) runPeriodicSnapshots() {
+	p := sim.periodic
+	if p == nil {
+		return
+	}
+	if p.everyNTicks > 0 && sim.time%p.everyNTicks == 0 {
+		sim.startCheckpoint()
+	}
+	pending := make([]int, 0, len(p.pending))
+	for _, snapshotId := range p.pending {
+		if _, ok := sim.TryCollectSnapshot(snapshotId); ok {
+			p.retained = append(p.retained, snapshotId)
+		} else {
+			pending = append(pending, snapshotId)
+		}
+	}
+	p.pending = pending
+	sim.discardCheckpoints()
+}
+
+// Start a snapshot from the next initiator in the rotation
+func (sim *Simulator) startCheckpoint() {
+	candidates := make([]string, 0)
+	for _, serverId := range getSortedKeys(sim.servers) {
+		if !sim.servers[serverId].crashed {
+			candidates = append(candidates, serverId)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	initiator := candidates[sim.periodic.started%len(candidates)]
+	sim.periodic.started++
+	snapshotId := sim.nextSnapshotId
+	if err := sim.StartSnapshot(initiator); err != nil {
+		sim.slogger.Error("Failed to start periodic snapshot",
+			"time", sim.time, "server", initiator, "err", err)
+		return
+	}
+	sim.periodic.pending = append(sim.periodic.pending, snapshotId)
+}
+
+// Release the periodic snapshots beyond the ones to keep, oldest first
+func (sim *Simulator) discardCheckpoints() {
+	p := sim.periodic
+	for len(p.retained) > p.retain {
+		snapshotId := p.retained[0]
+		p.retained = p.retained[1:]
+		sim.releaseSnapshot(snapshotId)
+		sim.collected.Delete(snapshotId)
+	}
+}
@@ -0,0 +1,52 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestPeriodicSnapshots(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.EnablePeriodicSnapshots(20)
+	sim.SetRetainedCheckpoints(2)
+	for i := 0; i < 100; i++ {
+		if i%3 == 0 {
+			sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+			sim.InjectEvent(PassTokenEvent{"N2", "N3", 1})
+		}
+		sim.Tick()
+	}
+	// Snapshots start at time 20, 40, 60, 80 and 100, and complete well within 20 ticks
+	checkpoints := sim.Checkpoints()
+	if len(checkpoints) != 2 || checkpoints[0].id != 2 || checkpoints[1].id != 3 {
+		t.Fatalf("Expected snapshots 2 and 3 to be kept, got %v\n", checkpoints)
+	}
+	for i, expected := range []string{"N3", "N1"} {
+		if checkpoints[i].initiator != expected {
+			t.Fatalf("Expected snapshot %v to be started by %v, got %v\n",
+				checkpoints[i].id, expected, checkpoints[i].initiator)
+		}
+		if err := sim.ValidateSnapshot(checkpoints[i].id, sim.InitialTokens()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, snapshotId := range []int{0, 1} {
+		if sim.CollectSnapshot(snapshotId) != nil {
+			t.Fatalf("Expected snapshot %v to be released\n", snapshotId)
+		}
+	}
+	for _, server := range sim.servers {
+		if server.snapshot[0] != nil || server.snapshot[1] != nil {
+			t.Fatalf("Expected %v to release the discarded snapshots\n", server.Id)
+		}
+	}
+	// Stopping the schedule still collects the snapshot started at time 100
+	sim.EnablePeriodicSnapshots(0)
+	sim.Drain()
+	if ids := sim.SnapshotIds(); !reflect.DeepEqual(ids, []int{3, 4}) {
+		t.Fatalf("Expected snapshots 3 and 4 to be kept after the schedule stopped, got %v\n", ids)
+	}
+}
@@ -0,0 +1,126 @@
+// Command clsim runs a snapshot simulation outside of `go test`.
+//
+// Usage:
+//
+//	clsim -topology 3nodes.top [-scenario run.scn] [-ticks 100] [-seed 1]
+//	      [-snapshots snapshots.json] [-log events.log] [-log-format jsonl]
+//
+// The topology is read with `LoadTopology`, either in the ".top" format or in
+// JSON, and the scenario with `ParseScenario`. The simulation runs for the given
+// number of ticks, or until all the events have been injected and all the
+// messages delivered if no number is given. The snapshots that completed are
+// then written as a JSON array, and the event log one event per line. Both are
+// written to stdout unless a file is given. The event log is written as text by
+// default, in JSON Lines with "-log-format jsonl", or as OpenTelemetry traces
+// in the OTLP/JSON encoding with "-log-format otlp", one time step per
+// millisecond from the start of the run.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	chandy_lamport "chandy-lamport"
+)
+
+func main() {
+	topologyFile := flag.String("topology", "", "topology file to load (required)")
+	scenarioFile := flag.String("scenario", "", "scenario of token transfers and snapshots")
+	ticks := flag.Int("ticks", 0, "number of ticks to run, or 0 to run until drained")
+	seed := flag.Int64("seed", 8053172852482175524, "seed of the message delays")
+	snapshotsFile := flag.String("snapshots", "", "file to write the snapshots to instead of stdout")
+	logFile := flag.String("log", "", "file to write the event log to instead of stdout")
+	logFormat := flag.String("log-format", "text", "format of the event log: text, jsonl or otlp")
+	flag.Parse()
+	if *topologyFile == "" || (*logFormat != "text" && *logFormat != "jsonl" && *logFormat != "otlp") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// An interrupt stops a run with a number of ticks early
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, *topologyFile, *scenarioFile, *ticks, *seed, *snapshotsFile, *logFile, *logFormat)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "clsim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, topologyFile, scenarioFile string, ticks int, seed int64, snapshotsFile, logFile, logFormat string) error {
+	start := time.Now()
+	sim, err := chandy_lamport.LoadTopologyWithSeed(topologyFile, seed)
+	if err != nil {
+		return err
+	}
+	if scenarioFile != "" {
+		f, err := os.Open(scenarioFile)
+		if err != nil {
+			return err
+		}
+		scenario, err := chandy_lamport.ParseScenario(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %v", scenarioFile, err)
+		}
+		if err := scenario.Schedule(sim); err != nil {
+			return err
+		}
+	}
+	if ticks > 0 {
+		if err := sim.Run(ctx, ticks); err != nil {
+			return err
+		}
+	} else {
+		sim.Drain()
+	}
+
+	snapshots := make([]*chandy_lamport.SnapshotState, 0)
+	for _, snapshotId := range sim.SnapshotIds() {
+		if snap, ok := sim.TryCollectSnapshot(snapshotId); ok {
+			snapshots = append(snapshots, snap)
+		} else {
+			fmt.Fprintf(os.Stderr, "clsim: snapshot %v did not complete\n", snapshotId)
+		}
+	}
+	err = writeTo(snapshotsFile, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshots)
+	})
+	if err != nil {
+		return err
+	}
+	return writeTo(logFile, func(w io.Writer) error {
+		switch logFormat {
+		case "jsonl":
+			return sim.Logger().WriteJSONL(w)
+		case "otlp":
+			return sim.Logger().WriteOTLP(w, start, time.Millisecond)
+		}
+		_, err := fmt.Fprintln(w, strings.Join(sim.EventLog(), "\n"))
+		return err
+	})
+}
+
+// Call write with the given file, or with stdout if no file is given
+func writeTo(fileName string, write func(w io.Writer) error) error {
+	if fileName == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
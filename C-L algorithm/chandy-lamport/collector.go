@@ -0,0 +1,118 @@
+package chandy_lamport
+
+import "sync"
+
+// The local states reported by the servers taking part in a snapshot. The
+// states are kept in the order the servers completed the snapshot, so merging
+// them does not depend on the goroutine collecting them.
+type snapshotCollector struct {
+	lock sync.Mutex
+	// IDs of the servers taking part in the snapshot, which grows when servers
+	// join while it is in progress
+	participants map[string]bool
+	records      []*SnapshotState
+	// IDs of the servers whose local state was reported
+	reporters map[string]bool
+	// Closed once every participant has reported its local state
+	done chan bool
+	// Channels the reported states are streamed to, see `StreamSnapshot`
+	streams []chan ServerSnapshotPart
+}
+
+// Start collecting the given snapshot from the given servers
+func (sim *Simulator) newCollector(snapshotId int, serverIds []string) {
+	participants := make(map[string]bool)
+	for _, serverId := range serverIds {
+		participants[serverId] = true
+	}
+	sim.collectors[snapshotId] = &snapshotCollector{
+		participants: participants,
+		records:      make([]*SnapshotState, 0),
+		reporters:    make(map[string]bool),
+		done:         make(chan bool),
+	}
+	if sim.collectionMode == TreeAggregation {
+		sim.newAggregationTree(snapshotId, serverIds)
+	}
+}
+
+// Add a server to the participants of a snapshot in progress
+func (c *snapshotCollector) join(serverId string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.participants[serverId] = true
+}
+
+// Keep the local state of a server, and signal the collection once every
+// participant has reported its own. Return false, without keeping it, if the
+// server has already reported a state.
+func (c *snapshotCollector) report(snap *SnapshotState) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if serverId := reportingServer(snap); serverId != "" {
+		if c.reporters[serverId] {
+			return false
+		}
+		c.reporters[serverId] = true
+	}
+	c.records = append(c.records, snap)
+	if len(c.records) == len(c.participants) {
+		close(c.done)
+	}
+	return true
+}
+
+// Return true if every participant has reported its local state
+func (c *snapshotCollector) complete() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.records) >= len(c.participants)
+}
+
+// Return the local states reported so far
+func (c *snapshotCollector) reported() []*SnapshotState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*SnapshotState{}, c.records...)
+}
+
+// Return the sorted IDs of the servers taking part in the snapshot
+func (c *snapshotCollector) participantIds() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return getSortedKeys(c.participants)
+}
+
+// Return the number of servers taking part in the given snapshot
+func (sim *Simulator) numParticipants(snapshotId int) int {
+	c, ok := sim.collectors[snapshotId]
+	if !ok {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.participants)
+}
+
+// Return true if the given snapshot was started and has not completed on every
+// server taking part yet
+func (sim *Simulator) snapshotInProgress(snapshotId int) bool {
+	if sim == nil {
+		return false
+	}
+	_, ok := sim.collectors[snapshotId]
+	return ok && sim.finishedMap[snapshotId] < sim.numParticipants(snapshotId)
+}
+
+// Hand the local state recorded by a server over to the collector of the
+// snapshot, or to its aggregation tree, see `TreeAggregation`. States of
+// snapshots that were not started through the simulator have nowhere to go and
+// are dropped.
+func (sim *Simulator) reportSnapshot(serverId string, snapshotId int, snap *SnapshotState) {
+	if sim.aggregateSnapshot(serverId, snapshotId, snap) {
+		return
+	}
+	if c, ok := sim.collectors[snapshotId]; ok {
+		sim.collect(snapshotId, c, snap)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 const debug = false
@@ -21,15 +23,27 @@ type SendMessageEvent struct {
 	message interface{}
 	// The message will be received by the server at or after this time step
 	receiveTime int
+	// Time step at which the message was sent, or entered the link if it was
+	// held at the sender
+	sendTime int
+	// Lamport clock of the sender when it sent the message, see `lamport.go`
+	lamport int
 }
 
 // A message sent from one server to another for token passing.
 // This is expected to be encapsulated within a `sendMessageEvent`.
 type TokenMessage struct {
 	numTokens int
+	// Number of times the tokens were forwarded before being sent on this link
+	hopCount int
+	// Type of the tokens, see `typed.go`
+	tokenType string
 }
 
 func (m TokenMessage) String() string {
+	if m.tokenType != DefaultTokenType {
+		return fmt.Sprintf("token(%v %v)", m.numTokens, m.tokenType)
+	}
 	return fmt.Sprintf("token(%v)", m.numTokens)
 }
 
@@ -43,6 +57,74 @@ func (m MarkerMessage) String() string {
 	return fmt.Sprintf("marker(%v)", m.snapshotId)
 }
 
+// A message that batches the markers of several snapshots sent on the same link
+// in the same time step. This is only used if `Simulator.CoalesceMarkers` is set.
+type MultiMarkerMessage struct {
+	snapshotIds []int
+}
+
+func (m MultiMarkerMessage) String() string {
+	ids := make([]string, 0)
+	for _, snapshotId := range m.snapshotIds {
+		ids = append(ids, strconv.Itoa(snapshotId))
+	}
+	return fmt.Sprintf("markers(%v)", strings.Join(ids, ","))
+}
+
+// Return true if the message is a marker, or a batch of markers, for the given snapshot
+func carriesMarker(message interface{}, snapshotId int) bool {
+	switch msg := message.(type) {
+	case MarkerMessage:
+		return msg.snapshotId == snapshotId
+	case LaiYangControlMessage:
+		return msg.snapshotId == snapshotId
+	case MatternControlMessage:
+		return msg.snapshotId == snapshotId
+	case MultiMarkerMessage:
+		for _, id := range msg.snapshotIds {
+			if id == snapshotId {
+				return true
+			}
+		}
+	case PiggybackedMessage:
+		for _, id := range msg.snapshotIds {
+			if id == snapshotId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return the token message carried by the message, if it carries tokens of the
+// default type. Only those are counted in `Server.Tokens` and in the token
+// accounting of the simulator; the other types are counted in `typed.go`.
+func tokenMessage(message interface{}) (TokenMessage, bool) {
+	msg, ok := anyTokenMessage(message)
+	if !ok || msg.tokenType != DefaultTokenType {
+		return TokenMessage{}, false
+	}
+	return msg, true
+}
+
+// Return the token message carried by the message, if any, whatever the type
+// of its tokens
+func anyTokenMessage(message interface{}) (TokenMessage, bool) {
+	switch msg := message.(type) {
+	case TokenMessage:
+		return msg, true
+	case ColoredMessage:
+		return msg.message, true
+	case TimestampedMessage:
+		return msg.message, true
+	case PiggybackedMessage:
+		return anyTokenMessage(msg.message)
+	case duplicateCopy:
+		return anyTokenMessage(msg.message)
+	}
+	return TokenMessage{}, false
+}
+
 // =======================
 //  Events used by logger
 // =======================
@@ -53,6 +135,9 @@ type ReceivedMessageEvent struct {
 	src     string
 	dest    string
 	message interface{}
+	// Lamport clock of the sender when it sent the message, which matches the
+	// receive with its send, see `causality.go`
+	lamport int
 }
 
 func (m ReceivedMessageEvent) String() string {
@@ -61,8 +146,15 @@ func (m ReceivedMessageEvent) String() string {
 		return fmt.Sprintf("%v received %v tokens from %v", m.dest, msg.numTokens, m.src)
 	case MarkerMessage:
 		return fmt.Sprintf("%v received marker(%v) from %v", m.dest, msg.snapshotId, m.src)
+	case MultiMarkerMessage, LaiYangControlMessage, MatternControlMessage, PiggybackedMessage:
+		return fmt.Sprintf("%v received %v from %v", m.dest, msg, m.src)
+	case ColoredMessage:
+		return ReceivedMessageEvent{m.src, m.dest, msg.message, m.lamport}.String()
+	case TimestampedMessage:
+		return ReceivedMessageEvent{m.src, m.dest, msg.message, m.lamport}.String()
 	}
-	return fmt.Sprintf("Unrecognized message: %v", m.message)
+	// Application messages, see `Simulator.RegisterMessageHandler`
+	return fmt.Sprintf("%v received %v from %v", m.dest, m.message, m.src)
 }
 
 // A message that signifies sending of a message on a particular server
@@ -79,8 +171,40 @@ func (m SentMessageEvent) String() string {
 		return fmt.Sprintf("%v sent %v tokens to %v", m.src, msg.numTokens, m.dest)
 	case MarkerMessage:
 		return fmt.Sprintf("%v sent marker(%v) to %v", m.src, msg.snapshotId, m.dest)
+	case MultiMarkerMessage, LaiYangControlMessage, MatternControlMessage, PiggybackedMessage:
+		return fmt.Sprintf("%v sent %v to %v", m.src, msg, m.dest)
+	case ColoredMessage:
+		return SentMessageEvent{m.src, m.dest, msg.message}.String()
+	case TimestampedMessage:
+		return SentMessageEvent{m.src, m.dest, msg.message}.String()
 	}
-	return fmt.Sprintf("Unrecognized message: %v", m.message)
+	// Application messages, see `Simulator.RegisterMessageHandler`
+	return fmt.Sprintf("%v sent %v to %v", m.src, m.message, m.dest)
+}
+
+// A message that signifies a message being altered on a link before delivery
+// This is used only for debugging that is not sent between servers
+type TransformedMessageEvent struct {
+	src    string
+	dest   string
+	before interface{}
+	after  interface{}
+}
+
+func (m TransformedMessageEvent) String() string {
+	return fmt.Sprintf("%v -> %v transformed %v into %v", m.src, m.dest, m.before, m.after)
+}
+
+// A message that signifies a message being dropped by a lossy link
+// This is used only for debugging that is not sent between servers
+type DroppedMessageEvent struct {
+	src     string
+	dest    string
+	message interface{}
+}
+
+func (m DroppedMessageEvent) String() string {
+	return fmt.Sprintf("%v -> %v dropped %v", m.src, m.dest, m.message)
 }
 
 // A message that signifies the beginning of the snapshot process on a particular server.
@@ -128,6 +252,29 @@ type SnapshotMessage struct {
 	src     string
 	dest    string
 	message interface{}
+	// Number of times the message was forwarded before it was recorded
+	hopCount int
+	// Lamport clock of the sender when it sent the message, see `lamport.go`
+	lamport int
+}
+
+// A message that has been sent but not yet delivered to its destination
+type InFlightInfo struct {
+	src         string
+	dest        string
+	message     interface{}
+	receiveTime int
+	sendTime    int
+}
+
+// A directed channel from one server to another
+type ChannelId struct {
+	src  string
+	dest string
+}
+
+func (c ChannelId) String() string {
+	return fmt.Sprintf("%v -> %v", c.src, c.dest)
 }
 
 // State recorded during the snapshot process
@@ -135,6 +282,120 @@ type SnapshotState struct {
 	id       int
 	tokens   map[string]int // key = server ID, value = num tokens
 	messages []*SnapshotMessage
+	// Channels closed by a marker, whose recorded messages are complete
+	channels []ChannelId
+	// Channels closed by a deadline instead of a marker, whose recorded
+	// messages may be incomplete
+	unknownChannels []ChannelId
+	// ID of the server that initiated the snapshot
+	initiator string
+	// Number of messages on each channel that were counted but not stored,
+	// because of the limit set in `Simulator.SetMaxRecordedPerChannel`
+	overflow map[ChannelId]int
+	// IDs of the servers excluded from the snapshot because they crashed
+	// before completing it, see `Simulator.CrashServer`
+	crashed []string
+	// Server ID -> if the server completed the snapshot and its local state
+	// was merged into this one
+	completion map[string]bool
+	// Server ID -> application state recorded by the server, see `state.go`
+	appState map[string][]byte
+	// Server ID -> wait-for state recorded by the server, see `deadlock.go`
+	waits map[string]waitState
+	// Server ID -> token type -> number of tokens of the types other than
+	// `DefaultTokenType` recorded by the server, see `typed.go`
+	typed map[string]map[string]int
+	// Server ID -> Lamport clock of the last event of the server before it
+	// recorded its state, see `causality.go`
+	cut map[string]int
+	// Token messages recorded on each channel in the `Aggregate` mode, see
+	// `channelrecording.go`
+	aggregated map[ChannelId]*channelAggregate
+}
+
+// Return the number of tokens recorded on each channel closed by a marker.
+// Every such channel has an entry, even if no messages were recorded on it.
+func (s *SnapshotState) ChannelSummary() map[ChannelId]int {
+	summary := make(map[ChannelId]int)
+	for _, channel := range s.channels {
+		summary[channel] = 0
+	}
+	for _, msg := range s.messages {
+		channel := ChannelId{msg.src, msg.dest}
+		if _, ok := summary[channel]; !ok {
+			continue
+		}
+		if tokens, ok := msg.message.(TokenMessage); ok && tokens.tokenType == DefaultTokenType {
+			summary[channel] += tokens.numTokens
+		}
+	}
+	for channel := range summary {
+		summary[channel] += s.aggregatedTokens(channel, DefaultTokenType)
+	}
+	return summary
+}
+
+// Return the sorted IDs of the servers recorded with a negative number of tokens,
+// which a correctly recorded snapshot never contains
+func (s *SnapshotState) HasNegativeBalances() []string {
+	negative := make([]string, 0)
+	for _, serverId := range getSortedKeys(s.tokens) {
+		if s.tokens[serverId] < 0 {
+			negative = append(negative, serverId)
+		}
+	}
+	return negative
+}
+
+// Return the fraction of the recorded channels on which at least one message
+// was recorded, or 0 if the snapshot has no channels
+func (s *SnapshotState) BusyChannelFraction() float64 {
+	channels := make(map[ChannelId]bool) // value = if messages were recorded
+	for _, channel := range s.channels {
+		channels[channel] = false
+	}
+	for _, channel := range s.unknownChannels {
+		channels[channel] = false
+	}
+	if len(channels) == 0 {
+		return 0
+	}
+	for _, msg := range s.messages {
+		channels[ChannelId{msg.src, msg.dest}] = true
+	}
+	for channel := range s.aggregated {
+		channels[channel] = true
+	}
+	busy := 0
+	for _, isBusy := range channels {
+		if isBusy {
+			busy++
+		}
+	}
+	return float64(busy) / float64(len(channels))
+}
+
+// Return the ID of the snapshot
+func (s *SnapshotState) Id() int {
+	return s.id
+}
+
+// Return whether each server completed the snapshot and its local state is
+// included, which is false for every server missing from a partial snapshot
+func (s *SnapshotState) Completion() map[string]bool {
+	completion := make(map[string]bool)
+	for serverId, completed := range s.completion {
+		completion[serverId] = completed
+	}
+	return completion
+}
+
+// Return the sorted IDs of the servers that crashed before completing the
+// snapshot, whose local state is missing from it
+func (s *SnapshotState) CrashedServers() []string {
+	crashed := append([]string{}, s.crashed...)
+	sort.Strings(crashed)
+	return crashed
 }
 
 // =====================
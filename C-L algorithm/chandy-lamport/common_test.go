@@ -0,0 +1,37 @@
+package chandy_lamport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBusyChannelFraction(t *testing.T) {
+	snap := SnapshotState{
+		id:     0,
+		tokens: map[string]int{"N1": 1, "N2": 0},
+		messages: []*SnapshotMessage{
+			{"N1", "N2", TokenMessage{numTokens: 1}, 0, 0},
+			{"N1", "N2", TokenMessage{numTokens: 2}, 0, 0},
+		},
+		channels: []ChannelId{{"N1", "N2"}, {"N2", "N1"}, {"N1", "N3"}, {"N3", "N1"}},
+	}
+	if fraction := snap.BusyChannelFraction(); fraction != 0.25 {
+		t.Fatalf("Expected a busy channel fraction of 0.25, got %v\n", fraction)
+	}
+	empty := SnapshotState{id: 1, tokens: map[string]int{"N1": 1}}
+	if fraction := empty.BusyChannelFraction(); fraction != 0 {
+		t.Fatalf("Expected a busy channel fraction of 0 without channels, got %v\n", fraction)
+	}
+}
+
+func TestHasNegativeBalances(t *testing.T) {
+	clean := readSnapshot("3nodes-simple.snap")
+	if negative := clean.HasNegativeBalances(); len(negative) != 0 {
+		t.Fatalf("Expected no negative balances, got %v\n", negative)
+	}
+	tampered := readSnapshot("3nodes-simple.snap")
+	tampered.tokens["N2"] = -1
+	if negative := tampered.HasNegativeBalances(); !reflect.DeepEqual(negative, []string{"N2"}) {
+		t.Fatalf("Expected N2 to have a negative balance, got %v\n", negative)
+	}
+}
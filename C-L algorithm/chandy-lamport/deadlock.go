@@ -0,0 +1,168 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ===================================================================
+//  Deadlock detection, a stable property detected with a snapshot
+// ===================================================================
+//
+// Servers request resources from their neighbors with `RequestResource`, and
+// wait for each of them to grant it. A server grants a request right away
+// unless it is waiting itself, in which case the grant is deferred until it
+// stops waiting. The servers waiting for each other form the wait-for graph,
+// and a cycle in it is a deadlock: none of the servers on the cycle will ever
+// grant the requests of the others. Like termination, a deadlock is a stable
+// property, so a consistent snapshot that shows one proves the system is
+// deadlocked.
+
+// A message of the wait-for graph: a request for a resource held by the
+// receiver, or the grant of a request sent by the receiver
+type WaitForMessage struct {
+	grant bool
+}
+
+func (m WaitForMessage) String() string {
+	if m.grant {
+		return "grant"
+	}
+	return "request"
+}
+
+// The wait-for state of a server, as recorded by a snapshot
+type waitState struct {
+	// Sorted IDs of the servers the server waits for a grant from
+	waitingFor []string
+	// Sorted IDs of the servers the server owes a grant to
+	deferred []string
+}
+
+func (server *Server) currentWaitState() waitState {
+	return waitState{getSortedKeys(server.waitingFor), getSortedKeys(server.deferredGrants)}
+}
+
+// Request a resource held by a neighbor attached to this server, and wait for
+// the neighbor to grant it. The neighbor must be able to send its grant back
+// on a link to this server. Wait-for messages are recorded on the channels
+// they are in flight on, so this is only supported by the Chandy-Lamport
+// algorithm.
+func (server *Server) RequestResource(dest string) error {
+	if _, ok := server.inboundLinks[dest]; !ok {
+		return newError(ErrUnknownDest, "Server %v has no link back to %v to grant the request",
+			dest, server.Id)
+	}
+	if err := server.sendRecordedMessage(WaitForMessage{false}, dest); err != nil {
+		return err
+	}
+	server.waitingFor[dest] = true
+	return nil
+}
+
+// Return the sorted IDs of the servers this server is waiting for
+func (server *Server) WaitingFor() []string {
+	return getSortedKeys(server.waitingFor)
+}
+
+// Grant requests from idle servers, and hand out the deferred grants once the
+// last awaited grant arrives
+func handleWaitFor(server *Server, src string, message interface{}) {
+	if !message.(WaitForMessage).grant {
+		if len(server.waitingFor) > 0 {
+			server.deferredGrants[src] = true
+			return
+		}
+		server.sendGrant(src)
+		return
+	}
+	delete(server.waitingFor, src)
+	if len(server.waitingFor) > 0 {
+		return
+	}
+	for _, dest := range getSortedKeys(server.deferredGrants) {
+		server.sendGrant(dest)
+	}
+	server.deferredGrants = make(map[string]bool)
+}
+
+func (server *Server) sendGrant(dest string) {
+	if err := server.sendRecordedMessage(WaitForMessage{true}, dest); err != nil {
+		server.sim.slogger.Error("Failed to grant request",
+			"server", server.Id, "dest", dest, "err", err)
+	}
+}
+
+// Inspect the wait-for graph recorded by a collected snapshot, and return the
+// servers on a cycle of it, starting from the smallest ID and in the order in
+// which they wait for each other. A server does not wait for a neighbor whose
+// grant was recorded in flight to it. Returns nil if the snapshot shows no
+// deadlock, and an error if it is missing the state of a server or channel,
+// which could hide a grant.
+func (sim *Simulator) DetectDeadlock(snapshotId int) ([]string, error) {
+	snap, ok := sim.collected.Load(snapshotId)
+	if !ok {
+		return nil, newError(ErrUnknownSnapshot, "Snapshot %v has not been collected", snapshotId)
+	}
+	if len(snap.unknownChannels) > 0 || len(snap.overflow) > 0 || len(snap.crashed) > 0 {
+		return nil, fmt.Errorf("Snapshot %v did not record the complete state of the system", snapshotId)
+	}
+	granted := make(map[ChannelId]bool)
+	for _, msg := range snap.messages {
+		if m, ok := msg.message.(WaitForMessage); ok && m.grant {
+			granted[ChannelId{msg.src, msg.dest}] = true
+		}
+	}
+	edges := make(map[string][]string)
+	for serverId, state := range snap.waits {
+		for _, dest := range state.waitingFor {
+			if !granted[ChannelId{dest, serverId}] {
+				edges[serverId] = append(edges[serverId], dest)
+			}
+		}
+	}
+	// Depth-first search from every server in order, where a server on the
+	// current path that is reached again closes a cycle
+	onPath := make(map[string]int) // server ID -> position on the path
+	visited := make(map[string]bool)
+	path := make([]string, 0)
+	var visit func(serverId string) []string
+	visit = func(serverId string) []string {
+		if i, ok := onPath[serverId]; ok {
+			return rotateCycle(path[i:])
+		}
+		if visited[serverId] {
+			return nil
+		}
+		visited[serverId] = true
+		onPath[serverId] = len(path)
+		path = append(path, serverId)
+		for _, dest := range edges[serverId] {
+			if cycle := visit(dest); cycle != nil {
+				return cycle
+			}
+		}
+		delete(onPath, serverId)
+		path = path[:len(path)-1]
+		return nil
+	}
+	for _, serverId := range getSortedKeys(snap.waits) {
+		if cycle := visit(serverId); cycle != nil {
+			return cycle, nil
+		}
+	}
+	return nil, nil
+}
+
+// Return a copy of the cycle that starts from its smallest server ID
+func rotateCycle(cycle []string) []string {
+	sorted := append([]string{}, cycle...)
+	sort.Strings(sorted)
+	start := 0
+	for i, serverId := range cycle {
+		if serverId == sorted[0] {
+			start = i
+		}
+	}
+	return append(append([]string{}, cycle[start:]...), cycle[:start]...)
+}
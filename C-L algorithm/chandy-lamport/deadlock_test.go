@@ -0,0 +1,80 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDetectDeadlock(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	// Each server waits for the other before receiving its request
+	if err := sim.servers["N2"].RequestResource("N1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.servers["N1"].RequestResource("N2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.servers["N3"].RequestResource("N1"); err != nil {
+		t.Fatal(err)
+	}
+	sim.Drain()
+	sim.StartSnapshot("N3")
+	sim.Drain()
+	sim.CollectSnapshot(0)
+	cycle, err := sim.DetectDeadlock(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cycle, []string{"N1", "N2"}) {
+		t.Fatalf("Expected N1 and N2 to be deadlocked, got %v\n", cycle)
+	}
+	if waiting := sim.servers["N3"].WaitingFor(); !reflect.DeepEqual(waiting, []string{"N1"}) {
+		t.Fatalf("Expected N3 to wait for N1, got %v\n", waiting)
+	}
+	if _, err := sim.DetectDeadlock(1); !errors.Is(err, ErrUnknownSnapshot) {
+		t.Fatalf("Expected ErrUnknownSnapshot, got %v\n", err)
+	}
+}
+
+func TestGrantInFlight(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	if err := sim.servers["N1"].RequestResource("N2"); err != nil {
+		t.Fatal(err)
+	}
+	for mustGetLink(sim, "N2", "N1").events.Empty() {
+		sim.Tick()
+	}
+	// N1 records that it waits for N2, and the grant on its way to N1
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	snap := sim.CollectSnapshot(0)
+	if len(snap.messages) != 1 || snap.messages[0].message != (WaitForMessage{true}) {
+		t.Fatalf("Expected the grant to be recorded on N2 -> N1, got %v\n", snap.messages)
+	}
+	cycle, err := sim.DetectDeadlock(0)
+	if err != nil || cycle != nil {
+		t.Fatalf("Expected no deadlock, got %v, %v\n", cycle, err)
+	}
+	if waiting := sim.servers["N1"].WaitingFor(); len(waiting) != 0 {
+		t.Fatalf("Expected N1 to be granted its request, still waiting for %v\n", waiting)
+	}
+}
+
+func TestRequestResourceErrors(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetSnapshotAlgorithm(LaiYang)
+	readTopology("3nodes.top", sim)
+	if err := sim.servers["N1"].RequestResource("N2"); err == nil {
+		t.Fatalf("Expected wait-for messages to be rejected under Lai-Yang\n")
+	}
+	if err := sim.servers["N1"].RequestResource("N4"); !errors.Is(err, ErrUnknownDest) {
+		t.Fatalf("Expected ErrUnknownDest, got %v\n", err)
+	}
+}
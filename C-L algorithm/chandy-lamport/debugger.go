@@ -0,0 +1,118 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A step debugger for the simulation. The simulation only moves forward when
+// `Step` or `RunUntil` is called, so its state can be examined with `Inspect`
+// between any two ticks, e.g. from a test or a REPL.
+type Stepper struct {
+	sim   *Simulator
+	steps int // number of ticks run by this stepper
+}
+
+// Take control of the simulation one tick at a time
+func (sim *Simulator) StepMode() *Stepper {
+	return &Stepper{sim, 0}
+}
+
+// Run a single tick and return the events logged during it, one per line
+func (s *Stepper) Step() []string {
+	s.sim.Tick()
+	s.steps++
+	lines := make([]string, 0)
+	for _, event := range s.sim.logger.EventsBetween(s.sim.time, s.sim.time) {
+		lines = append(lines, fmt.Sprintf("Time %v: %v", s.sim.time, event))
+	}
+	return lines
+}
+
+// Return the number of ticks run by this stepper
+func (s *Stepper) Steps() int {
+	return s.steps
+}
+
+// Keep ticking until the predicate holds, and return the number of ticks run.
+// The predicate is checked before every tick, so no tick is run if it already
+// holds. Returns false if the simulation ran out of messages to deliver before
+// the predicate held.
+func (s *Stepper) RunUntil(predicate func(sim *Simulator) bool) (int, bool) {
+	steps := 0
+	for !predicate(s.sim) {
+		if s.sim.deliverableMessages() == 0 {
+			return steps, false
+		}
+		s.Step()
+		steps++
+	}
+	return steps, true
+}
+
+// A view of the state of a server between two ticks
+type ServerInspection struct {
+	id     string
+	tokens int
+	// Messages queued on the inbound and outbound links of the server,
+	// sorted by receive time
+	inbound  []InFlightInfo
+	outbound []InFlightInfo
+	// snapshotID -> bookkeeping of the server for that snapshot
+	snapshots map[int]SnapshotDebugInfo
+}
+
+func (i ServerInspection) String() string {
+	lines := []string{fmt.Sprintf("%v has %v token(s)", i.id, i.tokens)}
+	for _, info := range i.inbound {
+		lines = append(lines, fmt.Sprintf("\tinbound from %v at time %v: %v",
+			info.src, info.receiveTime, info.message))
+	}
+	for _, info := range i.outbound {
+		lines = append(lines, fmt.Sprintf("\toutbound to %v at time %v: %v",
+			info.dest, info.receiveTime, info.message))
+	}
+	ids := make([]int, 0)
+	for snapshotId := range i.snapshots {
+		ids = append(ids, snapshotId)
+	}
+	sort.Ints(ids)
+	for _, snapshotId := range ids {
+		info := i.snapshots[snapshotId]
+		lines = append(lines, fmt.Sprintf(
+			"\tsnapshot %v: started=%v completed=%v markers from [%v], %v token(s) and %v message(s) recorded",
+			snapshotId, info.Received, info.Completed, strings.Join(info.MarkerSources, " "),
+			info.RecordedTokens, info.RecordedMessages))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Return the tokens, link queues and snapshot bookkeeping of a server
+func (s *Stepper) Inspect(serverId string) (ServerInspection, error) {
+	server, ok := s.sim.servers[serverId]
+	if !ok {
+		return ServerInspection{}, newError(ErrUnknownServer, "Server %v does not exist", serverId)
+	}
+	inspection := ServerInspection{
+		serverId,
+		server.Tokens,
+		make([]InFlightInfo, 0),
+		make([]InFlightInfo, 0),
+		make(map[int]SnapshotDebugInfo),
+	}
+	for _, info := range s.sim.AllInFlight() {
+		if info.dest == serverId {
+			inspection.inbound = append(inspection.inbound, info)
+		}
+		if info.src == serverId {
+			inspection.outbound = append(inspection.outbound, info)
+		}
+	}
+	for snapshotId, received := range server.receivedSnapshot {
+		if received {
+			inspection.snapshots[snapshotId] = server.SnapshotDebugInfo(snapshotId)
+		}
+	}
+	return inspection, nil
+}
@@ -0,0 +1,48 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestStepMode(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	stepper := sim.StepMode()
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 2})
+	sim.StartSnapshot("N1")
+
+	inspection, err := stepper.Inspect("N1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspection.tokens != 8 || len(inspection.outbound) != 3 || len(inspection.inbound) != 0 {
+		t.Fatalf("Expected N1 to hold 8 tokens with 3 outbound messages, got:\n%v\n", inspection)
+	}
+	if info := inspection.snapshots[0]; !info.Received || info.Completed || info.RecordedTokens != 8 {
+		t.Fatalf("Expected N1 to be recording snapshot 0 with 8 tokens, got:\n%v\n", inspection)
+	}
+	if _, err := stepper.Inspect("N4"); err == nil {
+		t.Fatalf("Expected an error inspecting an unknown server\n")
+	}
+
+	steps, ok := stepper.RunUntil(func(sim *Simulator) bool {
+		return sim.servers["N2"].Tokens == 5
+	})
+	if !ok || steps == 0 || stepper.Steps() != steps {
+		t.Fatalf("Expected N2 to receive the tokens after some steps, got %v steps\n", steps)
+	}
+	for _, line := range stepper.Step() {
+		if !strings.HasPrefix(line, "Time ") {
+			t.Fatalf("Unexpected event line: %v\n", line)
+		}
+	}
+	if _, ok := stepper.RunUntil(func(sim *Simulator) bool { return false }); ok {
+		t.Fatalf("Expected the simulation to run out of messages\n")
+	}
+	if !sim.servers["N3"].completedSnapshot[0] {
+		t.Fatalf("Expected the snapshot to complete once all messages were delivered\n")
+	}
+}
@@ -0,0 +1,137 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Token counts recorded on a server in two snapshots
+type TokenDiff struct {
+	before int
+	after  int
+}
+
+// Messages recorded on a channel in two snapshots
+type ChannelDiff struct {
+	before []interface{}
+	after  []interface{}
+}
+
+// The differences between two snapshot states.
+// Only the servers and channels whose recorded state differ are included.
+type SnapshotDiff struct {
+	beforeId int
+	afterId  int
+	tokens   map[string]TokenDiff      // key = server ID
+	channels map[ChannelId]ChannelDiff // key = channel
+}
+
+// Return true if the two snapshots recorded the same state
+func (d *SnapshotDiff) Empty() bool {
+	return len(d.tokens) == 0 && len(d.channels) == 0
+}
+
+// Describe the differences one per line, servers first then channels, e.g.
+//
+//	Snapshot 0 -> 1:
+//		N1: 10 -> 7 token(s)
+//		N1 -> N2: [token(3)] -> []
+func (d *SnapshotDiff) String() string {
+	if d.Empty() {
+		return fmt.Sprintf("Snapshot %v -> %v: no differences", d.beforeId, d.afterId)
+	}
+	lines := []string{fmt.Sprintf("Snapshot %v -> %v:", d.beforeId, d.afterId)}
+	serverIds := make([]string, 0)
+	for serverId := range d.tokens {
+		serverIds = append(serverIds, serverId)
+	}
+	sort.Strings(serverIds)
+	for _, serverId := range serverIds {
+		tokens := d.tokens[serverId]
+		lines = append(lines, fmt.Sprintf("\t%v: %v -> %v token(s)",
+			serverId, tokens.before, tokens.after))
+	}
+	channels := make([]ChannelId, 0)
+	for channel := range d.channels {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].String() < channels[j].String()
+	})
+	for _, channel := range channels {
+		messages := d.channels[channel]
+		lines = append(lines, fmt.Sprintf("\t%v: %v -> %v",
+			channel, formatMessages(messages.before), formatMessages(messages.after)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatMessages(messages []interface{}) string {
+	formatted := make([]string, 0)
+	for _, message := range messages {
+		formatted = append(formatted, fmt.Sprintf("%v", message))
+	}
+	return fmt.Sprintf("[%v]", strings.Join(formatted, " "))
+}
+
+// Compare the state recorded in two snapshots.
+// Servers missing from a snapshot are treated as having no tokens.
+func DiffSnapshots(a, b *SnapshotState) *SnapshotDiff {
+	diff := SnapshotDiff{a.id, b.id, make(map[string]TokenDiff), make(map[ChannelId]ChannelDiff)}
+	for serverId, tokens := range a.tokens {
+		if b.tokens[serverId] != tokens {
+			diff.tokens[serverId] = TokenDiff{tokens, b.tokens[serverId]}
+		}
+	}
+	for serverId, tokens := range b.tokens {
+		if _, ok := a.tokens[serverId]; !ok && tokens != 0 {
+			diff.tokens[serverId] = TokenDiff{0, tokens}
+		}
+	}
+	beforeChannels := channelMessages(a)
+	afterChannels := channelMessages(b)
+	for channel, before := range beforeChannels {
+		if after := afterChannels[channel]; !reflect.DeepEqual(before, after) {
+			diff.channels[channel] = ChannelDiff{before, after}
+		}
+	}
+	for channel, after := range afterChannels {
+		if _, ok := beforeChannels[channel]; !ok {
+			diff.channels[channel] = ChannelDiff{nil, after}
+		}
+	}
+	return &diff
+}
+
+// Group the messages recorded in a snapshot by channel, preserving their order
+func channelMessages(s *SnapshotState) map[ChannelId][]interface{} {
+	channels := make(map[ChannelId][]interface{})
+	for _, msg := range s.messages {
+		channel := ChannelId{msg.src, msg.dest}
+		channels[channel] = append(channels[channel], msg.message)
+	}
+	for _, channel := range s.aggregatedChannels() {
+		channels[channel] = append(channels[channel], *s.aggregated[channel])
+	}
+	return channels
+}
+
+// Compute the differences between each collected snapshot and the one before
+// it, in the given order. All the snapshots must have been collected already.
+func (sim *Simulator) SnapshotDeltas(ids []int) ([]SnapshotDiff, error) {
+	deltas := make([]SnapshotDiff, 0)
+	snaps := make([]*SnapshotState, 0)
+	for _, snapshotId := range ids {
+		snap, err := sim.collectedSnapshot(snapshotId)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+	for i := 1; i < len(snaps); i++ {
+		deltas = append(deltas, *DiffSnapshots(snaps[i-1], snaps[i]))
+	}
+	return deltas, nil
+}
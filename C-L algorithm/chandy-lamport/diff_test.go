@@ -0,0 +1,71 @@
+package chandy_lamport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotDeltas(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	census := func(snapshotId int) {
+		if _, err := sim.Census(snapshotId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	census(0)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	sim.Drain()
+	census(1)
+	sim.InjectEvent(PassTokenEvent{"N2", "N3", 1})
+	sim.Drain()
+	census(2)
+	if _, err := sim.SnapshotDeltas([]int{0, 3}); err == nil {
+		t.Fatalf("Expected an error for a snapshot that was not collected\n")
+	}
+	deltas, err := sim.SnapshotDeltas([]int{0, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 2 {
+		t.Fatalf("Expected 2 deltas, got %v\n", len(deltas))
+	}
+	expected := []map[string]TokenDiff{
+		{"N1": {10, 7}, "N2": {3, 6}},
+		{"N2": {6, 5}, "N3": {0, 1}},
+	}
+	for i, delta := range deltas {
+		if !reflect.DeepEqual(expected[i], delta.tokens) || len(delta.channels) != 0 {
+			t.Fatalf("Delta %v: expected tokens %v, got %v and channels %v\n",
+				i, expected[i], delta.tokens, delta.channels)
+		}
+	}
+}
+
+func TestSnapshotDiffString(t *testing.T) {
+	a := &SnapshotState{
+		id:     0,
+		tokens: map[string]int{"N1": 10, "N2": 3},
+		messages: []*SnapshotMessage{
+			{"N1", "N2", TokenMessage{numTokens: 3}, 0, 0},
+		},
+	}
+	b := &SnapshotState{
+		id:     1,
+		tokens: map[string]int{"N1": 7, "N2": 3, "N3": 2},
+		messages: []*SnapshotMessage{
+			{"N2", "N1", TokenMessage{numTokens: 1}, 0, 0},
+		},
+	}
+	expected := "Snapshot 0 -> 1:\n" +
+		"\tN1: 10 -> 7 token(s)\n" +
+		"\tN3: 0 -> 2 token(s)\n" +
+		"\tN1 -> N2: [token(3)] -> []\n" +
+		"\tN2 -> N1: [] -> [token(1)]"
+	if diff := DiffSnapshots(a, b).String(); diff != expected {
+		t.Fatalf("Expected diff:\n%v\ngot:\n%v\n", expected, diff)
+	}
+	if diff := DiffSnapshots(a, a).String(); diff != "Snapshot 0 -> 0: no differences" {
+		t.Fatalf("Expected no differences, got:\n%v\n", diff)
+	}
+}
@@ -0,0 +1,111 @@
+package chandy_lamport
+
+import (
+	"fmt"
+)
+
+// If enabled, servers send the local state they recorded for a snapshot to
+// their neighbors once they complete it, and forward the states they receive
+// from the others, so that every participant ends up with the whole global
+// state, see `Server.GlobalSnapshot`, as servers detecting a stable property
+// of the system would need. Every state is flooded on every link, so this
+// takes a message per state and link. The collection by the simulator is not
+// affected. Servers learn of the participants that crashed from the
+// simulator, as from a failure detector.
+func (sim *Simulator) SetDissemination(enabled bool) {
+	sim.disseminate = enabled
+}
+
+// A message carrying the local state a server recorded for a snapshot to the
+// other servers, see `SetDissemination`. It is not recorded by snapshots.
+type RecordedStateMessage struct {
+	snapshotId int
+	origin     string
+	state      *SnapshotState
+}
+
+func (m RecordedStateMessage) String() string {
+	return fmt.Sprintf("state(%v, %v)", m.snapshotId, m.origin)
+}
+
+// Return the global state of the snapshot as learned by this server from the
+// states disseminated by the others, or false if it has not heard of all the
+// participants yet. Disseminating must have been enabled when the snapshot
+// completed, see `SetDissemination`.
+func (server *Server) GlobalSnapshot(snapshotId int) (*SnapshotState, bool) {
+	snap, ok := server.globalSnapshot[snapshotId]
+	return snap, ok
+}
+
+// Send the local state the server recorded to its neighbors
+func (server *Server) disseminateState(snapshotId int) {
+	if !server.sim.disseminate {
+		return
+	}
+	snap := server.snapshot[snapshotId]
+	server.learnState(RecordedStateMessage{snapshotId, server.Id, snap})
+}
+
+// Keep a state disseminated by another server, and forward it the first time
+// it is received
+func (server *Server) handleRecordedState(message RecordedStateMessage) {
+	server.learnState(message)
+}
+
+// Keep a state the server had not heard of, forward it to the neighbors and
+// merge the global state once the states of all the participants are known
+func (server *Server) learnState(message RecordedStateMessage) {
+	known, ok := server.knownStates[message.snapshotId]
+	if !ok {
+		known = make(map[string]*SnapshotState)
+		server.knownStates[message.snapshotId] = known
+	}
+	if _, ok := known[message.origin]; ok {
+		return
+	}
+	known[message.origin] = message.state
+	server.SendToNeighbors(message)
+	server.mergeKnownStates(message.snapshotId)
+}
+
+// Merge the global state of the snapshot if the server knows the states of all
+// its participants. The participants that crashed are known from the states
+// the simulator recorded in their place.
+func (server *Server) mergeKnownStates(snapshotId int) {
+	sim := server.sim
+	known := server.knownStates[snapshotId]
+	collector, ok := sim.collectors[snapshotId]
+	if _, merged := server.globalSnapshot[snapshotId]; merged || !ok {
+		return
+	}
+	for _, rec := range collector.reported() {
+		for _, serverId := range rec.crashed {
+			if _, ok := known[serverId]; !ok {
+				known[serverId] = rec
+			}
+		}
+	}
+	if len(known) < sim.numParticipants(snapshotId) {
+		return
+	}
+	records := make([]*SnapshotState, 0, len(known))
+	for _, origin := range getSortedKeys(known) {
+		records = append(records, known[origin])
+	}
+	server.globalSnapshot[snapshotId] = sim.mergeSnapshot(snapshotId, records)
+}
+
+// Merge the global state of the snapshot on the servers that were only waiting
+// for the state of a server that crashed
+func (sim *Simulator) mergeAfterExclusion(snapshotId int) {
+	if !sim.disseminate {
+		return
+	}
+	for _, serverId := range sim.sortedServerIds() {
+		if server := sim.servers[serverId]; !server.crashed {
+			if _, ok := server.knownStates[snapshotId]; ok {
+				server.mergeKnownStates(snapshotId)
+			}
+		}
+	}
+}
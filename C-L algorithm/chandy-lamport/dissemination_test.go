@@ -0,0 +1,91 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDissemination(t *testing.T) {
+	for _, algorithm := range []SnapshotAlgorithm{ChandyLamport, LaiYang, Mattern} {
+		rand.Seed(8053172852482175524)
+		sim := NewSimulator()
+		sim.SetSnapshotAlgorithm(algorithm)
+		sim.SetDissemination(true)
+		readTopology("8nodes.top", sim)
+		sim.InjectEvent(PassTokenEvent{"N1", "N5", 3})
+		sim.StartSnapshot("N1")
+		sim.InjectEvent(PassTokenEvent{"N2", "N3", 2})
+		for sim.finishedMap[0] < sim.numParticipants(0) {
+			sim.Tick()
+		}
+		if _, ok := sim.servers["N7"].GlobalSnapshot(0); ok {
+			t.Fatalf("%v: expected N7 not to know the global state before the states reach it\n", algorithm)
+		}
+		sim.Drain()
+		collected := sim.CollectSnapshot(0)
+		for _, serverId := range sim.sortedServerIds() {
+			snap, ok := sim.servers[serverId].GlobalSnapshot(0)
+			if !ok {
+				t.Fatalf("%v: expected %v to know the global state\n", algorithm, serverId)
+			}
+			if diff := DiffSnapshots(collected, snap); !diff.Empty() {
+				t.Fatalf("%v: expected %v to know the collected state:\n%v\n", algorithm, serverId, diff)
+			}
+		}
+	}
+}
+
+func TestDisseminationCrash(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetDissemination(true)
+	readTopology("3nodes.top", sim)
+	sim.CrashServer("N3")
+	sim.StartSnapshot("N1")
+	sim.servers["N1"].SetSnapshotDeadline(0, 20)
+	sim.servers["N2"].SetSnapshotDeadline(0, 20)
+	for i := 0; i < 30; i++ {
+		sim.Tick()
+	}
+	for _, serverId := range []string{"N1", "N2"} {
+		snap, ok := sim.servers[serverId].GlobalSnapshot(0)
+		if !ok {
+			t.Fatalf("Expected %v to know the global state without N3\n", serverId)
+		}
+		if crashed := snap.CrashedServers(); !reflect.DeepEqual(crashed, []string{"N3"}) {
+			t.Fatalf("Expected %v to know that N3 crashed, got %v\n", serverId, crashed)
+		}
+	}
+}
+
+func TestSaveDissemination(t *testing.T) {
+	sim := NewSimulatorWithSeed(8053172852482175524)
+	sim.SetDissemination(true)
+	readTopology("8nodes.top", sim)
+	sim.StartSnapshot("N1")
+	for sim.finishedMap[0] == 0 {
+		sim.Tick()
+	}
+	var buf bytes.Buffer
+	if err := sim.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSimulator(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Drain()
+	loaded.Drain()
+	for _, serverId := range sim.sortedServerIds() {
+		want, _ := sim.servers[serverId].GlobalSnapshot(0)
+		got, ok := loaded.servers[serverId].GlobalSnapshot(0)
+		if !ok {
+			t.Fatalf("Expected %v to know the global state in the loaded simulation\n", serverId)
+		}
+		if diff := DiffSnapshots(want, got); !diff.Empty() {
+			t.Fatalf("Expected %v to know the same global state:\n%v\n", serverId, diff)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Write the topology as a Graphviz DOT graph, with one node per server labeled
+// with its current token count. `nodeAttrs` returns extra attributes for a node,
+// and `edgeAttrs`, if not nil, returns the attributes of the edge of a link.
+func (sim *Simulator) writeDOT(w io.Writer, nodeAttrs func(server *Server) string,
+	edgeAttrs func(channel ChannelId) string) {
+	fmt.Fprintln(w, "digraph simulator {")
+	for _, serverId := range getSortedKeys(sim.servers) {
+		server := sim.servers[serverId]
+		attrs := fmt.Sprintf("label=\"%v\\n%v tokens\"", server.Id, server.Tokens)
+		if extra := nodeAttrs(server); extra != "" {
+			attrs += ", " + extra
+		}
+		fmt.Fprintf(w, "\t\"%v\" [%v];\n", server.Id, attrs)
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		for _, dest := range getSortedKeys(sim.servers[serverId].outboundLinks) {
+			attrs := ""
+			if edgeAttrs != nil {
+				attrs = edgeAttrs(ChannelId{serverId, dest})
+			}
+			if attrs == "" {
+				fmt.Fprintf(w, "\t\"%v\" -> \"%v\";\n", serverId, dest)
+			} else {
+				fmt.Fprintf(w, "\t\"%v\" -> \"%v\" [%v];\n", serverId, dest, attrs)
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// Export the topology as a Graphviz DOT graph, coloring every server by its
+// phase in the given snapshot: gray if it has not started the snapshot yet,
+// yellow while it is recording and green once its local snapshot completed.
+func (sim *Simulator) ExportDOTForSnapshot(snapshotId int) string {
+	var b bytes.Buffer
+	sim.writeDOT(&b, func(server *Server) string {
+		color := "gray"
+		if server.completedSnapshot[snapshotId] {
+			color = "green"
+		} else if server.receivedSnapshot[snapshotId] {
+			color = "yellow"
+		}
+		return fmt.Sprintf("style=filled, fillcolor=%v", color)
+	}, nil)
+	return b.String()
+}
+
+// Write the topology as a Graphviz DOT graph showing the state captured by a
+// collected snapshot. Every server is labeled with its current token count and
+// the tokens it recorded. Every channel on which messages were recorded is
+// drawn in blue and labeled with the number of tokens recorded on it, channels
+// closed by a deadline are dashed and channels that were not recorded are gray.
+func (sim *Simulator) ExportDOT(w io.Writer, snapshotId int) error {
+	snap, ok := sim.collected.Load(snapshotId)
+	if !ok {
+		return fmt.Errorf("Snapshot %v has not been collected", snapshotId)
+	}
+	recorded := snap.ChannelSummary()
+	unknown := make(map[ChannelId]bool)
+	for _, channel := range snap.unknownChannels {
+		unknown[channel] = true
+	}
+	messages := channelMessages(snap)
+	sim.writeDOT(w, func(server *Server) string {
+		tokens, ok := snap.tokens[server.Id]
+		if !ok {
+			return "xlabel=\"not recorded\""
+		}
+		return fmt.Sprintf("xlabel=\"recorded %v\"", tokens)
+	}, func(channel ChannelId) string {
+		if unknown[channel] {
+			return "style=dashed, label=\"unknown\""
+		}
+		tokens, ok := recorded[channel]
+		if !ok {
+			return "color=gray"
+		}
+		if len(messages[channel]) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("color=blue, penwidth=2, label=\"%v tokens\"", tokens)
+	})
+	return nil
+}
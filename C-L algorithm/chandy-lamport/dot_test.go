@@ -0,0 +1,61 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestExportDOTForSnapshot(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("5nodes-line.top", sim)
+	snapshotId := sim.nextSnapshotId
+	// Keep the snapshot from spreading past N2
+	sim.FreezeLink("N2", "N3")
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	dot := sim.ExportDOTForSnapshot(snapshotId)
+	expected := []string{
+		"\"N1\" [label=\"N1\\n10 tokens\", style=filled, fillcolor=green];",
+		"\"N2\" [label=\"N2\\n0 tokens\", style=filled, fillcolor=yellow];",
+		"\"N3\" [label=\"N3\\n0 tokens\", style=filled, fillcolor=gray];",
+		"\"N5\" [label=\"N5\\n0 tokens\", style=filled, fillcolor=gray];",
+		"\"N4\" -> \"N5\";",
+	}
+	for _, line := range expected {
+		if !strings.Contains(dot, line) {
+			t.Fatalf("Expected DOT output to contain\n%v\ngot\n%v\n", line, dot)
+		}
+	}
+}
+
+func TestExportDOT(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("2nodes.top", sim)
+	var b bytes.Buffer
+	if err := sim.ExportDOT(&b, 0); err == nil {
+		t.Fatalf("Expected an error exporting a snapshot that was not collected\n")
+	}
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.StartSnapshot("N2")
+	sim.Drain()
+	sim.CollectSnapshot(0)
+	if err := sim.ExportDOT(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+	dot := b.String()
+	expected := []string{
+		"\"N1\" [label=\"N1\\n0 tokens\", xlabel=\"recorded 0\"];",
+		"\"N2\" [label=\"N2\\n1 tokens\", xlabel=\"recorded 0\"];",
+		"\"N1\" -> \"N2\" [color=blue, penwidth=2, label=\"1 tokens\"];",
+		"\"N2\" -> \"N1\";",
+	}
+	for _, line := range expected {
+		if !strings.Contains(dot, line) {
+			t.Fatalf("Expected DOT output to contain\n%v\ngot\n%v\n", line, dot)
+		}
+	}
+}
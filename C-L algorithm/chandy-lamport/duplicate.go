@@ -0,0 +1,103 @@
+package chandy_lamport
+
+import "fmt"
+
+// A copy of a message delivered again on a link that delivers some messages
+// more than once, see `InjectDuplication`. It is unwrapped before delivery, so
+// the destination cannot tell it from the original.
+type duplicateCopy struct {
+	message interface{}
+}
+
+func (m duplicateCopy) String() string {
+	return fmt.Sprintf("copy of %v", m.message)
+}
+
+// A message that signifies a message being queued again on a link that
+// delivers messages more than once
+// This is used only for debugging that is not sent between servers
+type DuplicatedMessageEvent struct {
+	src     string
+	dest    string
+	message interface{}
+}
+
+func (m DuplicatedMessageEvent) String() string {
+	return fmt.Sprintf("%v -> %v duplicated %v", m.src, m.dest, m.message)
+}
+
+// Make a link deliver messages more than once, for at-least-once semantics:
+// every message delivered on the link, including copies, is queued again on
+// the link with the given probability, with a new delay. The copies are logged
+// as a `DuplicatedMessageEvent`, and the tokens they carry are counted by
+// `DuplicatedTokens`. A probability of 0 delivers every message exactly once.
+func (sim *Simulator) InjectDuplication(src, dest string, probability float64) error {
+	server, ok := sim.servers[src]
+	if !ok {
+		return newError(ErrUnknownServer, "Server %v does not exist", src)
+	}
+	link, ok := server.outboundLinks[dest]
+	if !ok {
+		return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, src)
+	}
+	if probability < 0 || probability > 1 {
+		return fmt.Errorf("Expected a probability between 0 and 1, got %v", probability)
+	}
+	link.duplicateProbability = probability
+	return nil
+}
+
+// Return the total number of tokens carried by the copies of messages queued
+// on links that deliver messages more than once
+func (sim *Simulator) DuplicatedTokens() int {
+	return sim.duplicatedTokens
+}
+
+// Queue a copy of a message delivered on the link, if the link duplicates it
+func (sim *Simulator) duplicateOn(link *Link, e SendMessageEvent) {
+	if link.duplicateProbability == 0 || sim.random.Float64() >= link.duplicateProbability {
+		return
+	}
+	sim.logger.RecordEvent(sim.servers[e.src], DuplicatedMessageEvent{e.src, e.dest, e.message})
+	if message, isToken := tokenMessage(e.message); isToken {
+		sim.duplicatedTokens += message.numTokens
+	}
+	link.events.Push(SendMessageEvent{
+		e.src,
+		e.dest,
+		duplicateCopy{e.message},
+		sim.receiveTimeOn(link),
+		sim.time,
+		e.lamport})
+}
+
+// Deliver a copy of a message, remembering the channel messages recorded by the
+// destination while handling it
+func (sim *Simulator) deliverDuplicate(e SendMessageEvent) {
+	server := sim.servers[e.dest]
+	server.handlingDuplicate = true
+	defer func() { server.handlingDuplicate = false }()
+	sim.deliver(e)
+}
+
+// Verify that a collected snapshot does not record a message on a channel more
+// than once, because the link delivered copies of it before the marker
+func (sim *Simulator) AssertNoDoubleCounting(snapshotId int) error {
+	snap, err := sim.collectedSnapshot(snapshotId)
+	if err != nil {
+		return err
+	}
+	for _, msg := range snap.messages {
+		if sim.recordedDuplicates[msg] {
+			return fmt.Errorf("Snapshot %v recorded a copy of %v on channel %v -> %v, which counts it twice",
+				snapshotId, msg.message, msg.src, msg.dest)
+		}
+	}
+	for _, channel := range snap.aggregatedChannels() {
+		if a := snap.aggregated[channel]; a.duplicates > 0 {
+			return fmt.Errorf("Snapshot %v recorded %v copied message(s) on channel %v, which counts them twice",
+				snapshotId, a.duplicates, channel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateDelivery(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.EnableInvariantChecks()
+	if err := sim.InjectDuplication("N1", "N2", 1); err != nil {
+		t.Fatal(err)
+	}
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 2})
+	// Every delivery queues another copy, so stop duplicating after the first
+	for sim.DuplicatedTokens() == 0 {
+		sim.Tick()
+	}
+	sim.InjectDuplication("N1", "N2", 0)
+	sim.Drain()
+	if tokens := sim.servers["N2"].Tokens; tokens != 3+4 {
+		t.Fatalf("Expected N2 to receive the tokens twice, has %v\n", tokens)
+	}
+	if got := sim.TotalTokens(); got != sim.InitialTokens()+sim.DuplicatedTokens() {
+		t.Fatalf("Expected %v tokens, servers hold %v\n", sim.InitialTokens()+sim.DuplicatedTokens(), got)
+	}
+	found := false
+	for _, line := range sim.EventLog() {
+		found = found || strings.Contains(line, "N1 -> N2 duplicated token(2)")
+	}
+	if !found {
+		t.Fatalf("Expected the copy to be logged\n")
+	}
+}
+
+func TestDoubleCountedChannelMessage(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectDuplication("N1", "N2", 1)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 2})
+	// Hold the markers of N2 back, so N2 records the token and its copy
+	// on the channel from N1 before N1 sends its marker
+	sim.StartSnapshot("N2")
+	sim.FreezeLink("N2", "N1")
+	sim.FreezeLink("N2", "N3")
+	for sim.DuplicatedTokens() == 0 {
+		sim.Tick()
+	}
+	sim.InjectDuplication("N1", "N2", 0)
+	for mustGetLink(sim, "N1", "N2").events.Len() > 0 {
+		sim.Tick()
+	}
+	sim.UnfreezeLink("N2", "N1")
+	sim.UnfreezeLink("N2", "N3")
+	sim.Drain()
+	sim.CollectSnapshot(0)
+	err := sim.AssertNoDoubleCounting(0)
+	if err == nil || !strings.Contains(err.Error(), "copy of token(2)") {
+		t.Fatalf("Expected the copy to be flagged, got %v\n", err)
+	}
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err == nil {
+		t.Fatalf("Expected the snapshot to be invalid\n")
+	}
+	if err := sim.InjectDuplication("N1", "N4", 0.5); !errors.Is(err, ErrUnknownDest) {
+		t.Fatalf("Expected ErrUnknownDest, got %v\n", err)
+	}
+}
@@ -0,0 +1,40 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of invalid operations on servers and simulators. Errors returned by
+// the package match one of these with `errors.Is` when applicable, e.g.
+//
+//	if errors.Is(server.SendTokens(5, "N2"), ErrInsufficientTokens) { ... }
+var (
+	ErrUnknownServer          = errors.New("Unknown server")
+	ErrUnknownDest            = errors.New("Unknown dest")
+	ErrInsufficientTokens     = errors.New("Insufficient tokens")
+	ErrSnapshotAlreadyStarted = errors.New("Snapshot already started")
+	ErrServerCrashed          = errors.New("Server crashed")
+	ErrUnknownSnapshot        = errors.New("Unknown snapshot")
+	ErrServerExists           = errors.New("Server already exists")
+	ErrServerNotCrashed       = errors.New("Server not crashed")
+)
+
+// An error of one of the kinds above, with a message describing the operation
+// that failed
+type opError struct {
+	kind    error
+	message string
+}
+
+func newError(kind error, format string, a ...interface{}) error {
+	return &opError{kind, fmt.Sprintf(format, a...)}
+}
+
+func (e *opError) Error() string {
+	return e.message
+}
+
+func (e *opError) Unwrap() error {
+	return e.kind
+}
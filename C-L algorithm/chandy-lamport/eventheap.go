@@ -0,0 +1,138 @@
+package chandy_lamport
+
+import "container/heap"
+
+// A priority queue of send message events, popped in the order of their
+// receive times. Events received at the same time step are popped in the order
+// they were sent, then in the order they were pushed, so popping is
+// deterministic. It has the same interface as `Queue[SendMessageEvent]`.
+type EventHeap struct {
+	events eventsByReceiveTime
+	// Number of events pushed so far, which orders the events pushed at the
+	// same time steps
+	pushed int
+}
+
+// An event along with the order in which it was pushed
+type heapEntry struct {
+	event SendMessageEvent
+	seq   int
+}
+
+// Implementation of `heap.Interface` for `EventHeap`
+type eventsByReceiveTime []heapEntry
+
+func (h eventsByReceiveTime) Len() int {
+	return len(h)
+}
+
+func (h eventsByReceiveTime) Less(i, j int) bool {
+	if h[i].event.receiveTime != h[j].event.receiveTime {
+		return h[i].event.receiveTime < h[j].event.receiveTime
+	}
+	if h[i].event.sendTime != h[j].event.sendTime {
+		return h[i].event.sendTime < h[j].event.sendTime
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h eventsByReceiveTime) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *eventsByReceiveTime) Push(v interface{}) {
+	*h = append(*h, v.(heapEntry))
+}
+
+func (h *eventsByReceiveTime) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+func NewEventHeap() *EventHeap {
+	return &EventHeap{make(eventsByReceiveTime, 0), 0}
+}
+
+func (h *EventHeap) Empty() bool {
+	return h.events.Len() == 0
+}
+
+func (h *EventHeap) Push(e SendMessageEvent) {
+	heap.Push(&h.events, heapEntry{e, h.pushed})
+	h.pushed++
+}
+
+func (h *EventHeap) Len() int {
+	return h.events.Len()
+}
+
+func (h *EventHeap) Pop() SendMessageEvent {
+	return heap.Pop(&h.events).(heapEntry).event
+}
+
+func (h *EventHeap) Peek() SendMessageEvent {
+	return h.events[0].event
+}
+
+// The order in which the messages due at a time step are delivered
+type DeliveryOrder int
+
+const (
+	// Go through the senders in the order of their IDs, and deliver the first
+	// message due on their links, in the order of their destinations
+	SenderOrder DeliveryOrder = iota
+	// Deliver the messages due in the order of their receive times across all
+	// the links, where every sender still delivers at most one message per
+	// time step: the one it sent first among the earliest due on its links
+	ReceiveTimeOrder
+)
+
+func (o DeliveryOrder) String() string {
+	switch o {
+	case SenderOrder:
+		return "sender order"
+	case ReceiveTimeOrder:
+		return "receive time order"
+	}
+	return "unknown order"
+}
+
+// Select the order in which the messages due at every time step are delivered.
+// Both orders are deterministic, but they interleave the deliveries
+// differently, so a run with the same seed records different snapshots.
+func (sim *Simulator) SetDeliveryOrder(order DeliveryOrder) {
+	sim.deliveryOrder = order
+}
+
+// Deliver the messages due at the current time step in the order of their
+// receive times
+func (sim *Simulator) deliverByReceiveTime() {
+	due := NewEventHeap()
+	links := make(map[ChannelId]*Link)
+	for _, serverId := range sim.sortedServerIds() {
+		var next *Link
+		nextIndex := 0
+		var nextEvent SendMessageEvent
+		for _, link := range sim.servers[serverId].deliveringLinks() {
+			if link.blocked() || sim.servers[link.dest].crashed {
+				continue
+			}
+			i, e, ok := link.peekDeliverable(sim.time, sim.random)
+			if ok && (next == nil || e.receiveTime < nextEvent.receiveTime ||
+				(e.receiveTime == nextEvent.receiveTime && e.sendTime < nextEvent.sendTime)) {
+				next, nextIndex, nextEvent = link, i, e
+			}
+		}
+		if next != nil {
+			next.events.Remove(nextIndex)
+			due.Push(nextEvent)
+			links[ChannelId{next.src, next.dest}] = next
+		}
+	}
+	for !due.Empty() {
+		e := due.Pop()
+		sim.deliverOn(links[ChannelId{e.src, e.dest}], e)
+	}
+}
@@ -0,0 +1,58 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestEventHeap(t *testing.T) {
+	h := NewEventHeap()
+	h.Push(SendMessageEvent{"N1", "N2", TokenMessage{numTokens: 1}, 5, 0, 0})
+	h.Push(SendMessageEvent{"N2", "N3", TokenMessage{numTokens: 2}, 3, 1, 0})
+	h.Push(SendMessageEvent{"N3", "N1", TokenMessage{numTokens: 3}, 3, 0, 0})
+	h.Push(SendMessageEvent{"N1", "N3", TokenMessage{numTokens: 4}, 3, 1, 0})
+	if h.Len() != 4 || h.Peek().src != "N3" {
+		t.Fatalf("Expected the earliest event to come first, got %v\n", h.Peek())
+	}
+	// Ties are broken by send time, then by push order
+	for _, expected := range []int{3, 2, 4, 1} {
+		e := h.Pop()
+		if e.message.(TokenMessage).numTokens != expected {
+			t.Fatalf("Expected token(%v) to be popped, got %v\n", expected, e.message)
+		}
+	}
+	if !h.Empty() {
+		t.Fatalf("Expected the heap to be empty\n")
+	}
+}
+
+func TestReceiveTimeOrder(t *testing.T) {
+	for _, test := range []struct {
+		order    DeliveryOrder
+		receiver string
+	}{
+		{SenderOrder, "N2"},
+		{ReceiveTimeOrder, "N3"},
+	} {
+		rand.Seed(8053172852482175524)
+		sim := NewSimulator()
+		readTopology("3nodes.top", sim)
+		sim.SetDeliveryOrder(test.order)
+		mustGetLink(sim, "N1", "N2").SetLatencyModel(NewConstantLatency(2))
+		mustGetLink(sim, "N1", "N3").SetLatencyModel(NewConstantLatency(1))
+		sim.InjectEvent(PassTokenEvent{"N1", "N3", 1})
+		sim.InjectEvent(PassTokenEvent{"N1", "N3", 1})
+		sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+		sim.Tick()
+		// Both links have a message due, but N1 delivers only one of them
+		before := map[string]int{"N2": sim.servers["N2"].Tokens, "N3": sim.servers["N3"].Tokens}
+		sim.Tick()
+		for _, serverId := range []string{"N2", "N3"} {
+			received := sim.servers[serverId].Tokens - before[serverId]
+			if (serverId == test.receiver) != (received == 1) {
+				t.Fatalf("%v: expected %v to receive the token, %v received %v\n",
+					test.order, test.receiver, serverId, received)
+			}
+		}
+	}
+}
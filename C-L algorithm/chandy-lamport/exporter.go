@@ -0,0 +1,175 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// An HTTP server exposing the metrics of a simulation on /metrics in the
+// Prometheus text format, see `Simulator.EnableMetricsServer`. The simulation
+// is not safe for concurrent use, so the page is rendered by the simulation
+// itself after every tick, and the server only hands out the latest one.
+type MetricsServer struct {
+	lock     sync.Mutex
+	page     string
+	listener net.Listener
+	server   *http.Server
+	// IDs of the servers whose metrics are exported, or nil for all of them
+	serverIds []string
+	// Stop the simulation from rendering the page, see `Close`
+	detach func()
+}
+
+// Upper bounds of the buckets of the snapshot duration histogram, in ticks
+var snapshotDurationBuckets = []int{1, 2, 5, 10, 20, 50, 100, 200, 500}
+
+// Start serving the metrics of the simulation on the given address, e.g.
+// "localhost:9090". The metrics are refreshed after every tick.
+func (sim *Simulator) EnableMetricsServer(addr string) (*MetricsServer, error) {
+	if sim.exporter != nil {
+		return nil, fmt.Errorf("Metrics are already served on %v", sim.exporter.Addr())
+	}
+	exporter, err := newMetricsServer(addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	exporter.detach = func() {
+		if sim.exporter == exporter {
+			sim.exporter = nil
+		}
+	}
+	sim.exporter = exporter
+	sim.refreshMetrics()
+	return exporter, nil
+}
+
+// Start serving the metrics of the local server on the given address. Peers
+// are not exported, since their state is only known to their own process.
+func (ns *NetworkServer) EnableMetricsServer(addr string) (*MetricsServer, error) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	if ns.sim.exporter != nil {
+		return nil, fmt.Errorf("Metrics are already served on %v", ns.sim.exporter.Addr())
+	}
+	exporter, err := newMetricsServer(addr, []string{ns.server.Id})
+	if err != nil {
+		return nil, err
+	}
+	exporter.detach = func() {
+		ns.lock.Lock()
+		defer ns.lock.Unlock()
+		if ns.sim.exporter == exporter {
+			ns.sim.exporter = nil
+		}
+	}
+	ns.sim.exporter = exporter
+	ns.sim.refreshMetrics()
+	return exporter, nil
+}
+
+func newMetricsServer(addr string, serverIds []string) (*MetricsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	exporter := &MetricsServer{listener: listener, serverIds: serverIds}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		exporter.lock.Lock()
+		page := exporter.page
+		exporter.lock.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, page)
+	})
+	exporter.server = &http.Server{Handler: mux}
+	go exporter.server.Serve(listener)
+	return exporter, nil
+}
+
+// Return the address the metrics are served on
+func (m *MetricsServer) Addr() string {
+	return m.listener.Addr().String()
+}
+
+// Stop serving the metrics. The simulation no longer renders them, and they
+// can be served again with `EnableMetricsServer`. Close the metrics of a
+// simulation from the goroutine driving it.
+func (m *MetricsServer) Close() error {
+	m.detach()
+	return m.server.Close()
+}
+
+// Render the metrics page of the simulation, if they are exported
+func (sim *Simulator) refreshMetrics() {
+	if sim.exporter == nil {
+		return
+	}
+	serverIds := sim.exporter.serverIds
+	if serverIds == nil {
+		serverIds = getSortedKeys(sim.servers)
+	}
+	page := sim.renderMetrics(serverIds)
+	sim.exporter.lock.Lock()
+	sim.exporter.page = page
+	sim.exporter.lock.Unlock()
+}
+
+// Render the metrics of the given servers in the Prometheus text format
+func (sim *Simulator) renderMetrics(serverIds []string) string {
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+	}
+	metric("clsim_server_tokens", "gauge", "Number of tokens held by a server.")
+	for _, serverId := range serverIds {
+		fmt.Fprintf(&b, "clsim_server_tokens{server=%q} %v\n", serverId, sim.servers[serverId].Tokens)
+	}
+	metric("clsim_link_queue_depth", "gauge", "Number of messages queued on a link.")
+	for _, serverId := range serverIds {
+		server := sim.servers[serverId]
+		for _, dest := range getSortedKeys(server.outboundLinks) {
+			fmt.Fprintf(&b, "clsim_link_queue_depth{src=%q,dest=%q} %v\n",
+				serverId, dest, len(server.outboundLinks[dest].events.Elements()))
+		}
+	}
+	metrics := sim.Metrics()
+	// A histogram rather than one series per snapshot, so that the number of
+	// series stays the same however long the simulation runs
+	metric("clsim_snapshot_duration_ticks", "histogram",
+		"Number of ticks between the initiation of a snapshot and its completion on a server.")
+	durations := newLatencyHistogram()
+	for _, latencies := range metrics.SnapshotLatency {
+		for _, serverId := range serverIds {
+			if latency, ok := latencies[serverId]; ok {
+				durations.observe(latency)
+			}
+		}
+	}
+	for _, serverId := range serverIds {
+		if pruned, ok := sim.metrics.prunedLatency[serverId]; ok {
+			durations.add(pruned)
+		}
+	}
+	for i, bound := range snapshotDurationBuckets {
+		fmt.Fprintf(&b, "clsim_snapshot_duration_ticks_bucket{le=\"%v\"} %v\n", bound, durations.counts[i])
+	}
+	fmt.Fprintf(&b, "clsim_snapshot_duration_ticks_bucket{le=\"+Inf\"} %v\n", durations.count)
+	fmt.Fprintf(&b, "clsim_snapshot_duration_ticks_sum %v\n", durations.sum)
+	fmt.Fprintf(&b, "clsim_snapshot_duration_ticks_count %v\n", durations.count)
+	inProgress := 0
+	for _, snapshotId := range sim.SnapshotIds() {
+		if sim.finishedMap[snapshotId] < sim.numParticipants(snapshotId) {
+			inProgress++
+		}
+	}
+	metric("clsim_snapshots_in_progress", "gauge", "Number of snapshots that have not completed on every server.")
+	fmt.Fprintf(&b, "clsim_snapshots_in_progress %v\n", inProgress)
+	metric("clsim_messages_sent_total", "counter", "Number of messages sent, including markers.")
+	fmt.Fprintf(&b, "clsim_messages_sent_total %v\n", metrics.MessagesSent)
+	metric("clsim_markers_sent_total", "counter", "Number of messages sent by the snapshot algorithm.")
+	fmt.Fprintf(&b, "clsim_markers_sent_total %v\n", metrics.MarkersSent)
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package chandy_lamport
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func fetchMetrics(t *testing.T, exporter *MetricsServer) string {
+	resp, err := http.Get("http://" + exporter.Addr() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsServer(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	exporter, err := sim.EnableMetricsServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	if _, err := sim.EnableMetricsServer("127.0.0.1:0"); err == nil {
+		t.Fatalf("Expected enabling the metrics server twice to fail\n")
+	}
+	if page := fetchMetrics(t, exporter); !strings.Contains(page, `clsim_server_tokens{server="N1"} 10`) {
+		t.Fatalf("Expected the initial tokens of N1 to be exported, got:\n%v\n", page)
+	}
+
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	sim.Tick()
+	page := fetchMetrics(t, exporter)
+	expected := []string{
+		"# TYPE clsim_link_queue_depth gauge",
+		`clsim_link_queue_depth{src="N1",dest="N2"} 1`,
+		"clsim_messages_sent_total 1",
+	}
+	for _, line := range expected {
+		if !strings.Contains(page, line) {
+			t.Fatalf("Expected %q in the metrics, got:\n%v\n", line, page)
+		}
+	}
+
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	page = fetchMetrics(t, exporter)
+	for _, line := range []string{
+		`clsim_server_tokens{server="N1"} 7`,
+		`clsim_server_tokens{server="N2"} 6`,
+		"# TYPE clsim_snapshot_duration_ticks histogram",
+		`clsim_snapshot_duration_ticks_bucket{le="+Inf"} 3`,
+		"clsim_snapshot_duration_ticks_count 3",
+		"clsim_snapshots_in_progress 0",
+	} {
+		if !strings.Contains(page, line) {
+			t.Fatalf("Expected %q in the metrics, got:\n%v\n", line, page)
+		}
+	}
+	// Closing the metrics server detaches it from the simulation
+	exporter.Close()
+	if sim.exporter != nil {
+		t.Fatalf("Expected the simulation to stop rendering the metrics\n")
+	}
+	again, err := sim.EnableMetricsServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+}
@@ -0,0 +1,66 @@
+package chandy_lamport
+
+// Misbehavior of a faulty server, see `Simulator.MakeFaulty`
+type FaultSpec struct {
+	// Send every marker twice on each link
+	DuplicateMarkers bool
+	// If set, send markers for `ForgedSnapshotId` instead of the snapshot
+	// being recorded
+	ForgeMarkers     bool
+	ForgedSnapshotId int
+	// Number of tokens added to the tokens the server records in its local
+	// state, which may be negative
+	TokenError int
+}
+
+// A server that misbehaves in the snapshot protocol as described by its spec,
+// to test how the collector detects or is confused by faulty participants.
+// It stays in the simulation as a regular `Server`, and only the markers it
+// sends and the local state it records are affected.
+type FaultyServer struct {
+	*Server
+}
+
+// Make a server misbehave from now on as described by the spec. Markers only
+// exist in the Chandy-Lamport algorithm, so the other algorithms are only
+// affected by `TokenError`.
+func (sim *Simulator) MakeFaulty(id string, spec FaultSpec) (*FaultyServer, error) {
+	server, ok := sim.servers[id]
+	if !ok {
+		return nil, newError(ErrUnknownServer, "Server %v does not exist", id)
+	}
+	server.faults = &spec
+	return &FaultyServer{server}, nil
+}
+
+// Return how the server misbehaves
+func (f *FaultyServer) Spec() FaultSpec {
+	if f.faults == nil {
+		return FaultSpec{}
+	}
+	return *f.faults
+}
+
+// Make the server behave correctly again
+func (f *FaultyServer) Repair() {
+	f.faults = nil
+}
+
+// Return the markers sent in place of the given one on every link
+func (server *Server) faultyMarkers(marker MarkerMessage) []interface{} {
+	if server.faults.ForgeMarkers {
+		marker.snapshotId = server.faults.ForgedSnapshotId
+	}
+	if server.faults.DuplicateMarkers {
+		return []interface{}{marker, marker}
+	}
+	return []interface{}{marker}
+}
+
+// Return the number of tokens the server records in its local state
+func (server *Server) reportedTokens() int {
+	if server.faults == nil {
+		return server.Tokens
+	}
+	return server.Tokens + server.faults.TokenError
+}
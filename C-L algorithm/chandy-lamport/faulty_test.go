@@ -0,0 +1,74 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestFaultyDuplicateMarkers(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	if _, err := sim.MakeFaulty("N2", FaultSpec{DuplicateMarkers: true}); err != nil {
+		t.Fatal(err)
+	}
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	// The second marker on each link is ignored, so the snapshot is still valid
+	sim.CollectSnapshot(0)
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+	if sent := sim.Metrics().MarkersSent; sent != 8 {
+		t.Fatalf("Expected N2 to send 4 markers and the others 2 each, got %v\n", sent)
+	}
+}
+
+func TestFaultyTokenError(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	faulty, err := sim.MakeFaulty("N3", FaultSpec{TokenError: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	sim.CollectSnapshot(0)
+	// The collector detects that the tokens do not add up
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err == nil {
+		t.Fatalf("Expected the misreported tokens to be detected\n")
+	}
+	faulty.Repair()
+	if spec := faulty.Spec(); spec.TokenError != 0 {
+		t.Fatalf("Expected the server to be repaired, got %+v\n", spec)
+	}
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	sim.CollectSnapshot(1)
+	if err := sim.ValidateSnapshot(1, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFaultyForgedMarkers(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.MakeFaulty("N1", FaultSpec{ForgeMarkers: true, ForgedSnapshotId: 7})
+	sim.StartSnapshot("N1")
+	// No marker of snapshot 0 ever arrives from N1, so the snapshot never
+	// completes, and the others record a snapshot nobody started
+	if _, err := sim.CollectSnapshotWithTimeout(0, 20); err == nil {
+		t.Fatalf("Expected the snapshot to time out\n")
+	}
+	for _, serverId := range []string{"N2", "N3"} {
+		if !sim.servers[serverId].receivedSnapshot[7] {
+			t.Fatalf("Expected %v to record the forged snapshot\n", serverId)
+		}
+	}
+	if _, err := sim.MakeFaulty("N4", FaultSpec{}); !errors.Is(err, ErrUnknownServer) {
+		t.Fatalf("Expected ErrUnknownServer, got %v\n", err)
+	}
+}
@@ -0,0 +1,169 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// =======================================================
+//  Standard topologies, see `Topology.Build` to simulate
+// =======================================================
+//
+// Servers are named N1 to Nn and start without tokens. Tokens can be given to
+// them with `Topology.AddServer` before building the simulator.
+
+// Return the ID of the server with the given index, starting from 0
+func generatedId(i int) string {
+	return fmt.Sprintf("N%v", i+1)
+}
+
+func newGeneratedTopology(n int) *Topology {
+	t := NewTopology()
+	for i := 0; i < n; i++ {
+		t.AddServer(generatedId(i), 0)
+	}
+	return t
+}
+
+// Add a link in each direction between two servers
+func (t *Topology) addBidirectionalLink(a, b string) {
+	t.AddLink(a, b)
+	t.AddLink(b, a)
+}
+
+// A unidirectional ring N1 -> N2 -> ... -> Nn -> N1
+func BuildRing(n int) *Topology {
+	t := newGeneratedTopology(n)
+	if n < 2 {
+		return t
+	}
+	for i := 0; i < n; i++ {
+		t.AddLink(generatedId(i), generatedId((i+1)%n))
+	}
+	return t
+}
+
+// N1 linked in both directions to every other server
+func BuildStar(n int) *Topology {
+	t := newGeneratedTopology(n)
+	for i := 1; i < n; i++ {
+		t.addBidirectionalLink(generatedId(0), generatedId(i))
+	}
+	return t
+}
+
+// A link from every server to every other server
+func BuildFullyConnected(n int) *Topology {
+	t := newGeneratedTopology(n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i != j {
+				t.AddLink(generatedId(i), generatedId(j))
+			}
+		}
+	}
+	return t
+}
+
+// A grid of the given size, numbered row by row, where each server is linked
+// in both directions to the servers next to it in its row and column
+func BuildGrid(rows, cols int) *Topology {
+	t := newGeneratedTopology(rows * cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			id := generatedId(r*cols + c)
+			if c+1 < cols {
+				t.addBidirectionalLink(id, generatedId(r*cols+c+1))
+			}
+			if r+1 < rows {
+				t.addBidirectionalLink(id, generatedId((r+1)*cols+c))
+			}
+		}
+	}
+	return t
+}
+
+// A tree rooted at N1 where every server has up to `branching` children,
+// numbered level by level, and each child is linked in both directions to
+// its parent
+func BuildTree(n, branching int) *Topology {
+	t := newGeneratedTopology(n)
+	if branching < 1 {
+		return t
+	}
+	for i := 1; i < n; i++ {
+		t.addBidirectionalLink(generatedId((i-1)/branching), generatedId(i))
+	}
+	return t
+}
+
+// An Erdős–Rényi graph: each of the n*(n-1) possible links is present with
+// probability p, drawn from a source seeded with the given seed. The result
+// may not be strongly connected, in which case snapshots started on it only
+// complete with deadlines.
+func BuildRandom(n int, p float64, seed int64) *Topology {
+	t := newGeneratedTopology(n)
+	random := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i != j && random.Float64() < p {
+				t.AddLink(generatedId(i), generatedId(j))
+			}
+		}
+	}
+	return t
+}
+
+// Return true if every server can reach every other server along the links
+func (t *Topology) StronglyConnected() bool {
+	servers := t.Servers()
+	if len(servers) == 0 {
+		return true
+	}
+	forward := make(map[string][]string)
+	backward := make(map[string][]string)
+	for _, link := range t.links {
+		forward[link.src] = append(forward[link.src], link.dest)
+		backward[link.dest] = append(backward[link.dest], link.src)
+	}
+	reachable := func(edges map[string][]string) int {
+		visited := map[string]bool{servers[0]: true}
+		frontier := []string{servers[0]}
+		for len(frontier) > 0 {
+			next := frontier[0]
+			frontier = frontier[1:]
+			for _, neighbor := range edges[next] {
+				if !visited[neighbor] {
+					visited[neighbor] = true
+					frontier = append(frontier, neighbor)
+				}
+			}
+		}
+		return len(visited)
+	}
+	return reachable(forward) == len(servers) && reachable(backward) == len(servers)
+}
+
+// Return a new simulator with the servers and links of the topology, or an
+// error if a link involves a server missing from the topology
+func (t *Topology) Build() (*Simulator, error) {
+	return t.build(NewSimulator())
+}
+
+// Like `Build`, but the message delays are drawn from a source seeded with the
+// given seed instead of the global source of `math/rand`
+func (t *Topology) BuildWithSeed(seed int64) (*Simulator, error) {
+	return t.build(NewSimulatorWithSeed(seed))
+}
+
+func (t *Topology) build(sim *Simulator) (*Simulator, error) {
+	for _, serverId := range t.Servers() {
+		sim.AddServer(serverId, t.tokens[serverId])
+	}
+	for _, link := range t.links {
+		if err := sim.AddForwardLink(link.src, link.dest); err != nil {
+			return nil, err
+		}
+	}
+	return sim, nil
+}
@@ -0,0 +1,74 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestGeneratedTopologies(t *testing.T) {
+	tests := []struct {
+		name     string
+		topology *Topology
+		servers  int
+		links    int
+	}{
+		{"ring", BuildRing(5), 5, 5},
+		{"star", BuildStar(5), 5, 8},
+		{"full", BuildFullyConnected(4), 4, 12},
+		{"grid", BuildGrid(2, 3), 6, 14},
+		{"tree", BuildTree(7, 2), 7, 12},
+	}
+	for _, test := range tests {
+		if len(test.topology.Servers()) != test.servers || len(test.topology.Links()) != test.links {
+			t.Fatalf("Expected the %v to have %v servers and %v links, got %v and %v\n",
+				test.name, test.servers, test.links,
+				len(test.topology.Servers()), len(test.topology.Links()))
+		}
+		if !test.topology.StronglyConnected() {
+			t.Fatalf("Expected the %v to be strongly connected\n", test.name)
+		}
+	}
+	if links := BuildTree(4, 3).Links(); !reflect.DeepEqual(links[len(links)-2:], []ChannelId{{"N1", "N4"}, {"N4", "N1"}}) {
+		t.Fatalf("Expected N4 to be a child of N1, got %v\n", links)
+	}
+
+	random := BuildRandom(10, 0.3, 42)
+	if !reflect.DeepEqual(random.Links(), BuildRandom(10, 0.3, 42).Links()) {
+		t.Fatalf("Expected the same seed to build the same random topology\n")
+	}
+	if len(BuildRandom(10, 0, 42).Links()) != 0 || len(BuildRandom(10, 1, 42).Links()) != 90 {
+		t.Fatalf("Expected random topologies with p = 0 and p = 1 to be empty and full\n")
+	}
+	if BuildRandom(10, 0, 42).StronglyConnected() {
+		t.Fatalf("Expected a topology without links not to be strongly connected\n")
+	}
+}
+
+func TestBuildTopology(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	topology := BuildGrid(3, 3)
+	topology.AddServer("N1", 10)
+	topology.AddServer("N5", 4)
+	sim, err := topology.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	sim.InjectEvent(PassTokenEvent{"N5", "N8", 4})
+	sim.StartSnapshot("N9")
+	sim.Drain()
+	snap := sim.CollectSnapshot(0)
+	if err := VerifySnapshot(snap, topology); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildTopologyUnknownServer(t *testing.T) {
+	topology := BuildRing(3)
+	topology.AddLink("N1", "N4")
+	if _, err := topology.Build(); !errors.Is(err, ErrUnknownServer) {
+		t.Fatalf("Expected ErrUnknownServer for a link to a missing server, got %v\n", err)
+	}
+}
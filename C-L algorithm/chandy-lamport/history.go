@@ -0,0 +1,102 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// The states of the simulation saved at the end of the last ticks, oldest first
+type tickHistory struct {
+	keepTicks int
+	states    [][]byte
+	// Why the state of a tick could not be saved, after which no more states
+	// are saved
+	err     error
+	errTime int
+}
+
+// Save the state of the simulation at the end of every tick, keeping the
+// states of the last keepTicks ticks, so that `Rewind` can step back when an
+// inconsistent snapshot is observed, e.g. to re-run the same ticks with a trace.
+// The simulation must be one `Save` supports; the current state is saved right
+// away and the error returned if it cannot be. keepTicks <= 0 disables the
+// history. Every state is a whole copy of the simulation, event log included,
+// so the history is meant for debugging rather than long runs.
+func (sim *Simulator) EnableHistory(keepTicks int) error {
+	if keepTicks <= 0 {
+		sim.history = nil
+		return nil
+	}
+	state, err := sim.encodeState()
+	if err != nil {
+		return err
+	}
+	sim.history = &tickHistory{keepTicks: keepTicks, states: [][]byte{state}}
+	return nil
+}
+
+// Restore the state of the simulation n ticks ago, as saved by `EnableHistory`.
+// Rewinding 0 ticks discards what happened since the end of the last tick, e.g.
+// the events injected since. The states after the restored one are discarded,
+// and the simulation saves its states again from there. Message handlers,
+// observers and traces are kept, but the servers and links are new: pointers
+// to them obtained before rewinding, e.g. by `MakeFaulty`, must be fetched again.
+func (sim *Simulator) Rewind(n int) error {
+	history := sim.history
+	if history == nil {
+		return fmt.Errorf("Cannot rewind, the history of the simulation is not enabled")
+	}
+	if history.err != nil {
+		return fmt.Errorf("Cannot rewind, the state at time %v could not be saved: %v",
+			history.errTime, history.err)
+	}
+	if n < 0 || n >= len(history.states) {
+		return fmt.Errorf("Cannot rewind %v ticks, only the last %v are kept",
+			n, len(history.states)-1)
+	}
+	index := len(history.states) - 1 - n
+	var wire simulatorWire
+	if err := gob.NewDecoder(bytes.NewReader(history.states[index])).Decode(&wire); err != nil {
+		return err
+	}
+	if err := sim.fromWire(&wire); err != nil {
+		return err
+	}
+	history.states = history.states[:index+1]
+	return nil
+}
+
+// Number of ticks the simulation can be rewound by
+func (sim *Simulator) HistoryLength() int {
+	if sim.history == nil || sim.history.err != nil {
+		return 0
+	}
+	return len(sim.history.states) - 1
+}
+
+// Save the state at the end of a tick, if the history is enabled
+func (sim *Simulator) recordHistory() {
+	history := sim.history
+	if history == nil || history.err != nil {
+		return
+	}
+	state, err := sim.encodeState()
+	if err != nil {
+		history.err, history.errTime = err, sim.time
+		history.states = nil
+		return
+	}
+	history.states = append(history.states, state)
+	if len(history.states) > history.keepTicks+1 {
+		history.states = history.states[len(history.states)-history.keepTicks-1:]
+	}
+}
+
+func (sim *Simulator) encodeState() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := sim.Save(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
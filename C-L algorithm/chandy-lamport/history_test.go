@@ -0,0 +1,75 @@
+package chandy_lamport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewind(t *testing.T) {
+	for _, algorithm := range []SnapshotAlgorithm{ChandyLamport, LaiYang, Mattern} {
+		sim := startSavedRun(algorithm)
+		if err := sim.EnableHistory(3); err != nil {
+			t.Fatalf("%v: %v\n", algorithm, err)
+		}
+		sim.Tick()
+		time := sim.time
+		log := sim.EventLog()
+		sim.InjectEvent(PassTokenEvent{"N3", "N4", 1})
+		sim.Tick()
+		sim.Tick()
+		if err := sim.Rewind(2); err != nil {
+			t.Fatalf("%v: %v\n", algorithm, err)
+		}
+		if sim.time != time || !reflect.DeepEqual(sim.EventLog(), log) {
+			t.Fatalf("%v: expected the simulation to be back at time %v, got time %v\n",
+				algorithm, time, sim.time)
+		}
+		if sim.HistoryLength() != 1 {
+			t.Fatalf("%v: expected the later states to be discarded, %v ticks are kept\n",
+				algorithm, sim.HistoryLength())
+		}
+		// The rewound simulation goes on like one that was never rewound
+		other := startSavedRun(algorithm)
+		other.Tick()
+		finishSavedRun(sim)
+		finishSavedRun(other)
+		if !reflect.DeepEqual(sim.EventLog(), other.EventLog()) {
+			t.Fatalf("%v: expected the rewound simulation to log the same events, got:\n%v\ninstead of:\n%v\n",
+				algorithm, sim.EventLog(), other.EventLog())
+		}
+		sim.CollectSnapshot(0)
+		if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+			t.Fatalf("%v: %v\n", algorithm, err)
+		}
+	}
+}
+
+func TestRewindKeepsLastTicks(t *testing.T) {
+	sim := startSavedRun(ChandyLamport)
+	if err := sim.Rewind(0); err == nil {
+		t.Fatalf("Expected rewinding without a history to fail\n")
+	}
+	sim.EnableHistory(2)
+	for i := 0; i < 5; i++ {
+		sim.Tick()
+	}
+	if sim.HistoryLength() != 2 {
+		t.Fatalf("Expected the last 2 ticks to be kept, got %v\n", sim.HistoryLength())
+	}
+	if err := sim.Rewind(3); err == nil {
+		t.Fatalf("Expected rewinding past the kept ticks to fail\n")
+	}
+	time, tokens := sim.time, sim.servers["N1"].Tokens
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	if err := sim.Rewind(0); err != nil {
+		t.Fatal(err)
+	}
+	if sim.time != time || sim.servers["N1"].Tokens != tokens {
+		t.Fatalf("Expected rewinding 0 ticks to undo the token sent at time %v\n", time)
+	}
+	sim.SetLinkTransform("N1", "N2", func(message interface{}) interface{} { return message })
+	sim.Tick()
+	if err := sim.Rewind(1); err == nil {
+		t.Fatalf("Expected rewinding to fail once a state could not be saved\n")
+	}
+}
@@ -0,0 +1,54 @@
+package chandy_lamport
+
+import "sync"
+
+// Packets handed to a server from outside the simulation loop, e.g. by the
+// goroutines reading the connections of a real network backend. The protocol
+// state of a server is only touched by the goroutine driving the simulation:
+// other goroutines only append to the inbox, which has a lock of its own.
+type serverInbox struct {
+	lock    sync.Mutex
+	packets []inboxPacket
+}
+
+type inboxPacket struct {
+	src     string
+	message interface{}
+}
+
+func newInbox() *serverInbox {
+	return &serverInbox{packets: make([]inboxPacket, 0)}
+}
+
+// Hand a packet from the given server to this server. Unlike `HandlePacket`,
+// this is safe to call from any goroutine, including while the simulation is
+// running: the packet waits in the inbox of this server, and is handled at the
+// start of the next tick, after the packets delivered before it. A crashed
+// server keeps its packets in the inbox until it recovers.
+func (server *Server) Deliver(src string, message interface{}) {
+	server.inbox.lock.Lock()
+	defer server.inbox.lock.Unlock()
+	server.inbox.packets = append(server.inbox.packets, inboxPacket{src, message})
+}
+
+// Return the number of packets waiting in the inbox of this server
+func (server *Server) InboxLen() int {
+	server.inbox.lock.Lock()
+	defer server.inbox.lock.Unlock()
+	return len(server.inbox.packets)
+}
+
+// Handle the packets waiting in the inbox of this server, in the order they
+// were delivered. Packets delivered meanwhile wait for the next tick.
+func (server *Server) processInbox() {
+	if server.crashed {
+		return
+	}
+	server.inbox.lock.Lock()
+	packets := server.inbox.packets
+	server.inbox.packets = make([]inboxPacket, 0)
+	server.inbox.lock.Unlock()
+	for _, p := range packets {
+		server.sim.deliver(SendMessageEvent{p.src, server.Id, p.message, server.sim.time, server.sim.time, 0})
+	}
+}
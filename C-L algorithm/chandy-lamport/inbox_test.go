@@ -0,0 +1,55 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestDeliverConcurrently(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				sim.servers["N3"].Deliver("N2", TokenMessage{numTokens: 1})
+			}
+		}()
+	}
+	// The simulation keeps running while the packets are delivered
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 4})
+	for i := 0; i < 5; i++ {
+		sim.Tick()
+	}
+	wg.Wait()
+	sim.Drain()
+	if sim.servers["N3"].InboxLen() != 0 || sim.servers["N3"].Tokens != 50 {
+		t.Fatalf("Expected N3 to handle the 50 tokens delivered to it, got %v\n",
+			sim.servers["N3"].Tokens)
+	}
+	if sim.servers["N2"].Tokens != 7 {
+		t.Fatalf("Expected N2 to receive the tokens from N1, got %v\n", sim.servers["N2"].Tokens)
+	}
+}
+
+func TestDeliverToCrashedServer(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.CrashServer("N3")
+	sim.servers["N3"].Deliver("N1", TokenMessage{numTokens: 2})
+	sim.Tick()
+	if sim.servers["N3"].InboxLen() != 1 {
+		t.Fatalf("Expected the packet to wait for N3 to recover\n")
+	}
+	sim.RecoverServer("N3")
+	sim.Tick()
+	if sim.servers["N3"].InboxLen() != 0 || sim.servers["N3"].Tokens != 2 {
+		t.Fatalf("Expected N3 to handle the packet once recovered, got %v tokens\n",
+			sim.servers["N3"].Tokens)
+	}
+}
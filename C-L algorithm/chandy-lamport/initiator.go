@@ -0,0 +1,162 @@
+package chandy_lamport
+
+import (
+	"fmt"
+)
+
+// How the initiator of a snapshot is chosen when `StartSnapshot` is called with
+// an empty server ID, see `SetInitiatorPolicy`
+type SnapshotInitiatorPolicy interface {
+	Initiator(sim *Simulator) (string, error)
+}
+
+// The same server initiates every snapshot
+type FixedInitiator struct {
+	serverId string
+}
+
+func NewFixedInitiator(serverId string) FixedInitiator {
+	return FixedInitiator{serverId}
+}
+
+func (p FixedInitiator) Initiator(sim *Simulator) (string, error) {
+	return p.serverId, nil
+}
+
+// A server drawn uniformly among the servers that have not crashed, from the
+// source of randomness of the simulator
+type RandomInitiator struct{}
+
+func NewRandomInitiator() RandomInitiator {
+	return RandomInitiator{}
+}
+
+func (p RandomInitiator) Initiator(sim *Simulator) (string, error) {
+	alive := sim.aliveServerIds()
+	if len(alive) == 0 {
+		return "", fmt.Errorf("No server is alive to initiate a snapshot")
+	}
+	return alive[sim.random.Intn(len(alive))], nil
+}
+
+// The server with the lowest ID among the servers that have not crashed
+type LowestIdInitiator struct{}
+
+func NewLowestIdInitiator() LowestIdInitiator {
+	return LowestIdInitiator{}
+}
+
+func (p LowestIdInitiator) Initiator(sim *Simulator) (string, error) {
+	alive := sim.aliveServerIds()
+	if len(alive) == 0 {
+		return "", fmt.Errorf("No server is alive to initiate a snapshot")
+	}
+	return alive[0], nil
+}
+
+// The server elected by the servers themselves: every server that has not
+// crashed proposes itself to its neighbors, and forwards the lowest ID it
+// hears of, until no election message is left in flight. The server that
+// heard of no lower ID than its own initiates the snapshot, which on a
+// strongly connected network is the lowest ID alive, as with
+// `LowestIdInitiator`, but only after the election messages are delivered.
+// The simulation runs until the election ends, so tokens sent before
+// `StartSnapshot` may be delivered before the snapshot starts.
+type ElectedInitiator struct{}
+
+func NewElectedInitiator() ElectedInitiator {
+	return ElectedInitiator{}
+}
+
+func (p ElectedInitiator) Initiator(sim *Simulator) (string, error) {
+	return sim.electInitiator()
+}
+
+// Choose how the initiator of a snapshot is chosen when `StartSnapshot` is
+// called with an empty server ID, or nil to require StartSnapshot to be given
+// a server
+func (sim *Simulator) SetInitiatorPolicy(policy SnapshotInitiatorPolicy) {
+	sim.initiatorPolicy = policy
+}
+
+// A message of the election of an initiator: the lowest ID the sender heard of
+// in the given round of elections. Election messages are not recorded by
+// snapshots.
+type ElectionMessage struct {
+	round     int
+	candidate string
+}
+
+func (m ElectionMessage) String() string {
+	return fmt.Sprintf("elect(%v)", m.candidate)
+}
+
+// Sorted IDs of the servers that have not crashed
+func (sim *Simulator) aliveServerIds() []string {
+	alive := make([]string, 0)
+	for _, serverId := range sim.sortedServerIds() {
+		if !sim.servers[serverId].crashed {
+			alive = append(alive, serverId)
+		}
+	}
+	return alive
+}
+
+// Run a round of elections and return the elected server
+func (sim *Simulator) electInitiator() (string, error) {
+	alive := sim.aliveServerIds()
+	if len(alive) == 0 {
+		return "", fmt.Errorf("No server is alive to initiate a snapshot")
+	}
+	sim.electionRound++
+	round := sim.electionRound
+	for _, serverId := range alive {
+		server := sim.servers[serverId]
+		server.electionRound, server.leader = round, serverId
+		server.SendToNeighbors(ElectionMessage{round, serverId})
+	}
+	for sim.electionInFlight(round) {
+		sim.Tick()
+	}
+	for _, serverId := range sim.aliveServerIds() {
+		server := sim.servers[serverId]
+		if server.electionRound == round && server.leader == serverId {
+			return serverId, nil
+		}
+	}
+	return "", fmt.Errorf("The election of round %v elected no server", round)
+}
+
+// Return true if a message of the given round of elections is still to be
+// delivered. Messages held on frozen or partitioned links or to crashed
+// servers are not waited for: they are ignored if they are delivered after the
+// election.
+func (sim *Simulator) electionInFlight(round int) bool {
+	for _, serverId := range sim.sortedServerIds() {
+		for _, link := range sim.servers[serverId].deliveringLinks() {
+			if link.blocked() || sim.servers[link.dest].crashed {
+				continue
+			}
+			for _, event := range append(link.events.Elements(), link.backlog.Elements()...) {
+				if m, ok := event.message.(ElectionMessage); ok && m.round == round {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Adopt the candidate of an election message if it is lower than the one the
+// server heard of, and forward it. A server that did not take part in the
+// round, e.g. because it crashed when it started, joins it without
+// proposing itself.
+func (server *Server) handleElection(message ElectionMessage) {
+	if message.round < server.electionRound {
+		return
+	}
+	if message.round > server.electionRound || message.candidate < server.leader {
+		server.electionRound, server.leader = message.round, message.candidate
+		server.SendToNeighbors(message)
+	}
+}
@@ -0,0 +1,99 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestInitiatorPolicies(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	if err := sim.StartSnapshot(""); err == nil {
+		t.Fatalf("Expected an error starting a snapshot without a server or policy\n")
+	}
+	sim.SetInitiatorPolicy(NewFixedInitiator("N2"))
+	if err := sim.StartSnapshot(""); err != nil {
+		t.Fatal(err)
+	}
+	sim.CrashServer("N1")
+	sim.SetInitiatorPolicy(NewLowestIdInitiator())
+	if err := sim.StartSnapshot(""); err != nil {
+		t.Fatal(err)
+	}
+	sim.SetInitiatorPolicy(NewRandomInitiator())
+	for i := 0; i < 10; i++ {
+		if err := sim.StartSnapshot(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if initiator := sim.initiators[0]; initiator != "N2" {
+		t.Fatalf("Expected the fixed initiator N2, got %v\n", initiator)
+	}
+	if initiator := sim.initiators[1]; initiator != "N2" {
+		t.Fatalf("Expected the lowest ID alive N2, got %v\n", initiator)
+	}
+	for snapshotId := 2; snapshotId < 12; snapshotId++ {
+		if initiator := sim.initiators[snapshotId]; initiator == "N1" {
+			t.Fatalf("Expected the random initiator of snapshot %v not to have crashed\n", snapshotId)
+		}
+	}
+	sim.SetInitiatorPolicy(NewFixedInitiator("N1"))
+	if err := sim.StartSnapshot(""); err == nil {
+		t.Fatalf("Expected an error starting a snapshot on a crashed server\n")
+	}
+}
+
+func TestElectedInitiator(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	sim.SetInitiatorPolicy(NewElectedInitiator())
+	if err := sim.StartSnapshot(""); err != nil {
+		t.Fatal(err)
+	}
+	if sim.time == 0 {
+		t.Fatalf("Expected the election to take time steps\n")
+	}
+	if initiator := sim.initiators[0]; initiator != "N1" {
+		t.Fatalf("Expected N1 to be elected, got %v\n", initiator)
+	}
+	if !strings.Contains(strings.Join(sim.EventLog(), "\n"), "elect(N1)") {
+		t.Fatalf("Expected the election messages to be logged\n")
+	}
+	sim.Drain()
+	sim.CollectSnapshot(0)
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+	// A crashed server is not elected, and the others do not wait for it
+	sim.CrashServer("N1")
+	if err := sim.StartSnapshot(""); err != nil {
+		t.Fatal(err)
+	}
+	if initiator := sim.initiators[1]; initiator != "N2" {
+		t.Fatalf("Expected N2 to be elected while N1 is down, got %v\n", initiator)
+	}
+}
+
+func TestSaveInitiatorPolicy(t *testing.T) {
+	sim := NewSimulatorWithSeed(1)
+	readTopology("3nodes.top", sim)
+	sim.SetInitiatorPolicy(NewFixedInitiator("N3"))
+	var buf bytes.Buffer
+	if err := sim.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSimulator(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.StartSnapshot(""); err != nil {
+		t.Fatal(err)
+	}
+	if initiator := loaded.initiators[0]; initiator != "N3" {
+		t.Fatalf("Expected the loaded simulator to start snapshots on N3, got %v\n", initiator)
+	}
+}
@@ -0,0 +1,52 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Verify after every tick that no token is created or destroyed, i.e. that the
+// tokens held by the servers plus the tokens in flight add up to the initial
+// total, counting minted, duplicated and lost tokens. The simulation panics with a report
+// of where the tokens are as soon as the check fails, which points at protocol
+// bugs much closer to their cause than checking the totals at the end of a run.
+func (sim *Simulator) EnableInvariantChecks() {
+	sim.checkInvariants = true
+}
+
+func (sim *Simulator) checkTokenConservation() {
+	onServers := sim.TotalTokens()
+	inFlight := 0
+	for _, info := range sim.AllInFlight() {
+		if message, isToken := tokenMessage(info.message); isToken {
+			inFlight += message.numTokens
+		}
+	}
+	expected := sim.initialTokens + sim.generatedTokens + sim.duplicatedTokens - sim.lostTokens
+	if onServers+inFlight == expected {
+		return
+	}
+	panic(sim.conservationReport(expected, onServers, inFlight))
+}
+
+// Describe a violation of token conservation, along with the tokens held by
+// every server and carried on every link
+func (sim *Simulator) conservationReport(expected, onServers, inFlight int) string {
+	lines := []string{
+		fmt.Sprintf("Token conservation violated at time %v: expected %v token(s), found %v",
+			sim.time, expected, onServers+inFlight),
+		fmt.Sprintf("\t%v initial + %v generated + %v duplicated - %v lost",
+			sim.initialTokens, sim.generatedTokens, sim.duplicatedTokens, sim.lostTokens),
+		fmt.Sprintf("\t%v on servers + %v in flight", onServers, inFlight),
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		lines = append(lines, fmt.Sprintf("\t%v holds %v token(s)", serverId, sim.servers[serverId].Tokens))
+	}
+	for _, info := range sim.AllInFlight() {
+		if message, isToken := tokenMessage(info.message); isToken {
+			lines = append(lines, fmt.Sprintf("\t%v -> %v carries %v token(s), due at time %v",
+				info.src, info.dest, message.numTokens, info.receiveTime))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
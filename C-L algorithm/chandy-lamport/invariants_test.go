@@ -0,0 +1,50 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestInvariantChecks(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.EnableInvariantChecks()
+	readTopology("3nodes.top", sim)
+	sim.servers["N3"].SetGenerationRate(1)
+	injectEvents("3nodes-bidirectional-messages.events", sim)
+	// Tokens dropped on a lossy link are accounted for
+	sim.InjectLoss("N1", "N2", 0.5)
+	for i := 0; i < 5; i++ {
+		sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	}
+	sim.Drain()
+	if sim.LostTokens() == 0 {
+		t.Fatalf("Expected some tokens to be lost\n")
+	}
+}
+
+func TestInvariantViolation(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.EnableInvariantChecks()
+	readTopology("3nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 4})
+	// A token appears out of nowhere
+	sim.servers["N3"].Tokens++
+	defer func() {
+		report := fmt.Sprint(recover())
+		for _, expected := range []string{
+			"expected 13 token(s), found 14",
+			"N3 holds 1 token(s)",
+			"N1 -> N2 carries 4 token(s)",
+		} {
+			if !strings.Contains(report, expected) {
+				t.Fatalf("Expected the report to contain %q, got:\n%v\n", expected, report)
+			}
+		}
+	}()
+	sim.Tick()
+	t.Fatalf("Expected the tick to panic\n")
+}
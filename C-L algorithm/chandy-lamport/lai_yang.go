@@ -0,0 +1,148 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+)
+
+// =========================================================================
+//  Lai-Yang snapshot algorithm, selected with `SetSnapshotAlgorithm(LaiYang)`
+// =========================================================================
+//
+// Unlike chandy-lamport, this algorithm does not rely on FIFO links. Every token
+// message is colored with the snapshots its sender had already recorded: it is
+// "red" for those snapshots and "white" for all others. A server records its state
+// before processing the first red message of a snapshot, and records the white
+// messages it receives afterwards as the state of their channel.
+//
+// Since white messages may still arrive after a red one on a non-FIFO link, a
+// server that records its state sends a control message on every outbound link,
+// announcing how many messages it sent on that link before recording. A channel
+// is closed once its control message and all the white messages it announced
+// have been received.
+
+// A token message colored with the IDs of the snapshots its sender had recorded
+// before sending it, in sorted order.
+type ColoredMessage struct {
+	message  TokenMessage
+	recorded []int
+}
+
+func (m ColoredMessage) String() string {
+	return m.message.String()
+}
+
+// A message sent on every outbound link by a server when it records its state.
+// This also counts as a red message for the snapshot.
+type LaiYangControlMessage struct {
+	snapshotId int
+	// Number of token messages sent on the link before the state was recorded
+	whiteSent int
+}
+
+func (m LaiYangControlMessage) String() string {
+	return fmt.Sprintf("control(%v, %v)", m.snapshotId, m.whiteSent)
+}
+
+// Color a token message with the snapshots this server has recorded
+func (server *Server) colorMessage(message TokenMessage) ColoredMessage {
+	recorded := make([]int, 0)
+	for snapshotId, received := range server.receivedSnapshot {
+		if received {
+			recorded = append(recorded, snapshotId)
+		}
+	}
+	sort.Ints(recorded)
+	return ColoredMessage{message, recorded}
+}
+
+// Send a control message on every outbound link after recording the local state
+func (server *Server) sendLaiYangControls(snapshotId int) {
+	server.whiteExpected[snapshotId] = make(map[string]int)
+	server.whiteReceived[snapshotId] = make(map[string]int)
+	// Every message received so far was sent before its sender recorded its state
+	for src, count := range server.receivedCount {
+		server.whiteReceived[snapshotId][src] = count
+	}
+	for _, dest := range server.neighborIds() {
+		link := server.outboundLinks[dest]
+		message := LaiYangControlMessage{snapshotId, server.sentCount[dest]}
+		server.sim.logger.RecordEvent(server, SentMessageEvent{server.Id, dest, message})
+		server.sim.enqueue(link, SendMessageEvent{
+			server.Id,
+			dest,
+			message,
+			server.sim.receiveTimeOn(link),
+			server.sim.time,
+			server.lamport})
+	}
+}
+
+// Callback for when a message is received on this server with the Lai-Yang algorithm
+func (server *Server) handleLaiYangPacket(src string, message interface{}) error {
+	switch v := message.(type) {
+	case LaiYangControlMessage:
+		if server.isPruned(v.snapshotId) {
+			return nil
+		}
+		if !server.receivedSnapshot[v.snapshotId] {
+			server.StartSnapshot(v.snapshotId)
+		}
+		if !server.inReceivedMarker[v.snapshotId][src] {
+			server.closeChannel(v.snapshotId, src)
+			server.whiteExpected[v.snapshotId][src] = v.whiteSent
+		}
+		server.checkLaiYangComplete(v.snapshotId)
+	case TokenMessage:
+		// Messages that were never colored, e.g. injected in flight, are white
+		return server.handleLaiYangPacket(src, ColoredMessage{v, nil})
+	case ColoredMessage:
+		red := make(map[int]bool)
+		for _, snapshotId := range v.recorded {
+			red[snapshotId] = true
+			// Record the local state before processing a red message
+			if !server.receivedSnapshot[snapshotId] && !server.isPruned(snapshotId) {
+				server.StartSnapshot(snapshotId)
+			}
+		}
+		server.receivedCount[src]++
+		recording := make([]int, 0)
+		for snapshotId, received := range server.receivedSnapshot {
+			if received && !server.completedSnapshot[snapshotId] && !red[snapshotId] {
+				recording = append(recording, snapshotId)
+			}
+		}
+		sort.Ints(recording)
+		for _, snapshotId := range recording {
+			server.whiteReceived[snapshotId][src]++
+			server.recordMessage(snapshotId, &SnapshotMessage{
+				src:      src,
+				dest:     server.Id,
+				message:  v.message,
+				hopCount: v.message.hopCount,
+			})
+		}
+		server.addTokens(v.message.tokenType, v.message.numTokens)
+		err := server.forwardTokens(v.message)
+		for _, snapshotId := range recording {
+			server.checkLaiYangComplete(snapshotId)
+		}
+		return err
+	}
+	return nil
+}
+
+// Complete the local snapshot once every inbound channel has delivered its
+// control message along with all the white messages announced in it
+func (server *Server) checkLaiYangComplete(snapshotId int) {
+	if !server.receivedSnapshot[snapshotId] || server.completedSnapshot[snapshotId] {
+		return
+	}
+	for src := range server.inboundLinks {
+		if !server.inReceivedMarker[snapshotId][src] ||
+			server.whiteReceived[snapshotId][src] != server.whiteExpected[snapshotId][src] {
+			return
+		}
+	}
+	server.completeSnapshot(snapshotId)
+}
@@ -0,0 +1,51 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"path"
+	"testing"
+)
+
+func runLaiYangTest(t *testing.T, topFile string, eventsFile string, numSnapshots int) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetSnapshotAlgorithm(LaiYang)
+	readTopology(topFile, sim)
+	snaps := injectEvents(eventsFile, sim)
+	if len(snaps) != numSnapshots {
+		t.Fatalf("Expected %v snapshot(s), got %v\n", numSnapshots, len(snaps))
+	}
+	checkTokens(sim, snaps)
+	ids := make([]int, 0)
+	for _, snap := range snaps {
+		ids = append(ids, snap.id)
+	}
+	if err := sim.AssertConcurrentConsistency(ids, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLaiYang3NodesBidirectionalMessages(t *testing.T) {
+	runLaiYangTest(t, "3nodes.top", "3nodes-bidirectional-messages.events", 1)
+}
+
+func TestLaiYang8NodesConcurrentSnapshots(t *testing.T) {
+	runLaiYangTest(t, "8nodes.top", "8nodes-concurrent-snapshots.events", 5)
+}
+
+func TestLaiYangInFlightMessages(t *testing.T) {
+	sim, err := LoadTopology(path.Join(testDir, "2nodes-inflight.top"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.SetSnapshotAlgorithm(LaiYang)
+	snapshotId := sim.nextSnapshotId
+	sim.StartSnapshot("N2")
+	for sim.finishedMap[snapshotId] < len(sim.servers) {
+		sim.Tick()
+	}
+	sim.CollectSnapshot(snapshotId)
+	if err := sim.ValidateSnapshot(snapshotId, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
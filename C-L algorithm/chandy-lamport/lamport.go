@@ -0,0 +1,57 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Besides the vector clocks of Mattern's algorithm, every server keeps a
+// Lamport clock, which ticks on every message the server sends or receives.
+// A message carries the Lamport clock of its sender, and a server receiving it
+// moves its clock past that value, so an event that happened before another
+// one always has a smaller clock. The logged events, the messages in flight and
+// the messages recorded by snapshots carry these clocks.
+
+// Return the Lamport clock of this server
+func (server *Server) LamportClock() int {
+	return server.lamport
+}
+
+// Tick the Lamport clock of the server for an event logged on it. Sends and
+// receives are the only events that tick the clock; the other events get its
+// current value.
+func (server *Server) tickLamport(event interface{}) {
+	switch event.(type) {
+	case SentMessageEvent, ReceivedMessageEvent:
+		server.lamport++
+	}
+}
+
+// Move the Lamport clock of the server past the clock of a message delivered
+// to it, before the receive is logged
+func (server *Server) receiveLamport(e SendMessageEvent) {
+	if e.lamport > server.lamport {
+		server.lamport = e.lamport
+	}
+	server.handlingLamport = e.lamport
+}
+
+// Return the events logged so far, one per line, prefixed with the Lamport
+// clock of their server, in an order consistent with happened-before: events
+// are sorted by clock, then by server ID, and the events of a server keep their
+// order. Unlike `EventLog`, which orders events by time step alone, a receive
+// always comes after its send.
+func (sim *Simulator) CausalEventLog() []string {
+	events := sim.logger.EventsBetween(0, sim.time)
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].lamport != events[j].lamport {
+			return events[i].lamport < events[j].lamport
+		}
+		return events[i].serverId < events[j].serverId
+	})
+	lines := make([]string, 0)
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("Clock %v: %v", event.lamport, event))
+	}
+	return lines
+}
@@ -0,0 +1,62 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLamportClocks(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.Drain()
+	// N3 has not sent or received anything, so its clock lags behind
+	sim.InjectEvent(PassTokenEvent{"N2", "N3", 4})
+	sim.Drain()
+	expected := map[string]int{"N1": 2, "N2": 4, "N3": 5}
+	for serverId, clock := range expected {
+		if actual := sim.servers[serverId].LamportClock(); actual != clock {
+			t.Fatalf("Expected the clock of %v to be %v, got %v\n", serverId, clock, actual)
+		}
+	}
+	lines := sim.CausalEventLog()
+	index := func(event string) int {
+		for i, line := range lines {
+			if strings.HasSuffix(line, event) {
+				return i
+			}
+		}
+		t.Fatalf("Expected %q to be logged in\n%v\n", event, strings.Join(lines, "\n"))
+		return -1
+	}
+	if index("N2 sent 4 tokens to N3") > index("N3 received 4 tokens from N2") {
+		t.Fatalf("Expected the send to come before the receive in\n%v\n", strings.Join(lines, "\n"))
+	}
+	if !strings.HasPrefix(lines[len(lines)-1], "Clock 5: ") {
+		t.Fatalf("Expected the receive of N3 to come last, got %q\n", lines[len(lines)-1])
+	}
+}
+
+func TestLamportClockRecorded(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	// The token reaches N2 before the marker of N1 does
+	sim.StartSnapshot("N2")
+	sim.FreezeLink("N2", "N1")
+	sim.FreezeLink("N2", "N3")
+	for !mustGetLink(sim, "N1", "N2").events.Empty() {
+		sim.Tick()
+	}
+	sim.UnfreezeLink("N2", "N1")
+	sim.UnfreezeLink("N2", "N3")
+	sim.Drain()
+	snap := sim.CollectSnapshot(0)
+	if len(snap.messages) != 1 || snap.messages[0].lamport != 1 {
+		t.Fatalf("Expected the token to be recorded with the clock of its send, got %v\n", snap.messages)
+	}
+}
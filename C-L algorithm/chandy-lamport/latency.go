@@ -0,0 +1,86 @@
+package chandy_lamport
+
+import (
+	"math"
+	"math/rand"
+)
+
+// A distribution of the number of time steps a message takes to be delivered
+// after it is sent. Delays are drawn from the source of randomness of the
+// simulator so that runs stay reproducible, and must be at least 1.
+type LatencyModel interface {
+	Delay(random *rand.Rand) int
+}
+
+// Delays drawn uniformly between a min and a max, both inclusive
+type UniformLatency struct {
+	min int
+	max int
+}
+
+func NewUniformLatency(min, max int) UniformLatency {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return UniformLatency{min, max}
+}
+
+func (l UniformLatency) Delay(random *rand.Rand) int {
+	return l.min + random.Intn(l.max-l.min+1)
+}
+
+// The same delay for every message
+type ConstantLatency struct {
+	delay int
+}
+
+func NewConstantLatency(delay int) ConstantLatency {
+	if delay < 1 {
+		delay = 1
+	}
+	return ConstantLatency{delay}
+}
+
+func (l ConstantLatency) Delay(random *rand.Rand) int {
+	return l.delay
+}
+
+// Delays of 1 time step plus an exponentially distributed extra delay with the
+// given mean, which models links that are usually fast but sometimes very slow
+type ExponentialLatency struct {
+	mean float64
+}
+
+func NewExponentialLatency(mean float64) ExponentialLatency {
+	return ExponentialLatency{math.Max(mean, 0)}
+}
+
+func (l ExponentialLatency) Delay(random *rand.Rand) int {
+	return 1 + int(math.Round(random.ExpFloat64()*l.mean))
+}
+
+// Set the latency model of the messages sent on this link, overriding the one
+// of the simulator. A nil model restores the one of the simulator.
+func (link *Link) SetLatencyModel(model LatencyModel) {
+	link.latency = model
+}
+
+// Set the latency model of the messages sent on every link that does not have
+// one of its own. By default, delays are uniform between 1 and `maxDelay`.
+func (sim *Simulator) SetLatencyModel(model LatencyModel) {
+	if model == nil {
+		model = NewUniformLatency(1, maxDelay)
+	}
+	sim.latency = model
+}
+
+// Return the receive time of a message sent on the given link now
+func (sim *Simulator) receiveTimeOn(link *Link) int {
+	if link.latency != nil {
+		return sim.time + link.latency.Delay(sim.random)
+	}
+	return sim.GetReceiveTime()
+}
@@ -0,0 +1,43 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLatencyModels(t *testing.T) {
+	random := rand.New(rand.NewSource(8053172852482175524))
+	models := map[string]LatencyModel{
+		"uniform":     NewUniformLatency(2, 4),
+		"constant":    NewConstantLatency(3),
+		"exponential": NewExponentialLatency(10),
+	}
+	for name, model := range models {
+		for i := 0; i < 100; i++ {
+			delay := model.Delay(random)
+			if delay < 1 || (name == "uniform" && delay > 4) || (name == "constant" && delay != 3) {
+				t.Fatalf("Unexpected %v delay: %v\n", name, delay)
+			}
+		}
+	}
+}
+
+func TestLinkLatencyOverride(t *testing.T) {
+	sim := NewSimulatorWithSeed(8053172852482175524)
+	readTopology("3nodes.top", sim)
+	sim.SetLatencyModel(NewConstantLatency(2))
+	mustGetLink(sim, "N1", "N2").SetLatencyModel(NewConstantLatency(7))
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.InjectEvent(PassTokenEvent{"N1", "N3", 1})
+	for i := 0; i < 6; i++ {
+		sim.Tick()
+	}
+	if sim.servers["N2"].Tokens != 3 || sim.servers["N3"].Tokens != 1 {
+		t.Fatalf("Expected only N3 to have received its tokens after 6 ticks, got %v and %v\n",
+			sim.servers["N2"].Tokens, sim.servers["N3"].Tokens)
+	}
+	sim.Tick()
+	if sim.servers["N2"].Tokens != 4 {
+		t.Fatalf("Expected N2 to receive its tokens at time 7, got %v\n", sim.servers["N2"].Tokens)
+	}
+}
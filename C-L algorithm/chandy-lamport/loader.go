@@ -0,0 +1,299 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Create a simulator from a topology file.
+// The expected format of the file is the same as the ".top" files read in tests:
+//   - The first line contains number of servers N (e.g. "2")
+//   - The next N lines each contains the server ID and the number of tokens on
+//     that server, in the form "[serverId] [numTokens]" (e.g. "N1 1")
+//   - The rest of the lines represent unidirectional links in the form "[src dst]"
+//     (e.g. "N1 N2")
+//
+// In addition, messages that are already in flight when the simulation starts
+// can be placed on existing links with lines of the form
+// "inflight [src] [dest] [numTokens] [receiveTime]" (e.g. "inflight N1 N2 2 5").
+// Lines starting with "#" are ignored.
+// Files ending in ".json" are read in the format described in `topologyFile`
+// instead, which also supports latency models and a schedule of events.
+func LoadTopology(fileName string) (*Simulator, error) {
+	return loadTopology(fileName, NewSimulator())
+}
+
+// Create a simulator from a topology file like `LoadTopology`, making its random
+// decisions from its own source seeded with the given seed, see
+// `NewSimulatorWithSeed`
+func LoadTopologyWithSeed(fileName string, seed int64) (*Simulator, error) {
+	return loadTopology(fileName, NewSimulatorWithSeed(seed))
+}
+
+// Add the servers and links of a topology file to an empty simulator
+func loadTopology(fileName string, sim *Simulator) (*Simulator, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(fileName, ".json") {
+		sim, err := loadTopologyJSON(b, sim)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", fileName, err)
+		}
+		return sim, nil
+	}
+	// Must call this before we start logging
+	sim.logger.NewEpoch()
+
+	numServersLeft := -1
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lineError := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%v:%v: %v: %v",
+				fileName, i+1, fmt.Sprintf(format, args...), line)
+		}
+		parts := strings.Fields(line)
+		if numServersLeft < 0 {
+			numServersLeft, err = strconv.Atoi(line)
+			if err != nil || numServersLeft < 0 {
+				return nil, lineError("expected number of servers")
+			}
+			continue
+		}
+		if parts[0] == "inflight" {
+			if numServersLeft > 0 {
+				return nil, lineError("in-flight message before all servers are defined")
+			}
+			if len(parts) != 5 {
+				return nil, lineError("expected 5 tokens")
+			}
+			numTokens, err1 := strconv.Atoi(parts[3])
+			receiveTime, err2 := strconv.Atoi(parts[4])
+			if err1 != nil || err2 != nil {
+				return nil, lineError("expected integer token count and receive time")
+			}
+			if err := sim.InjectInFlight(parts[1], parts[2], numTokens, receiveTime); err != nil {
+				return nil, lineError("%v", err)
+			}
+			continue
+		}
+		if len(parts) != 2 {
+			return nil, lineError("expected 2 tokens")
+		}
+		if numServersLeft > 0 {
+			// This is a server
+			numTokens, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, lineError("expected integer token count")
+			}
+			sim.AddServer(parts[0], numTokens)
+			numServersLeft--
+		} else {
+			// This is a link
+			_, ok1 := sim.servers[parts[0]]
+			_, ok2 := sim.servers[parts[1]]
+			if !ok1 || !ok2 {
+				return nil, lineError("unknown server")
+			}
+			sim.AddForwardLink(parts[0], parts[1])
+		}
+	}
+	if numServersLeft > 0 {
+		return nil, fmt.Errorf("%v: expected %v more server(s)", fileName, numServersLeft)
+	}
+	return sim, nil
+}
+
+// A simulation setup in JSON, e.g.
+//
+//	{
+//	  "servers": [{"id": "N1", "tokens": 10}, {"id": "N2", "tokens": 0}],
+//	  "latency": {"model": "uniform", "min": 1, "max": 5},
+//	  "links": [
+//	    {"src": "N1", "dest": "N2", "latency": {"model": "constant", "delay": 3}},
+//	    {"src": "N2", "dest": "N1"}
+//	  ],
+//	  "events": [
+//	    {"time": 2, "type": "send", "src": "N1", "dest": "N2", "tokens": 4},
+//	    {"time": 5, "type": "snapshot", "server": "N2"}
+//	  ]
+//	}
+//
+// The default latency and the latency of each link are optional. Events are
+// scheduled with `Simulator.ScheduleEvent`, after checking that their servers
+// and links exist and that no server sends more tokens than it could have.
+type topologyFile struct {
+	Servers []struct {
+		Id     string `json:"id"`
+		Tokens int    `json:"tokens"`
+	} `json:"servers"`
+	Latency *latencyFile `json:"latency"`
+	Links   []struct {
+		Src     string       `json:"src"`
+		Dest    string       `json:"dest"`
+		Latency *latencyFile `json:"latency"`
+	} `json:"links"`
+	Events []struct {
+		Time   int    `json:"time"`
+		Type   string `json:"type"`
+		Src    string `json:"src"`
+		Dest   string `json:"dest"`
+		Tokens int    `json:"tokens"`
+		Server string `json:"server"`
+	} `json:"events"`
+}
+
+// A latency model: "uniform" with "min" and "max", "constant" with "delay",
+// or "exponential" with "mean"
+type latencyFile struct {
+	Model string  `json:"model"`
+	Min   int     `json:"min"`
+	Max   int     `json:"max"`
+	Delay int     `json:"delay"`
+	Mean  float64 `json:"mean"`
+}
+
+func (l *latencyFile) model() (LatencyModel, error) {
+	switch l.Model {
+	case "uniform":
+		if l.Min < 1 || l.Max < l.Min {
+			return nil, fmt.Errorf("invalid uniform latency between %v and %v", l.Min, l.Max)
+		}
+		return NewUniformLatency(l.Min, l.Max), nil
+	case "constant":
+		if l.Delay < 1 {
+			return nil, fmt.Errorf("invalid constant latency %v", l.Delay)
+		}
+		return NewConstantLatency(l.Delay), nil
+	case "exponential":
+		if l.Mean <= 0 {
+			return nil, fmt.Errorf("invalid exponential latency with mean %v", l.Mean)
+		}
+		return NewExponentialLatency(l.Mean), nil
+	}
+	return nil, fmt.Errorf("unknown latency model %q", l.Model)
+}
+
+func loadTopologyJSON(b []byte, sim *Simulator) (*Simulator, error) {
+	var file topologyFile
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+	// Must call this before we start logging
+	sim.logger.NewEpoch()
+	for _, server := range file.Servers {
+		if _, ok := sim.servers[server.Id]; ok || server.Id == "" {
+			return nil, fmt.Errorf("invalid or duplicate server ID %q", server.Id)
+		}
+		sim.AddServer(server.Id, server.Tokens)
+	}
+	if file.Latency != nil {
+		model, err := file.Latency.model()
+		if err != nil {
+			return nil, err
+		}
+		sim.SetLatencyModel(model)
+	}
+	for _, link := range file.Links {
+		if err := sim.AddForwardLink(link.Src, link.Dest); err != nil {
+			return nil, err
+		}
+		if link.Latency != nil {
+			model, err := link.Latency.model()
+			if err != nil {
+				return nil, fmt.Errorf("link %v -> %v: %v", link.Src, link.Dest, err)
+			}
+			l, err := sim.GetLink(link.Src, link.Dest)
+			if err != nil {
+				return nil, err
+			}
+			l.SetLatencyModel(model)
+		}
+	}
+	events := make([]interface{}, len(file.Events))
+	for i, e := range file.Events {
+		switch e.Type {
+		case "send":
+			events[i] = PassTokenEvent{e.Src, e.Dest, e.Tokens}
+		case "snapshot":
+			events[i] = SnapshotEvent{e.Server}
+		default:
+			return nil, fmt.Errorf("event %v: unknown type %q", i, e.Type)
+		}
+	}
+	times := make([]int, len(file.Events))
+	for i, e := range file.Events {
+		times[i] = e.Time
+	}
+	if err := checkSchedule(sim, times, events); err != nil {
+		return nil, err
+	}
+	for i, event := range events {
+		if err := sim.ScheduleEvent(times[i], event); err != nil {
+			return nil, fmt.Errorf("event %v: %v", i, err)
+		}
+	}
+	return sim, nil
+}
+
+// Check the events to schedule at the given times against the servers and links
+// of the simulation, so that mistakes are reported when the file is loaded
+// instead of logged when the events fail to run. Tokens only arrive after the
+// time step they are sent in, so a server can send at most the tokens it starts
+// with plus the tokens sent to it at earlier time steps.
+func checkSchedule(sim *Simulator, times []int, events []interface{}) error {
+	order := make([]int, len(events))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return times[order[a]] < times[order[b]] })
+	available := make(map[string]int) // key = server ID
+	for _, serverId := range getSortedKeys(sim.servers) {
+		available[serverId] = sim.servers[serverId].Tokens
+	}
+	// Tokens sent at the current time step, available from the next one
+	arriving := make(map[string]int)
+	for j, i := range order {
+		if j > 0 && times[i] != times[order[j-1]] {
+			for serverId, numTokens := range arriving {
+				available[serverId] += numTokens
+			}
+			arriving = make(map[string]int)
+		}
+		var err error
+		switch event := events[i].(type) {
+		case PassTokenEvent:
+			src, ok := sim.servers[event.src]
+			if !ok {
+				err = newError(ErrUnknownServer, "Server %v does not exist", event.src)
+			} else if _, ok := src.outboundLinks[event.dest]; !ok {
+				err = newError(ErrUnknownDest, "Unknown dest ID %v from server %v", event.dest, event.src)
+			} else if event.tokens > available[event.src] {
+				err = newError(ErrInsufficientTokens,
+					"Server %v sends %v tokens at time %v but can have at most %v",
+					event.src, event.tokens, times[i], available[event.src])
+			}
+			available[event.src] -= event.tokens
+			arriving[event.dest] += event.tokens
+		case SnapshotEvent:
+			if _, ok := sim.servers[event.serverId]; !ok {
+				err = newError(ErrUnknownServer, "Server %v does not exist", event.serverId)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("event %v: %w", i, err)
+		}
+	}
+	return nil
+}
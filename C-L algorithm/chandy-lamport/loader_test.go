@@ -0,0 +1,140 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadTopologyInFlight(t *testing.T) {
+	sim, err := LoadTopology(path.Join(testDir, "2nodes-inflight.top"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.InitialTokens() != 3 {
+		t.Fatalf("Expected 3 tokens in the system, got %v\n", sim.InitialTokens())
+	}
+	snapshotId := sim.nextSnapshotId
+	sim.StartSnapshot("N2")
+	for sim.finishedMap[snapshotId] < len(sim.servers) {
+		sim.Tick()
+	}
+	snap := sim.CollectSnapshot(snapshotId)
+	expected := []*SnapshotMessage{{"N1", "N2", TokenMessage{numTokens: 2}, 0, 0}}
+	if !reflect.DeepEqual(expected, snap.messages) {
+		t.Fatalf("Expected recorded messages\n%v\ngot\n%v\n",
+			messagesString(expected, "\t"), messagesString(snap.messages, "\t"))
+	}
+}
+
+func TestLoadTopologyWithSeed(t *testing.T) {
+	receiveTimes := func() []int {
+		sim, err := LoadTopologyWithSeed(path.Join(testDir, "3nodes.top"), 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Draws from the global source must not affect the seeded simulator
+		rand.Int63()
+		times := make([]int, 0)
+		for i := 0; i < 10; i++ {
+			sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+		}
+		for _, info := range sim.AllInFlight() {
+			times = append(times, info.receiveTime)
+		}
+		return times
+	}
+	if first, second := receiveTimes(), receiveTimes(); !reflect.DeepEqual(first, second) {
+		t.Fatalf("Expected the same delays from the same seed, got %v and %v\n", first, second)
+	}
+}
+
+func TestLoadTopologyParseError(t *testing.T) {
+	fileName := path.Join(t.TempDir(), "bad.top")
+	contents := "2\nN1 1\nN2 0\nN1 N2\ninflight N1 N2 two 5\n"
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadTopology(fileName)
+	if err == nil || !strings.Contains(err.Error(), fileName+":5:") {
+		t.Fatalf("Expected an error naming line 5, got %v\n", err)
+	}
+}
+
+func TestLoadTopologyJSON(t *testing.T) {
+	fileName := path.Join(t.TempDir(), "scenario.json")
+	contents := `{
+	  "servers": [{"id": "N1", "tokens": 10}, {"id": "N2", "tokens": 0}],
+	  "latency": {"model": "constant", "delay": 2},
+	  "links": [
+	    {"src": "N1", "dest": "N2", "latency": {"model": "constant", "delay": 5}},
+	    {"src": "N2", "dest": "N1"}
+	  ],
+	  "events": [
+	    {"time": 2, "type": "send", "src": "N1", "dest": "N2", "tokens": 4},
+	    {"time": 3, "type": "snapshot", "server": "N2"}
+	  ]
+	}`
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sim, err := LoadTopology(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Drain()
+	// The tokens are sent at time 2 and take 5 ticks, while the marker of N2
+	// reaches N1 at time 5, so the tokens are recorded in flight
+	snap := sim.CollectSnapshot(0)
+	expected := []*SnapshotMessage{{"N1", "N2", TokenMessage{numTokens: 4}, 0, 1}}
+	if !reflect.DeepEqual(expected, snap.messages) {
+		t.Fatalf("Expected recorded messages\n%v\ngot\n%v\n",
+			messagesString(expected, "\t"), messagesString(snap.messages, "\t"))
+	}
+	if err := VerifySnapshot(snap, sim.Topology()); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := strings.Replace(contents, `"constant", "delay": 5`, `"poisson", "delay": 5`, 1)
+	if err := ioutil.WriteFile(fileName, []byte(bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTopology(fileName); err == nil || !strings.Contains(err.Error(), "poisson") {
+		t.Fatalf("Expected an error for an unknown latency model, got %v\n", err)
+	}
+}
+
+func TestLoadTopologyJSONInvalidEvents(t *testing.T) {
+	for _, test := range []struct {
+		events   string
+		expected error
+	}{
+		{`{"time": 1, "type": "send", "src": "N3", "dest": "N1", "tokens": 1}`, ErrUnknownServer},
+		{`{"time": 1, "type": "send", "src": "N2", "dest": "N3", "tokens": 1}`, ErrUnknownDest},
+		{`{"time": 1, "type": "snapshot", "server": "N3"}`, ErrUnknownServer},
+		{`{"time": 1, "type": "send", "src": "N1", "dest": "N2", "tokens": 11}`, ErrInsufficientTokens},
+		// N2 cannot forward the tokens of N1 before they arrive
+		{`{"time": 2, "type": "send", "src": "N2", "dest": "N1", "tokens": 4},
+		  {"time": 2, "type": "send", "src": "N1", "dest": "N2", "tokens": 4}`, ErrInsufficientTokens},
+		{`{"time": 3, "type": "send", "src": "N2", "dest": "N1", "tokens": 4},
+		  {"time": 2, "type": "send", "src": "N1", "dest": "N2", "tokens": 4}`, nil},
+	} {
+		fileName := path.Join(t.TempDir(), "scenario.json")
+		contents := `{
+		  "servers": [{"id": "N1", "tokens": 10}, {"id": "N2", "tokens": 0}],
+		  "links": [{"src": "N1", "dest": "N2"}, {"src": "N2", "dest": "N1"}],
+		  "events": [` + test.events + `]
+		}`
+		if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadTopology(fileName)
+		if test.expected == nil && err != nil || !errors.Is(err, test.expected) {
+			t.Fatalf("Events %v: expected error %v, got %v\n", test.events, test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package chandy_lamport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// An event of the log as written by `WriteJSONL`
+type jsonlEvent struct {
+	Time    int    `json:"time"`
+	Server  string `json:"server"`
+	Tokens  int    `json:"tokens"`
+	Lamport int    `json:"lamport"`
+	Kind    string `json:"kind"`
+	Src     string `json:"src,omitempty"`
+	Dest    string `json:"dest,omitempty"`
+	Message string `json:"message,omitempty"`
+	// The message a transformed message was turned into
+	Transformed string `json:"transformed,omitempty"`
+	// Number of tokens carried by the message, of any type
+	NumTokens int `json:"numTokens,omitempty"`
+	// IDs of the snapshots the message carries a marker for, or of the
+	// snapshot started or ended
+	SnapshotIds []int `json:"snapshotIds,omitempty"`
+}
+
+func newJSONLEvent(epoch int, event LogEvent) jsonlEvent {
+	e := jsonlEvent{
+		Time:    epoch,
+		Server:  event.serverId,
+		Tokens:  event.serverTokens,
+		Lamport: event.lamport,
+	}
+	message := func(kind, src, dest string, message interface{}) {
+		e.Kind, e.Src, e.Dest, e.Message = kind, src, dest, fmt.Sprint(message)
+		if token, ok := anyTokenMessage(message); ok {
+			e.NumTokens = token.numTokens
+		}
+		e.SnapshotIds = markerSnapshotIds(message)
+	}
+	switch evt := event.event.(type) {
+	case SentMessageEvent:
+		message("sent", evt.src, evt.dest, evt.message)
+	case ReceivedMessageEvent:
+		message("received", evt.src, evt.dest, evt.message)
+	case DroppedMessageEvent:
+		message("dropped", evt.src, evt.dest, evt.message)
+	case DuplicatedMessageEvent:
+		message("duplicated", evt.src, evt.dest, evt.message)
+	case TransformedMessageEvent:
+		message("transformed", evt.src, evt.dest, evt.before)
+		e.Transformed = fmt.Sprint(evt.after)
+	case StartSnapshot:
+		e.Kind, e.SnapshotIds = "startSnapshot", []int{evt.snapshotId}
+	case EndSnapshot:
+		e.Kind, e.SnapshotIds = "endSnapshot", []int{evt.snapshotId}
+	}
+	return e
+}
+
+// Write the retained events in the JSON Lines format, one object per line with
+// the time step of the event, the server it happened on, the tokens of that
+// server before the event and its Lamport clock, the kind of the event and the
+// message it concerns
+func (logger *Logger) WriteJSONL(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for epoch, events := range logger.events {
+		for _, event := range events {
+			if err := encoder.Encode(newJSONLEvent(logger.firstEpoch+epoch, event)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// =======================================
+//  OpenTelemetry traces in the OTLP/JSON
+//  encoding, see `Logger.WriteOTLP`
+// =======================================
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Events            []otlpSpanEvent `json:"events,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpSpanEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{key, otlpValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int) otlpAttribute {
+	s := fmt.Sprint(value)
+	return otlpAttribute{key, otlpValue{IntValue: &s}}
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+)
+
+// The spans of the trace of a snapshot, while they are built
+type snapshotTrace struct {
+	root otlpSpan
+	// Time steps of the first and last events of the snapshot
+	first, last int
+	markers     []markerSpan
+	// Marker spans not ended yet, by the send they were started on
+	pending map[sendId]*otlpSpan
+}
+
+// The span of a marker, along with the send it was started on
+type markerSpan struct {
+	span *otlpSpan
+	send sendId
+}
+
+// Write the retained events as OpenTelemetry traces in the OTLP/JSON encoding,
+// which a collector accepts on /v1/traces, e.g. to inspect a run in Jaeger.
+// Every snapshot is a trace: its root span lasts from the first to the last
+// event of the snapshot and has an event for every server completing it, and
+// every marker sent for it is a child span lasting from its send to its
+// receive. Time step n is mapped to start + n * tick. The markers dropped on
+// their link, or still in flight at the end of the log, end with an error.
+func (logger *Logger) WriteOTLP(w io.Writer, start time.Time, tick time.Duration) error {
+	timestamp := func(step int) string {
+		return fmt.Sprint(start.Add(time.Duration(step) * tick).UnixNano())
+	}
+	traces := make(map[int]*snapshotTrace)
+	traceOf := func(snapshotId, step int) *snapshotTrace {
+		trace, ok := traces[snapshotId]
+		if !ok {
+			trace = &snapshotTrace{first: step, pending: make(map[sendId]*otlpSpan)}
+			trace.root = otlpSpan{
+				TraceId:    fmt.Sprintf("%032x", snapshotId+1),
+				SpanId:     fmt.Sprintf("%016x", 1),
+				Name:       fmt.Sprintf("snapshot %v", snapshotId),
+				Kind:       otlpSpanKindInternal,
+				Attributes: []otlpAttribute{intAttribute("snapshot.id", snapshotId)},
+			}
+			traces[snapshotId] = trace
+		}
+		trace.last = step
+		return trace
+	}
+	// Markers dropped on a link are matched with the first marker pending on it
+	endDropped := func(trace *snapshotTrace, channel ChannelId, step int) {
+		for _, marker := range trace.markers {
+			if marker.send.channel == channel && trace.pending[marker.send] == marker.span {
+				marker.span.EndTimeUnixNano = timestamp(step)
+				marker.span.Status = &otlpStatus{otlpStatusError, "dropped"}
+				delete(trace.pending, marker.send)
+				return
+			}
+		}
+	}
+	lastStep := logger.firstEpoch
+	for epoch, events := range logger.events {
+		step := logger.firstEpoch + epoch
+		lastStep = step
+		for _, event := range events {
+			switch evt := event.event.(type) {
+			case StartSnapshot:
+				trace := traceOf(evt.snapshotId, step)
+				if len(trace.root.Attributes) == 1 {
+					trace.root.Attributes = append(trace.root.Attributes,
+						stringAttribute("snapshot.initiator", evt.serverId))
+				}
+			case EndSnapshot:
+				trace := traceOf(evt.snapshotId, step)
+				trace.root.Events = append(trace.root.Events, otlpSpanEvent{
+					timestamp(step),
+					"snapshot completed",
+					[]otlpAttribute{stringAttribute("server.id", evt.serverId)},
+				})
+			case SentMessageEvent:
+				channel := ChannelId{evt.src, evt.dest}
+				for _, snapshotId := range markerSnapshotIds(evt.message) {
+					trace := traceOf(snapshotId, step)
+					span := &otlpSpan{
+						TraceId:           trace.root.TraceId,
+						SpanId:            fmt.Sprintf("%016x", len(trace.markers)+2),
+						ParentSpanId:      trace.root.SpanId,
+						Name:              fmt.Sprintf("marker %v -> %v", evt.src, evt.dest),
+						Kind:              otlpSpanKindInternal,
+						StartTimeUnixNano: timestamp(step),
+						Attributes: []otlpAttribute{
+							intAttribute("snapshot.id", snapshotId),
+							stringAttribute("marker.src", evt.src),
+							stringAttribute("marker.dest", evt.dest),
+							stringAttribute("message", fmt.Sprint(evt.message)),
+						},
+					}
+					send := sendId{channel, event.lamport}
+					trace.markers = append(trace.markers, markerSpan{span, send})
+					trace.pending[send] = span
+				}
+			case ReceivedMessageEvent:
+				id := sendId{ChannelId{evt.src, evt.dest}, evt.lamport}
+				for _, snapshotId := range markerSnapshotIds(evt.message) {
+					trace := traceOf(snapshotId, step)
+					// Only the first copy of a duplicated marker ends its span
+					if span, ok := trace.pending[id]; ok {
+						span.EndTimeUnixNano = timestamp(step)
+						delete(trace.pending, id)
+					}
+				}
+			case DroppedMessageEvent:
+				for _, snapshotId := range markerSnapshotIds(evt.message) {
+					endDropped(traceOf(snapshotId, step), ChannelId{evt.src, evt.dest}, step)
+				}
+			}
+		}
+	}
+	snapshotIds := make([]int, 0)
+	for snapshotId := range traces {
+		snapshotIds = append(snapshotIds, snapshotId)
+	}
+	sort.Ints(snapshotIds)
+	spans := make([]otlpSpan, 0)
+	for _, snapshotId := range snapshotIds {
+		trace := traces[snapshotId]
+		trace.root.StartTimeUnixNano = timestamp(trace.first)
+		trace.root.EndTimeUnixNano = timestamp(trace.last)
+		spans = append(spans, trace.root)
+		for _, marker := range trace.markers {
+			span := marker.span
+			if span.EndTimeUnixNano == "" {
+				span.EndTimeUnixNano = timestamp(lastStep)
+				span.Status = &otlpStatus{otlpStatusError, "not delivered"}
+			}
+			spans = append(spans, *span)
+		}
+	}
+	request := otlpRequest{[]otlpResourceSpans{{
+		otlpResource{[]otlpAttribute{stringAttribute("service.name", "clsim")}},
+		[]otlpScopeSpans{{otlpScope{"chandy_lamport"}, spans}},
+	}}}
+	return json.NewEncoder(w).Encode(request)
+}
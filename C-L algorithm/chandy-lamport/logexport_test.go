@@ -0,0 +1,131 @@
+package chandy_lamport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteJSONL(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	var buf bytes.Buffer
+	if err := sim.Logger().WriteJSONL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	events := make([]jsonlEvent, 0)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e jsonlEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Line %q is not valid JSON: %v\n", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != len(sim.EventLog()) {
+		t.Fatalf("Expected %v lines, got %v\n", len(sim.EventLog()), len(events))
+	}
+	expected := jsonlEvent{
+		Server:    "N1",
+		Tokens:    10,
+		Lamport:   1,
+		Kind:      "sent",
+		Src:       "N1",
+		Dest:      "N2",
+		Message:   "token(3)",
+		NumTokens: 3,
+	}
+	if !reflect.DeepEqual(events[0], expected) {
+		t.Fatalf("Expected the first event to be %+v, got %+v\n", expected, events[0])
+	}
+	started := jsonlEvent{Server: "N1", Tokens: 7, Lamport: 1, Kind: "startSnapshot", SnapshotIds: []int{0}}
+	if !reflect.DeepEqual(events[1], started) {
+		t.Fatalf("Expected the second event to be %+v, got %+v\n", started, events[1])
+	}
+}
+
+func TestWriteOTLP(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	sim.StartSnapshot("N3")
+	sim.Drain()
+	var buf bytes.Buffer
+	start := time.Unix(1000, 0)
+	if err := sim.Logger().WriteOTLP(&buf, start, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	var request otlpRequest
+	if err := json.Unmarshal(buf.Bytes(), &request); err != nil {
+		t.Fatal(err)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	roots := make(map[string]otlpSpan)
+	markers := make(map[string]int)
+	for _, span := range spans {
+		if span.ParentSpanId == "" {
+			roots[span.TraceId] = span
+			continue
+		}
+		markers[span.TraceId]++
+		if span.ParentSpanId != roots[span.TraceId].SpanId {
+			t.Fatalf("Expected marker span %v to be a child of the snapshot\n", span.Name)
+		}
+		if span.Status != nil || span.EndTimeUnixNano <= span.StartTimeUnixNano {
+			t.Fatalf("Expected marker span %v to end after it starts, got %+v\n", span.Name, span)
+		}
+	}
+	if len(roots) != 2 {
+		t.Fatalf("Expected a trace per snapshot, got %v\n", len(roots))
+	}
+	for traceId, root := range roots {
+		if markers[traceId] != 6 {
+			t.Fatalf("Expected a span per marker of %v, got %v\n", root.Name, markers[traceId])
+		}
+		if len(root.Events) != 3 {
+			t.Fatalf("Expected an event per server completing %v, got %v\n", root.Name, root.Events)
+		}
+	}
+	first := roots[spans[0].TraceId]
+	if first.Name != "snapshot 0" || first.StartTimeUnixNano != "1000000000000" {
+		t.Fatalf("Expected snapshot 0 to start at time step 0, got %+v\n", first)
+	}
+}
+
+func TestWriteOTLPDroppedMarker(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectLoss("N1", "N2", 1)
+	sim.StartSnapshot("N1")
+	for i := 0; i < 5; i++ {
+		sim.Tick()
+	}
+	var buf bytes.Buffer
+	if err := sim.Logger().WriteOTLP(&buf, time.Unix(0, 0), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var request otlpRequest
+	if err := json.Unmarshal(buf.Bytes(), &request); err != nil {
+		t.Fatal(err)
+	}
+	for _, span := range request.ResourceSpans[0].ScopeSpans[0].Spans {
+		if span.Name == "marker N1 -> N2" {
+			if span.Status == nil || span.Status.Message != "dropped" {
+				t.Fatalf("Expected the marker to N2 to be dropped, got %+v\n", span)
+			}
+			return
+		}
+	}
+	t.Fatalf("Expected a span for the marker to N2\n")
+}
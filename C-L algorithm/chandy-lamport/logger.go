@@ -2,6 +2,7 @@ package chandy_lamport
 
 import (
 	"fmt"
+	"io"
 	"log"
 )
 
@@ -10,9 +11,22 @@ import (
 // =================================
 
 type Logger struct {
-	// index = time step
+	// index = time step, offset by firstEpoch
 	// value = events that occurred at that time step
 	events [][]LogEvent
+	// Max number of events retained, or 0 if unbounded
+	maxEvents int
+	// Number of events currently retained
+	numEvents int
+	// Number of events dropped to stay within maxEvents
+	evicted int
+	// Time step of the first entry in events. Epochs whose events were all
+	// evicted are dropped from the front of events.
+	firstEpoch int
+	// If set, every event is also written to this trace, see `RecordTrace`
+	trace io.Writer
+	// Number of events recorded by kind, see `Simulator.Metrics`
+	counts eventCounts
 }
 
 type LogEvent struct {
@@ -20,6 +34,8 @@ type LogEvent struct {
 	// Number of tokens before execution of event
 	serverTokens int
 	event        interface{}
+	// Lamport clock of the server after the event, see `lamport.go`
+	lamport int
 }
 
 func (event LogEvent) String() string {
@@ -27,17 +43,20 @@ func (event LogEvent) String() string {
 	switch evt := event.event.(type) {
 	case SentMessageEvent:
 		switch evt.message.(type) {
-		case TokenMessage:
+		case TokenMessage, ColoredMessage, TimestampedMessage, PiggybackedMessage:
 			prependWithTokens = true
 		}
 	case ReceivedMessageEvent:
 		switch evt.message.(type) {
-		case TokenMessage:
+		case TokenMessage, ColoredMessage, TimestampedMessage, PiggybackedMessage:
 			prependWithTokens = true
 		}
 	case StartSnapshot:
 		prependWithTokens = true
 	case EndSnapshot:
+	case TransformedMessageEvent:
+	case DroppedMessageEvent:
+	case DuplicatedMessageEvent:
 	default:
 		log.Fatal("Attempted to log unrecognized event: ", event.event)
 	}
@@ -52,13 +71,13 @@ func (event LogEvent) String() string {
 }
 
 func NewLogger() *Logger {
-	return &Logger{make([][]LogEvent, 0)}
+	return &Logger{make([][]LogEvent, 0), 0, 0, 0, 0, nil, eventCounts{}}
 }
 
 func (log *Logger) PrettyPrint() {
 	for epoch, events := range log.events {
 		if len(events) != 0 {
-			fmt.Printf("Time %v:\n", epoch)
+			fmt.Printf("Time %v:\n", log.firstEpoch+epoch)
 		}
 		for _, event := range events {
 			fmt.Printf("\t%v\n", event)
@@ -66,13 +85,110 @@ func (log *Logger) PrettyPrint() {
 	}
 }
 
+// Return the retained events formatted one per line, prefixed with their time step
+func (log *Logger) Lines() []string {
+	lines := make([]string, 0)
+	for epoch, events := range log.events {
+		for _, event := range events {
+			lines = append(lines, fmt.Sprintf("Time %v: %v", log.firstEpoch+epoch, event))
+		}
+	}
+	return lines
+}
+
 func (log *Logger) NewEpoch() {
 	log.events = append(log.events, make([]LogEvent, 0))
 }
 
 func (logger *Logger) RecordEvent(server *Server, event interface{}) {
+	// Events recorded before the first epoch belong to time step 0
+	if len(logger.events) == 0 {
+		logger.NewEpoch()
+	}
 	mostRecent := len(logger.events) - 1
 	events := logger.events[mostRecent]
-	events = append(events, LogEvent{server.Id, server.Tokens, event})
+	server.tickLamport(event)
+	logEvent := LogEvent{server.Id, server.Tokens, event, server.lamport}
+	events = append(events, logEvent)
 	logger.events[mostRecent] = events
+	if logger.trace != nil {
+		fmt.Fprintf(logger.trace, "event %q\n", logEvent.String())
+	}
+	logger.counts.count(event)
+	logger.numEvents++
+	logger.evict()
+}
+
+// Replace the message of the last event of the current time step that logged a
+// send on the given link, e.g. when a marker is merged into the one before it.
+// Nothing happens if that event has already been evicted.
+func (logger *Logger) replaceLastSent(src, dest string, message interface{}) {
+	if len(logger.events) == 0 {
+		return
+	}
+	events := logger.events[len(logger.events)-1]
+	for i := len(events) - 1; i >= 0; i-- {
+		if sent, ok := events[i].event.(SentMessageEvent); ok && sent.src == src && sent.dest == dest {
+			events[i].event = SentMessageEvent{src, dest, message}
+			return
+		}
+	}
+}
+
+// Keep only the most recent n events, dropping the oldest ones first.
+// A value of 0 or less removes the bound.
+func (logger *Logger) SetMaxEvents(n int) {
+	if n < 0 {
+		n = 0
+	}
+	logger.maxEvents = n
+	logger.evict()
+}
+
+// Return the number of events dropped because of the bound set in `SetMaxEvents`
+func (logger *Logger) Evicted() int {
+	return logger.evicted
+}
+
+// Drop the oldest retained events until the logger is within its bound
+func (logger *Logger) evict() {
+	if logger.maxEvents == 0 {
+		return
+	}
+	for logger.numEvents > logger.maxEvents {
+		oldest := logger.events[0]
+		if len(oldest) == 0 {
+			logger.dropOldestEpoch()
+			continue
+		}
+		// Clear the evicted entry so the backing array does not keep its event alive
+		oldest[0] = LogEvent{}
+		logger.events[0] = oldest[1:]
+		logger.numEvents--
+		logger.evicted++
+	}
+	// Epochs without events would otherwise accumulate one per time step
+	for len(logger.events) > 1 && len(logger.events[0]) == 0 {
+		logger.dropOldestEpoch()
+	}
+}
+
+// Release the oldest retained epoch. Once the front of the outer slice is
+// dropped, the next append that outgrows it reallocates only the live epochs.
+func (logger *Logger) dropOldestEpoch() {
+	logger.events[0] = nil
+	logger.events = logger.events[1:]
+	logger.firstEpoch++
+}
+
+// Return the retained events that occurred between the two time steps, inclusive
+func (logger *Logger) EventsBetween(start, end int) []LogEvent {
+	events := make([]LogEvent, 0)
+	if start < logger.firstEpoch {
+		start = logger.firstEpoch
+	}
+	for epoch := start; epoch <= end && epoch-logger.firstEpoch < len(logger.events); epoch++ {
+		events = append(events, logger.events[epoch-logger.firstEpoch]...)
+	}
+	return events
 }
@@ -0,0 +1,52 @@
+package chandy_lamport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoggerMaxEvents(t *testing.T) {
+	logger := NewLogger()
+	logger.NewEpoch()
+	logger.SetMaxEvents(3)
+	server := NewServer("N1", 0, nil)
+	for i := 0; i < 5; i++ {
+		logger.RecordEvent(server, StartSnapshot{server.Id, i})
+	}
+	expected := []LogEvent{
+		{"N1", 0, StartSnapshot{"N1", 2}, 0},
+		{"N1", 0, StartSnapshot{"N1", 3}, 0},
+		{"N1", 0, StartSnapshot{"N1", 4}, 0},
+	}
+	if actual := logger.EventsBetween(0, 0); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected retained events %v, got %v\n", expected, actual)
+	}
+	if logger.Evicted() != 2 {
+		t.Fatalf("Expected 2 evicted events, got %v\n", logger.Evicted())
+	}
+}
+
+func TestLoggerDropsEvictedEpochs(t *testing.T) {
+	logger := NewLogger()
+	logger.SetMaxEvents(2)
+	server := NewServer("N1", 0, nil)
+	for i := 0; i < 100; i++ {
+		logger.NewEpoch()
+		if i%10 == 0 {
+			logger.RecordEvent(server, StartSnapshot{server.Id, i})
+		}
+	}
+	if len(logger.events) > 20 {
+		t.Fatalf("Expected evicted epochs to be released, %v are retained\n", len(logger.events))
+	}
+	expected := []LogEvent{
+		{"N1", 0, StartSnapshot{"N1", 80}, 0},
+		{"N1", 0, StartSnapshot{"N1", 90}, 0},
+	}
+	if actual := logger.EventsBetween(0, 99); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected retained events %v, got %v\n", expected, actual)
+	}
+	if actual := logger.EventsBetween(90, 90); !reflect.DeepEqual(expected[1:], actual) {
+		t.Fatalf("Expected the event of time step 90, got %v\n", actual)
+	}
+}
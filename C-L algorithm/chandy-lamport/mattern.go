@@ -0,0 +1,255 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ===========================================================================
+//  Mattern's snapshot algorithm, selected with `SetSnapshotAlgorithm(Mattern)`
+// ===========================================================================
+//
+// Like Lai-Yang, this algorithm does not rely on FIFO links, but it tells the
+// messages sent before and after the cut apart with vector clocks instead of
+// colors. Every server maintains a vector clock, which it ticks on every send,
+// receive and local state recording, and attaches to every message it sends.
+//
+// The cut of a snapshot is defined by the initiator: it is the value of the
+// initiator's own clock entry when it records its state. A message is sent
+// after the cut if its timestamp has reached that value, i.e. its sender knew,
+// directly or not, that the initiator had recorded its state. Servers learn
+// about the cuts from the messages they receive, and record their state before
+// processing the first message sent after a cut. Messages sent before the cut
+// and received after recording are the state of their channel. As in Lai-Yang,
+// control messages announce how many messages were sent on each link before
+// the cut, so that a channel is closed once all of them have been received.
+
+// A vector clock: server ID -> number of events of that server known to have
+// happened before
+type VectorClock map[string]int
+
+func NewVectorClock() VectorClock {
+	return make(VectorClock)
+}
+
+// Return a copy of the clock that does not change with it
+func (c VectorClock) Copy() VectorClock {
+	clock := NewVectorClock()
+	for serverId, time := range c {
+		clock[serverId] = time
+	}
+	return clock
+}
+
+// Count one more event of the given server
+func (c VectorClock) Increment(serverId string) {
+	c[serverId]++
+}
+
+// Take the maximum of each entry of both clocks
+func (c VectorClock) Merge(other VectorClock) {
+	for serverId, time := range other {
+		if time > c[serverId] {
+			c[serverId] = time
+		}
+	}
+}
+
+// Return true if the event of this clock happened before the event of the
+// other one, i.e. no entry is larger and the clocks are not equal
+func (c VectorClock) HappenedBefore(other VectorClock) bool {
+	for serverId, time := range c {
+		if time > other[serverId] {
+			return false
+		}
+	}
+	for serverId, time := range other {
+		if time > c[serverId] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c VectorClock) String() string {
+	entries := make([]string, 0)
+	for _, serverId := range getSortedKeys(map[string]int(c)) {
+		entries = append(entries, fmt.Sprintf("%v:%v", serverId, c[serverId]))
+	}
+	return fmt.Sprintf("[%v]", strings.Join(entries, " "))
+}
+
+// The cut of a snapshot: the value of the initiator's entry in its own clock
+// when it recorded its state
+type snapshotCut struct {
+	initiator string
+	time      int
+}
+
+// Return true if a message with the given timestamp was sent after the cut
+func (cut snapshotCut) after(clock VectorClock) bool {
+	return clock[cut.initiator] >= cut.time
+}
+
+// A token message with the vector clock of its sender right after sending it,
+// and the cuts of the snapshots its sender knew about
+type TimestampedMessage struct {
+	message TokenMessage
+	clock   VectorClock
+	cuts    map[int]snapshotCut // key = snapshot ID
+}
+
+func (m TimestampedMessage) String() string {
+	return m.message.String()
+}
+
+// A message sent on every outbound link by a server when it records its state
+type MatternControlMessage struct {
+	snapshotId int
+	cut        snapshotCut
+	// Number of token messages sent on the link before the state was recorded
+	whiteSent int
+	clock     VectorClock
+}
+
+func (m MatternControlMessage) String() string {
+	return fmt.Sprintf("control(%v, %v)", m.snapshotId, m.whiteSent)
+}
+
+// Return the vector clock of this server
+func (server *Server) Clock() VectorClock {
+	return server.clock.Copy()
+}
+
+// Tick the clock for sending a token message and attach it to the message
+func (server *Server) timestampMessage(message TokenMessage) TimestampedMessage {
+	server.clock.Increment(server.Id)
+	cuts := make(map[int]snapshotCut)
+	for snapshotId, cut := range server.cuts {
+		cuts[snapshotId] = cut
+	}
+	return TimestampedMessage{message, server.clock.Copy(), cuts}
+}
+
+// Record the local state for Mattern's algorithm. This is the cut event of the
+// snapshot if this server initiates it.
+func (server *Server) recordMatternState(snapshotId int) {
+	server.clock.Increment(server.Id)
+	cut, ok := server.cuts[snapshotId]
+	if !ok {
+		cut = snapshotCut{server.Id, server.clock[server.Id]}
+		server.cuts[snapshotId] = cut
+	}
+	// The state may be recorded on a control message, whose clock may not show
+	// the cut yet. Every message sent from now on must be after the cut.
+	if server.clock[cut.initiator] < cut.time {
+		server.clock[cut.initiator] = cut.time
+	}
+	server.sendMatternControls(snapshotId)
+}
+
+// Send a control message on every outbound link after recording the local state
+func (server *Server) sendMatternControls(snapshotId int) {
+	server.whiteExpected[snapshotId] = make(map[string]int)
+	server.whiteReceived[snapshotId] = make(map[string]int)
+	// Every message received so far was sent before the cut
+	for src, count := range server.receivedCount {
+		server.whiteReceived[snapshotId][src] = count
+	}
+	for _, dest := range server.neighborIds() {
+		server.sendMatternControl(snapshotId, server.outboundLinks[dest])
+	}
+}
+
+// Send the control message of a snapshot on one outbound link
+func (server *Server) sendMatternControl(snapshotId int, link *Link) {
+	message := MatternControlMessage{
+		snapshotId,
+		server.cuts[snapshotId],
+		server.sentCount[link.dest],
+		server.clock.Copy(),
+	}
+	server.sim.logger.RecordEvent(server, SentMessageEvent{server.Id, link.dest, message})
+	server.sim.enqueue(link, SendMessageEvent{
+		server.Id,
+		link.dest,
+		message,
+		server.sim.receiveTimeOn(link),
+		server.sim.time,
+		server.lamport})
+}
+
+// Learn about a cut, recording the local state first if the message it was
+// learned from was sent after it. Control messages are always sent after the
+// cut, even though the clock of their sender may not show it yet.
+func (server *Server) learnCut(snapshotId int, cut snapshotCut, afterCut bool) {
+	if server.isPruned(snapshotId) {
+		return
+	}
+	if _, ok := server.cuts[snapshotId]; !ok {
+		server.cuts[snapshotId] = cut
+	}
+	if afterCut && !server.receivedSnapshot[snapshotId] {
+		server.StartSnapshot(snapshotId)
+	}
+}
+
+func (server *Server) handleMatternPacket(src string, message interface{}) error {
+	switch v := message.(type) {
+	case MatternControlMessage:
+		server.learnCut(v.snapshotId, v.cut, true)
+		server.clock.Merge(v.clock)
+		server.clock.Increment(server.Id)
+		if server.isPruned(v.snapshotId) {
+			return nil
+		}
+		if !server.inReceivedMarker[v.snapshotId][src] {
+			server.closeChannel(v.snapshotId, src)
+			server.whiteExpected[v.snapshotId][src] = v.whiteSent
+		}
+		server.checkLaiYangComplete(v.snapshotId)
+	case TokenMessage:
+		// Messages without a timestamp, e.g. injected in flight, were sent
+		// before any cut
+		return server.handleMatternPacket(src, TimestampedMessage{v, NewVectorClock(), nil})
+	case TimestampedMessage:
+		learned := make([]int, 0)
+		for snapshotId := range v.cuts {
+			learned = append(learned, snapshotId)
+		}
+		sort.Ints(learned)
+		// Record the local state before processing a message sent after a cut
+		for _, snapshotId := range learned {
+			cut := v.cuts[snapshotId]
+			server.learnCut(snapshotId, cut, cut.after(v.clock))
+		}
+		server.clock.Merge(v.clock)
+		server.clock.Increment(server.Id)
+		server.receivedCount[src]++
+		recording := make([]int, 0)
+		for snapshotId, received := range server.receivedSnapshot {
+			if received && !server.completedSnapshot[snapshotId] &&
+				!server.cuts[snapshotId].after(v.clock) {
+				recording = append(recording, snapshotId)
+			}
+		}
+		sort.Ints(recording)
+		for _, snapshotId := range recording {
+			server.whiteReceived[snapshotId][src]++
+			server.recordMessage(snapshotId, &SnapshotMessage{
+				src:      src,
+				dest:     server.Id,
+				message:  v.message,
+				hopCount: v.message.hopCount,
+			})
+		}
+		server.addTokens(v.message.tokenType, v.message.numTokens)
+		err := server.forwardTokens(v.message)
+		for _, snapshotId := range recording {
+			server.checkLaiYangComplete(snapshotId)
+		}
+		return err
+	}
+	return nil
+}
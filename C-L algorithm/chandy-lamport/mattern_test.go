@@ -0,0 +1,68 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func runMatternTest(t *testing.T, topFile string, eventsFile string, numSnapshots int) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetSnapshotAlgorithm(Mattern)
+	readTopology(topFile, sim)
+	for _, src := range getSortedKeys(sim.servers) {
+		for _, dest := range getSortedKeys(sim.servers[src].outboundLinks) {
+			mustGetLink(sim, src, dest).SetOrdering(RandomReorder)
+		}
+	}
+	snaps := injectEvents(eventsFile, sim)
+	if len(snaps) != numSnapshots {
+		t.Fatalf("Expected %v snapshot(s), got %v\n", numSnapshots, len(snaps))
+	}
+	ids := make([]int, 0)
+	for _, snap := range snaps {
+		ids = append(ids, snap.id)
+	}
+	if err := sim.AssertConcurrentConsistency(ids, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMattern3NodesBidirectionalMessages(t *testing.T) {
+	runMatternTest(t, "3nodes.top", "3nodes-bidirectional-messages.events", 1)
+}
+
+func TestMattern8NodesConcurrentSnapshots(t *testing.T) {
+	runMatternTest(t, "8nodes.top", "8nodes-concurrent-snapshots.events", 5)
+}
+
+func TestVectorClock(t *testing.T) {
+	a := NewVectorClock()
+	a.Increment("N1")
+	b := a.Copy()
+	b.Increment("N2")
+	if !a.HappenedBefore(b) || b.HappenedBefore(a) {
+		t.Fatalf("Expected %v to happen before %v\n", a, b)
+	}
+	c := NewVectorClock()
+	c.Increment("N3")
+	if a.HappenedBefore(c) || c.HappenedBefore(a) {
+		t.Fatalf("Expected %v and %v to be concurrent\n", a, c)
+	}
+	c.Merge(b)
+	if c.String() != "[N1:1 N2:1 N3:1]" {
+		t.Fatalf("Expected the merged clock to be [N1:1 N2:1 N3:1], got %v\n", c)
+	}
+	if a.HappenedBefore(a) {
+		t.Fatalf("Expected a clock not to happen before itself\n")
+	}
+}
+
+func TestMatternCensusUnsupported(t *testing.T) {
+	sim := NewSimulator()
+	sim.SetSnapshotAlgorithm(Mattern)
+	readTopology("3nodes.top", sim)
+	if _, err := sim.Census(0); err == nil {
+		t.Fatalf("Expected an error taking a census with Mattern's algorithm\n")
+	}
+}
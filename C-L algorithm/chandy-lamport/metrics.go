@@ -0,0 +1,164 @@
+package chandy_lamport
+
+// Measurements of a simulation run so far, see `Simulator.Metrics`
+type Metrics struct {
+	// Number of messages of any kind sent and received by the servers,
+	// including markers and control messages
+	MessagesSent     int
+	MessagesReceived int
+	// Number of messages sent by the snapshot algorithm: markers, batches of
+	// markers, and Lai-Yang or Mattern control messages
+	MarkersSent int
+	// Number of markers attached to token or application messages instead,
+	// see `SetMarkerMode`
+	MarkersPiggybacked int
+	// Number of messages queued on a link after each tick, averaged over all
+	// the links and ticks
+	AvgQueueDepth float64
+	// snapshotID -> server ID -> number of ticks between the initiation of the
+	// snapshot and the completion of the local snapshot of the server
+	SnapshotLatency map[int]map[string]int
+	// Number of tokens carried by the messages queued on the links right now
+	TokensInFlight int
+}
+
+// Number of events recorded by the logger, by kind. Unlike the events
+// themselves, these are kept when events are evicted.
+type eventCounts struct {
+	sent               int
+	received           int
+	markersSent        int
+	markersPiggybacked int
+}
+
+func (c *eventCounts) count(event interface{}) {
+	switch evt := event.(type) {
+	case SentMessageEvent:
+		c.sent++
+		switch msg := evt.message.(type) {
+		case MarkerMessage, MultiMarkerMessage, LaiYangControlMessage, MatternControlMessage:
+			c.markersSent++
+		case PiggybackedMessage:
+			c.markersPiggybacked += len(msg.snapshotIds)
+		}
+	case ReceivedMessageEvent:
+		c.received++
+	}
+}
+
+// Bookkeeping of the simulator for the metrics that the logger cannot provide
+type simMetrics struct {
+	snapshotStart map[int]int            // snapshotID -> time step of initiation
+	snapshotEnd   map[int]map[string]int // snapshotID -> server ID -> time step of completion
+	// Sum over the ticks of the average number of messages queued per link
+	queueDepthTotal float64
+	queueSamples    int
+	// server ID -> latencies of the snapshots released by pruning
+	prunedLatency map[string]*latencyHistogram
+}
+
+func newSimMetrics() *simMetrics {
+	return &simMetrics{make(map[int]int), make(map[int]map[string]int), 0, 0,
+		make(map[string]*latencyHistogram)}
+}
+
+// Snapshot latencies counted in the buckets of `snapshotDurationBuckets`
+type latencyHistogram struct {
+	counts []int // cumulative, as in the exported histogram
+	count  int
+	sum    int
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{make([]int, len(snapshotDurationBuckets)), 0, 0}
+}
+
+func (h *latencyHistogram) observe(latency int) {
+	for i, bound := range snapshotDurationBuckets {
+		if latency <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += latency
+}
+
+func (h *latencyHistogram) add(other *latencyHistogram) {
+	for i := range h.counts {
+		h.counts[i] += other.counts[i]
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// Fold the latencies of a snapshot into the histograms of its servers and
+// forget the snapshot
+func (m *simMetrics) releaseSnapshot(snapshotId int) {
+	if start, ok := m.snapshotStart[snapshotId]; ok {
+		for serverId, end := range m.snapshotEnd[snapshotId] {
+			if m.prunedLatency[serverId] == nil {
+				m.prunedLatency[serverId] = newLatencyHistogram()
+			}
+			m.prunedLatency[serverId].observe(end - start)
+		}
+	}
+	delete(m.snapshotStart, snapshotId)
+	delete(m.snapshotEnd, snapshotId)
+}
+
+func (m *simMetrics) snapshotCompleted(snapshotId int, serverId string, time int) {
+	if m.snapshotEnd[snapshotId] == nil {
+		m.snapshotEnd[snapshotId] = make(map[string]int)
+	}
+	m.snapshotEnd[snapshotId][serverId] = time
+}
+
+// Record the depth of the link queues at the end of a tick
+func (sim *Simulator) sampleQueueDepth() {
+	numLinks, numQueued := 0, 0
+	for _, server := range sim.servers {
+		// The sorted links are cheaper to walk than the map of outbound links
+		for _, link := range server.deliveringLinks() {
+			if _, closing := server.closingLinks[link.dest]; closing {
+				continue
+			}
+			numLinks++
+			numQueued += link.events.Len()
+		}
+	}
+	if numLinks > 0 {
+		sim.metrics.queueDepthTotal += float64(numQueued) / float64(numLinks)
+		sim.metrics.queueSamples++
+	}
+}
+
+// Return measurements of the simulation so far, e.g. to compare the cost of
+// snapshots on different topologies
+func (sim *Simulator) Metrics() Metrics {
+	metrics := Metrics{
+		MessagesSent:       sim.logger.counts.sent,
+		MessagesReceived:   sim.logger.counts.received,
+		MarkersSent:        sim.logger.counts.markersSent,
+		MarkersPiggybacked: sim.logger.counts.markersPiggybacked,
+		SnapshotLatency:    make(map[int]map[string]int),
+	}
+	if sim.metrics.queueSamples > 0 {
+		metrics.AvgQueueDepth = sim.metrics.queueDepthTotal / float64(sim.metrics.queueSamples)
+	}
+	for snapshotId, ends := range sim.metrics.snapshotEnd {
+		start, ok := sim.metrics.snapshotStart[snapshotId]
+		if !ok {
+			continue
+		}
+		metrics.SnapshotLatency[snapshotId] = make(map[string]int)
+		for serverId, end := range ends {
+			metrics.SnapshotLatency[snapshotId][serverId] = end - start
+		}
+	}
+	for _, info := range sim.AllInFlight() {
+		if msg, ok := tokenMessage(info.message); ok {
+			metrics.TokensInFlight += msg.numTokens
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,43 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	if metrics := sim.Metrics(); metrics.TokensInFlight != 3 || metrics.MessagesSent != 1 {
+		t.Fatalf("Expected 1 message carrying 3 tokens in flight, got %+v\n", metrics)
+	}
+	sim.StartSnapshot("N1")
+	start := sim.time
+	sim.Drain()
+	metrics := sim.Metrics()
+
+	if metrics.MessagesSent != 7 || metrics.MessagesReceived != 7 {
+		t.Fatalf("Expected 7 messages sent and received, got %v and %v\n",
+			metrics.MessagesSent, metrics.MessagesReceived)
+	}
+	if metrics.MarkersSent != 6 {
+		t.Fatalf("Expected one marker per link, got %v\n", metrics.MarkersSent)
+	}
+	if metrics.TokensInFlight != 0 {
+		t.Fatalf("Expected no tokens in flight after draining, got %v\n", metrics.TokensInFlight)
+	}
+	if metrics.AvgQueueDepth <= 0 || metrics.AvgQueueDepth > 2 {
+		t.Fatalf("Expected an average queue depth between 0 and 2, got %v\n", metrics.AvgQueueDepth)
+	}
+	latencies := metrics.SnapshotLatency[0]
+	if len(latencies) != 3 {
+		t.Fatalf("Expected a latency for every server, got %v\n", latencies)
+	}
+	for serverId, latency := range latencies {
+		if latency <= 0 || latency > sim.time-start {
+			t.Fatalf("Expected the latency of %v to be within the run, got %v\n", serverId, latency)
+		}
+	}
+}
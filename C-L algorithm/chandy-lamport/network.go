@@ -0,0 +1,398 @@
+package chandy_lamport
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ======================================================
+//  Real-network transport, as an alternative to Simulator
+// ======================================================
+//
+// A network server runs the same `Server` protocol logic as the simulator, but
+// its links are TCP connections to servers in other processes. Each network
+// server owns a private simulator holding the local server and a stub for every
+// peer, so that `HandlePacket` and `StartSnapshot` run unchanged: instead of
+// waiting for a tick, messages queued on the outbound links of the local server
+// are handed to the writer of their peer right away, which writes them to the
+// connection without holding the lock of the server. TCP connections are
+// FIFO, as required by Chandy-Lamport. Only the Chandy-Lamport algorithm is
+// supported, and gob is used as the wire format to avoid external dependencies.
+
+// A message as written on a connection
+type networkMessage struct {
+	From       string
+	Kind       string // "hello", "token" or "marker"
+	NumTokens  int
+	HopCount   int
+	SnapshotId int
+}
+
+type NetworkServer struct {
+	lock     sync.Mutex
+	sim      *Simulator
+	server   *Server
+	listener net.Listener
+	writers  map[string]*peerWriter // key = peer address
+	conns    []net.Conn
+	// Error that stopped the server from sending or receiving messages, if any
+	err error
+	// Closed when the server is closed
+	done      chan bool
+	closeOnce sync.Once
+}
+
+// Start a server listening for TCP connections on the given address, e.g.
+// "127.0.0.1:0". The ID of the server is the address it actually listens on.
+func NewNetworkServer(addr string) (*NetworkServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sim := NewSimulator()
+	id := listener.Addr().String()
+	sim.AddServer(id, 0)
+	ns := &NetworkServer{
+		sim:      sim,
+		server:   sim.servers[id],
+		listener: listener,
+		writers:  make(map[string]*peerWriter),
+		conns:    make([]net.Conn, 0),
+		done:     make(chan bool),
+	}
+	go ns.accept()
+	return ns, nil
+}
+
+// Start a server like `NewNetworkServer`, which is closed once the context is
+// done, stopping the goroutines that accept and read its connections
+func NewNetworkServerContext(ctx context.Context, addr string) (*NetworkServer, error) {
+	ns, err := NewNetworkServer(addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			ns.Close()
+		case <-ns.done:
+		}
+	}()
+	return ns, nil
+}
+
+// Return the ID of this server, which is the address it listens on
+func (ns *NetworkServer) Id() string {
+	return ns.server.Id
+}
+
+// Return the number of tokens held by this server
+func (ns *NetworkServer) Tokens() int {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	return ns.server.Tokens
+}
+
+// Add tokens to this server, e.g. to set up its initial state
+func (ns *NetworkServer) AddTokens(numTokens int) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	ns.server.Tokens += numTokens
+}
+
+// Return the error that stopped this server from sending or receiving messages
+func (ns *NetworkServer) Err() error {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	return ns.err
+}
+
+// Keep the first error that stopped this server
+func (ns *NetworkServer) setErr(err error) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	if ns.err == nil {
+		ns.err = err
+	}
+}
+
+// Writes the messages sent to a peer on its connection, in the order they were
+// sent. Writing can block on a slow peer, so it happens in a goroutine of its
+// own rather than under the lock of the server.
+type peerWriter struct {
+	lock    sync.Mutex
+	encoder *gob.Encoder
+	pending []networkMessage
+	// Signaled when messages are queued or the writer is stopped
+	wakeup *sync.Cond
+	closed bool
+}
+
+// Start writing the messages queued for a peer with the given encoder
+func (ns *NetworkServer) newPeerWriter(encoder *gob.Encoder) *peerWriter {
+	w := &peerWriter{encoder: encoder, pending: make([]networkMessage, 0)}
+	w.wakeup = sync.NewCond(&w.lock)
+	go w.run(ns)
+	return w
+}
+
+// Queue a message for the peer without waiting for it to be written
+func (w *peerWriter) send(msg networkMessage) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.pending = append(w.pending, msg)
+	w.wakeup.Signal()
+}
+
+// Stop writing, dropping the messages not written yet
+func (w *peerWriter) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	w.wakeup.Signal()
+}
+
+func (w *peerWriter) run(ns *NetworkServer) {
+	for {
+		w.lock.Lock()
+		for len(w.pending) == 0 && !w.closed {
+			w.wakeup.Wait()
+		}
+		if w.closed {
+			w.lock.Unlock()
+			return
+		}
+		batch := w.pending
+		w.pending = make([]networkMessage, 0)
+		w.lock.Unlock()
+		for _, msg := range batch {
+			if err := w.encoder.Encode(msg); err != nil {
+				w.lock.Lock()
+				closed := w.closed
+				w.lock.Unlock()
+				// Connections are closed on purpose when the server is closed
+				if !closed {
+					ns.setErr(err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// Add a stub for a peer to the private simulator if it is not known yet
+func (ns *NetworkServer) addPeer(peer string) *Server {
+	if _, ok := ns.sim.servers[peer]; !ok {
+		ns.sim.AddServer(peer, 0)
+	}
+	return ns.sim.servers[peer]
+}
+
+// Open a unidirectional link from this server to the server listening on the
+// given address
+func (ns *NetworkServer) Connect(peer string) error {
+	conn, err := net.Dial("tcp", peer)
+	if err != nil {
+		return err
+	}
+	encoder := gob.NewEncoder(conn)
+	if err := encoder.Encode(networkMessage{From: ns.Id(), Kind: "hello"}); err != nil {
+		conn.Close()
+		return err
+	}
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	ns.conns = append(ns.conns, conn)
+	ns.writers[peer] = ns.newPeerWriter(encoder)
+	ns.server.AddOutboundLink(ns.addPeer(peer))
+	ns.flush()
+	return nil
+}
+
+func (ns *NetworkServer) accept() {
+	for {
+		conn, err := ns.listener.Accept()
+		if err != nil {
+			return
+		}
+		ns.lock.Lock()
+		ns.conns = append(ns.conns, conn)
+		ns.lock.Unlock()
+		go ns.receive(conn)
+	}
+}
+
+// Handle the messages received on a connection from a peer
+func (ns *NetworkServer) receive(conn net.Conn) {
+	decoder := gob.NewDecoder(conn)
+	peer := ""
+	for {
+		var msg networkMessage
+		if err := decoder.Decode(&msg); err != nil {
+			return
+		}
+		ns.lock.Lock()
+		switch msg.Kind {
+		case "hello":
+			peer = msg.From
+			ns.addPeer(peer).AddOutboundLink(ns.server)
+		case "token":
+			ns.deliver(peer, TokenMessage{numTokens: msg.NumTokens, hopCount: msg.HopCount})
+		case "marker":
+			// Prepare the collection of a snapshot started elsewhere
+			if _, ok := ns.sim.collectors[msg.SnapshotId]; !ok {
+				ns.prepareSnapshot(msg.SnapshotId)
+			}
+			ns.deliver(peer, MarkerMessage{msg.SnapshotId})
+		default:
+			ns.err = fmt.Errorf("Unknown kind of message %q from %v", msg.Kind, peer)
+		}
+		ns.lock.Unlock()
+	}
+}
+
+// Deliver a message received from a peer to the local server, and send the
+// messages it queued in response
+func (ns *NetworkServer) deliver(src string, message interface{}) {
+	ns.sim.logger.RecordEvent(ns.server, ReceivedMessageEvent{src, ns.server.Id, message, 0})
+	if err := ns.server.HandlePacket(src, message); err != nil && ns.err == nil {
+		ns.err = err
+	}
+	ns.flush()
+}
+
+// Hand the messages queued on the outbound links of the local server over to
+// the writers of their peers
+func (ns *NetworkServer) flush() {
+	defer ns.sim.refreshMetrics()
+	for _, dest := range getSortedKeys(ns.server.outboundLinks) {
+		link := ns.server.outboundLinks[dest]
+		for !link.events.Empty() {
+			e := link.events.Pop()
+			msg := networkMessage{From: ns.server.Id}
+			switch m := e.message.(type) {
+			case TokenMessage:
+				if m.tokenType != DefaultTokenType {
+					ns.err = fmt.Errorf("Cannot send %v over the network", e.message)
+					continue
+				}
+				msg.Kind = "token"
+				msg.NumTokens = m.numTokens
+				msg.HopCount = m.hopCount
+			case MarkerMessage:
+				msg.Kind = "marker"
+				msg.SnapshotId = m.snapshotId
+			default:
+				ns.err = fmt.Errorf("Cannot send %v over the network", e.message)
+				continue
+			}
+			ns.writers[dest].send(msg)
+		}
+	}
+}
+
+func (ns *NetworkServer) prepareSnapshot(snapshotId int) {
+	ns.sim.newCollector(snapshotId, []string{ns.server.Id})
+}
+
+// Send tokens to a peer this server is connected to
+func (ns *NetworkServer) SendTokens(numTokens int, dest string) error {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	if _, ok := ns.writers[dest]; !ok {
+		return newError(ErrUnknownDest, "Server %v is not connected to %v", ns.server.Id, dest)
+	}
+	if err := ns.server.SendTokens(numTokens, dest); err != nil {
+		return err
+	}
+	ns.flush()
+	return nil
+}
+
+// Start a snapshot with the given ID from this server. Snapshot IDs must be
+// unique across all the servers taking part.
+func (ns *NetworkServer) StartSnapshot(snapshotId int) error {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	if ns.server.receivedSnapshot[snapshotId] {
+		return newError(ErrSnapshotAlreadyStarted,
+			"Snapshot %v has already been started on %v", snapshotId, ns.server.Id)
+	}
+	ns.prepareSnapshot(snapshotId)
+	ns.sim.logger.RecordEvent(ns.server, StartSnapshot{ns.server.Id, snapshotId})
+	ns.server.StartSnapshot(snapshotId)
+	ns.flush()
+	return nil
+}
+
+// Wait for the local snapshot of this server to complete, and return the state
+// it recorded: its tokens and the messages on its inbound channels. The global
+// snapshot is the merge of the local snapshots of all the servers.
+func (ns *NetworkServer) LocalSnapshot(snapshotId int, timeout time.Duration) (*SnapshotState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	snap, err := ns.LocalSnapshotContext(ctx, snapshotId)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("Snapshot %v did not complete on %v within %v",
+			snapshotId, ns.server.Id, timeout)
+	}
+	return snap, err
+}
+
+// Wait for the local snapshot of this server to complete like `LocalSnapshot`,
+// until the context is done, in which case the error of the context is returned
+func (ns *NetworkServer) LocalSnapshotContext(ctx context.Context, snapshotId int) (*SnapshotState, error) {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		ns.lock.Lock()
+		completed := ns.server.completedSnapshot[snapshotId]
+		snap := ns.server.snapshot[snapshotId]
+		ns.lock.Unlock()
+		if completed {
+			return snap, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop listening and close all the connections of this server, as well as its
+// metrics server if any
+func (ns *NetworkServer) Close() error {
+	ns.closeOnce.Do(func() { close(ns.done) })
+	err := ns.listener.Close()
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+	for _, w := range ns.writers {
+		w.stop()
+	}
+	for _, conn := range ns.conns {
+		conn.Close()
+	}
+	if ns.sim.exporter != nil {
+		// The lock is held, so the exporter is detached here instead of in its Close
+		ns.sim.exporter.server.Close()
+		ns.sim.exporter = nil
+	}
+	return err
+}
+
+// Merge the local snapshots recorded by several servers into a global snapshot
+func MergeLocalSnapshots(snapshotId int, locals []*SnapshotState) *SnapshotState {
+	sim := NewSimulator()
+	for _, local := range locals {
+		for serverId := range local.tokens {
+			sim.AddServer(serverId, 0)
+		}
+	}
+	return sim.mergeSnapshot(snapshotId, locals)
+}
@@ -0,0 +1,182 @@
+package chandy_lamport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Wait until every server has an inbound link from every other server
+func waitForInboundLinks(t *testing.T, servers []*NetworkServer) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ns := range servers {
+		for {
+			ns.lock.Lock()
+			numLinks := len(ns.server.inboundLinks)
+			ns.lock.Unlock()
+			if numLinks == len(servers)-1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Server %v only has %v inbound link(s)\n", ns.Id(), numLinks)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestNetworkSnapshot(t *testing.T) {
+	servers := make([]*NetworkServer, 0)
+	for i := 0; i < 3; i++ {
+		ns, err := NewNetworkServer("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ns.Close()
+		ns.AddTokens(10)
+		servers = append(servers, ns)
+	}
+	for _, src := range servers {
+		for _, dest := range servers {
+			if src != dest {
+				if err := src.Connect(dest.Id()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	waitForInboundLinks(t, servers)
+
+	for i := 0; i < 5; i++ {
+		if err := servers[0].SendTokens(1, servers[1].Id()); err != nil {
+			t.Fatal(err)
+		}
+		if err := servers[1].SendTokens(2, servers[2].Id()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := servers[0].StartSnapshot(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := servers[2].SendTokens(3, servers[0].Id()); err != nil {
+		t.Fatal(err)
+	}
+	if err := servers[0].StartSnapshot(0); err == nil {
+		t.Fatalf("Expected starting snapshot 0 twice to fail\n")
+	}
+
+	locals := make([]*SnapshotState, 0)
+	for _, ns := range servers {
+		local, err := ns.LocalSnapshot(0, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		locals = append(locals, local)
+	}
+	snap := MergeLocalSnapshots(0, locals)
+	total := 0
+	for _, tokens := range snap.tokens {
+		total += tokens
+	}
+	for _, msg := range snap.messages {
+		total += msg.message.(TokenMessage).numTokens
+	}
+	if total != 30 {
+		t.Fatalf("Expected the snapshot to hold 30 tokens, found %v\n", total)
+	}
+	if len(snap.channels) != 6 {
+		t.Fatalf("Expected 6 recorded channels, found %v\n", len(snap.channels))
+	}
+	for _, ns := range servers {
+		if err := ns.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := servers[0].SendTokens(1, "127.0.0.1:1"); err == nil {
+		t.Fatalf("Expected sending to an unknown peer to fail\n")
+	}
+}
+
+func TestNetworkSlowPeer(t *testing.T) {
+	// A peer that accepts the connection but never reads from it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Minute)
+		}
+	}()
+	ns, err := NewNetworkServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+	if err := ns.Connect(listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	// Enough messages to fill the socket buffers many times over
+	const numMessages = 200000
+	ns.AddTokens(numMessages)
+	done := make(chan error)
+	go func() {
+		for i := 0; i < numMessages; i++ {
+			if err := ns.SendTokens(1, listener.Addr().String()); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatalf("Expected sending to a slow peer not to block the server\n")
+	}
+	if tokens := ns.Tokens(); tokens != 0 {
+		t.Fatalf("Expected every token to be sent, %v left\n", tokens)
+	}
+}
+
+func TestNetworkServerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ns, err := NewNetworkServerContext(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.StartSnapshot(0); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancelWait := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelWait()
+	// Without inbound links, the local snapshot completes right away
+	if _, err := ns.LocalSnapshotContext(waitCtx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.LocalSnapshotContext(waitCtx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected waiting for a snapshot never started to time out, got %v\n", err)
+	}
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", ns.Id())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the server to stop listening once the context is cancelled\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,99 @@
+package chandy_lamport
+
+import "fmt"
+
+// A step of the snapshot protocol taken by a server, as reported to the
+// observers registered with `Simulator.OnSnapshotEvent`
+type SnapshotProgressKind int
+
+const (
+	// The server recorded its local state
+	SnapshotStarted SnapshotProgressKind = iota
+	// The server sent a marker, or the control message that stands for one
+	// in the Lai-Yang and Mattern algorithms, to `Peer`
+	MarkerSent
+	// The server received a marker from `Peer`
+	MarkerReceived
+	// The server stopped recording the channel from `Peer`, on a marker or
+	// on the deadline of the snapshot
+	ChannelRecordingClosed
+	// The local snapshot of the server completed
+	SnapshotCompleted
+)
+
+func (k SnapshotProgressKind) String() string {
+	switch k {
+	case SnapshotStarted:
+		return "snapshot started"
+	case MarkerSent:
+		return "marker sent"
+	case MarkerReceived:
+		return "marker received"
+	case ChannelRecordingClosed:
+		return "channel recording closed"
+	case SnapshotCompleted:
+		return "snapshot completed"
+	}
+	return "unknown progress"
+}
+
+// The progress of a snapshot on a server. The name `SnapshotEvent` is taken by
+// the event that starts a snapshot.
+type SnapshotProgressEvent struct {
+	Kind       SnapshotProgressKind
+	SnapshotId int
+	ServerId   string
+	// The other end of the link the marker was sent or received on, or of the
+	// channel that was closed; empty for the other kinds of events
+	Peer string
+	// Time step at which the event happened
+	Time int
+}
+
+func (ev SnapshotProgressEvent) String() string {
+	if ev.Peer == "" {
+		return fmt.Sprintf("%v: %v %v(%v)", ev.Time, ev.ServerId, ev.Kind, ev.SnapshotId)
+	}
+	return fmt.Sprintf("%v: %v %v(%v) %v", ev.Time, ev.ServerId, ev.Kind, ev.SnapshotId, ev.Peer)
+}
+
+// Call the given function on every step of the snapshot protocol taken by the
+// servers from now on, e.g. to drive a visualizer or a grader without changing
+// the protocol code. Observers are called in the order they were registered,
+// from the goroutine driving the simulation, and must not modify it.
+func (sim *Simulator) OnSnapshotEvent(observer func(ev SnapshotProgressEvent)) {
+	sim.observers = append(sim.observers, observer)
+}
+
+func (sim *Simulator) notifyObservers(kind SnapshotProgressKind, snapshotId int, serverId, peer string) {
+	for _, observer := range sim.observers {
+		observer(SnapshotProgressEvent{kind, snapshotId, serverId, peer, sim.time})
+	}
+}
+
+// Report the markers carried by a message sent or received on a link
+func (sim *Simulator) notifyMarkers(kind SnapshotProgressKind, message interface{}, serverId, peer string) {
+	if len(sim.observers) == 0 {
+		return
+	}
+	for _, snapshotId := range markerSnapshotIds(message) {
+		sim.notifyObservers(kind, snapshotId, serverId, peer)
+	}
+}
+
+// Return the IDs of the snapshots a message carries a marker for
+func markerSnapshotIds(message interface{}) []int {
+	switch msg := message.(type) {
+	case MarkerMessage:
+		return []int{msg.snapshotId}
+	case LaiYangControlMessage:
+		return []int{msg.snapshotId}
+	case MatternControlMessage:
+		return []int{msg.snapshotId}
+	case MultiMarkerMessage:
+		return msg.snapshotIds
+	case PiggybackedMessage:
+		return msg.snapshotIds
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestOnSnapshotEvent(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	events := make([]SnapshotProgressEvent, 0)
+	sim.OnSnapshotEvent(func(ev SnapshotProgressEvent) {
+		events = append(events, ev)
+	})
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	sim.CollectSnapshot(0)
+
+	counts := make(map[SnapshotProgressKind]int)
+	for _, ev := range events {
+		counts[ev.Kind]++
+	}
+	// One marker per directed link, each closing the channel it arrives on
+	for kind, expected := range map[SnapshotProgressKind]int{
+		SnapshotStarted:        3,
+		MarkerSent:             6,
+		MarkerReceived:         6,
+		ChannelRecordingClosed: 6,
+		SnapshotCompleted:      3,
+	} {
+		if counts[kind] != expected {
+			t.Fatalf("Expected %v event(s) of kind %v, got %v in %v\n", expected, kind, counts[kind], events)
+		}
+	}
+	first := events[0]
+	if first.Kind != SnapshotStarted || first.ServerId != "N1" || first.Time != 0 {
+		t.Fatalf("Expected the snapshot to start on N1 at time 0, got %v\n", first)
+	}
+	if last := events[len(events)-1]; last.Kind != SnapshotCompleted {
+		t.Fatalf("Expected the last event to complete the snapshot, got %v\n", last)
+	}
+	for _, ev := range events {
+		if ev.Kind == MarkerReceived && ev.Peer == "" {
+			t.Fatalf("Expected received markers to name their sender, got %v\n", ev)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package chandy_lamport
+
+import "fmt"
+
+// Split the network in two: every message between a server of groupA and a
+// server of groupB is held on its link until `Heal` is called, while traffic
+// within each group is delivered as usual. Messages are held, not dropped, so
+// no tokens are lost across the partition.
+//
+// Markers are held like any other message, so a snapshot started during the
+// partition stays pending on the servers at the edge of the group of its
+// initiator, which wait for markers from across the partition, and is not
+// started on the other group at all. Such a snapshot can be:
+//   - collected partially with `CollectSnapshotWithTimeout`, which returns the
+//     state of the servers that completed it and reports them in `Completion`,
+//   - completed by the deadlines of the servers, see `Server.SetSnapshotDeadline`,
+//     which close the channels across the partition with what they recorded,
+//   - or resumed once the partition heals, when the held markers are delivered
+//     and the snapshot completes as if the links had only been slow.
+//
+// Calling `Partition` again adds to the links already partitioned.
+func (sim *Simulator) Partition(groupA, groupB []string) error {
+	inA := make(map[string]bool)
+	for _, serverId := range groupA {
+		if _, ok := sim.servers[serverId]; !ok {
+			return newError(ErrUnknownServer, "Server %v does not exist", serverId)
+		}
+		inA[serverId] = true
+	}
+	for _, serverId := range groupB {
+		if _, ok := sim.servers[serverId]; !ok {
+			return newError(ErrUnknownServer, "Server %v does not exist", serverId)
+		}
+		if inA[serverId] {
+			return fmt.Errorf("Server %v cannot be on both sides of a partition", serverId)
+		}
+	}
+	for _, a := range groupA {
+		for _, b := range groupB {
+			if link, ok := sim.servers[a].outboundLinks[b]; ok {
+				link.partitioned = true
+			}
+			if link, ok := sim.servers[b].outboundLinks[a]; ok {
+				link.partitioned = true
+			}
+		}
+	}
+	return nil
+}
+
+// Remove every partition made by `Partition`. The messages held across it are
+// delivered from the next time step.
+func (sim *Simulator) Heal() {
+	for _, serverId := range sim.sortedServerIds() {
+		for _, link := range sim.servers[serverId].deliveringLinks() {
+			link.partitioned = false
+		}
+	}
+}
+
+// Return the channels that cross a partition, sorted by source and destination
+func (sim *Simulator) PartitionedChannels() []ChannelId {
+	channels := make([]ChannelId, 0)
+	for _, serverId := range sim.sortedServerIds() {
+		for _, link := range sim.servers[serverId].deliveringLinks() {
+			if link.partitioned {
+				channels = append(channels, ChannelId{serverId, link.dest})
+			}
+		}
+	}
+	return channels
+}
+
+// Return true if messages are held on the link instead of being delivered
+func (link *Link) blocked() bool {
+	return link.frozen || link.partitioned
+}
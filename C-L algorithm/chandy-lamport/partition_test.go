@@ -0,0 +1,120 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+var (
+	ringServers   = []string{"N1", "N2", "N3", "N4"}
+	squareServers = []string{"N5", "N6", "N7", "N8"}
+)
+
+func TestPartitionHoldsTraffic(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	if err := sim.Partition(ringServers, squareServers); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ChannelId{{"N4", "N5"}, {"N5", "N4"}}
+	if channels := sim.PartitionedChannels(); !reflect.DeepEqual(channels, expected) {
+		t.Fatalf("Expected channels %v to cross the partition, got %v\n", expected, channels)
+	}
+	sim.InjectEvent(PassTokenEvent{"N4", "N5", 3})
+	sim.InjectEvent(PassTokenEvent{"N4", "N3", 2})
+	sim.Drain()
+	if tokens := sim.servers["N5"].Tokens; tokens != 0 {
+		t.Fatalf("Expected the tokens to N5 to be held by the partition, got %v\n", tokens)
+	}
+	if tokens := sim.servers["N3"].Tokens; tokens != 12 {
+		t.Fatalf("Expected the tokens to N3 to be delivered, got %v\n", tokens)
+	}
+	sim.Heal()
+	if channels := sim.PartitionedChannels(); len(channels) != 0 {
+		t.Fatalf("Expected no partition after healing, got %v\n", channels)
+	}
+	sim.Drain()
+	if tokens := sim.servers["N5"].Tokens; tokens != 3 {
+		t.Fatalf("Expected the tokens to N5 to be delivered after healing, got %v\n", tokens)
+	}
+}
+
+func TestPartitionSnapshot(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	sim.Partition(ringServers, squareServers)
+	sim.StartSnapshot("N1")
+	snap, err := sim.CollectSnapshotWithTimeout(0, 20)
+	if err == nil {
+		t.Fatalf("Expected the snapshot to time out during the partition\n")
+	}
+	// N4 waits for the marker of N5, and the square never receives one
+	completion := map[string]bool{"N1": true, "N2": true, "N3": true,
+		"N4": false, "N5": false, "N6": false, "N7": false, "N8": false}
+	if !reflect.DeepEqual(snap.Completion(), completion) {
+		t.Fatalf("Expected only N1 to N3 to complete the snapshot, got %v\n", snap.Completion())
+	}
+	if pending := sim.PendingServers(0); !reflect.DeepEqual(pending, []string{"N4"}) {
+		t.Fatalf("Expected N4 to be recording, got %v\n", pending)
+	}
+	// The snapshot resumes once the partition heals
+	sim.Heal()
+	sim.Drain()
+	if _, ok := sim.TryCollectSnapshot(0); !ok {
+		t.Fatalf("Expected the snapshot to complete after healing\n")
+	}
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPartitionSnapshotDeadline(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	sim.Partition(ringServers, squareServers)
+	sim.StartSnapshot("N1")
+	for _, serverId := range append(ringServers, squareServers...) {
+		sim.servers[serverId].SetSnapshotDeadline(0, 10)
+	}
+	snap, err := sim.CollectSnapshotWithTimeout(0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The servers of the square record their state at the deadline, without
+	// a marker on any of their channels
+	closedByDeadline := false
+	for _, channel := range snap.unknownChannels {
+		if channel == (ChannelId{"N5", "N4"}) {
+			closedByDeadline = true
+		} else if channel.dest < "N5" {
+			t.Fatalf("Expected channel %v to be recorded through a marker\n", channel)
+		}
+	}
+	if !closedByDeadline {
+		t.Fatalf("Expected the channel from N5 to N4 to be closed by the deadline\n")
+	}
+	sim.Heal()
+	sim.Drain()
+	if err := sim.AssertConservedAfterDrain(sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPartitionErrors(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	if err := sim.Partition([]string{"N1"}, []string{"N4"}); !errors.Is(err, ErrUnknownServer) {
+		t.Fatalf("Expected an error for an unknown server, got %v\n", err)
+	}
+	if err := sim.Partition([]string{"N1", "N2"}, []string{"N2"}); err == nil {
+		t.Fatalf("Expected an error for a server on both sides\n")
+	}
+	if channels := sim.PartitionedChannels(); len(channels) != 0 {
+		t.Fatalf("Expected a failed partition to leave the links alone, got %v\n", channels)
+	}
+}
@@ -0,0 +1,117 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How the Chandy-Lamport algorithm sends its markers
+type MarkerMode int
+
+const (
+	// Send every marker in a message of its own
+	Dedicated MarkerMode = iota
+	// Hold the markers of a link until the next token or application message
+	// is sent on it, and attach them to that message. Markers still held
+	// after the piggyback timeout are sent in messages of their own.
+	Piggyback
+)
+
+func (m MarkerMode) String() string {
+	switch m {
+	case Dedicated:
+		return "dedicated"
+	case Piggyback:
+		return "piggyback"
+	}
+	return "unknown mode"
+}
+
+// Number of ticks a marker waits for a message to piggyback on by default
+const defaultPiggybackTimeout = 3
+
+// A token or application message carrying the markers sent on the link before
+// it. The receiver handles the markers first, so the message is not recorded
+// in the state of the channel for these snapshots: like the messages following
+// a dedicated marker, it was sent after the sender recorded its state.
+type PiggybackedMessage struct {
+	snapshotIds []int
+	message     interface{}
+}
+
+func (m PiggybackedMessage) String() string {
+	markers := make([]string, 0)
+	for _, snapshotId := range m.snapshotIds {
+		markers = append(markers, MarkerMessage{snapshotId}.String())
+	}
+	return fmt.Sprintf("%v+%v", m.message, strings.Join(markers, "+"))
+}
+
+// Select how markers are sent, to compare the number of messages sent by
+// snapshots. Only the Chandy-Lamport algorithm uses markers; the control
+// messages of Lai-Yang and Mattern are always sent in messages of their own.
+func (sim *Simulator) SetMarkerMode(mode MarkerMode) {
+	sim.markerMode = mode
+}
+
+// Set the number of ticks a marker waits for a message to piggyback on before
+// it is sent in a message of its own, in the `Piggyback` mode
+func (sim *Simulator) SetPiggybackTimeout(ticks int) {
+	if ticks < 0 {
+		ticks = 0
+	}
+	sim.piggybackTimeout = ticks
+}
+
+// Hold a marker on the link until the next message sent on it, and return true,
+// if markers are piggybacked
+func (server *Server) holdMarker(link *Link, marker MarkerMessage) bool {
+	if server.sim.markerMode != Piggyback || server.sim.algorithm != ChandyLamport {
+		return false
+	}
+	if len(link.heldMarkers) == 0 {
+		link.markerTimeout = server.sim.time + server.sim.piggybackTimeout
+	}
+	link.heldMarkers = append(link.heldMarkers, marker.snapshotId)
+	return true
+}
+
+// Attach the markers held on the link to a message sent on it
+func (server *Server) piggyback(link *Link, message interface{}) interface{} {
+	if len(link.heldMarkers) == 0 {
+		return message
+	}
+	packet := PiggybackedMessage{link.heldMarkers, message}
+	link.heldMarkers = nil
+	return packet
+}
+
+// Send in messages of their own the markers that waited too long for a message
+// to piggyback on
+func (sim *Simulator) sendOverdueMarkers() {
+	if sim.markerMode != Piggyback {
+		return
+	}
+	for _, serverId := range sim.sortedServerIds() {
+		server := sim.servers[serverId]
+		for _, dest := range server.neighborIds() {
+			link := server.outboundLinks[dest]
+			if len(link.heldMarkers) == 0 || sim.time < link.markerTimeout {
+				continue
+			}
+			held := link.heldMarkers
+			link.heldMarkers = nil
+			for _, snapshotId := range held {
+				message := MarkerMessage{snapshotId}
+				sim.logger.RecordEvent(server, SentMessageEvent{serverId, dest, message})
+				sim.enqueue(link, SendMessageEvent{
+					serverId,
+					dest,
+					message,
+					sim.receiveTimeOn(link),
+					sim.time,
+					server.lamport})
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestPiggybackMarkers(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.SetMarkerMode(Piggyback)
+	sim.SetPiggybackTimeout(2)
+	sim.StartSnapshot("N1")
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	events := mustGetLink(sim, "N1", "N2").events.Elements()
+	expected := PiggybackedMessage{[]int{0}, TokenMessage{numTokens: 1}}
+	if len(events) != 1 || !reflect.DeepEqual(events[0].message, expected) {
+		t.Fatalf("Expected a single %v on the link to N2, got %v\n", expected, events)
+	}
+	// Nothing is sent to N3, so its marker is sent on its own after the timeout
+	link := mustGetLink(sim, "N1", "N3")
+	for sim.time < 2 {
+		if !link.events.Empty() {
+			t.Fatalf("Expected the marker to N3 to be held until time 2, sent at %v\n", sim.time)
+		}
+		sim.Tick()
+	}
+	if link.events.Empty() || link.events.Peek().message != (MarkerMessage{0}) {
+		t.Fatalf("Expected a marker on the link to N3, got %v\n", link.events.Elements())
+	}
+	sim.Drain()
+	sim.CollectSnapshot(0)
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.AssertMarkerBeforeTokens(0); err != nil {
+		t.Fatal(err)
+	}
+	if n := sim.Metrics().MarkersPiggybacked; n == 0 {
+		t.Fatalf("Expected some markers to be piggybacked\n")
+	}
+}
+
+func TestPiggybackSavesMessages(t *testing.T) {
+	sent := make(map[MarkerMode]int)
+	for _, mode := range []MarkerMode{Dedicated, Piggyback} {
+		rand.Seed(8053172852482175524)
+		sim := NewSimulator()
+		readTopology("3nodes.top", sim)
+		sim.SetMarkerMode(mode)
+		sim.StartSnapshot("N2")
+		for _, dest := range []string{"N1", "N3"} {
+			sim.InjectEvent(PassTokenEvent{"N2", dest, 1})
+		}
+		sim.Drain()
+		sim.CollectSnapshot(0)
+		if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+			t.Fatalf("%v: %v\n", mode, err)
+		}
+		sent[mode] = sim.Metrics().MessagesSent
+	}
+	if sent[Piggyback] >= sent[Dedicated] {
+		t.Fatalf("Expected piggybacking to send fewer messages, sent %v instead of %v\n",
+			sent[Piggyback], sent[Dedicated])
+	}
+}
@@ -0,0 +1,184 @@
+// Package protocoltest checks the snapshot algorithms against randomly
+// generated runs. A run is generated from a seed: a strongly connected random
+// topology, a schedule of token transfers and the time steps at which snapshots
+// are started. The run is then simulated and every snapshot it took is checked
+// for consistency, so a seed that fails reproduces the failure exactly.
+//
+// The harness plugs into native fuzzing:
+//
+//	func FuzzSnapshots(f *testing.F) {
+//		f.Add(int64(1))
+//		f.Fuzz(func(t *testing.T, seed int64) {
+//			if err := protocoltest.Check(seed); err != nil {
+//				t.Fatal(err)
+//			}
+//		})
+//	}
+package protocoltest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	chandy_lamport "chandy-lamport"
+)
+
+// The bounds of the runs generated from a seed
+type Config struct {
+	// The snapshot algorithm under test
+	Algorithm chandy_lamport.SnapshotAlgorithm
+	// Runs have between 2 and MaxServers servers
+	MaxServers int
+	// Probability of each link beyond the ring that keeps the topology
+	// strongly connected
+	LinkProbability float64
+	// Each server starts with up to MaxTokens tokens
+	MaxTokens int
+	// Number of token transfers and of snapshots in a run
+	Transfers int
+	Snapshots int
+	// The transfers and snapshots are scheduled within this many time steps
+	Duration int
+}
+
+// The configuration used by `Check`
+var DefaultConfig = Config{
+	Algorithm:       chandy_lamport.ChandyLamport,
+	MaxServers:      6,
+	LinkProbability: 0.3,
+	MaxTokens:       20,
+	Transfers:       30,
+	Snapshots:       3,
+	Duration:        20,
+}
+
+// A run generated from a seed
+type Run struct {
+	Seed     int64
+	Config   Config
+	Topology *chandy_lamport.Topology
+	Scenario *chandy_lamport.Scenario
+	// The scheduled events, one per line in the format of `ParseScenario`, to
+	// report along with a failure
+	Schedule []string
+}
+
+// Generate a run from the seed within the bounds of the configuration. The
+// transfers never send more tokens than their sender has left of the tokens it
+// started with, so the schedule can always be injected whatever the delays.
+func Generate(seed int64, config Config) *Run {
+	random := rand.New(rand.NewSource(seed))
+	n := 2
+	if config.MaxServers > 2 {
+		n += random.Intn(config.MaxServers - 1)
+	}
+	ids := make([]string, n)
+	topology := chandy_lamport.NewTopology()
+	balances := make(map[string]int)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("N%v", i+1)
+		balances[ids[i]] = random.Intn(config.MaxTokens + 1)
+		topology.AddServer(ids[i], balances[ids[i]])
+	}
+	// A ring makes the topology strongly connected, so snapshots complete
+	for i := range ids {
+		topology.AddLink(ids[i], ids[(i+1)%n])
+	}
+	links := make(map[string][]string) // key = src, value = destinations
+	for i := range ids {
+		for j := range ids {
+			if j == i {
+				continue
+			}
+			if j == (i+1)%n || random.Float64() < config.LinkProbability {
+				if j != (i+1)%n {
+					topology.AddLink(ids[i], ids[j])
+				}
+				links[ids[i]] = append(links[ids[i]], ids[j])
+			}
+		}
+	}
+
+	run := &Run{seed, config, topology, chandy_lamport.NewScenario(), make([]string, 0)}
+	for i := 0; i < config.Transfers; i++ {
+		src := ids[random.Intn(n)]
+		if balances[src] == 0 {
+			continue
+		}
+		dest := links[src][random.Intn(len(links[src]))]
+		numTokens := 1 + random.Intn(balances[src])
+		balances[src] -= numTokens
+		time := random.Intn(config.Duration + 1)
+		run.Scenario.Send(time, src, dest, numTokens)
+		run.Schedule = append(run.Schedule, fmt.Sprintf("at %v send %v %v %v", time, src, dest, numTokens))
+	}
+	for i := 0; i < config.Snapshots; i++ {
+		serverId := ids[random.Intn(n)]
+		time := random.Intn(config.Duration + 1)
+		run.Scenario.Snapshot(time, serverId)
+		run.Schedule = append(run.Schedule, fmt.Sprintf("at %v snapshot %v", time, serverId))
+	}
+	return run
+}
+
+// Simulate the run and return the simulator, or an error if the schedule could
+// not be injected
+func (r *Run) Simulate() (*chandy_lamport.Simulator, error) {
+	sim, err := r.Topology.BuildWithSeed(r.Seed)
+	if err != nil {
+		return nil, err
+	}
+	sim.SetSnapshotAlgorithm(r.Config.Algorithm)
+	if err := sim.RunScenario(r.Scenario); err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+// Simulate the run and check that every snapshot completed and is consistent:
+// it recorded every server and channel of the topology, its tokens add up to
+// the total the servers started with, and its cut is consistent with the
+// happened-before relation between the events. The error describes the run, so
+// that the failure can be reproduced.
+func (r *Run) Check() error {
+	sim, err := r.Simulate()
+	if err == nil {
+		err = checkSnapshots(sim, r.Topology, r.Config.Algorithm)
+	}
+	if err != nil {
+		return fmt.Errorf("Seed %v: %v\nTopology: %v\nSchedule:\n\t%v",
+			r.Seed, err, r.Topology.Links(), strings.Join(r.Schedule, "\n\t"))
+	}
+	return nil
+}
+
+func checkSnapshots(sim *chandy_lamport.Simulator, topology *chandy_lamport.Topology, algorithm chandy_lamport.SnapshotAlgorithm) error {
+	total := topology.TotalTokens()
+	for _, snapshotId := range sim.SnapshotIds() {
+		snap, ok := sim.TryCollectSnapshot(snapshotId)
+		if !ok {
+			return fmt.Errorf("Snapshot %v did not complete", snapshotId)
+		}
+		if err := chandy_lamport.VerifySnapshot(snap, topology); err != nil {
+			return err
+		}
+		if err := sim.ValidateSnapshot(snapshotId, total); err != nil {
+			return err
+		}
+		if err := sim.AssertCausallyConsistent(snapshotId); err != nil {
+			return err
+		}
+		if algorithm == chandy_lamport.ChandyLamport {
+			if err := sim.AssertMarkerBeforeTokens(snapshotId); err != nil {
+				return err
+			}
+		}
+	}
+	return sim.AssertConservedAfterDrain(total)
+}
+
+// Generate a run from the seed with `DefaultConfig` and check it
+func Check(seed int64) error {
+	return Generate(seed, DefaultConfig).Check()
+}
@@ -0,0 +1,57 @@
+package protocoltest
+
+import (
+	"reflect"
+	"testing"
+
+	chandy_lamport "chandy-lamport"
+)
+
+func TestRandomRuns(t *testing.T) {
+	algorithms := []chandy_lamport.SnapshotAlgorithm{
+		chandy_lamport.ChandyLamport,
+		chandy_lamport.LaiYang,
+		chandy_lamport.Mattern,
+	}
+	for _, algorithm := range algorithms {
+		config := DefaultConfig
+		config.Algorithm = algorithm
+		for seed := int64(0); seed < 50; seed++ {
+			if err := Generate(seed, config).Check(); err != nil {
+				t.Fatalf("%v: %v\n", algorithm, err)
+			}
+		}
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	a, b := Generate(42, DefaultConfig), Generate(42, DefaultConfig)
+	if !reflect.DeepEqual(a.Schedule, b.Schedule) || !reflect.DeepEqual(a.Topology.Links(), b.Topology.Links()) {
+		t.Fatalf("Expected the same seed to generate the same run\n")
+	}
+	simA, err := a.Simulate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	simB, err := b.Simulate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(simA.EventLog(), simB.EventLog()) {
+		t.Fatalf("Expected the same seed to simulate the same events\n")
+	}
+	if !a.Topology.StronglyConnected() {
+		t.Fatalf("Expected the generated topology to be strongly connected\n")
+	}
+}
+
+func FuzzSnapshots(f *testing.F) {
+	for _, seed := range []int64{0, 1, 8053172852482175524} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		if err := Check(seed); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package chandy_lamport
+
+import "sort"
+
+// Release the bookkeeping of the completed snapshots with an ID lower than the
+// given one, so that long simulations do not keep every snapshot in memory.
+// Snapshots still in progress are kept. From then on, messages of the snapshots
+// below the given ID that are not in progress, e.g. Lai-Yang messages colored
+// with them, are ignored rather than mistaken for new snapshots. Only this
+// bound is remembered, not the IDs of the released snapshots.
+// Returns the IDs of the released snapshots.
+func (server *Server) PruneSnapshots(before int) []int {
+	released := make([]int, 0)
+	for snapshotId, completed := range server.completedSnapshot {
+		if completed && snapshotId < before {
+			released = append(released, snapshotId)
+		}
+	}
+	sort.Ints(released)
+	for _, snapshotId := range released {
+		server.releaseSnapshot(snapshotId)
+	}
+	if before > server.prunedBelow {
+		for snapshotId := range server.pruned {
+			if snapshotId < before {
+				delete(server.pruned, snapshotId)
+			}
+		}
+		server.prunedBelow = before
+		server.compactPruned()
+	}
+	return released
+}
+
+// Return true if the given snapshot was released, so its messages are ignored
+func (server *Server) isPruned(snapshotId int) bool {
+	if server.pruned[snapshotId] {
+		return true
+	}
+	return snapshotId < server.prunedBelow && !server.receivedSnapshot[snapshotId] &&
+		!server.sim.snapshotInProgress(snapshotId)
+}
+
+// Raise the bound of the released snapshots past the ones released one by one
+func (server *Server) compactPruned() {
+	for server.pruned[server.prunedBelow] {
+		delete(server.pruned, server.prunedBelow)
+		server.prunedBelow++
+	}
+}
+
+// Drop everything this server recorded for a completed snapshot
+func (server *Server) releaseSnapshot(snapshotId int) {
+	if !server.completedSnapshot[snapshotId] {
+		return
+	}
+	delete(server.receivedSnapshot, snapshotId)
+	delete(server.inReceivedMarker, snapshotId)
+	delete(server.snapshot, snapshotId)
+	delete(server.completedSnapshot, snapshotId)
+	delete(server.snapshotDeadline, snapshotId)
+	delete(server.markerArrival, snapshotId)
+	delete(server.channelRecorded, snapshotId)
+	delete(server.whiteExpected, snapshotId)
+	delete(server.whiteReceived, snapshotId)
+	delete(server.cuts, snapshotId)
+	delete(server.knownStates, snapshotId)
+	delete(server.globalSnapshot, snapshotId)
+	if snapshotId >= server.prunedBelow {
+		server.pruned[snapshotId] = true
+		server.compactPruned()
+	}
+}
+
+// If enabled, servers release their bookkeeping of a snapshot as soon as it is
+// collected, as with `Server.PruneSnapshots`, and so does the simulator. The
+// merged state stays available from `CollectSnapshot`, but debugging helpers
+// that look at the servers or the ground truth, e.g. `SnapshotDebugInfo` and
+// `GroundTruthAt`, no longer see the snapshot, and `Metrics` no longer reports
+// its latency. The exported duration histogram still counts it.
+func (sim *Simulator) SetPruneOnCollect(prune bool) {
+	sim.pruneOnCollect = prune
+}
+
+// Remember the merged state of a snapshot, and release the local states it
+// was merged from if pruning is enabled
+func (sim *Simulator) storeCollected(snapshotId int, snap *SnapshotState) {
+	sim.collected.Store(snapshotId, snap)
+	if sim.pruneOnCollect {
+		sim.releaseSnapshot(snapshotId)
+	}
+}
+
+// Release what the servers and the simulator keep of a collected snapshot,
+// apart from its merged state
+func (sim *Simulator) releaseSnapshot(snapshotId int) {
+	for _, serverId := range getSortedKeys(sim.servers) {
+		sim.servers[serverId].releaseSnapshot(snapshotId)
+	}
+	delete(sim.collectors, snapshotId)
+	delete(sim.trees, snapshotId)
+	delete(sim.finishedMap, snapshotId)
+	delete(sim.initiators, snapshotId)
+	delete(sim.groundTruth, snapshotId)
+	if sim.markers != nil {
+		delete(sim.markers.delivered, snapshotId)
+	}
+	sim.metrics.releaseSnapshot(snapshotId)
+}
@@ -0,0 +1,108 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Return the number of snapshots a server keeps bookkeeping for
+func retainedSnapshots(server *Server) int {
+	return len(server.receivedSnapshot) + len(server.snapshot) + len(server.inReceivedMarker)
+}
+
+func TestPruneOnCollect(t *testing.T) {
+	for _, algorithm := range []SnapshotAlgorithm{ChandyLamport, LaiYang, Mattern} {
+		rand.Seed(8053172852482175524)
+		sim := NewSimulator()
+		sim.SetSnapshotAlgorithm(algorithm)
+		sim.SetPruneOnCollect(true)
+		readTopology("3nodes.top", sim)
+		sim.StartSnapshot("N1")
+		sim.InjectEvent(PassTokenEvent{"N1", "N2", 2})
+		sim.Drain()
+		first := sim.CollectSnapshot(0)
+		for _, server := range sim.servers {
+			if retained := retainedSnapshots(server); retained != 0 {
+				t.Fatalf("Algorithm %v: expected %v to release snapshot 0, it retains %v entries\n",
+					algorithm, server.Id, retained)
+			}
+		}
+		// Messages sent after the first snapshot may still carry its ID
+		sim.InjectEvent(PassTokenEvent{"N2", "N3", 4})
+		sim.InjectEvent(PassTokenEvent{"N1", "N3", 1})
+		sim.Drain()
+		for _, server := range sim.servers {
+			if server.receivedSnapshot[0] {
+				t.Fatalf("Algorithm %v: expected %v not to restart snapshot 0\n", algorithm, server.Id)
+			}
+		}
+		sim.StartSnapshot("N3")
+		sim.Drain()
+		if err := VerifySnapshot(sim.CollectSnapshot(1), sim.Topology()); err != nil {
+			t.Fatalf("Algorithm %v: %v\n", algorithm, err)
+		}
+		if sim.CollectSnapshot(0) != first {
+			t.Fatalf("Algorithm %v: expected the merged state to stay available\n", algorithm)
+		}
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.StartSnapshot("N1")
+	sim.Drain()
+	sim.StartSnapshot("N2")
+	sim.Drain()
+	sim.StartSnapshot("N3")
+	server := sim.servers["N3"]
+	if released := server.PruneSnapshots(3); !reflect.DeepEqual(released, []int{0, 1}) {
+		t.Fatalf("Expected N3 to release the completed snapshots 0 and 1, got %v\n", released)
+	}
+	if !server.receivedSnapshot[2] || server.snapshot[0] != nil {
+		t.Fatalf("Expected N3 to keep only snapshot 2\n")
+	}
+	sim.Drain()
+	if err := VerifySnapshot(sim.CollectSnapshot(2), sim.Topology()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneBookkeepingBounded(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetPruneOnCollect(true)
+	readTopology("3nodes.top", sim)
+	const numSnapshots = 50
+	for i := 0; i < numSnapshots; i++ {
+		sim.StartSnapshot("N1")
+		sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+		sim.Drain()
+		sim.CollectSnapshot(i)
+		sim.InjectEvent(PassTokenEvent{"N2", "N1", 1})
+	}
+	for _, server := range sim.servers {
+		if len(server.pruned) != 0 || server.prunedBelow != numSnapshots {
+			t.Fatalf("Expected %v to only keep a bound of %v, got %v and %v exception(s)\n",
+				server.Id, numSnapshots, server.prunedBelow, len(server.pruned))
+		}
+	}
+	if len(sim.collectors) != 0 || len(sim.finishedMap) != 0 || len(sim.initiators) != 0 ||
+		len(sim.groundTruth) != 0 || len(sim.metrics.snapshotStart) != 0 || len(sim.metrics.snapshotEnd) != 0 {
+		t.Fatalf("Expected the simulator to release the collected snapshots\n")
+	}
+	if ids := sim.SnapshotIds(); len(ids) != numSnapshots {
+		t.Fatalf("Expected %v snapshot IDs, got %v\n", numSnapshots, ids)
+	}
+	if _, err := sim.CollectSnapshotFrom(0, "N1"); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("clsim_snapshot_duration_ticks_count %v\n", numSnapshots*len(sim.servers))
+	if rendered := sim.renderMetrics(getSortedKeys(sim.servers)); !strings.Contains(rendered, expected) {
+		t.Fatalf("Expected the durations of the released snapshots to be exported, got\n%v\n", rendered)
+	}
+}
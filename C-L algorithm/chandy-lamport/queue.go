@@ -3,26 +3,59 @@ package chandy_lamport
 import "container/list"
 
 // Define a queue -- simple implementation over List
-type Queue struct {
+type Queue[T any] struct {
 	elements *list.List
 }
 
-func NewQueue() *Queue {
-	return &Queue{list.New()}
+func NewQueue[T any]() *Queue[T] {
+	return &Queue[T]{list.New()}
 }
 
-func (q *Queue) Empty() bool {
+func (q *Queue[T]) Empty() bool {
 	return (q.elements.Len() == 0)
 }
 
-func (q *Queue) Push(v interface{}) {
+func (q *Queue[T]) Push(v T) {
 	q.elements.PushFront(v)
 }
 
-func (q *Queue) Pop() interface{} {
-	return q.elements.Remove(q.elements.Back())
+func (q *Queue[T]) Len() int {
+	return q.elements.Len()
 }
 
-func (q *Queue) Peek() interface{} {
-	return q.elements.Back().Value
+func (q *Queue[T]) Pop() T {
+	return q.elements.Remove(q.elements.Back()).(T)
+}
+
+func (q *Queue[T]) Peek() T {
+	return q.elements.Back().Value.(T)
+}
+
+// Return the elements of the queue in the order they would be popped
+func (q *Queue[T]) Elements() []T {
+	elements := make([]T, 0, q.elements.Len())
+	for e := q.elements.Back(); e != nil; e = e.Prev() {
+		elements = append(elements, e.Value.(T))
+	}
+	return elements
+}
+
+// Return the element that was pushed most recently
+func (q *Queue[T]) Last() T {
+	return q.elements.Front().Value.(T)
+}
+
+// Replace the element that was pushed most recently, keeping its position
+func (q *Queue[T]) ReplaceLast(v T) {
+	q.elements.Front().Value = v
+}
+
+// Remove and return the element at the given position, counting in the order
+// the elements would be popped
+func (q *Queue[T]) Remove(i int) T {
+	e := q.elements.Back()
+	for ; i > 0; i-- {
+		e = e.Prev()
+	}
+	return q.elements.Remove(e).(T)
 }
@@ -0,0 +1,74 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Handles an application message of a registered type received by a server
+type MessageHandler func(server *Server, src string, message interface{})
+
+// Register a handler for application messages of the same type as `msgType`
+// in this simulation, e.g. `sim.RegisterMessageHandler(Put{}, handlePut)`.
+// Registered messages can be sent with `Server.SendMessage`, and are recorded in
+// the state of the channels they are in flight on during a snapshot, like token
+// messages. Registering a type again replaces its handler.
+func (sim *Simulator) RegisterMessageHandler(msgType interface{}, handler MessageHandler) {
+	sim.messageHandlers[reflect.TypeOf(msgType)] = handler
+}
+
+// Return the handler registered for the type of the message, if any
+func (sim *Simulator) messageHandler(message interface{}) (MessageHandler, bool) {
+	handler, ok := sim.messageHandlers[reflect.TypeOf(message)]
+	return handler, ok
+}
+
+// Send an application message of a registered type to a neighbor attached to
+// this server. Only the Chandy-Lamport algorithm records application messages
+// in snapshots: Lai-Yang and Mattern attach their bookkeeping to token messages,
+// so sending one under them fails rather than leaving it out of the snapshots.
+func (server *Server) SendMessage(message interface{}, dest string) error {
+	if _, ok := server.sim.messageHandler(message); !ok {
+		return fmt.Errorf("No handler registered for messages of type %T", message)
+	}
+	return server.sendRecordedMessage(message, dest)
+}
+
+// Send a message that is recorded in the state of the channel it is in flight
+// on during a snapshot, like a token message
+func (server *Server) sendRecordedMessage(message interface{}, dest string) error {
+	if server.sim.algorithm != ChandyLamport {
+		return fmt.Errorf("Messages of type %T cannot be recorded by the %v algorithm",
+			message, server.sim.algorithm)
+	}
+	link, ok := server.outboundLinks[dest]
+	if !ok {
+		return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, server.Id)
+	}
+	message = server.piggyback(link, message)
+	server.sim.logger.RecordEvent(server, SentMessageEvent{server.Id, dest, message})
+	server.sim.enqueue(link, SendMessageEvent{
+		server.Id,
+		dest,
+		message,
+		server.sim.receiveTimeOn(link),
+		server.sim.time,
+		server.lamport})
+	return nil
+}
+
+// Record an application message on the channels that are still open, then pass
+// it to its handler
+func (server *Server) handleUserMessage(src string, message interface{}, handler MessageHandler) {
+	for snapshotId, received := range server.receivedSnapshot {
+		if received && !server.completedSnapshot[snapshotId] &&
+			!server.inReceivedMarker[snapshotId][src] {
+			server.recordMessage(snapshotId, &SnapshotMessage{
+				src:     src,
+				dest:    server.Id,
+				message: message,
+			})
+		}
+	}
+	handler(server, src, message)
+}
@@ -0,0 +1,78 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+type putMessage struct {
+	key   string
+	value string
+}
+
+func (m putMessage) String() string {
+	return fmt.Sprintf("put(%v=%v)", m.key, m.value)
+}
+
+func TestRegisteredMessages(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	stores := make(map[string]map[string]string)
+	sim := NewSimulator()
+	sim.RegisterMessageHandler(putMessage{}, func(server *Server, src string, message interface{}) {
+		put := message.(putMessage)
+		if stores[server.Id] == nil {
+			stores[server.Id] = make(map[string]string)
+		}
+		stores[server.Id][put.key] = put.value
+	})
+	readTopology("3nodes.top", sim)
+	if err := sim.servers["N1"].SendMessage(putMessage{"a", "1"}, "N2"); err != nil {
+		t.Fatal(err)
+	}
+	sim.StartSnapshot("N2")
+	sim.Drain()
+	snap := sim.CollectSnapshot(0)
+
+	if stores["N2"]["a"] != "1" {
+		t.Fatalf("Expected N2 to handle the put message, got %v\n", stores["N2"])
+	}
+	if len(snap.messages) != 1 || snap.messages[0].message != (putMessage{"a", "1"}) ||
+		snap.messages[0].src != "N1" || snap.messages[0].dest != "N2" {
+		t.Fatalf("Expected the put message to be recorded on N1 -> N2, got %v\n", snap.messages)
+	}
+	found := false
+	for _, line := range sim.logger.Lines() {
+		if strings.Contains(line, "N2 received put(a=1) from N1") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected the delivery of the put message to be logged\n")
+	}
+
+	if err := sim.servers["N1"].SendMessage(struct{}{}, "N2"); err == nil {
+		t.Fatalf("Expected sending an unregistered message to fail\n")
+	}
+	if err := sim.servers["N1"].SendMessage(putMessage{"b", "2"}, "N4"); err == nil {
+		t.Fatalf("Expected sending to an unknown server to fail\n")
+	}
+}
+
+func TestRegisteredMessagesPerSimulator(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.RegisterMessageHandler(putMessage{}, func(*Server, string, interface{}) {})
+	other := NewSimulator()
+	readTopology("3nodes.top", other)
+	if err := other.servers["N1"].SendMessage(putMessage{"a", "1"}, "N2"); err == nil {
+		t.Fatalf("Expected the handler to be registered on the first simulator only\n")
+	}
+	for _, algorithm := range []SnapshotAlgorithm{LaiYang, Mattern} {
+		sim.SetSnapshotAlgorithm(algorithm)
+		if err := sim.servers["N1"].SendMessage(putMessage{"a", "1"}, "N2"); err == nil {
+			t.Fatalf("Algorithm %v: expected sending an unrecordable message to fail\n", algorithm)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package chandy_lamport
+
+import "fmt"
+
+// Roll the system back to the global state recorded by a collected snapshot,
+// for checkpoint-rollback recovery experiments. Every server gets the tokens it
+// recorded, the messages in flight are dropped, and the messages recorded on
+// each channel are sent again on its link, in the order they were recorded,
+// with a new delay. Only tokens and the wait-for state of the servers, see
+// `deadlock.go`, are restored, not states set with `Server.SetApplicationState`.
+//
+// The token accounting starts over from the restored state: the tokens of the
+// snapshot become the initial tokens of the system, and the generated,
+// duplicated and lost tokens are reset. The snapshot must record the state of every server and
+// every channel completely, and no other snapshot may be in progress, since its
+// recorded state would mix the state before and after the rollback.
+func (sim *Simulator) RestoreFromSnapshot(snap *SnapshotState) error {
+	if err := sim.checkRestorable(snap); err != nil {
+		return err
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		server := sim.servers[serverId]
+		server.Tokens = snap.tokens[serverId]
+		server.typedTokens = copyTokenCounts(snap.typed[serverId])
+		server.waitingFor = make(map[string]bool)
+		server.deferredGrants = make(map[string]bool)
+		for _, dest := range snap.waits[serverId].waitingFor {
+			server.waitingFor[dest] = true
+		}
+		for _, src := range snap.waits[serverId].deferred {
+			server.deferredGrants[src] = true
+		}
+		for _, link := range server.outboundLinks {
+			link.events = NewQueue[SendMessageEvent]()
+			link.backlog = NewQueue[SendMessageEvent]()
+			link.heldMarkers = nil
+		}
+		// Removed links have nothing left to deliver
+		server.closingLinks = make(map[string]*Link)
+		server.linksChanged()
+		// Lai-Yang counts the messages sent and received on every channel
+		server.sentCount = make(map[string]int)
+		server.receivedCount = make(map[string]int)
+		sim.startingTokens[serverId] = server.Tokens
+	}
+	sim.initialTokens = 0
+	for _, numTokens := range snap.tokens {
+		sim.initialTokens += numTokens
+	}
+	sim.initialTypedTokens = snap.TypedTokens()
+	sim.generatedTokens = 0
+	sim.lostTokens = 0
+	sim.duplicatedTokens = 0
+	for _, msg := range snap.messages {
+		src := sim.servers[msg.src]
+		link := src.outboundLinks[msg.dest]
+		if token, isToken := tokenMessage(msg.message); isToken {
+			src.sentCount[msg.dest]++
+			sim.initialTokens += token.numTokens
+			sim.startingTokens[msg.src] += token.numTokens
+		}
+		sim.enqueue(link, SendMessageEvent{
+			msg.src,
+			msg.dest,
+			msg.message,
+			sim.receiveTimeOn(link),
+			sim.time,
+			src.lamport})
+	}
+	// The tokens recorded in aggregate are sent in a message of each type
+	for _, channel := range snap.aggregatedChannels() {
+		src := sim.servers[channel.src]
+		link := src.outboundLinks[channel.dest]
+		a := snap.aggregated[channel]
+		for _, tokenType := range getSortedKeys(a.tokens) {
+			message := TokenMessage{a.tokens[tokenType], 0, tokenType}
+			if tokenType == DefaultTokenType {
+				src.sentCount[channel.dest]++
+				sim.initialTokens += message.numTokens
+				sim.startingTokens[channel.src] += message.numTokens
+			}
+			sim.enqueue(link, SendMessageEvent{
+				channel.src,
+				channel.dest,
+				message,
+				sim.receiveTimeOn(link),
+				sim.time,
+				src.lamport})
+		}
+	}
+	return nil
+}
+
+// Return an error if the system cannot be rolled back to the given snapshot
+func (sim *Simulator) checkRestorable(snap *SnapshotState) error {
+	if snap == nil {
+		return fmt.Errorf("Cannot restore a snapshot that was not collected")
+	}
+	if len(snap.unknownChannels) > 0 || len(snap.overflow) > 0 || len(snap.crashed) > 0 {
+		return fmt.Errorf("Snapshot %v did not record the complete state of the system", snap.id)
+	}
+	for _, serverId := range getSortedKeys(snap.tokens) {
+		if _, ok := sim.servers[serverId]; !ok {
+			return newError(ErrUnknownServer, "Server %v does not exist", serverId)
+		}
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		if _, ok := snap.tokens[serverId]; !ok {
+			return fmt.Errorf("Snapshot %v did not record the state of %v", snap.id, serverId)
+		}
+	}
+	for _, msg := range snap.messages {
+		if _, ok := sim.servers[msg.src].outboundLinks[msg.dest]; !ok {
+			return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", msg.dest, msg.src)
+		}
+	}
+	for channel := range snap.aggregated {
+		if _, ok := sim.servers[channel.src].outboundLinks[channel.dest]; !ok {
+			return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", channel.dest, channel.src)
+		}
+	}
+	for _, snapshotId := range sim.SnapshotIds() {
+		if sim.snapshotInProgress(snapshotId) {
+			return fmt.Errorf("Cannot restore snapshot %v while snapshot %v is in progress",
+				snap.id, snapshotId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRestoreFromSnapshot(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	injectEvents("3nodes-bidirectional-messages.events", sim)
+	snap := sim.CollectSnapshot(0)
+	recorded := 0
+	for _, msg := range snap.messages {
+		recorded += msg.message.(TokenMessage).numTokens
+	}
+	if recorded == 0 {
+		t.Fatalf("Expected the snapshot to record tokens in flight\n")
+	}
+	// Move on from the snapshot, losing some tokens along the way
+	sim.InjectLoss("N1", "N2", 1)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	sim.InjectEvent(PassTokenEvent{"N2", "N3", 1})
+	sim.Tick()
+
+	if err := sim.RestoreFromSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	sim.InjectLoss("N1", "N2", 0)
+	for serverId, numTokens := range snap.tokens {
+		if sim.servers[serverId].Tokens != numTokens {
+			t.Fatalf("Expected %v to hold %v token(s), got %v\n",
+				serverId, numTokens, sim.servers[serverId].Tokens)
+		}
+	}
+	if inFlight := sim.Metrics().TokensInFlight; inFlight != recorded {
+		t.Fatalf("Expected the %v recorded token(s) to be in flight again, got %v\n", recorded, inFlight)
+	}
+	if sim.InitialTokens() != 13 || sim.LostTokens() != 0 {
+		t.Fatalf("Expected the accounting to start over from 13 tokens, got %v and %v lost\n",
+			sim.InitialTokens(), sim.LostTokens())
+	}
+	sim.StartSnapshot("N2")
+	sim.Drain()
+	if err := VerifySnapshot(sim.CollectSnapshot(1), sim.Topology()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.ValidateSnapshot(1, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreIncompleteSnapshot(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("2nodes.top", sim)
+	sim.StartSnapshot("N1")
+	sim.servers["N1"].SetSnapshotDeadline(0, 1)
+	for sim.finishedMap[0] < len(sim.servers) {
+		sim.Tick()
+	}
+	if err := sim.RestoreFromSnapshot(sim.CollectSnapshot(0)); err == nil {
+		t.Fatalf("Expected an error restoring a snapshot with unknown channels\n")
+	}
+	if err := sim.RestoreFromSnapshot(nil); err == nil {
+		t.Fatalf("Expected an error restoring a snapshot that was not collected\n")
+	}
+}
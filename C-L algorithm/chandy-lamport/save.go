@@ -0,0 +1,1238 @@
+package chandy_lamport
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+)
+
+// A source of randomness seeded by `NewSimulatorWithSeed`, which counts the
+// values drawn from it, so that `Save` can capture its state as the seed and the
+// number of values to draw again
+type seededSource struct {
+	source rand.Source64
+	seed   int64
+	draws  int64
+}
+
+func newSeededSource(seed int64) *seededSource {
+	return &seededSource{rand.NewSource(seed).(rand.Source64), seed, 0}
+}
+
+func (s *seededSource) Int63() int64 {
+	s.draws++
+	return s.source.Int63()
+}
+
+func (s *seededSource) Uint64() uint64 {
+	s.draws++
+	return s.source.Uint64()
+}
+
+func (s *seededSource) Seed(seed int64) {
+	s.source.Seed(seed)
+	s.seed = seed
+	s.draws = 0
+}
+
+// Return a source seeded with the given seed, from which the given number of
+// values were drawn
+func restoreSeededSource(seed, draws int64) *seededSource {
+	restored := newSeededSource(seed)
+	for ; draws > 0; draws-- {
+		restored.Int63()
+	}
+	return restored
+}
+
+// ======================================================================
+//  The exported form of a simulator, which is what `Save` writes in the
+//  gob format. The messages of the protocol and the events of the log
+//  are flattened into `packetWire` and `logEventWire`.
+// ======================================================================
+
+type simulatorWire struct {
+	Time                  int
+	NextSnapshotId        int
+	Seed                  int64
+	Draws                 int64
+	Servers               []serverWire
+	Log                   loggerWire
+	Collectors            map[int]collectorWire
+	FinishedMap           map[int]int
+	InitialTokens         int
+	StartingTokens        map[string]int
+	Initiators            map[int]string
+	Collected             map[int]savedSnapshot
+	GeneratedTokens       int
+	CoalesceMarkers       bool
+	GroundTruth           map[int]savedSnapshot
+	MaxRecordedPerChannel int
+	Algorithm             SnapshotAlgorithm
+	LostTokens            int
+	LinkRemoval           LinkRemovalPolicy
+	Latency               latencyWire
+	Metrics               metricsWire
+	Schedule              []scheduledWire
+	PruneOnCollect        bool
+	CheckInvariants       bool
+	Periodic              *periodicWire
+	DeliveryOrder         DeliveryOrder
+	MarkerMode            MarkerMode
+	PiggybackTimeout      int
+	InitialTypedTokens    map[string]int
+	DuplicatedTokens      int
+	InitiatorPolicy       initiatorWire
+	ElectionRound         int
+	CollectionMode        CollectionMode
+	Disseminate           bool
+	ChannelRecording      ChannelRecordingMode
+	Markers               *markerLedgerWire
+	CensusTimeout         int
+}
+
+type serverWire struct {
+	Id                string
+	Tokens            int
+	Links             []linkWire
+	ClosingLinks      []linkWire
+	ReceivedSnapshot  map[int]bool
+	InReceivedMarker  map[int]map[string]bool
+	Snapshot          map[int]savedSnapshot
+	CompletedSnapshot map[int]bool
+	SnapshotDeadline  map[int]int
+	MarkerArrival     map[int]map[string]int
+	GenerationRate    int
+	ForwardTo         string
+	ChannelRecorded   map[int]map[string]int
+	SentCount         map[string]int
+	ReceivedCount     map[string]int
+	WhiteExpected     map[int]map[string]int
+	WhiteReceived     map[int]map[string]int
+	Crashed           bool
+	Clock             map[string]int
+	Cuts              map[int]cutWire
+	Pruned            map[int]bool
+	PrunedBelow       int
+	WaitingFor        map[string]bool
+	DeferredGrants    map[string]bool
+	TypedTokens       map[string]int
+	Faults            *FaultSpec
+	Lamport           int
+	ElectionRound     int
+	Leader            string
+	KnownStates       map[int]map[string]snapshotWire
+	GlobalSnapshot    map[int]snapshotWire
+}
+
+type linkWire struct {
+	Dest                 string
+	Events               []eventWire
+	Frozen               bool
+	Partitioned          bool
+	LastMarkerTime       int
+	Ordering             OrderingPolicy
+	LossProbability      float64
+	DuplicateProbability float64
+	Latency              latencyWire
+	Capacity             int
+	Backpressure         BackpressurePolicy
+	Backlog              []eventWire
+	HeldMarkers          []int
+	MarkerTimeout        int
+	Bandwidth            int
+	Transmitted          int
+}
+
+// A message on a link
+type eventWire struct {
+	Src         string
+	Dest        string
+	Message     packetWire
+	ReceiveTime int
+	SendTime    int
+	Lamport     int
+}
+
+// A message of any kind. `Kind` tells which of the other fields are set.
+type packetWire struct {
+	Kind        string
+	NumTokens   int
+	HopCount    int
+	TokenType   string
+	SnapshotId  int
+	SnapshotIds []int
+	WhiteSent   int
+	Grant       bool
+	Round       int
+	Candidate   string
+	Clock       map[string]int
+	Cut         cutWire
+	Cuts        map[int]cutWire
+	// The local states carried by an aggregate or recorded state message,
+	// and the server that recorded the latter
+	States []snapshotWire
+	Origin string
+	// The message carried by a piggybacked message or a copy
+	Inner *packetWire
+	// A message sent with `Server.SendMessage`, whose type must be registered
+	// with `gob.Register`
+	Application interface{}
+}
+
+type cutWire struct {
+	Initiator string
+	Time      int
+}
+
+// A snapshot, along with the indices of its messages that were recorded while
+// handling a copy, see `AssertNoDoubleCounting`
+type savedSnapshot struct {
+	State      snapshotWire
+	Duplicates []int
+}
+
+type collectorWire struct {
+	Participants []string
+	Records      []recordWire
+}
+
+// A local state reported to a collector, which is either the state kept by
+// the server that recorded it, or a state of its own, e.g. of a crashed server
+type recordWire struct {
+	ServerId string
+	State    *savedSnapshot
+}
+
+type loggerWire struct {
+	Events     [][]logEventWire
+	MaxEvents  int
+	Evicted    int
+	FirstEpoch int
+	Counts     [4]int
+}
+
+type logEventWire struct {
+	ServerId     string
+	ServerTokens int
+	Lamport      int
+	Kind         string
+	Src          string
+	Dest         string
+	Message      packetWire
+	After        packetWire
+	SnapshotId   int
+	// Lamport clock carried by a received message
+	SenderLamport int
+}
+
+// A built-in latency model, or none if `Kind` is empty
+type latencyWire struct {
+	Kind  string
+	Min   int
+	Max   int
+	Delay int
+	Mean  float64
+}
+
+// A built-in initiator policy, or none if `Kind` is empty
+type initiatorWire struct {
+	Kind     string
+	ServerId string
+}
+
+type metricsWire struct {
+	SnapshotStart   map[int]int
+	SnapshotEnd     map[int]map[string]int
+	QueueDepthTotal float64
+	QueueSamples    int
+	PrunedLatency   map[string]histogramWire
+}
+
+type histogramWire struct {
+	Counts []int
+	Count  int
+	Sum    int
+}
+
+type scheduledWire struct {
+	Time     int
+	Kind     string
+	Src      string
+	Dest     string
+	Tokens   int
+	ServerId string
+}
+
+type periodicWire struct {
+	EveryNTicks int
+	Retain      int
+	Started     int
+	Pending     []int
+	Retained    []int
+}
+
+type markerLedgerWire struct {
+	FirstSnapshotId int
+	Delivered       []markerCountWire
+}
+
+// The number of markers of a snapshot delivered on a channel
+type markerCountWire struct {
+	SnapshotId int
+	Src        string
+	Dest       string
+	Count      int
+}
+
+// Write the whole state of the simulation in the gob format: the servers and
+// their bookkeeping of the snapshots, the messages on the links, the events
+// scheduled, the snapshots in progress and collected, the event log and the
+// state of the source of randomness. `LoadSimulator` then resumes the
+// simulation exactly where it was saved.
+//
+// Only simulators created with a seed, e.g. by `NewSimulatorWithSeed`, can be
+// saved, and Save fails if the simulation depends on code it cannot encode:
+// link transforms, snapshot triggers, latency models and initiator policies
+// other than the built-in ones, application states, packets waiting in an
+// inbox or local states being aggregated up a tree. Message handlers,
+// observers, traces and the metrics and visualizer servers are not saved, and
+// must be set again on the loaded simulator. Application messages in flight are
+// encoded by gob, so their types must be registered with `gob.Register`.
+func (sim *Simulator) Save(w io.Writer) error {
+	wire, err := sim.toWire()
+	if err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(wire)
+}
+
+// Read a simulation written by `Simulator.Save`
+func LoadSimulator(r io.Reader) (*Simulator, error) {
+	var wire simulatorWire
+	if err := gob.NewDecoder(r).Decode(&wire); err != nil {
+		return nil, err
+	}
+	sim := NewSimulator()
+	if err := sim.fromWire(&wire); err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+func (sim *Simulator) toWire() (*simulatorWire, error) {
+	if sim.source == nil {
+		return nil, fmt.Errorf("Only simulators created with a seed can be saved")
+	}
+	if len(sim.triggers) > 0 {
+		return nil, fmt.Errorf("Cannot save the snapshot triggers of the simulation")
+	}
+	if sim.workload != nil {
+		return nil, fmt.Errorf("Cannot save the workload of the simulation")
+	}
+	if len(sim.trees) > 0 {
+		return nil, fmt.Errorf("Cannot save the simulation while local states are aggregated up a tree")
+	}
+	latency, err := latencyToWire(sim.latency)
+	if err != nil {
+		return nil, err
+	}
+	wire := &simulatorWire{
+		Time:                  sim.time,
+		NextSnapshotId:        sim.nextSnapshotId,
+		Seed:                  sim.source.seed,
+		Draws:                 sim.source.draws,
+		Servers:               make([]serverWire, 0),
+		Collectors:            make(map[int]collectorWire),
+		FinishedMap:           copyIntMap(sim.finishedMap),
+		InitialTokens:         sim.initialTokens,
+		StartingTokens:        copyTokenCounts(sim.startingTokens),
+		Initiators:            make(map[int]string),
+		Collected:             make(map[int]savedSnapshot),
+		GeneratedTokens:       sim.generatedTokens,
+		CoalesceMarkers:       sim.CoalesceMarkers,
+		GroundTruth:           make(map[int]savedSnapshot),
+		MaxRecordedPerChannel: sim.maxRecordedPerChannel,
+		Algorithm:             sim.algorithm,
+		LostTokens:            sim.lostTokens,
+		LinkRemoval:           sim.linkRemoval,
+		Latency:               latency,
+		Metrics:               sim.metrics.toWire(),
+		Schedule:              make([]scheduledWire, 0),
+		PruneOnCollect:        sim.pruneOnCollect,
+		CheckInvariants:       sim.checkInvariants,
+		DeliveryOrder:         sim.deliveryOrder,
+		MarkerMode:            sim.markerMode,
+		PiggybackTimeout:      sim.piggybackTimeout,
+		CensusTimeout:         sim.censusTimeout,
+		InitialTypedTokens:    copyTokenCounts(sim.initialTypedTokens),
+		DuplicatedTokens:      sim.duplicatedTokens,
+		ElectionRound:         sim.electionRound,
+		CollectionMode:        sim.collectionMode,
+		Disseminate:           sim.disseminate,
+		ChannelRecording:      sim.channelRecording,
+	}
+	if wire.InitiatorPolicy, err = initiatorToWire(sim.initiatorPolicy); err != nil {
+		return nil, err
+	}
+	for snapshotId, initiator := range sim.initiators {
+		wire.Initiators[snapshotId] = initiator
+	}
+	for _, serverId := range sim.sortedServerIds() {
+		server, err := sim.servers[serverId].toWire()
+		if err != nil {
+			return nil, err
+		}
+		wire.Servers = append(wire.Servers, server)
+	}
+	if wire.Log, err = sim.logger.toWire(); err != nil {
+		return nil, err
+	}
+	for snapshotId, c := range sim.collectors {
+		records := make([]recordWire, 0)
+		for _, snap := range c.reported() {
+			if record, ok := sim.keptRecord(snapshotId, snap); ok {
+				records = append(records, record)
+				continue
+			}
+			saved, err := sim.saveSnapshot(snap)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, recordWire{State: &saved})
+		}
+		wire.Collectors[snapshotId] = collectorWire{c.participantIds(), records}
+	}
+	sim.collected.Range(func(snapshotId int, snap *SnapshotState) bool {
+		var saved savedSnapshot
+		if saved, err = sim.saveSnapshot(snap); err == nil {
+			wire.Collected[snapshotId] = saved
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for snapshotId, snap := range sim.groundTruth {
+		if wire.GroundTruth[snapshotId], err = sim.saveSnapshot(snap); err != nil {
+			return nil, err
+		}
+	}
+	times := make([]int, 0)
+	for time := range sim.schedule {
+		times = append(times, time)
+	}
+	sort.Ints(times)
+	for _, time := range times {
+		for _, event := range sim.schedule[time] {
+			scheduled := scheduledWire{Time: time}
+			switch e := event.(type) {
+			case PassTokenEvent:
+				scheduled.Kind, scheduled.Src, scheduled.Dest, scheduled.Tokens = "send", e.src, e.dest, e.tokens
+			case SnapshotEvent:
+				scheduled.Kind, scheduled.ServerId = "snapshot", e.serverId
+			default:
+				return nil, fmt.Errorf("Cannot save the event %v scheduled at time %v", event, time)
+			}
+			wire.Schedule = append(wire.Schedule, scheduled)
+		}
+	}
+	if p := sim.periodic; p != nil {
+		wire.Periodic = &periodicWire{p.everyNTicks, p.retain, p.started,
+			append([]int{}, p.pending...), append([]int{}, p.retained...)}
+	}
+	if ledger := sim.markers; ledger != nil {
+		wire.Markers = &markerLedgerWire{ledger.firstSnapshotId, make([]markerCountWire, 0)}
+		delivered := wire.Markers.Delivered
+		for snapshotId, counts := range ledger.delivered {
+			for channel, count := range counts {
+				delivered = append(delivered, markerCountWire{snapshotId, channel.src, channel.dest, count})
+			}
+		}
+		sort.Slice(delivered, func(i, j int) bool {
+			if delivered[i].SnapshotId != delivered[j].SnapshotId {
+				return delivered[i].SnapshotId < delivered[j].SnapshotId
+			}
+			if delivered[i].Src != delivered[j].Src {
+				return delivered[i].Src < delivered[j].Src
+			}
+			return delivered[i].Dest < delivered[j].Dest
+		})
+		wire.Markers.Delivered = delivered
+	}
+	return wire, nil
+}
+
+// Restore the state of a saved simulation into this simulator, which is either
+// new or one whose state is replaced, see `Rewind`. Message handlers, observers
+// and the other attachments of the simulator are kept.
+func (sim *Simulator) fromWire(wire *simulatorWire) error {
+	sim.time = wire.Time
+	sim.nextSnapshotId = wire.NextSnapshotId
+	sim.source = restoreSeededSource(wire.Seed, wire.Draws)
+	sim.random = rand.New(sim.source)
+	if sim.trace != nil {
+		sim.random = rand.New(&tracedSource{sim.random, sim.trace})
+	}
+	sim.servers = make(map[string]*Server)
+	sim.serverIds = nil
+	for _, s := range wire.Servers {
+		sim.servers[s.Id] = NewServer(s.Id, s.Tokens, sim)
+	}
+	sim.recordedDuplicates = make(map[*SnapshotMessage]bool)
+	for _, s := range wire.Servers {
+		if err := sim.servers[s.Id].fromWire(s); err != nil {
+			return err
+		}
+	}
+	if err := sim.logger.fromWire(wire.Log); err != nil {
+		return err
+	}
+	sim.collectors = make(map[int]*snapshotCollector)
+	for snapshotId, c := range wire.Collectors {
+		sim.newCollector(snapshotId, c.Participants)
+		collector := sim.collectors[snapshotId]
+		for _, record := range c.Records {
+			if record.State == nil {
+				collector.report(sim.servers[record.ServerId].snapshot[snapshotId])
+				continue
+			}
+			snap, err := sim.loadSnapshot(*record.State)
+			if err != nil {
+				return err
+			}
+			collector.report(snap)
+		}
+	}
+	sim.finishedMap = copyIntMap(wire.FinishedMap)
+	sim.initialTokens = wire.InitialTokens
+	sim.startingTokens = copyTokenCounts(wire.StartingTokens)
+	sim.initiators = make(map[int]string)
+	for snapshotId, initiator := range wire.Initiators {
+		sim.initiators[snapshotId] = initiator
+	}
+	sim.collected = NewSyncMap[int, *SnapshotState]()
+	for snapshotId, saved := range wire.Collected {
+		snap, err := sim.loadSnapshot(saved)
+		if err != nil {
+			return err
+		}
+		sim.collected.Store(snapshotId, snap)
+	}
+	sim.generatedTokens = wire.GeneratedTokens
+	sim.CoalesceMarkers = wire.CoalesceMarkers
+	sim.groundTruth = make(map[int]*SnapshotState)
+	for snapshotId, saved := range wire.GroundTruth {
+		snap, err := sim.loadSnapshot(saved)
+		if err != nil {
+			return err
+		}
+		sim.groundTruth[snapshotId] = snap
+	}
+	sim.maxRecordedPerChannel = wire.MaxRecordedPerChannel
+	sim.algorithm = wire.Algorithm
+	sim.lostTokens = wire.LostTokens
+	sim.linkRemoval = wire.LinkRemoval
+	sim.latency = latencyFromWire(wire.Latency)
+	sim.initiatorPolicy = initiatorFromWire(wire.InitiatorPolicy)
+	sim.electionRound = wire.ElectionRound
+	sim.collectionMode = wire.CollectionMode
+	sim.disseminate = wire.Disseminate
+	sim.channelRecording = wire.ChannelRecording
+	sim.trees = make(map[int]*aggregationTree)
+	sim.metrics = metricsFromWire(wire.Metrics)
+	sim.schedule = make(map[int][]interface{})
+	for _, scheduled := range wire.Schedule {
+		var event interface{} = SnapshotEvent{scheduled.ServerId}
+		if scheduled.Kind == "send" {
+			event = PassTokenEvent{scheduled.Src, scheduled.Dest, scheduled.Tokens}
+		}
+		sim.schedule[scheduled.Time] = append(sim.schedule[scheduled.Time], event)
+	}
+	sim.pruneOnCollect = wire.PruneOnCollect
+	sim.checkInvariants = wire.CheckInvariants
+	sim.periodic = nil
+	if p := wire.Periodic; p != nil {
+		sim.periodic = &periodicSnapshots{p.EveryNTicks, p.Retain, p.Started,
+			append([]int{}, p.Pending...), append([]int{}, p.Retained...)}
+	}
+	sim.markers = nil
+	if m := wire.Markers; m != nil {
+		sim.markers = &markerLedger{m.FirstSnapshotId, make(map[int]map[ChannelId]int)}
+		for _, count := range m.Delivered {
+			if sim.markers.delivered[count.SnapshotId] == nil {
+				sim.markers.delivered[count.SnapshotId] = make(map[ChannelId]int)
+			}
+			sim.markers.delivered[count.SnapshotId][ChannelId{count.Src, count.Dest}] = count.Count
+		}
+	}
+	sim.deliveryOrder = wire.DeliveryOrder
+	sim.markerMode = wire.MarkerMode
+	sim.piggybackTimeout = wire.PiggybackTimeout
+	sim.censusTimeout = wire.CensusTimeout
+	sim.initialTypedTokens = copyTokenCounts(wire.InitialTypedTokens)
+	sim.duplicatedTokens = wire.DuplicatedTokens
+	return nil
+}
+
+func (server *Server) toWire() (serverWire, error) {
+	if server.state != nil {
+		return serverWire{}, fmt.Errorf("Cannot save the application state of %v", server.Id)
+	}
+	if pending := server.InboxLen(); pending > 0 {
+		return serverWire{}, fmt.Errorf("Cannot save %v packets waiting in the inbox of %v", pending, server.Id)
+	}
+	wire := serverWire{
+		Id:                server.Id,
+		Tokens:            server.Tokens,
+		Links:             make([]linkWire, 0),
+		ClosingLinks:      make([]linkWire, 0),
+		ReceivedSnapshot:  copyBoolMap(server.receivedSnapshot),
+		InReceivedMarker:  make(map[int]map[string]bool),
+		Snapshot:          make(map[int]savedSnapshot),
+		CompletedSnapshot: copyBoolMap(server.completedSnapshot),
+		SnapshotDeadline:  copyIntMap(server.snapshotDeadline),
+		MarkerArrival:     copyNestedCounts(server.markerArrival),
+		GenerationRate:    server.generationRate,
+		ForwardTo:         server.forwardTo,
+		ChannelRecorded:   copyNestedCounts(server.channelRecorded),
+		SentCount:         copyTokenCounts(server.sentCount),
+		ReceivedCount:     copyTokenCounts(server.receivedCount),
+		WhiteExpected:     copyNestedCounts(server.whiteExpected),
+		WhiteReceived:     copyNestedCounts(server.whiteReceived),
+		Crashed:           server.crashed,
+		Clock:             server.clock.Copy(),
+		Cuts:              cutsToWire(server.cuts),
+		Pruned:            copyBoolMap(server.pruned),
+		PrunedBelow:       server.prunedBelow,
+		WaitingFor:        copyStringSet(server.waitingFor),
+		DeferredGrants:    copyStringSet(server.deferredGrants),
+		TypedTokens:       copyTokenCounts(server.typedTokens),
+		Faults:            server.faults,
+		Lamport:           server.lamport,
+		ElectionRound:     server.electionRound,
+		Leader:            server.leader,
+	}
+	for snapshotId, markers := range server.inReceivedMarker {
+		wire.InReceivedMarker[snapshotId] = copyStringSet(markers)
+	}
+	for snapshotId, snap := range server.snapshot {
+		saved, err := server.sim.saveSnapshot(snap)
+		if err != nil {
+			return serverWire{}, err
+		}
+		wire.Snapshot[snapshotId] = saved
+	}
+	wire.KnownStates = make(map[int]map[string]snapshotWire)
+	for snapshotId, known := range server.knownStates {
+		wire.KnownStates[snapshotId] = make(map[string]snapshotWire)
+		for origin, snap := range known {
+			state, err := snap.toWire()
+			if err != nil {
+				return serverWire{}, err
+			}
+			wire.KnownStates[snapshotId][origin] = *state
+		}
+	}
+	wire.GlobalSnapshot = make(map[int]snapshotWire)
+	for snapshotId, snap := range server.globalSnapshot {
+		state, err := snap.toWire()
+		if err != nil {
+			return serverWire{}, err
+		}
+		wire.GlobalSnapshot[snapshotId] = *state
+	}
+	for _, dest := range getSortedKeys(server.outboundLinks) {
+		link, err := server.outboundLinks[dest].toWire()
+		if err != nil {
+			return serverWire{}, err
+		}
+		wire.Links = append(wire.Links, link)
+	}
+	for _, dest := range getSortedKeys(server.closingLinks) {
+		link, err := server.closingLinks[dest].toWire()
+		if err != nil {
+			return serverWire{}, err
+		}
+		wire.ClosingLinks = append(wire.ClosingLinks, link)
+	}
+	return wire, nil
+}
+
+func (server *Server) fromWire(wire serverWire) error {
+	sim := server.sim
+	server.receivedSnapshot = copyBoolMap(wire.ReceivedSnapshot)
+	for snapshotId, markers := range wire.InReceivedMarker {
+		server.inReceivedMarker[snapshotId] = copyStringSet(markers)
+	}
+	for snapshotId, saved := range wire.Snapshot {
+		snap, err := sim.loadSnapshot(saved)
+		if err != nil {
+			return err
+		}
+		server.snapshot[snapshotId] = snap
+	}
+	for snapshotId, known := range wire.KnownStates {
+		server.knownStates[snapshotId] = make(map[string]*SnapshotState)
+		for origin, state := range known {
+			snap := &SnapshotState{}
+			if err := snap.fromWire(&state); err != nil {
+				return err
+			}
+			server.knownStates[snapshotId][origin] = snap
+		}
+	}
+	for snapshotId, state := range wire.GlobalSnapshot {
+		snap := &SnapshotState{}
+		if err := snap.fromWire(&state); err != nil {
+			return err
+		}
+		server.globalSnapshot[snapshotId] = snap
+	}
+	server.completedSnapshot = copyBoolMap(wire.CompletedSnapshot)
+	server.snapshotDeadline = copyIntMap(wire.SnapshotDeadline)
+	server.markerArrival = copyNestedCounts(wire.MarkerArrival)
+	server.generationRate = wire.GenerationRate
+	server.forwardTo = wire.ForwardTo
+	server.channelRecorded = copyNestedCounts(wire.ChannelRecorded)
+	server.sentCount = copyTokenCounts(wire.SentCount)
+	server.receivedCount = copyTokenCounts(wire.ReceivedCount)
+	server.whiteExpected = copyNestedCounts(wire.WhiteExpected)
+	server.whiteReceived = copyNestedCounts(wire.WhiteReceived)
+	server.crashed = wire.Crashed
+	server.clock = VectorClock(copyTokenCounts(wire.Clock))
+	server.cuts = cutsFromWire(wire.Cuts)
+	server.pruned = copyBoolMap(wire.Pruned)
+	server.prunedBelow = wire.PrunedBelow
+	server.waitingFor = copyStringSet(wire.WaitingFor)
+	server.deferredGrants = copyStringSet(wire.DeferredGrants)
+	server.typedTokens = copyTokenCounts(wire.TypedTokens)
+	server.faults = wire.Faults
+	server.lamport = wire.Lamport
+	server.electionRound, server.leader = wire.ElectionRound, wire.Leader
+	for _, l := range wire.Links {
+		link, err := server.linkFromWire(l)
+		if err != nil {
+			return err
+		}
+		server.outboundLinks[l.Dest] = link
+		sim.servers[l.Dest].inboundLinks[server.Id] = link
+	}
+	for _, l := range wire.ClosingLinks {
+		link, err := server.linkFromWire(l)
+		if err != nil {
+			return err
+		}
+		server.closingLinks[l.Dest] = link
+		sim.servers[l.Dest].inboundLinks[server.Id] = link
+	}
+	server.linksChanged()
+	return nil
+}
+
+func (link *Link) toWire() (linkWire, error) {
+	if link.transform != nil {
+		return linkWire{}, fmt.Errorf("Cannot save the transform of link %v -> %v", link.src, link.dest)
+	}
+	latency, err := latencyToWire(link.latency)
+	if err != nil {
+		return linkWire{}, err
+	}
+	wire := linkWire{
+		Dest:                 link.dest,
+		Frozen:               link.frozen,
+		Partitioned:          link.partitioned,
+		LastMarkerTime:       link.lastMarkerTime,
+		Ordering:             link.ordering,
+		LossProbability:      link.lossProbability,
+		DuplicateProbability: link.duplicateProbability,
+		Latency:              latency,
+		Capacity:             link.capacity,
+		Backpressure:         link.backpressure,
+		HeldMarkers:          append([]int{}, link.heldMarkers...),
+		MarkerTimeout:        link.markerTimeout,
+		Bandwidth:            link.bandwidth,
+		Transmitted:          link.transmitted,
+	}
+	if wire.Events, err = eventsToWire(link.events); err != nil {
+		return linkWire{}, err
+	}
+	if wire.Backlog, err = eventsToWire(link.backlog); err != nil {
+		return linkWire{}, err
+	}
+	return wire, nil
+}
+
+func (server *Server) linkFromWire(wire linkWire) (*Link, error) {
+	events, err := eventsFromWire(wire.Events)
+	if err != nil {
+		return nil, err
+	}
+	backlog, err := eventsFromWire(wire.Backlog)
+	if err != nil {
+		return nil, err
+	}
+	var heldMarkers []int
+	if len(wire.HeldMarkers) > 0 {
+		heldMarkers = append(heldMarkers, wire.HeldMarkers...)
+	}
+	return &Link{
+		src:                  server.Id,
+		dest:                 wire.Dest,
+		events:               events,
+		frozen:               wire.Frozen,
+		partitioned:          wire.Partitioned,
+		lastMarkerTime:       wire.LastMarkerTime,
+		ordering:             wire.Ordering,
+		lossProbability:      wire.LossProbability,
+		duplicateProbability: wire.DuplicateProbability,
+		latency:              latencyFromWire(wire.Latency),
+		capacity:             wire.Capacity,
+		backpressure:         wire.Backpressure,
+		backlog:              backlog,
+		heldMarkers:          heldMarkers,
+		markerTimeout:        wire.MarkerTimeout,
+		bandwidth:            wire.Bandwidth,
+		transmitted:          wire.Transmitted,
+	}, nil
+}
+
+func eventsToWire(queue *Queue[SendMessageEvent]) ([]eventWire, error) {
+	wire := make([]eventWire, 0)
+	for _, e := range queue.Elements() {
+		message, err := packetToWire(e.message)
+		if err != nil {
+			return nil, err
+		}
+		wire = append(wire, eventWire{e.src, e.dest, message, e.receiveTime, e.sendTime, e.lamport})
+	}
+	return wire, nil
+}
+
+func eventsFromWire(wire []eventWire) (*Queue[SendMessageEvent], error) {
+	queue := NewQueue[SendMessageEvent]()
+	for _, e := range wire {
+		message, err := packetFromWire(e.Message)
+		if err != nil {
+			return nil, err
+		}
+		queue.Push(SendMessageEvent{e.Src, e.Dest, message, e.ReceiveTime, e.SendTime, e.Lamport})
+	}
+	return queue, nil
+}
+
+func packetToWire(message interface{}) (packetWire, error) {
+	switch m := message.(type) {
+	case nil:
+		return packetWire{}, nil
+	case TokenMessage:
+		return packetWire{Kind: "token", NumTokens: m.numTokens, HopCount: m.hopCount, TokenType: m.tokenType}, nil
+	case MarkerMessage:
+		return packetWire{Kind: "marker", SnapshotId: m.snapshotId}, nil
+	case MultiMarkerMessage:
+		return packetWire{Kind: "multiMarker", SnapshotIds: append([]int{}, m.snapshotIds...)}, nil
+	case WaitForMessage:
+		return packetWire{Kind: "waitFor", Grant: m.grant}, nil
+	case ElectionMessage:
+		return packetWire{Kind: "election", Round: m.round, Candidate: m.candidate}, nil
+	case RecordedStateMessage:
+		state, err := m.state.toWire()
+		if err != nil {
+			return packetWire{}, err
+		}
+		return packetWire{Kind: "recordedState", SnapshotId: m.snapshotId, Origin: m.origin, States: []snapshotWire{*state}}, nil
+	case AggregateMessage:
+		wire := packetWire{Kind: "aggregate", SnapshotId: m.snapshotId, States: make([]snapshotWire, 0)}
+		for _, snap := range m.states {
+			state, err := snap.toWire()
+			if err != nil {
+				return packetWire{}, err
+			}
+			wire.States = append(wire.States, *state)
+		}
+		return wire, nil
+	case ColoredMessage:
+		wire, _ := packetToWire(m.message)
+		wire.Kind = "colored"
+		wire.SnapshotIds = append([]int{}, m.recorded...)
+		return wire, nil
+	case LaiYangControlMessage:
+		return packetWire{Kind: "laiYangControl", SnapshotId: m.snapshotId, WhiteSent: m.whiteSent}, nil
+	case TimestampedMessage:
+		wire, _ := packetToWire(m.message)
+		wire.Kind = "timestamped"
+		wire.Clock = m.clock.Copy()
+		wire.Cuts = cutsToWire(m.cuts)
+		return wire, nil
+	case MatternControlMessage:
+		return packetWire{
+			Kind:       "matternControl",
+			SnapshotId: m.snapshotId,
+			WhiteSent:  m.whiteSent,
+			Clock:      m.clock.Copy(),
+			Cut:        cutWire{m.cut.initiator, m.cut.time},
+		}, nil
+	case PiggybackedMessage:
+		inner, err := packetToWire(m.message)
+		if err != nil {
+			return packetWire{}, err
+		}
+		return packetWire{Kind: "piggybacked", SnapshotIds: append([]int{}, m.snapshotIds...), Inner: &inner}, nil
+	case duplicateCopy:
+		inner, err := packetToWire(m.message)
+		if err != nil {
+			return packetWire{}, err
+		}
+		return packetWire{Kind: "duplicate", Inner: &inner}, nil
+	}
+	return packetWire{Kind: "application", Application: message}, nil
+}
+
+func packetFromWire(wire packetWire) (interface{}, error) {
+	token := TokenMessage{wire.NumTokens, wire.HopCount, wire.TokenType}
+	switch wire.Kind {
+	case "":
+		return nil, nil
+	case "token":
+		return token, nil
+	case "marker":
+		return MarkerMessage{wire.SnapshotId}, nil
+	case "multiMarker":
+		return MultiMarkerMessage{append([]int{}, wire.SnapshotIds...)}, nil
+	case "waitFor":
+		return WaitForMessage{wire.Grant}, nil
+	case "election":
+		return ElectionMessage{wire.Round, wire.Candidate}, nil
+	case "recordedState":
+		if len(wire.States) != 1 {
+			return nil, fmt.Errorf("Saved recorded state message carries %v states", len(wire.States))
+		}
+		snap := &SnapshotState{}
+		if err := snap.fromWire(&wire.States[0]); err != nil {
+			return nil, err
+		}
+		return RecordedStateMessage{wire.SnapshotId, wire.Origin, snap}, nil
+	case "aggregate":
+		states := make([]*SnapshotState, 0)
+		for i := range wire.States {
+			snap := &SnapshotState{}
+			if err := snap.fromWire(&wire.States[i]); err != nil {
+				return nil, err
+			}
+			states = append(states, snap)
+		}
+		return AggregateMessage{wire.SnapshotId, states}, nil
+	case "colored":
+		var recorded []int
+		if len(wire.SnapshotIds) > 0 {
+			recorded = append(recorded, wire.SnapshotIds...)
+		}
+		return ColoredMessage{token, recorded}, nil
+	case "laiYangControl":
+		return LaiYangControlMessage{wire.SnapshotId, wire.WhiteSent}, nil
+	case "timestamped":
+		return TimestampedMessage{token, VectorClock(copyTokenCounts(wire.Clock)), cutsFromWire(wire.Cuts)}, nil
+	case "matternControl":
+		return MatternControlMessage{
+			wire.SnapshotId,
+			snapshotCut{wire.Cut.Initiator, wire.Cut.Time},
+			wire.WhiteSent,
+			VectorClock(copyTokenCounts(wire.Clock)),
+		}, nil
+	case "piggybacked", "duplicate":
+		if wire.Inner == nil {
+			return nil, fmt.Errorf("Saved %v message carries no message", wire.Kind)
+		}
+		inner, err := packetFromWire(*wire.Inner)
+		if err != nil {
+			return nil, err
+		}
+		if wire.Kind == "duplicate" {
+			return duplicateCopy{inner}, nil
+		}
+		return PiggybackedMessage{append([]int{}, wire.SnapshotIds...), inner}, nil
+	case "application":
+		return wire.Application, nil
+	}
+	return nil, fmt.Errorf("Unknown kind of saved message %q", wire.Kind)
+}
+
+func cutsToWire(cuts map[int]snapshotCut) map[int]cutWire {
+	wire := make(map[int]cutWire)
+	for snapshotId, cut := range cuts {
+		wire[snapshotId] = cutWire{cut.initiator, cut.time}
+	}
+	return wire
+}
+
+func cutsFromWire(wire map[int]cutWire) map[int]snapshotCut {
+	cuts := make(map[int]snapshotCut)
+	for snapshotId, cut := range wire {
+		cuts[snapshotId] = snapshotCut{cut.Initiator, cut.Time}
+	}
+	return cuts
+}
+
+func (sim *Simulator) saveSnapshot(snap *SnapshotState) (savedSnapshot, error) {
+	if snap == nil {
+		return savedSnapshot{}, fmt.Errorf("Cannot save a missing snapshot")
+	}
+	state, err := snap.toWire()
+	if err != nil {
+		return savedSnapshot{}, err
+	}
+	saved := savedSnapshot{*state, make([]int, 0)}
+	for i, msg := range snap.messages {
+		if sim.recordedDuplicates[msg] {
+			saved.Duplicates = append(saved.Duplicates, i)
+		}
+	}
+	return saved, nil
+}
+
+// Return a reference to the state reported to the collector of a snapshot, if
+// it is the state kept by the server that recorded it, so that both are loaded
+// as the same state
+func (sim *Simulator) keptRecord(snapshotId int, snap *SnapshotState) (recordWire, bool) {
+	for _, serverId := range sim.sortedServerIds() {
+		if sim.servers[serverId].snapshot[snapshotId] == snap {
+			return recordWire{ServerId: serverId}, true
+		}
+	}
+	return recordWire{}, false
+}
+
+func (sim *Simulator) loadSnapshot(saved savedSnapshot) (*SnapshotState, error) {
+	snap := &SnapshotState{}
+	if err := snap.fromWire(&saved.State); err != nil {
+		return nil, err
+	}
+	for _, i := range saved.Duplicates {
+		sim.recordedDuplicates[snap.messages[i]] = true
+	}
+	return snap, nil
+}
+
+func (logger *Logger) toWire() (loggerWire, error) {
+	wire := loggerWire{
+		Events:     make([][]logEventWire, 0),
+		MaxEvents:  logger.maxEvents,
+		Evicted:    logger.evicted,
+		FirstEpoch: logger.firstEpoch,
+		Counts: [4]int{logger.counts.sent, logger.counts.received,
+			logger.counts.markersSent, logger.counts.markersPiggybacked},
+	}
+	for _, events := range logger.events {
+		epoch := make([]logEventWire, 0)
+		for _, event := range events {
+			e, err := logEventToWire(event)
+			if err != nil {
+				return loggerWire{}, err
+			}
+			epoch = append(epoch, e)
+		}
+		wire.Events = append(wire.Events, epoch)
+	}
+	return wire, nil
+}
+
+func (logger *Logger) fromWire(wire loggerWire) error {
+	logger.events = make([][]LogEvent, 0)
+	logger.numEvents = 0
+	for _, epoch := range wire.Events {
+		events := make([]LogEvent, 0)
+		for _, e := range epoch {
+			event, err := logEventFromWire(e)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		logger.events = append(logger.events, events)
+		logger.numEvents += len(events)
+	}
+	logger.maxEvents = wire.MaxEvents
+	logger.evicted = wire.Evicted
+	logger.firstEpoch = wire.FirstEpoch
+	logger.counts = eventCounts{wire.Counts[0], wire.Counts[1], wire.Counts[2], wire.Counts[3]}
+	return nil
+}
+
+func logEventToWire(event LogEvent) (logEventWire, error) {
+	wire := logEventWire{ServerId: event.serverId, ServerTokens: event.serverTokens, Lamport: event.lamport}
+	var message interface{}
+	switch e := event.event.(type) {
+	case SentMessageEvent:
+		wire.Kind, wire.Src, wire.Dest, message = "sent", e.src, e.dest, e.message
+	case ReceivedMessageEvent:
+		wire.Kind, wire.Src, wire.Dest, message = "received", e.src, e.dest, e.message
+		wire.SenderLamport = e.lamport
+	case DroppedMessageEvent:
+		wire.Kind, wire.Src, wire.Dest, message = "dropped", e.src, e.dest, e.message
+	case DuplicatedMessageEvent:
+		wire.Kind, wire.Src, wire.Dest, message = "duplicated", e.src, e.dest, e.message
+	case TransformedMessageEvent:
+		wire.Kind, wire.Src, wire.Dest, message = "transformed", e.src, e.dest, e.before
+		after, err := packetToWire(e.after)
+		if err != nil {
+			return logEventWire{}, err
+		}
+		wire.After = after
+	case StartSnapshot:
+		wire.Kind, wire.Src, wire.SnapshotId = "startSnapshot", e.serverId, e.snapshotId
+	case EndSnapshot:
+		wire.Kind, wire.Src, wire.SnapshotId = "endSnapshot", e.serverId, e.snapshotId
+	default:
+		return logEventWire{}, fmt.Errorf("Cannot save the logged event %v", event.event)
+	}
+	var err error
+	wire.Message, err = packetToWire(message)
+	return wire, err
+}
+
+func logEventFromWire(wire logEventWire) (LogEvent, error) {
+	message, err := packetFromWire(wire.Message)
+	if err != nil {
+		return LogEvent{}, err
+	}
+	var event interface{}
+	switch wire.Kind {
+	case "sent":
+		event = SentMessageEvent{wire.Src, wire.Dest, message}
+	case "received":
+		event = ReceivedMessageEvent{wire.Src, wire.Dest, message, wire.SenderLamport}
+	case "dropped":
+		event = DroppedMessageEvent{wire.Src, wire.Dest, message}
+	case "duplicated":
+		event = DuplicatedMessageEvent{wire.Src, wire.Dest, message}
+	case "transformed":
+		after, err := packetFromWire(wire.After)
+		if err != nil {
+			return LogEvent{}, err
+		}
+		event = TransformedMessageEvent{wire.Src, wire.Dest, message, after}
+	case "startSnapshot":
+		event = StartSnapshot{wire.Src, wire.SnapshotId}
+	case "endSnapshot":
+		event = EndSnapshot{wire.Src, wire.SnapshotId}
+	default:
+		return LogEvent{}, fmt.Errorf("Unknown kind of saved event %q", wire.Kind)
+	}
+	return LogEvent{wire.ServerId, wire.ServerTokens, event, wire.Lamport}, nil
+}
+
+func latencyToWire(model LatencyModel) (latencyWire, error) {
+	switch l := model.(type) {
+	case nil:
+		return latencyWire{}, nil
+	case UniformLatency:
+		return latencyWire{Kind: "uniform", Min: l.min, Max: l.max}, nil
+	case ConstantLatency:
+		return latencyWire{Kind: "constant", Delay: l.delay}, nil
+	case ExponentialLatency:
+		return latencyWire{Kind: "exponential", Mean: l.mean}, nil
+	}
+	return latencyWire{}, fmt.Errorf("Cannot save the latency model %T", model)
+}
+
+func initiatorToWire(policy SnapshotInitiatorPolicy) (initiatorWire, error) {
+	switch p := policy.(type) {
+	case nil:
+		return initiatorWire{}, nil
+	case FixedInitiator:
+		return initiatorWire{Kind: "fixed", ServerId: p.serverId}, nil
+	case RandomInitiator:
+		return initiatorWire{Kind: "random"}, nil
+	case LowestIdInitiator:
+		return initiatorWire{Kind: "lowestId"}, nil
+	case ElectedInitiator:
+		return initiatorWire{Kind: "elected"}, nil
+	}
+	return initiatorWire{}, fmt.Errorf("Cannot save the initiator policy %T", policy)
+}
+
+func initiatorFromWire(wire initiatorWire) SnapshotInitiatorPolicy {
+	switch wire.Kind {
+	case "fixed":
+		return FixedInitiator{wire.ServerId}
+	case "random":
+		return RandomInitiator{}
+	case "lowestId":
+		return LowestIdInitiator{}
+	case "elected":
+		return ElectedInitiator{}
+	}
+	return nil
+}
+
+func latencyFromWire(wire latencyWire) LatencyModel {
+	switch wire.Kind {
+	case "uniform":
+		return UniformLatency{wire.Min, wire.Max}
+	case "constant":
+		return ConstantLatency{wire.Delay}
+	case "exponential":
+		return ExponentialLatency{wire.Mean}
+	}
+	return nil
+}
+
+func (m *simMetrics) toWire() metricsWire {
+	wire := metricsWire{
+		SnapshotStart:   copyIntMap(m.snapshotStart),
+		SnapshotEnd:     copyNestedCounts(m.snapshotEnd),
+		QueueDepthTotal: m.queueDepthTotal,
+		QueueSamples:    m.queueSamples,
+		PrunedLatency:   make(map[string]histogramWire),
+	}
+	for serverId, h := range m.prunedLatency {
+		wire.PrunedLatency[serverId] = histogramWire{append([]int{}, h.counts...), h.count, h.sum}
+	}
+	return wire
+}
+
+func metricsFromWire(wire metricsWire) *simMetrics {
+	m := newSimMetrics()
+	m.snapshotStart = copyIntMap(wire.SnapshotStart)
+	m.snapshotEnd = copyNestedCounts(wire.SnapshotEnd)
+	m.queueDepthTotal = wire.QueueDepthTotal
+	m.queueSamples = wire.QueueSamples
+	for serverId, h := range wire.PrunedLatency {
+		m.prunedLatency[serverId] = &latencyHistogram{append([]int{}, h.Counts...), h.Count, h.Sum}
+	}
+	return m
+}
+
+func copyIntMap(m map[int]int) map[int]int {
+	copied := make(map[int]int)
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copyBoolMap(m map[int]bool) map[int]bool {
+	copied := make(map[int]bool)
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copyStringSet(m map[string]bool) map[string]bool {
+	copied := make(map[string]bool)
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copyNestedCounts(m map[int]map[string]int) map[int]map[string]int {
+	copied := make(map[int]map[string]int)
+	for k, counts := range m {
+		copied[k] = copyTokenCounts(counts)
+	}
+	return copied
+}
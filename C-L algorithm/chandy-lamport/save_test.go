@@ -0,0 +1,80 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Start a run with tokens and markers in flight, for every algorithm
+func startSavedRun(algorithm SnapshotAlgorithm) *Simulator {
+	sim := NewSimulatorWithSeed(8053172852482175524)
+	sim.SetSnapshotAlgorithm(algorithm)
+	readTopology("8nodes.top", sim)
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	sim.InjectEvent(PassTokenEvent{"N2", "N3", 1})
+	sim.Tick()
+	sim.StartSnapshot("N1")
+	sim.InjectEvent(PassTokenEvent{"N1", "N3", 2})
+	sim.Tick()
+	return sim
+}
+
+// Run the rest of the run started by `startSavedRun`
+func finishSavedRun(sim *Simulator) {
+	sim.ScheduleEvent(sim.time+2, SnapshotEvent{"N5"})
+	sim.InjectEvent(PassTokenEvent{"N3", "N4", 1})
+	sim.Drain()
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	for _, algorithm := range []SnapshotAlgorithm{ChandyLamport, LaiYang, Mattern} {
+		sim := startSavedRun(algorithm)
+		var buf bytes.Buffer
+		if err := sim.Save(&buf); err != nil {
+			t.Fatalf("%v: %v\n", algorithm, err)
+		}
+		loaded, err := LoadSimulator(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v\n", algorithm, err)
+		}
+		if !reflect.DeepEqual(loaded.EventLog(), sim.EventLog()) {
+			t.Fatalf("%v: expected the loaded simulation to have the same event log\n", algorithm)
+		}
+		// Both simulations go on exactly the same way
+		finishSavedRun(sim)
+		finishSavedRun(loaded)
+		if !reflect.DeepEqual(loaded.EventLog(), sim.EventLog()) {
+			t.Fatalf("%v: expected the resumed simulation to log the same events, got:\n%v\ninstead of:\n%v\n",
+				algorithm, loaded.EventLog(), sim.EventLog())
+		}
+		for _, snapshotId := range []int{0, 1} {
+			snap := loaded.CollectSnapshot(snapshotId)
+			if diff := DiffSnapshots(sim.CollectSnapshot(snapshotId), snap); !diff.Empty() {
+				t.Fatalf("%v: expected the resumed simulation to record the same snapshot:\n%v\n", algorithm, diff)
+			}
+			if err := loaded.ValidateSnapshot(snapshotId, loaded.InitialTokens()); err != nil {
+				t.Fatalf("%v: %v\n", algorithm, err)
+			}
+		}
+		if !reflect.DeepEqual(loaded.Metrics(), sim.Metrics()) {
+			t.Fatalf("%v: expected the same metrics, got %+v instead of %+v\n",
+				algorithm, loaded.Metrics(), sim.Metrics())
+		}
+	}
+}
+
+func TestSaveUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	if err := sim.Save(&buf); err == nil {
+		t.Fatalf("Expected saving a simulator without a seed to fail\n")
+	}
+	sim = NewSimulatorWithSeed(1)
+	readTopology("3nodes.top", sim)
+	sim.SetLinkTransform("N1", "N2", func(message interface{}) interface{} { return message })
+	if err := sim.Save(&buf); err == nil {
+		t.Fatalf("Expected saving a simulator with a link transform to fail\n")
+	}
+}
@@ -0,0 +1,142 @@
+package chandy_lamport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A script of events to inject at given time steps, replacing hand-rolled test
+// drivers. Scenarios are built with `Send` and `Snapshot`, or parsed from text
+// with `ParseScenario`, and run with `Simulator.RunScenario`.
+type Scenario struct {
+	steps []scenarioStep
+}
+
+type scenarioStep struct {
+	time  int
+	event interface{}
+}
+
+func NewScenario() *Scenario {
+	return &Scenario{make([]scenarioStep, 0)}
+}
+
+// At the given time step, send tokens from src to dest
+func (s *Scenario) Send(time int, src, dest string, numTokens int) *Scenario {
+	s.steps = append(s.steps, scenarioStep{time, PassTokenEvent{src, dest, numTokens}})
+	return s
+}
+
+// At the given time step, start a snapshot on the server
+func (s *Scenario) Snapshot(time int, serverId string) *Scenario {
+	s.steps = append(s.steps, scenarioStep{time, SnapshotEvent{serverId}})
+	return s
+}
+
+// Return the time step of the last event of the scenario
+func (s *Scenario) Duration() int {
+	duration := 0
+	for _, step := range s.steps {
+		if step.time > duration {
+			duration = step.time
+		}
+	}
+	return duration
+}
+
+// Parse a scenario with one event per line, in either of the forms
+//
+//	at [time] send [src] [dest] [numTokens]
+//	at [time] snapshot [serverId]
+//
+// e.g. "at 5 send N1 N2 3". Empty lines and lines starting with "#" are ignored.
+func ParseScenario(r io.Reader) (*Scenario, error) {
+	s := NewScenario()
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lineError := func(format string, args ...interface{}) error {
+			return fmt.Errorf("Scenario line %v: %v: %v",
+				lineNum, fmt.Sprintf(format, args...), line)
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 3 || parts[0] != "at" {
+			return nil, lineError("expected \"at [time] [action]\"")
+		}
+		time, err := strconv.Atoi(parts[1])
+		if err != nil || time < 1 {
+			return nil, lineError("invalid time")
+		}
+		switch parts[2] {
+		case "send":
+			if len(parts) != 6 {
+				return nil, lineError("expected a source, a destination and a number of tokens")
+			}
+			numTokens, err := strconv.Atoi(parts[5])
+			if err != nil {
+				return nil, lineError("invalid number of tokens")
+			}
+			s.Send(time, parts[3], parts[4], numTokens)
+		case "snapshot":
+			if len(parts) != 4 {
+				return nil, lineError("expected a server ID")
+			}
+			s.Snapshot(time, parts[3])
+		default:
+			return nil, lineError("unknown action %v", parts[2])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Schedule the events of the scenario with `Simulator.ScheduleEvent`, taking
+// the current time step as time 0 of the script. Events at time 0 are injected
+// right away.
+func (s *Scenario) Schedule(sim *Simulator) error {
+	for _, step := range s.steps {
+		if step.time == 0 {
+			if err := sim.InjectEvent(step.event); err != nil {
+				return fmt.Errorf("At time 0: %w", err)
+			}
+			continue
+		}
+		if err := sim.ScheduleEvent(sim.time+step.time, step.event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run the scenario from the current time step, which is time 0 of the script,
+// until all its events have been injected and all the messages delivered.
+// Events at the same time step are injected in the order of the script, so the
+// run is deterministic given the randomness of the simulator. Stops with an
+// error at the first event that cannot be injected, e.g. sending more tokens
+// than held.
+func (sim *Simulator) RunScenario(s *Scenario) error {
+	steps := append([]scenarioStep{}, s.steps...)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].time < steps[j].time
+	})
+	start := sim.time
+	for _, step := range steps {
+		for sim.time < start+step.time {
+			sim.Tick()
+		}
+		if err := sim.InjectEvent(step.event); err != nil {
+			return fmt.Errorf("At time %v: %w", step.time, err)
+		}
+	}
+	sim.Drain()
+	return nil
+}
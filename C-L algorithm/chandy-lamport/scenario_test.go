@@ -0,0 +1,81 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunScenario(t *testing.T) {
+	script := `
+# N1 pays N2, then N3 takes a snapshot while N2 pays N3
+at 1 send N1 N2 3
+at 5 send N2 N3 4
+at 5 snapshot N3
+at 7 send N1 N3 1
+`
+	parsed, err := ParseScenario(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Steps are sorted by time, but keep the order of the script within a time step
+	built := NewScenario().
+		Send(7, "N1", "N3", 1).
+		Send(1, "N1", "N2", 3).
+		Send(5, "N2", "N3", 4).
+		Snapshot(5, "N3")
+	if parsed.Duration() != 7 || built.Duration() != 7 {
+		t.Fatalf("Expected both scenarios to last 7 ticks\n")
+	}
+
+	run := func(s *Scenario) *Simulator {
+		rand.Seed(8053172852482175524)
+		sim := NewSimulator()
+		readTopology("3nodes.top", sim)
+		if err := sim.RunScenario(s); err != nil {
+			t.Fatal(err)
+		}
+		return sim
+	}
+	sim := run(parsed)
+	if !reflect.DeepEqual(sim.logger.Lines(), run(built).logger.Lines()) {
+		t.Fatalf("Expected the parsed and built scenarios to run the same way\n")
+	}
+	if sim.initiators[0] != "N3" {
+		t.Fatalf("Expected N3 to initiate snapshot 0\n")
+	}
+	if err := VerifySnapshot(sim.CollectSnapshot(0), sim.Topology()); err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := NewScenario().Send(2, "N3", "N1", 1)
+	err = NewSimulator().RunScenario(unknown)
+	if !errors.Is(err, ErrUnknownServer) || !strings.Contains(err.Error(), "At time 2") {
+		t.Fatalf("Expected an error at time 2 for an unknown server, got %v\n", err)
+	}
+	if _, err := ParseScenario(strings.NewReader("at 3 jump N1\n")); err == nil {
+		t.Fatalf("Expected an error parsing an unknown action\n")
+	}
+}
+
+func TestScheduleScenario(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	scenario := NewScenario().Snapshot(0, "N1").Send(2, "N1", "N2", 3).Snapshot(4, "N2")
+	if err := scenario.Schedule(sim); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sim.SnapshotIds(), []int{0}) {
+		t.Fatalf("Expected the snapshot at time 0 to start right away, got %v\n", sim.SnapshotIds())
+	}
+	sim.Drain()
+	if !reflect.DeepEqual(sim.SnapshotIds(), []int{0, 1}) {
+		t.Fatalf("Expected 2 snapshots after draining, got %v\n", sim.SnapshotIds())
+	}
+	if len(sim.EventLog()) == 0 {
+		t.Fatalf("Expected the event log to be non-empty\n")
+	}
+}
@@ -0,0 +1,265 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The exported form of a `SnapshotState`, which is what the JSON and binary
+// encodings of a snapshot contain
+type snapshotWire struct {
+	Id              int             `json:"id"`
+	Initiator       string          `json:"initiator,omitempty"`
+	Tokens          map[string]int  `json:"tokens"`
+	Messages        []messageWire   `json:"messages"`
+	Channels        []channelWire   `json:"channels"`
+	UnknownChannels []channelWire   `json:"unknownChannels,omitempty"`
+	Overflow        []channelWire   `json:"overflow,omitempty"`
+	Crashed         []string        `json:"crashed,omitempty"`
+	Completion      map[string]bool `json:"completion,omitempty"`
+	// Application state of each server, base64 encoded in JSON
+	AppState map[string][]byte   `json:"appState,omitempty"`
+	Waits    map[string]waitWire `json:"waits,omitempty"`
+	// Server ID -> token type -> number of tokens of the other types than the
+	// default one
+	Typed map[string]map[string]int `json:"typed,omitempty"`
+	// Server ID -> Lamport clock of the server when it recorded its state
+	Cut map[string]int `json:"cut,omitempty"`
+	// Token messages recorded in aggregate on each channel
+	Aggregated []aggregateWire `json:"aggregated,omitempty"`
+}
+
+// The token messages recorded on a channel in the `Aggregate` mode
+type aggregateWire struct {
+	Src        string         `json:"src"`
+	Dest       string         `json:"dest"`
+	Messages   int            `json:"messages"`
+	Tokens     map[string]int `json:"tokens"`
+	Duplicates int            `json:"duplicates,omitempty"`
+}
+
+// The wait-for state recorded by a server, see `deadlock.go`
+type waitWire struct {
+	WaitingFor []string `json:"waitingFor,omitempty"`
+	Deferred   []string `json:"deferred,omitempty"`
+}
+
+// A message recorded on a channel. Only token, marker and wait-for messages can
+// be recorded, so `Kind` is either "token", "marker", "request" or "grant".
+type messageWire struct {
+	Src        string `json:"src"`
+	Dest       string `json:"dest"`
+	Kind       string `json:"kind"`
+	NumTokens  int    `json:"numTokens,omitempty"`
+	TokenType  string `json:"tokenType,omitempty"`
+	HopCount   int    `json:"hopCount,omitempty"`
+	SnapshotId int    `json:"snapshotId,omitempty"`
+	Lamport    int    `json:"lamport,omitempty"`
+}
+
+// A channel, along with the number of messages that overflowed on it when
+// used in `snapshotWire.Overflow`
+type channelWire struct {
+	Src   string `json:"src"`
+	Dest  string `json:"dest"`
+	Count int    `json:"count,omitempty"`
+}
+
+func channelsToWire(channels []ChannelId) []channelWire {
+	wire := make([]channelWire, 0)
+	for _, channel := range channels {
+		wire = append(wire, channelWire{Src: channel.src, Dest: channel.dest})
+	}
+	return wire
+}
+
+func channelsFromWire(wire []channelWire) []ChannelId {
+	channels := make([]ChannelId, 0)
+	for _, channel := range wire {
+		channels = append(channels, ChannelId{channel.Src, channel.Dest})
+	}
+	return channels
+}
+
+func (s *SnapshotState) toWire() (*snapshotWire, error) {
+	wire := snapshotWire{
+		Id:              s.id,
+		Initiator:       s.initiator,
+		Tokens:          make(map[string]int),
+		Messages:        make([]messageWire, 0),
+		Channels:        channelsToWire(s.channels),
+		UnknownChannels: channelsToWire(s.unknownChannels),
+		Overflow:        make([]channelWire, 0),
+		Crashed:         append([]string{}, s.crashed...),
+		Completion:      s.Completion(),
+		AppState:        make(map[string][]byte),
+		Waits:           make(map[string]waitWire),
+		Typed:           make(map[string]map[string]int),
+		Cut:             make(map[string]int),
+	}
+	for serverId, state := range s.appState {
+		wire.AppState[serverId] = state
+	}
+	for serverId, state := range s.waits {
+		wire.Waits[serverId] = waitWire{state.waitingFor, state.deferred}
+	}
+	for serverId, counts := range s.typed {
+		wire.Typed[serverId] = copyTokenCounts(counts)
+	}
+	for serverId, clock := range s.cut {
+		wire.Cut[serverId] = clock
+	}
+	for serverId, tokens := range s.tokens {
+		wire.Tokens[serverId] = tokens
+	}
+	for _, msg := range s.messages {
+		m := messageWire{Src: msg.src, Dest: msg.dest, HopCount: msg.hopCount, Lamport: msg.lamport}
+		switch v := msg.message.(type) {
+		case TokenMessage:
+			m.Kind = "token"
+			m.NumTokens = v.numTokens
+			m.TokenType = v.tokenType
+		case MarkerMessage:
+			m.Kind = "marker"
+			m.SnapshotId = v.snapshotId
+		case WaitForMessage:
+			m.Kind = v.String()
+		default:
+			return nil, fmt.Errorf("Cannot encode %v recorded on channel %v -> %v",
+				msg.message, msg.src, msg.dest)
+		}
+		wire.Messages = append(wire.Messages, m)
+	}
+	for _, channel := range getSortedChannels(s.overflow) {
+		wire.Overflow = append(wire.Overflow,
+			channelWire{channel.src, channel.dest, s.overflow[channel]})
+	}
+	for _, channel := range s.aggregatedChannels() {
+		a := s.aggregated[channel]
+		wire.Aggregated = append(wire.Aggregated,
+			aggregateWire{channel.src, channel.dest, a.messages, copyTokenCounts(a.tokens), a.duplicates})
+	}
+	return &wire, nil
+}
+
+func (s *SnapshotState) fromWire(wire *snapshotWire) error {
+	snap := SnapshotState{
+		id:              wire.Id,
+		tokens:          make(map[string]int),
+		messages:        make([]*SnapshotMessage, 0),
+		channels:        channelsFromWire(wire.Channels),
+		unknownChannels: channelsFromWire(wire.UnknownChannels),
+		initiator:       wire.Initiator,
+		overflow:        make(map[ChannelId]int),
+		crashed:         append([]string{}, wire.Crashed...),
+		completion:      make(map[string]bool),
+		appState:        make(map[string][]byte),
+		waits:           make(map[string]waitState),
+		typed:           make(map[string]map[string]int),
+		cut:             make(map[string]int),
+	}
+	for serverId, counts := range wire.Typed {
+		snap.typed[serverId] = copyTokenCounts(counts)
+	}
+	for serverId, clock := range wire.Cut {
+		snap.cut[serverId] = clock
+	}
+	for serverId, state := range wire.AppState {
+		snap.appState[serverId] = state
+	}
+	for serverId, state := range wire.Waits {
+		snap.waits[serverId] = waitState{
+			append([]string{}, state.WaitingFor...),
+			append([]string{}, state.Deferred...),
+		}
+	}
+	for serverId, tokens := range wire.Tokens {
+		snap.tokens[serverId] = tokens
+	}
+	for _, m := range wire.Messages {
+		msg := SnapshotMessage{src: m.Src, dest: m.Dest, hopCount: m.HopCount, lamport: m.Lamport}
+		switch m.Kind {
+		case "token":
+			msg.message = TokenMessage{m.NumTokens, m.HopCount, m.TokenType}
+		case "marker":
+			msg.message = MarkerMessage{m.SnapshotId}
+		case "request", "grant":
+			msg.message = WaitForMessage{m.Kind == "grant"}
+		default:
+			return fmt.Errorf("Unknown kind of message %q on channel %v -> %v",
+				m.Kind, m.Src, m.Dest)
+		}
+		snap.messages = append(snap.messages, &msg)
+	}
+	for _, channel := range wire.Overflow {
+		snap.overflow[ChannelId{channel.Src, channel.Dest}] = channel.Count
+	}
+	for _, a := range wire.Aggregated {
+		if snap.aggregated == nil {
+			snap.aggregated = make(map[ChannelId]*channelAggregate)
+		}
+		snap.aggregated[ChannelId{a.Src, a.Dest}] = &channelAggregate{a.Messages, copyTokenCounts(a.Tokens), a.Duplicates}
+	}
+	for serverId, completed := range wire.Completion {
+		snap.completion[serverId] = completed
+	}
+	*s = snap
+	return nil
+}
+
+// Return the channels in the keys of the map, sorted by source then destination
+func getSortedChannels(m map[ChannelId]int) []ChannelId {
+	channels := make([]ChannelId, 0)
+	for channel := range m {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].String() < channels[j].String()
+	})
+	return channels
+}
+
+// Encode the snapshot as JSON, e.g. to write it to disk or load it into
+// external analysis tools
+func (s *SnapshotState) MarshalJSON() ([]byte, error) {
+	wire, err := s.toWire()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(wire)
+}
+
+// Decode a snapshot encoded by `MarshalJSON`
+func (s *SnapshotState) UnmarshalJSON(data []byte) error {
+	var wire snapshotWire
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	return s.fromWire(&wire)
+}
+
+// Encode the snapshot in the compact gob format. This also lets snapshots be
+// written directly with a `gob.Encoder`.
+func (s *SnapshotState) MarshalBinary() ([]byte, error) {
+	wire, err := s.toWire()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(wire); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode a snapshot encoded by `MarshalBinary`
+func (s *SnapshotState) UnmarshalBinary(data []byte) error {
+	var wire snapshotWire
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wire); err != nil {
+		return err
+	}
+	return s.fromWire(&wire)
+}
@@ -0,0 +1,47 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotSerialization(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetMaxRecordedPerChannel(1)
+	readTopology("3nodes.top", sim)
+	snaps := injectEvents("3nodes-bidirectional-messages.events", sim)
+	snap := sim.CollectSnapshot(snaps[0].id)
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON SnapshotState
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&fromJSON, snap) {
+		t.Fatalf("Expected JSON round trip to preserve the snapshot:\n%v\n%v\n", snap, fromJSON)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snap); err != nil {
+		t.Fatal(err)
+	}
+	var fromGob SnapshotState
+	if err := gob.NewDecoder(&buf).Decode(&fromGob); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&fromGob, snap) {
+		t.Fatalf("Expected binary round trip to preserve the snapshot:\n%v\n%v\n", snap, fromGob)
+	}
+
+	if err := json.Unmarshal([]byte(`{"messages":[{"kind":"ack"}]}`), &fromJSON); err == nil {
+		t.Fatalf("Expected an error decoding an unknown kind of message\n")
+	}
+}
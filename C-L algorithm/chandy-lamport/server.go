@@ -1,6 +1,9 @@
 package chandy_lamport
 
-import "log"
+import (
+	"math/rand"
+	"sort"
+)
 
 // The main participant of the distributed snapshot protocol.
 // Servers exchange token messages and marker messages among each other.
@@ -17,6 +20,69 @@ type Server struct {
 	receivedSnapshot map[int]bool            // snapshotID -> if received snapshot
 	inReceivedMarker map[int]map[string]bool // snapshotID -> src -> if received marker
 	snapshot         map[int]*SnapshotState  // snapshotID -> state
+	// snapshotID -> if local snapshot has completed
+	completedSnapshot map[int]bool
+	// snapshotID -> time step by which the local snapshot must complete
+	snapshotDeadline map[int]int
+	// snapshotID -> src -> time step at which the marker arrived
+	markerArrival map[int]map[string]int
+	// Number of tokens minted on this server at every time step
+	generationRate int
+	// If set, the ID of the server that received tokens are forwarded to
+	forwardTo string
+	// snapshotID -> src -> number of messages recorded on the channel
+	channelRecorded map[int]map[string]int
+	sentCount       map[string]int // dest -> number of token messages sent
+	receivedCount   map[string]int // src -> number of token messages received
+	// Bookkeeping of the Lai-Yang algorithm, see `lai_yang.go`
+	// snapshotID -> src -> white messages the sender announced for the channel
+	whiteExpected map[int]map[string]int
+	// snapshotID -> src -> white messages received on the channel
+	whiteReceived map[int]map[string]int
+	// If true, the server has crashed and does not process any packets
+	crashed bool
+	// Bookkeeping of Mattern's algorithm, see `mattern.go`
+	clock VectorClock
+	cuts  map[int]snapshotCut // snapshotID -> cut
+	// State recorded along with the tokens, see `state.go`. If nil, the
+	// number of tokens is recorded.
+	state ApplicationState
+	// Snapshots released by `PruneSnapshots`, whose late messages are ignored:
+	// those below prunedBelow that are not in progress, and the ones in pruned
+	pruned      map[int]bool
+	prunedBelow int
+	// Links removed while messages were still on them, see `Simulator.RemoveLink`.
+	// Nothing is sent on them anymore, but their messages are still delivered.
+	closingLinks map[string]*Link // key = link.dest
+	// Wait-for state of this server, see `deadlock.go`
+	waitingFor     map[string]bool // dest -> if waiting for a grant
+	deferredGrants map[string]bool // src -> if a grant is owed
+	// Sorted IDs of the outbound links and sorted delivering links, computed
+	// when first needed after the links change, see `linksChanged`
+	sortedNeighbors  []string
+	sortedDelivering []*Link
+	// Packets delivered from other goroutines, see `Deliver`
+	inbox *serverInbox
+	// Tokens of the other types than `DefaultTokenType`, see `typed.go`
+	typedTokens map[string]int // token type -> num tokens
+	// How this server misbehaves, or nil if it does not, see `faulty.go`
+	faults *FaultSpec
+	// If true, the message being handled is a copy, see `duplicate.go`
+	handlingDuplicate bool
+	// Lamport clock of this server, and clock of the sender of the message
+	// being handled, see `lamport.go`
+	lamport         int
+	handlingLamport int
+	// Lamport clock of the receive being handled, or 0 if none
+	receivedAt int
+	// Round of elections the server last took part in, and the lowest ID it
+	// heard of in that round, see `initiator.go`
+	electionRound int
+	leader        string
+	// snapshotID -> origin -> state disseminated by the origin, and
+	// snapshotID -> global state merged from them, see `dissemination.go`
+	knownStates    map[int]map[string]*SnapshotState
+	globalSnapshot map[int]*SnapshotState
 }
 
 // A unidirectional communication channel between two servers
@@ -24,19 +90,82 @@ type Server struct {
 type Link struct {
 	src    string
 	dest   string
-	events *Queue
+	events *Queue[SendMessageEvent]
+	// Optional function applied to every message delivered on this link
+	transform func(message interface{}) interface{}
+	// If true, messages are held on this link instead of being delivered
+	frozen bool
+	// If true, messages are held on this link because it crosses a network
+	// partition, see `Partition`
+	partitioned bool
+	// Time step at which the last marker was pushed on this link
+	lastMarkerTime int
+	// Order in which the messages on this link are delivered
+	ordering OrderingPolicy
+	// Probability that a message due for delivery is dropped instead
+	lossProbability float64
+	// Probability that a message delivered is queued again, see `InjectDuplication`
+	duplicateProbability float64
+	// If set, the latency of the messages sent on this link
+	latency LatencyModel
+	// Maximum number of messages queued on this link, 0 if unbounded, and what
+	// happens to the messages sent while it is full, see `SetCapacity`
+	capacity     int
+	backpressure BackpressurePolicy
+	// Messages held at the sender until there is room on the link
+	backlog *Queue[SendMessageEvent]
+	// Markers waiting for a message to piggyback on, see `piggyback.go`, and
+	// the time step at which they are sent on their own instead
+	heldMarkers   []int
+	markerTimeout int
+	// Size of the messages transmitted per time step, 0 if unlimited, and the
+	// units transmitted by the end of the messages queued so far, see
+	// `SetBandwidth`
+	bandwidth   int
+	transmitted int
 }
 
+// The order in which a link delivers the messages queued on it
+type OrderingPolicy int
+
+const (
+	// Deliver messages in the order they were sent
+	FIFO OrderingPolicy = iota
+	// Deliver any message whose receive time has passed, chosen at random
+	RandomReorder
+	// Deliver messages in the order of their receive times, so a message can
+	// only overtake older ones by having a shorter delay (at most `maxDelay`)
+	BoundedDelayReorder
+)
+
 func NewServer(id string, tokens int, sim *Simulator) *Server {
 	return &Server{
-		id,
-		tokens,
-		sim,
-		make(map[string]*Link),
-		make(map[string]*Link),
-		make(map[int]bool),
-		make(map[int]map[string]bool),
-		make(map[int]*SnapshotState),
+		Id:                id,
+		Tokens:            tokens,
+		sim:               sim,
+		outboundLinks:     make(map[string]*Link),
+		inboundLinks:      make(map[string]*Link),
+		receivedSnapshot:  make(map[int]bool),
+		inReceivedMarker:  make(map[int]map[string]bool),
+		snapshot:          make(map[int]*SnapshotState),
+		completedSnapshot: make(map[int]bool),
+		snapshotDeadline:  make(map[int]int),
+		markerArrival:     make(map[int]map[string]int),
+		channelRecorded:   make(map[int]map[string]int),
+		sentCount:         make(map[string]int),
+		receivedCount:     make(map[string]int),
+		whiteExpected:     make(map[int]map[string]int),
+		whiteReceived:     make(map[int]map[string]int),
+		clock:             NewVectorClock(),
+		cuts:              make(map[int]snapshotCut),
+		pruned:            make(map[int]bool),
+		closingLinks:      make(map[string]*Link),
+		waitingFor:        make(map[string]bool),
+		deferredGrants:    make(map[string]bool),
+		inbox:             newInbox(),
+		typedTokens:       make(map[string]int),
+		knownStates:       make(map[int]map[string]*SnapshotState),
+		globalSnapshot:    make(map[int]*SnapshotState),
 	}
 }
 
@@ -45,89 +174,609 @@ func (server *Server) AddOutboundLink(dest *Server) {
 	if server == dest {
 		return
 	}
-	l := Link{server.Id, dest.Id, NewQueue()}
-	server.outboundLinks[dest.Id] = &l
-	dest.inboundLinks[server.Id] = &l
+	if _, ok := server.outboundLinks[dest.Id]; ok {
+		return
+	}
+	l := &Link{
+		src:            server.Id,
+		dest:           dest.Id,
+		events:         NewQueue[SendMessageEvent](),
+		lastMarkerTime: -1,
+		backlog:        NewQueue[SendMessageEvent](),
+	}
+	// Adding back a link that is still closing keeps the messages on it
+	if closing, ok := server.closingLinks[dest.Id]; ok {
+		delete(server.closingLinks, dest.Id)
+		l = closing
+	}
+	server.outboundLinks[dest.Id] = l
+	dest.inboundLinks[server.Id] = l
+	server.linksChanged()
+	// The link may be added while snapshots are in progress. Since this server
+	// already sent its markers for the snapshots it recorded, it sends them on
+	// the new link right away, or the destination would wait for them forever.
+	snapshotIds := make([]int, 0)
+	for snapshotId := range server.snapshot {
+		snapshotIds = append(snapshotIds, snapshotId)
+	}
+	sort.Ints(snapshotIds)
+	for _, snapshotId := range snapshotIds {
+		if server.sim.algorithm == Mattern {
+			server.sendMatternControl(snapshotId, l)
+			continue
+		}
+		var message interface{} = MarkerMessage{snapshotId}
+		if server.sim.algorithm == LaiYang {
+			message = LaiYangControlMessage{snapshotId, server.sentCount[dest.Id]}
+		}
+		server.sim.logger.RecordEvent(server, SentMessageEvent{server.Id, dest.Id, message})
+		server.sim.enqueue(l, SendMessageEvent{
+			server.Id,
+			dest.Id,
+			message,
+			server.sim.receiveTimeOn(l),
+			server.sim.time,
+			server.lamport})
+	}
+}
+
+// Remove the link to the specified server, discarding the messages still on it.
+// The local snapshots of the destination that were only waiting for a marker
+// on this link complete right away.
+func (server *Server) RemoveOutboundLink(dest *Server) {
+	if _, ok := server.outboundLinks[dest.Id]; !ok {
+		return
+	}
+	delete(server.outboundLinks, dest.Id)
+	server.linksChanged()
+	server.detachLink(dest)
+}
+
+// Stop sending on the link to the specified server, but keep delivering the
+// messages still on it. The link is removed once they have all been delivered.
+func (server *Server) closeOutboundLink(dest *Server) {
+	link, ok := server.outboundLinks[dest.Id]
+	if !ok {
+		return
+	}
+	delete(server.outboundLinks, dest.Id)
+	server.linksChanged()
+	if link.events.Empty() && link.backlog.Empty() {
+		server.detachLink(dest)
+		return
+	}
+	server.closingLinks[dest.Id] = link
+}
+
+// Forget the sorted links of this server after its outbound or closing links
+// changed
+func (server *Server) linksChanged() {
+	server.sortedNeighbors = nil
+	server.sortedDelivering = nil
+}
+
+// Return the sorted IDs of the outbound links of this server. The slice is
+// shared by the callers, who must not modify it.
+func (server *Server) neighborIds() []string {
+	if server.sortedNeighbors == nil {
+		server.sortedNeighbors = getSortedKeys(server.outboundLinks)
+	}
+	return server.sortedNeighbors
+}
+
+// Return the links this server delivers messages on, sorted by destination:
+// its outbound links and the ones still closing. The slice is shared by the
+// callers, who must not modify it.
+func (server *Server) deliveringLinks() []*Link {
+	if server.sortedDelivering != nil {
+		return server.sortedDelivering
+	}
+	links := make([]*Link, 0, len(server.outboundLinks)+len(server.closingLinks))
+	for _, link := range server.outboundLinks {
+		links = append(links, link)
+	}
+	for _, link := range server.closingLinks {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].dest < links[j].dest
+	})
+	server.sortedDelivering = links
+	return links
+}
+
+// Remove the closing links no message is left on
+func (server *Server) detachDrainedLinks() {
+	if len(server.closingLinks) == 0 {
+		return
+	}
+	for _, dest := range getSortedKeys(server.closingLinks) {
+		link := server.closingLinks[dest]
+		if link.events.Empty() && link.backlog.Empty() {
+			delete(server.closingLinks, dest)
+			server.linksChanged()
+			server.detachLink(server.sim.servers[dest])
+		}
+	}
+}
+
+// Remove the link to the specified server from its inbound links, completing
+// its local snapshots that were only waiting for a marker on it
+func (server *Server) detachLink(dest *Server) {
+	delete(dest.inboundLinks, server.Id)
+	snapshotIds := make([]int, 0)
+	for snapshotId, received := range dest.receivedSnapshot {
+		if received && !dest.completedSnapshot[snapshotId] {
+			snapshotIds = append(snapshotIds, snapshotId)
+		}
+	}
+	sort.Ints(snapshotIds)
+	for _, snapshotId := range snapshotIds {
+		switch server.sim.algorithm {
+		case LaiYang, Mattern:
+			dest.checkLaiYangComplete(snapshotId)
+		default:
+			if dest.DistinctMarkerSources(snapshotId) == len(dest.inboundLinks) {
+				dest.completeSnapshot(snapshotId)
+			}
+		}
+	}
+}
+
+// Set the order in which this link delivers its messages
+func (link *Link) SetOrdering(policy OrderingPolicy) {
+	link.ordering = policy
+}
+
+// Remove and return the next message to deliver at the given time step
+// according to the ordering policy of the link, if any
+func (link *Link) nextDeliverable(time int, random *rand.Rand) (SendMessageEvent, bool) {
+	i, e, ok := link.peekDeliverable(time, random)
+	if ok {
+		link.events.Remove(i)
+	}
+	return e, ok
+}
+
+// Return the next message to deliver at the given time step according to the
+// ordering policy of the link, along with its position on the link, if any
+func (link *Link) peekDeliverable(time int, random *rand.Rand) (int, SendMessageEvent, bool) {
+	if link.ordering != RandomReorder && link.ordering != BoundedDelayReorder {
+		// FIFO: only the message at the head of the queue can be delivered
+		if link.events.Empty() || link.events.Peek().receiveTime > time {
+			return 0, SendMessageEvent{}, false
+		}
+		return 0, link.events.Peek(), true
+	}
+	events := link.events.Elements()
+	candidates := make([]int, 0) // indexes of the messages due for delivery
+	for i, e := range events {
+		if e.receiveTime <= time {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, SendMessageEvent{}, false
+	}
+	if link.ordering == RandomReorder {
+		i := candidates[random.Intn(len(candidates))]
+		return i, events[i], true
+	}
+	earliest := candidates[0]
+	for _, i := range candidates {
+		if events[i].receiveTime < events[earliest].receiveTime {
+			earliest = i
+		}
+	}
+	return earliest, events[earliest], true
+}
+
+// Return the number of links coming into this server
+func (server *Server) InDegree() int {
+	return len(server.inboundLinks)
+}
+
+// Return the number of links going out of this server
+func (server *Server) OutDegree() int {
+	return len(server.outboundLinks)
+}
+
+// Mint the given number of tokens on this server at every time step.
+// Note: this deliberately breaks global token conservation; the simulator keeps
+// track of the generated tokens separately (see `Simulator.GeneratedTokens`).
+func (server *Server) SetGenerationRate(tokensPerTick int) {
+	server.generationRate = tokensPerTick
 }
 
 // Send a message on all of the server's outbound links
 func (server *Server) SendToNeighbors(message interface{}) {
-	for _, serverId := range getSortedKeys(server.outboundLinks) {
+	messages := []interface{}{message}
+	if marker, ok := message.(MarkerMessage); ok && server.faults != nil {
+		messages = server.faultyMarkers(marker)
+	}
+	for _, serverId := range server.neighborIds() {
 		link := server.outboundLinks[serverId]
-		server.sim.logger.RecordEvent(
-			server,
-			SentMessageEvent{server.Id, link.dest, message})
-		link.events.Push(SendMessageEvent{
-			server.Id,
-			link.dest,
-			message,
-			server.sim.GetReceiveTime()})
+		for _, message := range messages {
+			if marker, ok := message.(MarkerMessage); ok {
+				if server.holdMarker(link, marker) || server.coalesceMarker(link, marker) {
+					continue
+				}
+				link.lastMarkerTime = server.sim.time
+			}
+			server.sim.logger.RecordEvent(
+				server,
+				SentMessageEvent{server.Id, link.dest, message})
+			server.sim.enqueue(link, SendMessageEvent{
+				server.Id,
+				link.dest,
+				message,
+				server.sim.receiveTimeOn(link),
+				server.sim.time,
+				server.lamport})
+		}
+	}
+}
+
+// Merge the marker into the last message pushed on the link if coalescing is
+// enabled and that message holds markers pushed in the same time step.
+// Since nothing was sent on the link in between, this preserves FIFO order.
+// The logged send of the last message is updated to the merged one, so a batch
+// is logged and counted as a single message. Return true if the marker was merged.
+func (server *Server) coalesceMarker(link *Link, marker MarkerMessage) bool {
+	if !server.sim.CoalesceMarkers || link.events.Empty() || !link.backlog.Empty() ||
+		link.lastMarkerTime != server.sim.time {
+		return false
+	}
+	last := link.events.Last()
+	switch msg := last.message.(type) {
+	case MarkerMessage:
+		last.message = MultiMarkerMessage{[]int{msg.snapshotId, marker.snapshotId}}
+	case MultiMarkerMessage:
+		ids := append(append([]int{}, msg.snapshotIds...), marker.snapshotId)
+		last.message = MultiMarkerMessage{ids}
+	default:
+		return false
 	}
+	link.events.ReplaceLast(last)
+	server.sim.logger.replaceLastSent(server.Id, link.dest, last.message)
+	server.sim.notifyMarkers(MarkerSent, marker, server.Id, link.dest)
+	return true
 }
 
 // Send a number of tokens to a neighbor attached to this server
-func (server *Server) SendTokens(numTokens int, dest string) {
-	if server.Tokens < numTokens {
-		log.Fatalf("Server %v attempted to send %v tokens when it only has %v\n",
-			server.Id, numTokens, server.Tokens)
+func (server *Server) SendTokens(numTokens int, dest string) error {
+	return server.sendTokenMessage(TokenMessage{numTokens: numTokens}, dest)
+}
+
+// Pass every token received from now on to the given neighbor, instead of
+// keeping it on this server. An empty ID turns forwarding off.
+func (server *Server) SetForwarding(dest string) error {
+	if _, ok := server.outboundLinks[dest]; dest != "" && !ok {
+		return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, server.Id)
 	}
-	message := TokenMessage{numTokens}
-	server.sim.logger.RecordEvent(server, SentMessageEvent{server.Id, dest, message})
-	// Update local state before sending the tokens
-	server.Tokens -= numTokens
-	link, ok := server.outboundLinks[dest]
-	if !ok {
-		log.Fatalf("Unknown dest ID %v from server %v\n", dest, server.Id)
+	server.forwardTo = dest
+	return nil
+}
+
+// Pass tokens received by this server on to its forwarding neighbor, if any
+func (server *Server) forwardTokens(message TokenMessage) error {
+	if server.forwardTo == "" {
+		return nil
 	}
-	link.events.Push(SendMessageEvent{
+	return server.sendTokenMessage(
+		TokenMessage{message.numTokens, message.hopCount + 1, message.tokenType}, server.forwardTo)
+}
+
+// Return an error if this server cannot send the given number of tokens of the
+// given type to dest
+func (server *Server) checkSend(tokenType string, numTokens int, dest string) error {
+	if held := server.TokensOf(tokenType); held < numTokens {
+		return newError(ErrInsufficientTokens, "Server %v attempted to send %v tokens when it only has %v",
+			server.Id, numTokens, held)
+	}
+	if _, ok := server.outboundLinks[dest]; !ok {
+		return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, server.Id)
+	}
+	return nil
+}
+
+func (server *Server) sendTokenMessage(message TokenMessage, dest string) error {
+	numTokens := message.numTokens
+	if err := server.checkSend(message.tokenType, numTokens, dest); err != nil {
+		return err
+	}
+	link := server.outboundLinks[dest]
+	var packet interface{} = message
+	switch server.sim.algorithm {
+	case LaiYang:
+		packet = server.colorMessage(message)
+	case Mattern:
+		packet = server.timestampMessage(message)
+	default:
+		packet = server.piggyback(link, message)
+	}
+	server.sim.logger.RecordEvent(server, SentMessageEvent{server.Id, dest, packet})
+	// Update local state before sending the tokens
+	server.addTokens(message.tokenType, -numTokens)
+	server.sentCount[dest]++
+	server.sim.enqueue(link, SendMessageEvent{
 		server.Id,
 		dest,
-		message,
-		server.sim.GetReceiveTime()})
+		packet,
+		server.sim.receiveTimeOn(link),
+		server.sim.time,
+		server.lamport})
+	return nil
 }
 
 // Callback for when a message is received on this server.
 // When the snapshot algorithm completes on this server, this function
 // should notify the simulator by calling `sim.NotifySnapshotComplete`.
-func (server *Server) HandlePacket(src string, message interface{}) {
+// Returns an error if the server failed to act on the message, e.g. to forward
+// the tokens it carries. This must be called from the goroutine driving the
+// simulation; other goroutines hand packets over with `Deliver`.
+func (server *Server) HandlePacket(src string, message interface{}) error {
 	// TODO: IMPLEMENT ME
+	if handler, ok := server.sim.messageHandler(message); ok {
+		server.handleUserMessage(src, message, handler)
+		return nil
+	}
+	switch v := message.(type) {
+	case ElectionMessage:
+		server.handleElection(v)
+		return nil
+	case AggregateMessage:
+		server.handleAggregate(v)
+		return nil
+	case RecordedStateMessage:
+		server.handleRecordedState(v)
+		return nil
+	}
+	switch server.sim.algorithm {
+	case LaiYang:
+		return server.handleLaiYangPacket(src, message)
+	case Mattern:
+		return server.handleMatternPacket(src, message)
+	}
 	switch v := message.(type) {
 	case MarkerMessage:
+		if server.isPruned(v.snapshotId) {
+			return nil
+		}
 		if !server.receivedSnapshot[v.snapshotId] {
 			server.StartSnapshot(v.snapshotId)
 		}
 		if !server.inReceivedMarker[v.snapshotId][src] {
-			server.inReceivedMarker[v.snapshotId][src] = true
+			server.closeChannel(v.snapshotId, src)
+		}
+		if !server.completedSnapshot[v.snapshotId] &&
+			server.DistinctMarkerSources(v.snapshotId) == len(server.inboundLinks) {
+			server.completeSnapshot(v.snapshotId)
 		}
-		if len(server.inReceivedMarker[v.snapshotId]) == len(server.inboundLinks) {
-			server.sim.chanMap[v.snapshotId] <- server.snapshot[v.snapshotId]
-			server.sim.NotifySnapshotComplete(server.Id, v.snapshotId)
+	case MultiMarkerMessage:
+		for _, snapshotId := range v.snapshotIds {
+			server.HandlePacket(src, MarkerMessage{snapshotId})
 		}
+	case PiggybackedMessage:
+		for _, snapshotId := range v.snapshotIds {
+			server.HandlePacket(src, MarkerMessage{snapshotId})
+		}
+		return server.HandlePacket(src, v.message)
+	case WaitForMessage:
+		server.handleUserMessage(src, v, handleWaitFor)
 	case TokenMessage:
+		server.receivedCount[src]++
 		for snapshotId, received := range server.receivedSnapshot {
-			if received && !server.inReceivedMarker[snapshotId][src] {
-				server.snapshot[snapshotId].messages =
-					append(server.snapshot[snapshotId].messages, &SnapshotMessage{
-						src:     src,
-						dest:    server.Id,
-						message: message,
-					})
+			if received && !server.completedSnapshot[snapshotId] &&
+				!server.inReceivedMarker[snapshotId][src] {
+				server.recordMessage(snapshotId, &SnapshotMessage{
+					src:      src,
+					dest:     server.Id,
+					message:  message,
+					hopCount: v.hopCount,
+				})
 			}
 		}
-		server.Tokens += v.numTokens
+		server.addTokens(v.tokenType, v.numTokens)
+		return server.forwardTokens(v)
+	}
+	return nil
+}
+
+// Stop recording the channel from the given server for a snapshot, once the
+// first marker arrives on it
+func (server *Server) closeChannel(snapshotId int, src string) {
+	server.inReceivedMarker[snapshotId][src] = true
+	server.markerArrival[snapshotId][src] = server.sim.time
+	server.sim.notifyObservers(ChannelRecordingClosed, snapshotId, server.Id, src)
+}
+
+// Return the number of inbound links that have delivered a marker for the given
+// snapshot. Duplicate markers on the same link are only counted once.
+func (server *Server) DistinctMarkerSources(snapshotId int) int {
+	count := 0
+	for src, received := range server.inReceivedMarker[snapshotId] {
+		if _, ok := server.inboundLinks[src]; ok && received {
+			count++
+		}
+	}
+	return count
+}
+
+// Return the marker that closed the channel from the given server for a snapshot,
+// along with the time step at which it arrived. The last return value is false
+// if the channel has not been closed by a marker yet.
+func (server *Server) ClosingMarker(snapshotId int, src string) (MarkerMessage, int, bool) {
+	time, ok := server.markerArrival[snapshotId][src]
+	if !ok {
+		return MarkerMessage{}, 0, false
+	}
+	return MarkerMessage{snapshotId}, time, true
+}
+
+// A read-only copy of the bookkeeping of a server for one snapshot
+type SnapshotDebugInfo struct {
+	// If true, the server has started the snapshot, on its own or on receiving
+	// a marker
+	Received bool
+	// If true, the local snapshot has completed
+	Completed bool
+	// Sorted IDs of the servers whose markers the server has received
+	MarkerSources []string
+	// Number of tokens recorded as the state of the server itself
+	RecordedTokens int
+	// Number of messages recorded on the inbound channels of the server
+	RecordedMessages int
+}
+
+// Return a copy of the bookkeeping of this server for the given snapshot
+func (server *Server) SnapshotDebugInfo(snapshotId int) SnapshotDebugInfo {
+	info := SnapshotDebugInfo{
+		Received:      server.receivedSnapshot[snapshotId],
+		Completed:     server.completedSnapshot[snapshotId],
+		MarkerSources: make([]string, 0),
+	}
+	for _, src := range getSortedKeys(server.inReceivedMarker[snapshotId]) {
+		if server.inReceivedMarker[snapshotId][src] {
+			info.MarkerSources = append(info.MarkerSources, src)
+		}
 	}
+	if snap, ok := server.snapshot[snapshotId]; ok {
+		info.RecordedTokens = snap.tokens[server.Id]
+		info.RecordedMessages = len(snap.messages)
+		for _, a := range snap.aggregated {
+			info.RecordedMessages += a.messages
+		}
+	}
+	return info
 }
 
 // Start the chandy-lamport snapshot algorithm on this server.
-// This should be called only once per server.
-func (server *Server) StartSnapshot(snapshotId int) {
+// This should be called only once per server: starting the same snapshot again
+// fails with `ErrSnapshotAlreadyStarted`.
+func (server *Server) StartSnapshot(snapshotId int) error {
+	if server.receivedSnapshot[snapshotId] {
+		return newError(ErrSnapshotAlreadyStarted,
+			"Snapshot %v has already been started on %v", snapshotId, server.Id)
+	}
 	// TODO: IMPLEMENT ME
+	server.recordLocalState(snapshotId)
+	switch server.sim.algorithm {
+	case LaiYang:
+		server.sendLaiYangControls(snapshotId)
+	case Mattern:
+		server.recordMatternState(snapshotId)
+	default:
+		server.SendToNeighbors(MarkerMessage{snapshotId: snapshotId})
+	}
+	// Without inbound links there are no markers to wait for
+	if len(server.inboundLinks) == 0 {
+		server.completeSnapshot(snapshotId)
+	}
+	return nil
+}
+
+// Record the local state of this server for the given snapshot and prepare
+// the bookkeeping for recording its inbound channels
+func (server *Server) recordLocalState(snapshotId int) {
 	server.inReceivedMarker[snapshotId] = make(map[string]bool)
+	server.markerArrival[snapshotId] = make(map[string]int)
+	server.channelRecorded[snapshotId] = make(map[string]int)
 	server.receivedSnapshot[snapshotId] = true
 	server.snapshot[snapshotId] = &SnapshotState{
 		id:       snapshotId,
-		tokens:   map[string]int{server.Id: server.Tokens},
+		tokens:   map[string]int{server.Id: server.reportedTokens()},
 		messages: make([]*SnapshotMessage, 0),
+		typed:    map[string]map[string]int{server.Id: server.typedTokenCounts()},
+		appState: map[string][]byte{server.Id: server.applicationState().Snapshot()},
+		waits:    map[string]waitState{server.Id: server.currentWaitState()},
+		cut:      map[string]int{server.Id: server.cutLamport()},
+	}
+	server.sim.notifyObservers(SnapshotStarted, snapshotId, server.Id, "")
+}
+
+// Record a message received on an inbound channel that has not been closed yet.
+// Once the channel holds as many messages as the limit set by
+// `Simulator.SetMaxRecordedPerChannel`, further messages are only counted.
+func (server *Server) recordMessage(snapshotId int, msg *SnapshotMessage) {
+	snap := server.snapshot[snapshotId]
+	if token, ok := msg.message.(TokenMessage); ok && server.sim.channelRecording == Aggregate {
+		snap.aggregate(ChannelId{msg.src, msg.dest}, token, server.handlingDuplicate)
+		return
+	}
+	limit := server.sim.maxRecordedPerChannel
+	if limit > 0 && server.channelRecorded[snapshotId][msg.src] >= limit {
+		if snap.overflow == nil {
+			snap.overflow = make(map[ChannelId]int)
+		}
+		snap.overflow[ChannelId{msg.src, msg.dest}]++
+		return
+	}
+	server.channelRecorded[snapshotId][msg.src]++
+	msg.lamport = server.handlingLamport
+	snap.messages = append(snap.messages, msg)
+	if server.handlingDuplicate {
+		server.sim.recordedDuplicates[msg] = true
+	}
+}
+
+// Hand the local snapshot state over to the simulator and notify it that
+// the snapshot process has completed on this server
+func (server *Server) completeSnapshot(snapshotId int) {
+	snap := server.snapshot[snapshotId]
+	for _, src := range getSortedKeys(server.inboundLinks) {
+		if server.inReceivedMarker[snapshotId][src] {
+			snap.channels = append(snap.channels, ChannelId{src, server.Id})
+		}
+	}
+	server.completedSnapshot[snapshotId] = true
+	server.sim.reportSnapshot(server.Id, snapshotId, server.snapshot[snapshotId])
+	server.disseminateState(snapshotId)
+	server.sim.NotifySnapshotComplete(server.Id, snapshotId)
+}
+
+// Require the local snapshot to complete by the given time step. If the server
+// has not received markers on all of its inbound links by then, it completes
+// the snapshot anyway and records the remaining channels as closed with an
+// unknown state (see `SnapshotState.unknownChannels`). The snapshot must have
+// been started through the simulator, otherwise nothing would collect it.
+func (server *Server) SetSnapshotDeadline(snapshotId int, time int) error {
+	if server.sim == nil || server.sim.collectors[snapshotId] == nil {
+		return newError(ErrUnknownSnapshot, "Snapshot %v was not started", snapshotId)
+	}
+	server.snapshotDeadline[snapshotId] = time
+	return nil
+}
+
+// Force-complete every local snapshot whose deadline has passed.
+// A server that has not started the snapshot yet records its state first.
+func (server *Server) checkSnapshotDeadlines() {
+	expired := make([]int, 0)
+	for snapshotId, deadline := range server.snapshotDeadline {
+		if server.sim.time >= deadline {
+			expired = append(expired, snapshotId)
+		}
+	}
+	sort.Ints(expired)
+	for _, snapshotId := range expired {
+		delete(server.snapshotDeadline, snapshotId)
+		if server.completedSnapshot[snapshotId] {
+			continue
+		}
+		if !server.receivedSnapshot[snapshotId] {
+			server.StartSnapshot(snapshotId)
+		}
+		// A server without inbound links completes the snapshot as it starts it
+		if server.completedSnapshot[snapshotId] {
+			continue
+		}
+		snap := server.snapshot[snapshotId]
+		for _, src := range getSortedKeys(server.inboundLinks) {
+			if !server.inReceivedMarker[snapshotId][src] {
+				snap.unknownChannels = append(snap.unknownChannels, ChannelId{src, server.Id})
+				server.sim.notifyObservers(ChannelRecordingClosed, snapshotId, server.Id, src)
+			}
+		}
+		server.completeSnapshot(snapshotId)
 	}
-	server.SendToNeighbors(MarkerMessage{snapshotId: snapshotId})
 }
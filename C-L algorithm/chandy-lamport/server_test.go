@@ -0,0 +1,408 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestServerDegree(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("5nodes-star.top", sim)
+	hub := sim.servers["N1"]
+	if hub.OutDegree() != 4 || hub.InDegree() != 4 {
+		t.Fatalf("Expected hub to have degree 4, got in = %v, out = %v\n",
+			hub.InDegree(), hub.OutDegree())
+	}
+	spoke := sim.servers["N3"]
+	if spoke.OutDegree() != 1 || spoke.InDegree() != 1 {
+		t.Fatalf("Expected spoke to have degree 1, got in = %v, out = %v\n",
+			spoke.InDegree(), spoke.OutDegree())
+	}
+}
+
+func TestSnapshotDeadline(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("2nodes.top", sim)
+	snapshotId := sim.nextSnapshotId
+	sim.StartSnapshot("N1")
+	// The marker from N2 cannot arrive before time step 2
+	sim.servers["N1"].SetSnapshotDeadline(snapshotId, 1)
+	sim.Tick()
+	if !sim.servers["N1"].completedSnapshot[snapshotId] {
+		t.Fatal("Expected N1 to force-complete its snapshot at the deadline")
+	}
+	for !sim.servers["N2"].completedSnapshot[snapshotId] {
+		sim.Tick()
+	}
+	for i := 0; i < maxDelay+1; i++ {
+		sim.Tick()
+	}
+	snap := sim.CollectSnapshot(snapshotId)
+	expected := []ChannelId{{"N2", "N1"}}
+	if !reflect.DeepEqual(snap.unknownChannels, expected) {
+		t.Fatalf("Expected unknown channels %v, got %v\n", expected, snap.unknownChannels)
+	}
+	if sim.finishedMap[snapshotId] != 2 {
+		t.Fatalf("Expected 2 completion notifications, got %v\n", sim.finishedMap[snapshotId])
+	}
+}
+
+func TestSnapshotDeadlineWithoutInboundLinks(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	topology := NewTopology()
+	topology.AddServer("A", 3)
+	topology.AddServer("B", 5)
+	topology.AddServer("C", 10)
+	topology.AddLink("A", "B")
+	topology.AddLink("B", "A")
+	topology.AddLink("C", "A")
+	sim, err := topology.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.StartSnapshot("A")
+	// No marker ever reaches C, which completes the snapshot as it starts it
+	sim.servers["C"].SetSnapshotDeadline(0, 1)
+	sim.Tick()
+	if sim.finishedMap[0] != 1 {
+		t.Fatalf("Expected C to complete the snapshot once, got %v completions\n", sim.finishedMap[0])
+	}
+	if _, ok := sim.TryCollectSnapshot(0); ok {
+		t.Fatalf("Expected the snapshot to wait for A and B\n")
+	}
+	sim.Drain()
+	snap, ok := sim.TryCollectSnapshot(0)
+	if !ok {
+		t.Fatalf("Expected the snapshot to complete\n")
+	}
+	expected := map[string]int{"A": 3, "B": 5, "C": 10}
+	if !reflect.DeepEqual(snap.tokens, expected) {
+		t.Fatalf("Expected tokens %v, got %v\n", expected, snap.tokens)
+	}
+}
+
+func TestCollectorIgnoresSecondReport(t *testing.T) {
+	sim := NewSimulator()
+	sim.newCollector(0, []string{"N1", "N2"})
+	c := sim.collectors[0]
+	state := &SnapshotState{id: 0, tokens: map[string]int{"N1": 1}}
+	if !c.report(state) {
+		t.Fatalf("Expected the first state of N1 to be kept\n")
+	}
+	if c.report(state) || c.complete() {
+		t.Fatalf("Expected the second state of N1 to be ignored\n")
+	}
+}
+
+func TestSnapshotDeadlineUnknownSnapshot(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("2nodes.top", sim)
+	err := sim.servers["N1"].SetSnapshotDeadline(7, 1)
+	if !errors.Is(err, ErrUnknownSnapshot) {
+		t.Fatalf("Expected ErrUnknownSnapshot, got %v\n", err)
+	}
+	// Nothing is forced to complete, and ticking does not panic
+	sim.Tick()
+	if sim.servers["N1"].receivedSnapshot[7] {
+		t.Fatalf("Expected N1 not to record a state for snapshot 7\n")
+	}
+}
+
+func TestDuplicateMarker(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	snapshotId := sim.nextSnapshotId
+	sim.StartSnapshot("N1")
+	// Deliver the marker from N1 to N2 twice
+	sim.servers["N1"].outboundLinks["N2"].events.Push(
+		SendMessageEvent{"N1", "N2", MarkerMessage{snapshotId}, 1, 0, 0})
+	server := sim.servers["N2"]
+	for sim.finishedMap[snapshotId] < len(sim.servers) {
+		sim.Tick()
+		completed := server.completedSnapshot[snapshotId]
+		distinct := server.DistinctMarkerSources(snapshotId)
+		if completed != (distinct == server.InDegree()) || distinct > server.InDegree() {
+			t.Fatalf("N2 completed = %v after markers from %v of %v inbound links\n",
+				completed, distinct, server.InDegree())
+		}
+	}
+	if sim.finishedMap[snapshotId] != len(sim.servers) {
+		t.Fatalf("Expected %v completion notifications, got %v\n",
+			len(sim.servers), sim.finishedMap[snapshotId])
+	}
+}
+
+func TestClosingMarker(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("2nodes.top", sim)
+	injectEvents("2nodes-message.events", sim)
+	// Find the delivery of the marker from N2 to N1 in the log
+	expectedTime := -1
+	for epoch, events := range sim.logger.events {
+		for _, logEvent := range events {
+			evt, ok := logEvent.event.(ReceivedMessageEvent)
+			if ok && evt.src == "N2" && evt.dest == "N1" && evt.message == (MarkerMessage{0}) {
+				expectedTime = epoch
+			}
+		}
+	}
+	marker, time, closed := sim.servers["N1"].ClosingMarker(0, "N2")
+	if !closed || marker != (MarkerMessage{0}) || time != expectedTime {
+		t.Fatalf("Expected marker(0) at time %v, got %v at time %v (closed = %v)\n",
+			expectedTime, marker, time, closed)
+	}
+	if _, _, closed := sim.servers["N1"].ClosingMarker(1, "N2"); closed {
+		t.Fatal("Expected the channel to be open for an unknown snapshot")
+	}
+}
+
+func TestGenerationRate(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	server := sim.servers["N3"]
+	server.SetGenerationRate(2)
+	for i := 1; i <= 5; i++ {
+		sim.Tick()
+		if server.Tokens != 2*i {
+			t.Fatalf("Expected N3 to have %v tokens after %v ticks, got %v\n",
+				2*i, i, server.Tokens)
+		}
+	}
+	if sim.GeneratedTokens() != 10 {
+		t.Fatalf("Expected 10 generated tokens, got %v\n", sim.GeneratedTokens())
+	}
+	if sim.TotalTokens() != sim.InitialTokens()+sim.GeneratedTokens() {
+		t.Fatalf("Expected %v tokens in total, got %v\n",
+			sim.InitialTokens()+sim.GeneratedTokens(), sim.TotalTokens())
+	}
+}
+
+func TestForwardedHopCount(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("5nodes-line.top", sim)
+	sim.servers["N2"].SetForwarding("N3")
+	sim.servers["N3"].SetForwarding("N4")
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 2})
+	// Wait until the tokens have been forwarded twice, then snapshot at the
+	// final destination so that the channel from N3 records them
+	link := sim.servers["N3"].outboundLinks["N4"]
+	for link.events.Empty() {
+		sim.Tick()
+	}
+	snapshotId := sim.nextSnapshotId
+	sim.StartSnapshot("N4")
+	for sim.finishedMap[snapshotId] < len(sim.servers) {
+		sim.Tick()
+	}
+	snap := sim.CollectSnapshot(snapshotId)
+	expected := []*SnapshotMessage{{"N3", "N4", TokenMessage{numTokens: 2, hopCount: 2}, 2, 5}}
+	if !reflect.DeepEqual(expected, snap.messages) {
+		t.Fatalf("Expected recorded messages\n%v\ngot\n%v\n",
+			messagesString(expected, "\t"), messagesString(snap.messages, "\t"))
+	}
+}
+
+func TestCoalesceMarkers(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.CoalesceMarkers = true
+	sim.StartSnapshot("N1")
+	sim.StartSnapshot("N1")
+	for _, dest := range []string{"N2", "N3"} {
+		events := sim.servers["N1"].outboundLinks[dest].events.Elements()
+		expected := MultiMarkerMessage{[]int{0, 1}}
+		if len(events) != 1 || !reflect.DeepEqual(events[0].message, expected) {
+			t.Fatalf("Expected a single %v on the link to %v, got %v\n", expected, dest, events)
+		}
+	}
+	// Each batch is logged and counted as a single message
+	if sent := sim.Metrics().MarkersSent; sent != 2 {
+		t.Fatalf("Expected 2 marker messages to be sent, got %v\n", sent)
+	}
+	for _, line := range sim.EventLog() {
+		if strings.Contains(line, "marker(") {
+			t.Fatalf("Expected only batches of markers to be logged, got %q\n", line)
+		}
+	}
+	for sim.finishedMap[0] < len(sim.servers) || sim.finishedMap[1] < len(sim.servers) {
+		sim.Tick()
+	}
+	for _, snapshotId := range []int{0, 1} {
+		sim.CollectSnapshot(snapshotId)
+		if err := sim.ValidateSnapshot(snapshotId, sim.InitialTokens()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotDebugInfo(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	snapshotId := sim.nextSnapshotId
+	sim.StartSnapshot("N1")
+	// Hold the marker from N3 so that N2 cannot complete, behind a message
+	// that N2 will record on that channel
+	sim.FreezeLink("N3", "N2")
+	sim.InjectEvent(PassTokenEvent{"N3", "N2", 0})
+	server := sim.servers["N2"]
+	for !server.receivedSnapshot[snapshotId] {
+		sim.Tick()
+	}
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 4})
+	sim.Drain()
+	info := server.SnapshotDebugInfo(snapshotId)
+	expected := SnapshotDebugInfo{Received: true, MarkerSources: []string{"N1"}, RecordedTokens: 3}
+	if !reflect.DeepEqual(expected, info) {
+		t.Fatalf("Expected debug info %+v, got %+v\n", expected, info)
+	}
+	sim.UnfreezeLink("N3", "N2")
+	sim.Drain()
+	info = server.SnapshotDebugInfo(snapshotId)
+	expected = SnapshotDebugInfo{Received: true, Completed: true, MarkerSources: []string{"N1", "N3"},
+		RecordedTokens: 3, RecordedMessages: 1}
+	if !reflect.DeepEqual(expected, info) {
+		t.Fatalf("Expected debug info %+v, got %+v\n", expected, info)
+	}
+}
+
+func TestMaxRecordedPerChannel(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.SetMaxRecordedPerChannel(2)
+	snapshotId := sim.nextSnapshotId
+	sim.StartSnapshot("N2")
+	// All of these are sent before N1 records its state
+	for i := 0; i < 5; i++ {
+		sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	}
+	for sim.finishedMap[snapshotId] < len(sim.servers) {
+		sim.Tick()
+	}
+	snap := sim.CollectSnapshot(snapshotId)
+	if len(snap.messages) != 2 {
+		t.Fatalf("Expected 2 recorded messages, got\n%v\n", messagesString(snap.messages, "\t"))
+	}
+	expected := map[ChannelId]int{{"N1", "N2"}: 3}
+	if !reflect.DeepEqual(expected, snap.overflow) {
+		t.Fatalf("Expected overflow %v, got %v\n", expected, snap.overflow)
+	}
+}
+
+// Push token messages with the given receive times on a link and return the
+// token counts in the order the link delivers them, once all of them are due
+func deliveryOrder(t *testing.T, policy OrderingPolicy, receiveTimes []int) []int {
+	sim := NewSimulator()
+	readTopology("2nodes.top", sim)
+	link := mustGetLink(sim, "N1", "N2")
+	link.SetOrdering(policy)
+	due := 0
+	for i, receiveTime := range receiveTimes {
+		link.events.Push(SendMessageEvent{"N1", "N2", TokenMessage{numTokens: i + 1}, receiveTime, 0, 0})
+		if receiveTime > due {
+			due = receiveTime
+		}
+	}
+	order := make([]int, 0)
+	for range receiveTimes {
+		e, ok := link.nextDeliverable(due, sim.random)
+		if !ok {
+			t.Fatalf("Expected a message to be due at time %v, delivered %v so far\n", due, order)
+		}
+		order = append(order, e.message.(TokenMessage).numTokens)
+	}
+	return order
+}
+
+func TestLinkOrdering(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	receiveTimes := []int{3, 1, 2, 1, 5}
+	if order := deliveryOrder(t, FIFO, receiveTimes); !reflect.DeepEqual(order, []int{1, 2, 3, 4, 5}) {
+		t.Fatalf("Expected FIFO delivery order, got %v\n", order)
+	}
+	if order := deliveryOrder(t, BoundedDelayReorder, receiveTimes); !reflect.DeepEqual(order, []int{2, 4, 3, 1, 5}) {
+		t.Fatalf("Expected delivery in receive time order, got %v\n", order)
+	}
+	order := deliveryOrder(t, RandomReorder, receiveTimes)
+	sorted := append([]int{}, order...)
+	sort.Ints(sorted)
+	if !reflect.DeepEqual(sorted, []int{1, 2, 3, 4, 5}) || reflect.DeepEqual(order, sorted) {
+		t.Fatalf("Expected every message to be delivered out of order, got %v\n", order)
+	}
+}
+
+func TestLaiYangWithoutFIFO(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	sim.SetSnapshotAlgorithm(LaiYang)
+	readTopology("3nodes.top", sim)
+	for _, src := range getSortedKeys(sim.servers) {
+		for _, dest := range getSortedKeys(sim.servers[src].outboundLinks) {
+			mustGetLink(sim, src, dest).SetOrdering(RandomReorder)
+		}
+	}
+	snaps := injectEvents("3nodes-bidirectional-messages.events", sim)
+	checkTokens(sim, snaps)
+	if err := sim.ValidateSnapshot(snaps[0].id, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendTokensErrors(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	if err := sim.servers["N2"].SendTokens(4, "N1"); !errors.Is(err, ErrInsufficientTokens) {
+		t.Fatalf("Expected sending more tokens than held to fail, got %v\n", err)
+	}
+	if err := sim.servers["N1"].SendTokens(1, "N4"); !errors.Is(err, ErrUnknownDest) {
+		t.Fatalf("Expected sending to an unknown server to fail, got %v\n", err)
+	}
+	if err := sim.servers["N1"].SetForwarding("N4"); !errors.Is(err, ErrUnknownDest) {
+		t.Fatalf("Expected forwarding to an unknown server to fail, got %v\n", err)
+	}
+	if sim.servers["N1"].Tokens != 10 || sim.servers["N2"].Tokens != 3 {
+		t.Fatalf("Expected failed sends to leave the tokens unchanged\n")
+	}
+}
+
+func TestHandlePacketErrorIsLogged(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	var out bytes.Buffer
+	sim.SetLogger(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	readTopology("5nodes-line.top", sim)
+	if err := sim.servers["N2"].SetForwarding("N3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.RemoveLink("N2", "N3"); err != nil {
+		t.Fatal(err)
+	}
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 2})
+	sim.Drain()
+
+	logged := out.String()
+	for _, expected := range []string{
+		"level=DEBUG msg=\"Delivered message\"",
+		"level=ERROR msg=\"Failed to handle message\"",
+		"err=\"Unknown dest ID N3 from server N2\"",
+	} {
+		if !strings.Contains(logged, expected) {
+			t.Fatalf("Expected %q in the log, got:\n%v\n", expected, logged)
+		}
+	}
+	if sim.servers["N2"].Tokens != 2 {
+		t.Fatalf("Expected N2 to keep the tokens it failed to forward, got %v\n",
+			sim.servers["N2"].Tokens)
+	}
+}
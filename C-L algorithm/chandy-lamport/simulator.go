@@ -1,8 +1,14 @@
 package chandy_lamport
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 )
 
 // Max random delay added to packet delivery
@@ -24,99 +30,911 @@ type Simulator struct {
 	servers        map[string]*Server // key = server ID
 	logger         *Logger
 	// TODO: ADD MORE FIELDS HERE
-	chanMap     map[int]chan *SnapshotState // snapshotID -> state from servers
-	finishedMap map[int]int                 // snapshotID -> number of servers that have finished
-	stopMap     map[int]chan bool           // snapshotID -> signal
+	collectors  map[int]*snapshotCollector // snapshotID -> states reported by the servers
+	finishedMap map[int]int                // snapshotID -> number of servers that have finished
+	// Total number of tokens the system started with
+	initialTokens int
+	// server ID -> number of tokens the server started with, including
+	// the tokens it had in flight when the simulation started
+	startingTokens map[string]int
+	initiators     map[int]string                // snapshotID -> ID of the initiating server
+	collected      *SyncMap[int, *SnapshotState] // snapshotID -> merged state, once collected
+	// Total number of tokens minted by servers with a generation rate
+	generatedTokens int
+	// If true, markers of different snapshots sent on the same link in the
+	// same time step are batched into a single `MultiMarkerMessage`
+	CoalesceMarkers bool
+	// snapshotID -> actual global state when the snapshot completed
+	groundTruth map[int]*SnapshotState
+	// Max number of messages stored per channel in a snapshot, or 0 if unbounded
+	maxRecordedPerChannel int
+	// Algorithm used by the servers to record snapshots
+	algorithm SnapshotAlgorithm
+	// Total number of tokens carried by messages dropped on lossy or removed links
+	lostTokens int
+	// What happens to the messages on a link when it is removed
+	linkRemoval LinkRemovalPolicy
+	// Source of every random decision made by the simulation
+	random *rand.Rand
+	// If set, the inputs of the simulation are written to this trace
+	trace io.Writer
+	// Latency of the messages sent on links without a model of their own
+	latency LatencyModel
+	// Bookkeeping for `Metrics`
+	metrics *simMetrics
+	// If set, the metrics are served over HTTP, see `EnableMetricsServer`
+	exporter *MetricsServer
+	// Structured logger for failures and, at debug level, deliveries. Unlike
+	// `logger`, which records the events of the protocol for tests, this is
+	// meant for the operator of the simulation.
+	slogger *slog.Logger
+	// time step -> events to inject at the end of that tick, in order
+	schedule map[int][]interface{}
+	// If set, every tick is streamed to the browser, see `ServeVisualizer`
+	visualizer *Visualizer
+	// If true, servers release their state of a snapshot once it is collected
+	pruneOnCollect bool
+	// If true, token conservation is verified after every tick
+	checkInvariants bool
+	// The handlers of application messages, by message type
+	messageHandlers map[reflect.Type]MessageHandler
+	// Conditions that start a snapshot once a server satisfies them, see `SnapshotWhen`
+	triggers []func(*Server) bool
+	// If set, snapshots are started on a schedule, see `EnablePeriodicSnapshots`
+	periodic *periodicSnapshots
+	// Sorted server IDs, computed when first needed after a server is added
+	serverIds []string
+	// Order in which the messages due at a time step are delivered
+	deliveryOrder DeliveryOrder
+	// Called on every step of the snapshot protocol, see `OnSnapshotEvent`
+	observers []func(ev SnapshotProgressEvent)
+	// How markers are sent, and how long a held marker waits for a message to
+	// piggyback on, see `SetMarkerMode`
+	markerMode       MarkerMode
+	piggybackTimeout int
+	// Token type -> number of tokens of that type given to the servers, for the
+	// types other than the default one, see `AddTypedTokens`
+	initialTypedTokens map[string]int
+	// Total number of tokens carried by the copies of messages queued on links
+	// that deliver messages more than once
+	duplicatedTokens int
+	// Channel messages recorded while handling a copy, see `AssertNoDoubleCounting`
+	recordedDuplicates map[*SnapshotMessage]bool
+	// The seeded source of `random`, whose state is saved by `Save`, or nil if
+	// the simulator uses the global source
+	source *seededSource
+	// The saved states of the last ticks, see `EnableHistory`
+	history *tickHistory
+	// How the initiator of a snapshot is chosen if none is given, or nil, and
+	// the last round of elections of an initiator, see `initiator.go`
+	initiatorPolicy SnapshotInitiatorPolicy
+	electionRound   int
+	// How local states are collected, and the aggregation trees of the
+	// snapshots being collected, see `aggregation.go`
+	collectionMode CollectionMode
+	trees          map[int]*aggregationTree
+	// If true, servers disseminate their recorded states, see `dissemination.go`
+	disseminate bool
+	// How token messages are recorded on channels, see `channelrecording.go`
+	channelRecording ChannelRecordingMode
+	// If set, the markers delivered on every channel, see `EnableIsolationChecks`
+	markers *markerLedger
+	// If set, the source of token traffic at every tick, see `SetWorkload`
+	workload Workload
+	// Number of ticks a census waits for its snapshot, see `SetCensusTimeout`
+	censusTimeout int
 }
 
+// The algorithms the servers can use to record snapshots
+type SnapshotAlgorithm int
+
+const (
+	// Marker-based algorithm, which requires FIFO links
+	ChandyLamport SnapshotAlgorithm = iota
+	// Message coloring algorithm, which does not require FIFO links
+	LaiYang
+	// Vector clock algorithm, which does not require FIFO links either
+	Mattern
+)
+
+func (a SnapshotAlgorithm) String() string {
+	switch a {
+	case ChandyLamport:
+		return "Chandy-Lamport"
+	case LaiYang:
+		return "Lai-Yang"
+	case Mattern:
+		return "Mattern"
+	}
+	return "unknown algorithm"
+}
+
+// What happens to the messages still in flight on a link removed at runtime
+type LinkRemovalPolicy int
+
+const (
+	// Nothing more is sent on the link, but the messages on it are still
+	// delivered as usual, and the link is gone once the last one is
+	DrainOnRemove LinkRemovalPolicy = iota
+	// The messages are dropped, and any tokens they carry are lost
+	DiscardOnRemove
+)
+
 func NewSimulator() *Simulator {
 	return &Simulator{
-		0,
-		0,
-		make(map[string]*Server),
-		NewLogger(),
-		make(map[int]chan *SnapshotState),
-		make(map[int]int),
-		make(map[int]chan bool),
+		servers:            make(map[string]*Server),
+		logger:             NewLogger(),
+		collectors:         make(map[int]*snapshotCollector),
+		finishedMap:        make(map[int]int),
+		startingTokens:     make(map[string]int),
+		initiators:         make(map[int]string),
+		collected:          NewSyncMap[int, *SnapshotState](),
+		groundTruth:        make(map[int]*SnapshotState),
+		random:             rand.New(globalSource{}),
+		latency:            NewUniformLatency(1, maxDelay),
+		metrics:            newSimMetrics(),
+		slogger:            slog.Default(),
+		schedule:           make(map[int][]interface{}),
+		messageHandlers:    make(map[reflect.Type]MessageHandler),
+		triggers:           make([]func(*Server) bool, 0),
+		observers:          make([]func(ev SnapshotProgressEvent), 0),
+		piggybackTimeout:   defaultPiggybackTimeout,
+		initialTypedTokens: make(map[string]int),
+		recordedDuplicates: make(map[*SnapshotMessage]bool),
+		trees:              make(map[int]*aggregationTree),
+		censusTimeout:      defaultCensusTimeout,
+	}
+}
+
+// Create a simulator that makes its random decisions from its own source of
+// randomness, seeded with the given seed. Unlike simulators created with
+// `NewSimulator`, which share the global source seeded by `rand.Seed`, runs
+// with the same seed are reproducible even if other simulators run at the
+// same time, so a failure can be replayed from its seed alone.
+func NewSimulatorWithSeed(seed int64) *Simulator {
+	sim := NewSimulator()
+	sim.source = newSeededSource(seed)
+	sim.random = rand.New(sim.source)
+	return sim
+}
+
+// A source of randomness backed by the global functions of `math/rand`
+type globalSource struct{}
+
+func (globalSource) Int63() int64 {
+	return rand.Int63()
+}
+
+func (globalSource) Seed(seed int64) {
+	rand.Seed(seed)
+}
+
+// Select the algorithm the servers use to record snapshots.
+// This must be called before any snapshot is started.
+func (sim *Simulator) SetSnapshotAlgorithm(algorithm SnapshotAlgorithm) {
+	sim.algorithm = algorithm
+}
+
+// Send the failures of the simulation, and every delivery at debug level, to
+// the given handler instead of the default logger of the `log/slog` package
+func (sim *Simulator) SetLogger(handler slog.Handler) {
+	sim.slogger = slog.New(handler)
+}
+
+// Bound the number of messages a server stores per inbound channel while
+// recording a snapshot. Messages beyond the limit are counted in
+// `SnapshotState.overflow` instead, so snapshots that overflow no longer
+// account for all the tokens in flight. A value of 0 or less removes the bound.
+func (sim *Simulator) SetMaxRecordedPerChannel(n int) {
+	if n < 0 {
+		n = 0
 	}
+	sim.maxRecordedPerChannel = n
 }
 
-// Return the receive time of a message after adding a random delay.
+// Return the current time step of the simulation
+func (sim *Simulator) Time() int {
+	return sim.time
+}
+
+// Return the receive time of a message after adding a random delay drawn from
+// the latency model of the simulator, see `SetLatencyModel`.
 // Note: since we only deliver one message to a given server at each time step,
 // the message may be received *after* the time step returned in this function.
 func (sim *Simulator) GetReceiveTime() int {
-	return sim.time + 1 + rand.Intn(5)
+	return sim.time + sim.latency.Delay(sim.random)
+}
+
+// Select what happens to the messages on links removed with `RemoveLink`
+func (sim *Simulator) SetLinkRemovalPolicy(policy LinkRemovalPolicy) {
+	sim.linkRemoval = policy
 }
 
 // Add a server to this simulator with the specified number of starting tokens
 func (sim *Simulator) AddServer(id string, tokens int) {
 	server := NewServer(id, tokens, sim)
 	sim.servers[id] = server
+	sim.serverIds = nil
+	sim.initialTokens += tokens
+	sim.startingTokens[id] += tokens
+}
+
+// Return the sorted IDs of the servers. The slice is shared by the callers, who
+// must not modify it, so the IDs are not sorted again at every tick.
+func (sim *Simulator) sortedServerIds() []string {
+	if sim.serverIds == nil {
+		sim.serverIds = getSortedKeys(sim.servers)
+	}
+	return sim.serverIds
 }
 
 // Add a unidirectional link between two servers
-func (sim *Simulator) AddForwardLink(src string, dest string) {
+func (sim *Simulator) AddForwardLink(src string, dest string) error {
 	server1, ok1 := sim.servers[src]
 	server2, ok2 := sim.servers[dest]
 	if !ok1 {
-		log.Fatalf("Server %v does not exist\n", src)
+		return newError(ErrUnknownServer, "Server %v does not exist", src)
 	}
 	if !ok2 {
-		log.Fatalf("Server %v does not exist\n", dest)
+		return newError(ErrUnknownServer, "Server %v does not exist", dest)
 	}
 	server1.AddOutboundLink(server2)
+	return nil
+}
+
+// Remove the link between two servers. This can be done while the simulation is
+// running: the messages still on the link are drained or discarded according to
+// `SetLinkRemovalPolicy`, and the destination no longer waits for markers on it
+// once the link is gone. Discarded messages are logged as a
+// `DroppedMessageEvent`, like lost messages.
+func (sim *Simulator) RemoveLink(src, dest string) error {
+	server, ok := sim.servers[src]
+	if !ok {
+		return newError(ErrUnknownServer, "Server %v does not exist", src)
+	}
+	link, ok := server.outboundLinks[dest]
+	if !ok {
+		return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, src)
+	}
+	if sim.linkRemoval == DrainOnRemove {
+		server.closeOutboundLink(sim.servers[dest])
+		return nil
+	}
+	// Messages held at the sender go the same way as the ones on the link
+	for !link.backlog.Empty() {
+		link.events.Push(link.backlog.Pop())
+	}
+	for !link.events.Empty() {
+		e := link.events.Pop()
+		sim.logger.RecordEvent(sim.servers[dest], DroppedMessageEvent{e.src, e.dest, e.message})
+		if message, isToken := tokenMessage(e.message); isToken {
+			sim.lostTokens += message.numTokens
+		}
+	}
+	server.RemoveOutboundLink(sim.servers[dest])
+	return nil
+}
+
+// Distribute a total number of tokens across the servers according to a scheme.
+// The supported schemes are:
+//   - "uniform": every server receives total / N tokens, with the remainder
+//     handed out one at a time to the servers in sorted ID order
+//   - "single-source": the lexicographically-first server receives all tokens
+//
+// This overwrites the current token counts and the recorded initial total,
+// so it is meant to be called during setup before any events are injected.
+func (sim *Simulator) DistributeTokens(total int, scheme string) error {
+	serverIds := getSortedKeys(sim.servers)
+	if len(serverIds) == 0 {
+		return fmt.Errorf("Attempted to distribute tokens without any servers")
+	}
+	switch scheme {
+	case "uniform":
+		share := total / len(serverIds)
+		remainder := total % len(serverIds)
+		for i, serverId := range serverIds {
+			tokens := share
+			if i < remainder {
+				tokens++
+			}
+			sim.servers[serverId].Tokens = tokens
+		}
+	case "single-source":
+		for _, serverId := range serverIds {
+			sim.servers[serverId].Tokens = 0
+		}
+		sim.servers[serverIds[0]].Tokens = total
+	default:
+		return fmt.Errorf("Unknown token distribution scheme %q", scheme)
+	}
+	for _, serverId := range serverIds {
+		sim.startingTokens[serverId] = sim.servers[serverId].Tokens
+	}
+	sim.initialTokens = total
+	return nil
+}
+
+// Return the total number of tokens the system started with
+func (sim *Simulator) InitialTokens() int {
+	return sim.initialTokens
+}
+
+// Return the server holding the most tokens along with its token count.
+// Ties are broken in favor of the lexicographically-smaller server ID.
+func (sim *Simulator) MaxTokenServer() (string, int) {
+	maxId := ""
+	maxTokens := 0
+	for _, serverId := range getSortedKeys(sim.servers) {
+		tokens := sim.servers[serverId].Tokens
+		if maxId == "" || tokens > maxTokens {
+			maxId = serverId
+			maxTokens = tokens
+		}
+	}
+	return maxId, maxTokens
+}
+
+// Return the server IDs sorted by their current token count, in descending
+// order if `desc` is set and ascending order otherwise. Ties are broken by ID.
+func (sim *Simulator) ServersByTokens(desc bool) []string {
+	serverIds := getSortedKeys(sim.servers)
+	sort.SliceStable(serverIds, func(i, j int) bool {
+		t1 := sim.servers[serverIds[i]].Tokens
+		t2 := sim.servers[serverIds[j]].Tokens
+		if desc {
+			return t1 > t2
+		}
+		return t1 < t2
+	})
+	return serverIds
+}
+
+// Same as `MaxTokenServer`, but each server's token count is scaled by its
+// weight (e.g. capacity). Servers without an entry in `weights` default to 1.0.
+func (sim *Simulator) MaxWeightedTokenServer(weights map[string]float64) (string, float64) {
+	maxId := ""
+	maxWeighted := 0.0
+	for _, serverId := range getSortedKeys(sim.servers) {
+		weight, ok := weights[serverId]
+		if !ok {
+			weight = 1.0
+		}
+		weighted := float64(sim.servers[serverId].Tokens) * weight
+		if maxId == "" || weighted > maxWeighted {
+			maxId = serverId
+			maxWeighted = weighted
+		}
+	}
+	return maxId, maxWeighted
+}
+
+// Return the link between two servers, or an error if it does not exist
+func (sim *Simulator) GetLink(src, dest string) (*Link, error) {
+	server, ok := sim.servers[src]
+	if !ok {
+		return nil, newError(ErrUnknownServer, "Server %v does not exist", src)
+	}
+	link, ok := server.outboundLinks[dest]
+	if !ok {
+		return nil, newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, src)
+	}
+	return link, nil
+}
+
+// Stop delivering messages on the link between two servers, while links
+// elsewhere remain active. Messages sent on the link are held until it is
+// unfrozen. Return the number of messages currently held on the link.
+func (sim *Simulator) FreezeLink(src, dest string) (held int, err error) {
+	link, err := sim.GetLink(src, dest)
+	if err != nil {
+		return 0, err
+	}
+	link.frozen = true
+	return len(link.events.Elements()), nil
+}
+
+// Resume delivering messages on a link frozen by `FreezeLink`
+func (sim *Simulator) UnfreezeLink(src, dest string) error {
+	link, err := sim.GetLink(src, dest)
+	if err != nil {
+		return err
+	}
+	link.frozen = false
+	return nil
+}
+
+// Tamper with the messages on the link between two servers. The transform is
+// applied to every message right before it is delivered, and each message it
+// alters is logged as a `TransformedMessageEvent`. A nil transform removes it.
+func (sim *Simulator) SetLinkTransform(src, dest string, transform func(message interface{}) interface{}) error {
+	link, err := sim.GetLink(src, dest)
+	if err != nil {
+		return err
+	}
+	link.transform = transform
+	return nil
+}
+
+// Drop each message on the link between two servers with the given probability
+// when it is due for delivery. Dropped messages are logged as a
+// `DroppedMessageEvent` and use up the delivery slot of the sender for that
+// time step. Drops are drawn from the source of randomness of the simulator, so
+// runs are deterministic for a given seed. A probability of 0 makes the link reliable.
+func (sim *Simulator) InjectLoss(src, dest string, probability float64) error {
+	server, ok := sim.servers[src]
+	if !ok {
+		return newError(ErrUnknownServer, "Server %v does not exist", src)
+	}
+	link, ok := server.outboundLinks[dest]
+	if !ok {
+		return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, src)
+	}
+	if probability < 0 || probability > 1 {
+		return fmt.Errorf("Expected a probability between 0 and 1, got %v", probability)
+	}
+	link.lossProbability = probability
+	return nil
+}
+
+// Return the total number of tokens carried by messages dropped on lossy or removed links
+func (sim *Simulator) LostTokens() int {
+	return sim.lostTokens
+}
+
+// Place a token message on the link between two servers as if it had been sent
+// before the simulation started. The tokens are added to the initial total.
+func (sim *Simulator) InjectInFlight(src, dest string, numTokens int, receiveTime int) error {
+	server, ok := sim.servers[src]
+	if !ok {
+		return newError(ErrUnknownServer, "Server %v does not exist", src)
+	}
+	link, ok := server.outboundLinks[dest]
+	if !ok {
+		return newError(ErrUnknownDest, "Unknown dest ID %v from server %v", dest, src)
+	}
+	if numTokens <= 0 {
+		return fmt.Errorf("Expected a positive number of tokens, got %v", numTokens)
+	}
+	link.events.Push(SendMessageEvent{
+		src,
+		dest,
+		TokenMessage{numTokens: numTokens},
+		receiveTime,
+		sim.time,
+		0})
+	server.sentCount[dest]++
+	sim.initialTokens += numTokens
+	sim.startingTokens[src] += numTokens
+	return nil
 }
 
 // Run an event in the system
-func (sim *Simulator) InjectEvent(event interface{}) {
+func (sim *Simulator) InjectEvent(event interface{}) error {
 	switch event := event.(type) {
 	case PassTokenEvent:
-		src := sim.servers[event.src]
-		src.SendTokens(event.tokens, event.dest)
+		src, ok := sim.servers[event.src]
+		if !ok {
+			return newError(ErrUnknownServer, "Server %v does not exist", event.src)
+		}
+		if src.crashed {
+			return newError(ErrServerCrashed, "Crashed server %v attempted to send tokens", event.src)
+		}
+		if err := src.checkSend(DefaultTokenType, event.tokens, event.dest); err != nil {
+			return err
+		}
+		if sim.trace != nil {
+			fmt.Fprintf(sim.trace, "send %v %v %v\n", event.src, event.dest, event.tokens)
+		}
+		return src.SendTokens(event.tokens, event.dest)
 	case SnapshotEvent:
-		sim.StartSnapshot(event.serverId)
-	default:
-		log.Fatal("Error unknown event: ", event)
+		return sim.StartSnapshot(event.serverId)
 	}
+	return fmt.Errorf("Unknown event: %v", event)
 }
 
 // Advance the simulator time forward by one step, handling all send message events
 // that expire at the new time step, if any.
 func (sim *Simulator) Tick() {
+	if sim.trace != nil {
+		fmt.Fprintln(sim.trace, "tick")
+	}
+	sim.advanceTime()
+	for _, serverId := range sim.sortedServerIds() {
+		sim.servers[serverId].processInbox()
+	}
+	sim.sendOverdueMarkers()
+	// Note: to ensure deterministic ordering of packet delivery across the servers,
+	// we must also iterate through the servers and the links in a deterministic way.
+	// Servers cannot handle their packets concurrently either: handling a packet
+	// draws link delays from the shared random source, appends to the event log
+	// and updates the token accounting and snapshot collectors, so the outcome
+	// of the run depends on the order in which the packets are handled.
+	if sim.deliveryOrder == ReceiveTimeOrder {
+		sim.deliverByReceiveTime()
+	} else {
+		for _, serverId := range sim.sortedServerIds() {
+			server := sim.servers[serverId]
+			for _, link := range server.deliveringLinks() {
+				dest := link.dest
+				// Deliver at most one packet per server at each time step to
+				// establish total ordering of packet delivery to each server
+				if !link.blocked() && !sim.servers[dest].crashed {
+					if e, ok := link.nextDeliverable(sim.time, sim.random); ok {
+						sim.deliverOn(link, e)
+						break
+					}
+				}
+			}
+		}
+	}
+	for _, serverId := range sim.sortedServerIds() {
+		server := sim.servers[serverId]
+		for _, link := range server.deliveringLinks() {
+			sim.admitBacklog(link)
+		}
+		server.detachDrainedLinks()
+	}
+	for _, serverId := range sim.sortedServerIds() {
+		sim.servers[serverId].checkSnapshotDeadlines()
+	}
+	sim.runScheduledEvents()
+	sim.runWorkload()
+	sim.checkSnapshotTriggers()
+	sim.runPeriodicSnapshots()
+	if sim.checkInvariants {
+		sim.checkTokenConservation()
+	}
+	if sim.markers != nil {
+		if err := sim.CheckSnapshotIsolation(); err != nil {
+			panic(err.Error())
+		}
+	}
+	sim.sampleQueueDepth()
+	sim.refreshMetrics()
+	sim.refreshVisualizer()
+	sim.recordHistory()
+}
+
+// Inject an event at the end of the tick that moves the simulation to the given
+// time step, after the messages of that tick have been delivered. Events
+// scheduled for the same time step are injected in the order they were
+// scheduled.
+func (sim *Simulator) ScheduleEvent(time int, event interface{}) error {
+	if time <= sim.time {
+		return fmt.Errorf("Cannot schedule an event at time %v, the simulation is at time %v",
+			time, sim.time)
+	}
+	sim.schedule[time] = append(sim.schedule[time], event)
+	return nil
+}
+
+// Inject the events scheduled for the current time step
+func (sim *Simulator) runScheduledEvents() {
+	events := sim.schedule[sim.time]
+	delete(sim.schedule, sim.time)
+	for _, event := range events {
+		if err := sim.InjectEvent(event); err != nil {
+			sim.slogger.Error("Failed to inject scheduled event",
+				"time", sim.time, "event", event, "err", err)
+		}
+	}
+}
+
+// Move the simulator time forward by one step without delivering any messages
+func (sim *Simulator) advanceTime() {
 	sim.time++
 	sim.logger.NewEpoch()
-	// Note: to ensure deterministic ordering of packet delivery across the servers,
-	// we must also iterate through the servers and the links in a deterministic way
-	for _, serverId := range getSortedKeys(sim.servers) {
+	for _, serverId := range sim.sortedServerIds() {
 		server := sim.servers[serverId]
-		for _, dest := range getSortedKeys(server.outboundLinks) {
-			link := server.outboundLinks[dest]
-			// Deliver at most one packet per server at each time step to
-			// establish total ordering of packet delivery to each server
-			if !link.events.Empty() {
-				e := link.events.Peek().(SendMessageEvent)
-				if e.receiveTime <= sim.time {
-					link.events.Pop()
-					sim.logger.RecordEvent(
-						sim.servers[e.dest],
-						ReceivedMessageEvent{e.src, e.dest, e.message})
-					sim.servers[e.dest].HandlePacket(e.src, e.message)
-					break
+		if server.crashed {
+			continue
+		}
+		server.Tokens += server.generationRate
+		sim.generatedTokens += server.generationRate
+	}
+}
+
+// Deliver a message taken off the given link, unless the link loses it, after
+// applying the transform of the link
+func (sim *Simulator) deliverOn(link *Link, e SendMessageEvent) {
+	if link.lossProbability > 0 && sim.random.Float64() < link.lossProbability {
+		sim.logger.RecordEvent(
+			sim.servers[e.dest],
+			DroppedMessageEvent{e.src, e.dest, e.message})
+		if message, isToken := tokenMessage(e.message); isToken {
+			sim.lostTokens += message.numTokens
+		}
+		return
+	}
+	copied, duplicate := e.message.(duplicateCopy)
+	if duplicate {
+		e.message = copied.message
+	}
+	sim.duplicateOn(link, e)
+	if link.transform != nil {
+		transformed := link.transform(e.message)
+		if !reflect.DeepEqual(transformed, e.message) {
+			sim.logger.RecordEvent(
+				sim.servers[e.dest],
+				TransformedMessageEvent{e.src, e.dest, e.message, transformed})
+			e.message = transformed
+		}
+	}
+	if duplicate {
+		sim.deliverDuplicate(e)
+		return
+	}
+	sim.deliver(e)
+}
+
+// Deliver a message to its destination server
+func (sim *Simulator) deliver(e SendMessageEvent) {
+	dest := sim.servers[e.dest]
+	dest.receiveLamport(e)
+	sim.logger.RecordEvent(dest, ReceivedMessageEvent{e.src, e.dest, e.message, e.lamport})
+	sim.slogger.Debug("Delivered message",
+		"time", sim.time, "src", e.src, "dest", e.dest, "message", e.message)
+	sim.notifyMarkers(MarkerReceived, e.message, e.dest, e.src)
+	sim.recordMarkers(e.message, e.src, e.dest)
+	dest.receivedAt = dest.lamport
+	defer func() { dest.receivedAt = 0 }()
+	if err := dest.HandlePacket(e.src, e.message); err != nil {
+		sim.slogger.Error("Failed to handle message",
+			"time", sim.time, "src", e.src, "dest", e.dest, "message", e.message, "err", err)
+	}
+}
+
+// A source of externally-generated messages, e.g. from a real message bus.
+// `Next` returns the next message to deliver, or false once the source is exhausted.
+type EventSource interface {
+	Next() (SendMessageEvent, bool)
+}
+
+// Deliver the messages produced by the source in order, instead of the messages
+// queued on the links. Before each delivery, the simulator time is moved forward
+// to the receive time of the message if it lies in the future. Messages sent by
+// the servers in response are queued on the links as usual and are not delivered.
+// Returns an error, without delivering it, on the first message to a server
+// that does not exist.
+func (sim *Simulator) RunFromSource(src EventSource) error {
+	for {
+		e, ok := src.Next()
+		if !ok {
+			return nil
+		}
+		if _, ok := sim.servers[e.dest]; !ok {
+			return newError(ErrUnknownServer, "Server %v does not exist", e.dest)
+		}
+		for sim.time < e.receiveTime {
+			sim.advanceTime()
+		}
+		sim.deliver(e)
+	}
+}
+
+// Return the sorted IDs of the servers reachable from the given server in at most
+// k hops along outbound links, including the server itself
+func (sim *Simulator) WithinHops(from string, k int) ([]string, error) {
+	if _, ok := sim.servers[from]; !ok {
+		return nil, newError(ErrUnknownServer, "Server %v does not exist", from)
+	}
+	visited := map[string]bool{from: true}
+	frontier := []string{from}
+	for hop := 0; hop < k && len(frontier) > 0; hop++ {
+		next := make([]string, 0)
+		for _, serverId := range frontier {
+			for _, dest := range getSortedKeys(sim.servers[serverId].outboundLinks) {
+				if !visited[dest] {
+					visited[dest] = true
+					next = append(next, dest)
 				}
 			}
 		}
+		frontier = next
+	}
+	return getSortedKeys(visited), nil
+}
+
+// Return the number of markers a snapshot is expected to generate, which is
+// one per directed link in the topology
+func (sim *Simulator) PredictedMarkerCount() int {
+	count := 0
+	for _, server := range sim.servers {
+		count += server.OutDegree()
 	}
+	return count
 }
 
-// Start a new snapshot process at the specified server
-func (sim *Simulator) StartSnapshot(serverId string) {
+// Return the number of markers sent for the given snapshot, according to the log.
+// A batch of markers counts once for each of its snapshots.
+func (sim *Simulator) MarkerCount(snapshotId int) int {
+	count := 0
+	for _, events := range sim.logger.events {
+		for _, logEvent := range events {
+			evt, ok := logEvent.event.(SentMessageEvent)
+			if ok && carriesMarker(evt.message, snapshotId) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Return the total number of tokens currently held by the servers.
+// Tokens in flight are not included.
+func (sim *Simulator) TotalTokens() int {
+	total := 0
+	for _, server := range sim.servers {
+		total += server.Tokens
+	}
+	return total
+}
+
+// Return the total number of tokens minted by servers since the start of the
+// simulation. These are not part of `InitialTokens`.
+func (sim *Simulator) GeneratedTokens() int {
+	return sim.generatedTokens
+}
+
+// Keep ticking until every message queued on the links or waiting in an inbox
+// has been delivered and every scheduled event has been injected. Messages held on frozen or
+// partitioned links or sent to crashed servers are not waited for.
+func (sim *Simulator) Drain() {
+	for sim.deliverableMessages() > 0 || len(sim.schedule) > 0 {
+		sim.Tick()
+	}
+}
+
+// Run the simulation for the given number of ticks, or until the context is
+// done, in which case the error of the context is returned. The context is
+// checked before every tick.
+func (sim *Simulator) Run(ctx context.Context, ticks int) error {
+	for i := 0; i < ticks; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sim.Tick()
+	}
+	return nil
+}
+
+// Return the number of messages queued on, or held back from, links that are
+// not frozen or partitioned, including the markers held for piggybacking, and
+// waiting in the inboxes of servers that have not crashed
+func (sim *Simulator) deliverableMessages() int {
+	count := 0
+	for _, server := range sim.servers {
+		if !server.crashed {
+			count += server.InboxLen()
+		}
+		for _, link := range server.deliveringLinks() {
+			if !link.blocked() && !sim.servers[link.dest].crashed {
+				count += link.events.Len() + link.backlog.Len() + len(link.heldMarkers)
+			}
+		}
+	}
+	return count
+}
+
+// Drain the simulation and verify that the servers hold exactly the expected
+// number of tokens afterwards.
+func (sim *Simulator) AssertConservedAfterDrain(expectedTotal int) error {
+	sim.Drain()
+	if total := sim.TotalTokens(); total != expectedTotal {
+		return fmt.Errorf("Expected %v tokens after drain, servers hold %v", expectedTotal, total)
+	}
+	return nil
+}
+
+// Return every message queued on any link that has not been delivered yet,
+// sorted by receive time, then by link. Messages on the same link with the
+// same receive time keep their queue order.
+func (sim *Simulator) AllInFlight() []InFlightInfo {
+	inFlight := make([]InFlightInfo, 0)
+	for _, serverId := range getSortedKeys(sim.servers) {
+		server := sim.servers[serverId]
+		for _, link := range server.deliveringLinks() {
+			// Messages held at the sender have been sent, so they are in flight
+			for _, event := range append(link.events.Elements(), link.backlog.Elements()...) {
+				inFlight = append(inFlight, InFlightInfo{
+					event.src,
+					event.dest,
+					event.message,
+					event.receiveTime,
+					event.sendTime})
+			}
+		}
+	}
+	sort.SliceStable(inFlight, func(i, j int) bool {
+		return inFlight[i].receiveTime < inFlight[j].receiveTime
+	})
+	return inFlight
+}
+
+// Start a new snapshot process at the specified server. Every snapshot gets an
+// ID of its own, so a snapshot ID always has exactly one initiator: servers that
+// start overlapping snapshots start distinct snapshots, which are collected
+// independently by ID. If the server ID is empty, the initiator is chosen by
+// the policy set with `SetInitiatorPolicy`.
+func (sim *Simulator) StartSnapshot(serverId string) error {
+	_, err := sim.startSnapshot(serverId)
+	return err
+}
+
+// Start a new snapshot like `StartSnapshot` and return its ID
+func (sim *Simulator) startSnapshot(serverId string) (int, error) {
+	if serverId == "" && sim.initiatorPolicy != nil {
+		initiator, err := sim.initiatorPolicy.Initiator(sim)
+		if err != nil {
+			return 0, err
+		}
+		serverId = initiator
+	}
+	server, ok := sim.servers[serverId]
+	if !ok {
+		return 0, newError(ErrUnknownServer, "Server %v does not exist", serverId)
+	}
+	if server.crashed {
+		return 0, newError(ErrServerCrashed, "Crashed server %v attempted to start a snapshot", serverId)
+	}
+	if sim.trace != nil {
+		fmt.Fprintf(sim.trace, "snapshot %v\n", serverId)
+	}
 	snapshotId := sim.nextSnapshotId
 	sim.nextSnapshotId++
 	sim.logger.RecordEvent(sim.servers[serverId], StartSnapshot{serverId, snapshotId})
 	// TODO: IMPLEMENT ME
-	sim.chanMap[snapshotId] = make(chan *SnapshotState, len(sim.servers))
-	sim.stopMap[snapshotId] = make(chan bool, 1)
-	sim.servers[serverId].StartSnapshot(snapshotId)
+	sim.initiators[snapshotId] = serverId
+	sim.metrics.snapshotStart[snapshotId] = sim.time
+	sim.newCollector(snapshotId, getSortedKeys(sim.servers))
+	sim.excludeCrashed(snapshotId)
+	return snapshotId, server.StartSnapshot(snapshotId)
+}
+
+// Number of ticks a census waits for its snapshot to complete by default
+const defaultCensusTimeout = 1000
+
+// Set the number of ticks `Census` runs the simulation for at most, waiting for
+// its snapshot to complete everywhere
+func (sim *Simulator) SetCensusTimeout(ticks int) {
+	if ticks < 0 {
+		ticks = 0
+	}
+	sim.censusTimeout = ticks
+}
+
+// Start the snapshot with the given ID on every server at the current time step,
+// run the simulation until it completes everywhere and return the merged state.
+// A census has no single initiator, so its state is recorded with an empty one.
+// Since all servers record their state at once, the result is the exact global
+// state at this time step, including tokens in flight. If the snapshot has not
+// completed after the ticks set by `SetCensusTimeout`, e.g. because a frozen or
+// partitioned link holds back a marker, the state merged from the servers that
+// completed it is returned along with an error, as `CollectSnapshotWithTimeout`
+// does.
+func (sim *Simulator) Census(snapshotId int) (*SnapshotState, error) {
+	_, started := sim.collectors[snapshotId]
+	if _, collected := sim.collected.Load(snapshotId); started || collected {
+		return nil, newError(ErrSnapshotAlreadyStarted, "Snapshot %v has already been started", snapshotId)
+	}
+	if sim.algorithm == Mattern {
+		// The cut of a snapshot is defined by its single initiator
+		return nil, fmt.Errorf("Census is not supported by Mattern's algorithm")
+	}
+	if snapshotId >= sim.nextSnapshotId {
+		sim.nextSnapshotId = snapshotId + 1
+	}
+	sim.newCollector(snapshotId, getSortedKeys(sim.servers))
+	sim.excludeCrashed(snapshotId)
+	for _, serverId := range getSortedKeys(sim.servers) {
+		server := sim.servers[serverId]
+		if server.crashed {
+			continue
+		}
+		sim.logger.RecordEvent(server, StartSnapshot{serverId, snapshotId})
+		server.StartSnapshot(snapshotId)
+	}
+	return sim.CollectSnapshotWithTimeout(snapshotId, sim.censusTimeout)
 }
 
 // Callback for servers to notify the simulator that the snapshot process has
@@ -124,30 +942,656 @@ func (sim *Simulator) StartSnapshot(serverId string) {
 func (sim *Simulator) NotifySnapshotComplete(serverId string, snapshotId int) {
 	sim.logger.RecordEvent(sim.servers[serverId], EndSnapshot{serverId, snapshotId})
 	// TODO: IMPLEMENT ME
+	sim.metrics.snapshotCompleted(snapshotId, serverId, sim.time)
+	sim.notifyObservers(SnapshotCompleted, snapshotId, serverId, "")
+	sim.finishSnapshot(snapshotId)
+}
+
+// Count one more server as finished with the given snapshot, and capture the
+// ground truth once all the servers are
+func (sim *Simulator) finishSnapshot(snapshotId int) {
 	sim.finishedMap[snapshotId]++
+	if sim.finishedMap[snapshotId] == sim.numParticipants(snapshotId) {
+		sim.groundTruth[snapshotId] = sim.liveState(snapshotId)
+	}
+}
+
+// Add a server to a running simulation, with links in both directions to each
+// of the given servers. The new server is forced into every snapshot that is
+// still in progress: it records its state immediately, as if it had received a
+// marker, and sends markers to its neighbors. Servers that already recorded
+// their state send their markers on the links to it, see `AddOutboundLink`.
+// Snapshots that completed before the server joined do not include it.
+func (sim *Simulator) AddServerDynamic(id string, tokens int, links []string) error {
+	if _, ok := sim.servers[id]; ok {
+		return newError(ErrServerExists, "Server %v already exists", id)
+	}
+	for _, neighbor := range links {
+		if _, ok := sim.servers[neighbor]; !ok {
+			return newError(ErrUnknownServer, "Server %v does not exist", neighbor)
+		}
+	}
+	inProgress := make([]int, 0)
+	for snapshotId := range sim.collectors {
+		if sim.finishedMap[snapshotId] < sim.numParticipants(snapshotId) {
+			inProgress = append(inProgress, snapshotId)
+		}
+	}
+	sort.Ints(inProgress)
+	sim.AddServer(id, tokens)
+	server := sim.servers[id]
+	for _, neighbor := range links {
+		sim.AddForwardLink(id, neighbor)
+		sim.AddForwardLink(neighbor, id)
+	}
+	for _, snapshotId := range inProgress {
+		sim.collectors[snapshotId].join(id)
+		sim.logger.RecordEvent(server, StartSnapshot{id, snapshotId})
+		server.StartSnapshot(snapshotId)
+	}
+	return nil
+}
+
+// Stop a server from processing packets, as if it had crashed. The server keeps
+// its tokens, and messages sent to it are buffered on its inbound links until it
+// recovers. The server is excluded from every snapshot it has not completed yet,
+// and every snapshot started while it is down, so that these can still be
+// collected: the collected state lists it in `CrashedServers` instead of
+// recording its tokens and inbound channels. Servers still waiting for a marker
+// from the crashed server only complete by their deadline, see
+// `Server.SetSnapshotDeadline`.
+func (sim *Simulator) CrashServer(serverId string) error {
+	server, ok := sim.servers[serverId]
+	if !ok {
+		return newError(ErrUnknownServer, "Server %v does not exist", serverId)
+	}
+	if server.crashed {
+		return newError(ErrServerCrashed, "Server %v has already crashed", serverId)
+	}
+	server.crashed = true
+	ids := make([]int, 0)
+	for snapshotId := range sim.collectors {
+		ids = append(ids, snapshotId)
+	}
+	sort.Ints(ids)
+	for _, snapshotId := range ids {
+		sim.excludeServer(server, snapshotId)
+		sim.failAggregation(serverId, snapshotId)
+	}
+	return nil
+}
+
+// Let a server crashed by `CrashServer` rejoin the system. The messages buffered
+// while it was down are delivered as usual from the next time step, but the
+// server does not take part in the snapshots it was excluded from.
+func (sim *Simulator) RecoverServer(serverId string) error {
+	server, ok := sim.servers[serverId]
+	if !ok {
+		return newError(ErrUnknownServer, "Server %v does not exist", serverId)
+	}
+	if !server.crashed {
+		return newError(ErrServerNotCrashed, "Server %v has not crashed", serverId)
+	}
+	server.crashed = false
+	return nil
+}
+
+// Exclude every crashed server from the given snapshot
+func (sim *Simulator) excludeCrashed(snapshotId int) {
+	for _, serverId := range getSortedKeys(sim.servers) {
+		if server := sim.servers[serverId]; server.crashed {
+			sim.excludeServer(server, snapshotId)
+		}
+	}
+}
+
+// Mark the snapshot as done on a server without recording any of its state,
+// unless the server has already completed it. Any state the server recorded so
+// far is discarded, and markers it receives for the snapshot later are ignored.
+func (sim *Simulator) excludeServer(server *Server, snapshotId int) {
+	if server.completedSnapshot[snapshotId] {
+		return
+	}
+	if !server.receivedSnapshot[snapshotId] {
+		server.inReceivedMarker[snapshotId] = make(map[string]bool)
+		server.markerArrival[snapshotId] = make(map[string]int)
+		server.whiteExpected[snapshotId] = make(map[string]int)
+	}
+	server.receivedSnapshot[snapshotId] = true
+	server.completedSnapshot[snapshotId] = true
+	sim.reportSnapshot(server.Id, snapshotId, &SnapshotState{
+		id:        snapshotId,
+		tokens:    make(map[string]int),
+		messages:  make([]*SnapshotMessage, 0),
+		initiator: sim.initiators[snapshotId],
+		crashed:   []string{server.Id},
+	})
+	sim.finishSnapshot(snapshotId)
+	sim.mergeAfterExclusion(snapshotId)
+}
+
+// Return the actual global state of the system right now: the tokens held by
+// every server and the token messages in flight on every link
+func (sim *Simulator) liveState(snapshotId int) *SnapshotState {
+	snap := SnapshotState{
+		id:        snapshotId,
+		tokens:    make(map[string]int),
+		messages:  make([]*SnapshotMessage, 0),
+		channels:  make([]ChannelId, 0),
+		initiator: sim.initiators[snapshotId],
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		server := sim.servers[serverId]
+		snap.tokens[serverId] = server.Tokens
+		for _, dest := range getSortedKeys(server.outboundLinks) {
+			snap.channels = append(snap.channels, ChannelId{serverId, dest})
+		}
+	}
+	for _, info := range sim.AllInFlight() {
+		if msg, ok := anyTokenMessage(info.message); ok {
+			snap.messages = append(snap.messages,
+				&SnapshotMessage{src: info.src, dest: info.dest, message: msg, hopCount: msg.hopCount})
+		}
+	}
+	return &snap
+}
+
+// Return the sorted IDs of the servers that have started recording the given
+// snapshot but have not completed it yet
+func (sim *Simulator) PendingServers(snapshotId int) []string {
+	pending := make([]string, 0)
+	for _, serverId := range getSortedKeys(sim.servers) {
+		server := sim.servers[serverId]
+		if server.receivedSnapshot[snapshotId] && !server.completedSnapshot[snapshotId] {
+			pending = append(pending, serverId)
+		}
+	}
+	return pending
+}
+
+// Return the actual global state of the system at the moment the given snapshot
+// completed on the last server, or nil if it has not completed yet. This is the
+// ground truth to compare the state recorded by the algorithm against: the two
+// are equal if no tokens were passed while the snapshot was in progress.
+func (sim *Simulator) GroundTruthAt(snapshotId int) *SnapshotState {
+	return sim.groundTruth[snapshotId]
 }
 
 // Collect and merge snapshot state from all the servers.
 // This function blocks until the snapshot process has completed on all servers.
+// Collecting a snapshot that was already collected returns the same state again.
+// Returns nil if the snapshot has not been started.
 func (sim *Simulator) CollectSnapshot(snapshotId int) *SnapshotState {
 	// TODO: IMPLEMENT ME
-	tk := make(map[string]int)
-	msg := make([]*SnapshotMessage, 0)
-	cnt := 0
-	for {
+	if snap, ok := sim.collected.Load(snapshotId); ok {
+		return snap
+	}
+	collector, ok := sim.collectors[snapshotId]
+	if !ok {
+		return nil
+	}
+	<-collector.done
+	snap := sim.mergeSnapshot(snapshotId, collector.reported())
+	sim.storeCollected(snapshotId, snap)
+	return snap
+}
+
+// Collect a snapshot like `CollectSnapshot`, but give up once the context is
+// done, e.g. if the goroutine driving the simulation is stuck. Returns the error
+// of the context in that case, and `ErrUnknownSnapshot` if the snapshot has not
+// been started.
+func (sim *Simulator) CollectSnapshotContext(ctx context.Context, snapshotId int) (*SnapshotState, error) {
+	if snap, ok := sim.collected.Load(snapshotId); ok {
+		return snap, nil
+	}
+	collector, ok := sim.collectors[snapshotId]
+	if !ok {
+		return nil, newError(ErrUnknownSnapshot, "Snapshot %v has not been started", snapshotId)
+	}
+	select {
+	case <-collector.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	snap := sim.mergeSnapshot(snapshotId, collector.reported())
+	sim.storeCollected(snapshotId, snap)
+	return snap, nil
+}
+
+// Collect a snapshot like `CollectSnapshot`, but run the simulation for at most
+// the given number of ticks while waiting for it, and give up once they have
+// passed. This must be called from the goroutine driving the simulation. On
+// timeout, the state merged from the servers that have completed the snapshot
+// so far is returned along with an error; its `Completion` reports which
+// servers these are. A timed out snapshot is not marked as collected, and can
+// be collected again once the remaining servers complete it.
+func (sim *Simulator) CollectSnapshotWithTimeout(snapshotId int, ticks int) (*SnapshotState, error) {
+	if snap, ok := sim.collected.Load(snapshotId); ok {
+		return snap, nil
+	}
+	collector, ok := sim.collectors[snapshotId]
+	if !ok {
+		return nil, newError(ErrUnknownSnapshot, "Snapshot %v has not been started", snapshotId)
+	}
+	for i := 0; ; i++ {
 		select {
-		case rec := <-sim.chanMap[snapshotId]:
-			for k, v := range rec.tokens {
-				tk[k] += v
+		case <-collector.done:
+			snap := sim.mergeSnapshot(snapshotId, collector.reported())
+			sim.storeCollected(snapshotId, snap)
+			return snap, nil
+		default:
+		}
+		if i == ticks {
+			break
+		}
+		sim.Tick()
+	}
+	// The records stay with the collector, so that the snapshot can still be collected
+	records := collector.reported()
+	snap := sim.mergeSnapshot(snapshotId, records)
+	return snap, fmt.Errorf("Snapshot %v timed out after %v tick(s) with %v of %v servers completed",
+		snapshotId, ticks, len(records), sim.numParticipants(snapshotId))
+}
+
+// Merge the local states recorded by the servers for the given snapshot
+func (sim *Simulator) mergeSnapshot(snapshotId int, records []*SnapshotState) *SnapshotState {
+	snap := SnapshotState{
+		id:              snapshotId,
+		tokens:          make(map[string]int),
+		messages:        make([]*SnapshotMessage, 0),
+		channels:        make([]ChannelId, 0),
+		unknownChannels: make([]ChannelId, 0),
+		initiator:       sim.initiators[snapshotId],
+		overflow:        make(map[ChannelId]int),
+		crashed:         make([]string, 0),
+		completion:      make(map[string]bool),
+		appState:        make(map[string][]byte),
+		waits:           make(map[string]waitState),
+		typed:           make(map[string]map[string]int),
+		cut:             make(map[string]int),
+	}
+	// Only the servers taking part in the snapshot, not the ones that joined
+	// after it completed, are expected to record their state
+	participants := getSortedKeys(sim.servers)
+	if collector, ok := sim.collectors[snapshotId]; ok {
+		participants = collector.participantIds()
+	}
+	for _, serverId := range participants {
+		snap.completion[serverId] = false
+	}
+	for _, rec := range records {
+		for k, v := range rec.tokens {
+			snap.tokens[k] += v
+			snap.completion[k] = true
+		}
+		snap.messages = append(snap.messages, rec.messages...)
+		snap.mergeAggregates(rec)
+		snap.channels = append(snap.channels, rec.channels...)
+		snap.unknownChannels = append(snap.unknownChannels, rec.unknownChannels...)
+		for channel, count := range rec.overflow {
+			snap.overflow[channel] += count
+		}
+		snap.crashed = append(snap.crashed, rec.crashed...)
+		for serverId, state := range rec.appState {
+			snap.appState[serverId] = state
+		}
+		for serverId, state := range rec.waits {
+			snap.waits[serverId] = state
+		}
+		for serverId, counts := range rec.typed {
+			snap.typed[serverId] = counts
+		}
+		for serverId, clock := range rec.cut {
+			snap.cut[serverId] = clock
+		}
+	}
+	return &snap
+}
+
+// Verify that every server sent its marker for the given snapshot on each outbound
+// link before sending any token on that link after it recorded its local state.
+// A server records its state either when it initiates the snapshot or when it
+// receives its first marker, both of which are visible in the event log.
+func (sim *Simulator) AssertMarkerBeforeTokens(snapshotId int) error {
+	started := make(map[string]bool)    // key = server ID
+	markerSent := make(map[string]bool) // key = "src dest"
+	for epoch, events := range sim.logger.events {
+		for _, logEvent := range events {
+			switch evt := logEvent.event.(type) {
+			case StartSnapshot:
+				if evt.snapshotId == snapshotId {
+					started[evt.serverId] = true
+				}
+			case ReceivedMessageEvent:
+				if carriesMarker(evt.message, snapshotId) {
+					started[evt.dest] = true
+				}
+			case SentMessageEvent:
+				link := evt.src + " " + evt.dest
+				switch msg := evt.message.(type) {
+				case MarkerMessage:
+					if msg.snapshotId == snapshotId {
+						markerSent[link] = true
+					}
+				case PiggybackedMessage:
+					// The markers are handled before the message they are attached to
+					if carriesMarker(msg, snapshotId) {
+						markerSent[link] = true
+					}
+				case TokenMessage:
+					if started[evt.src] && !markerSent[link] {
+						return fmt.Errorf(
+							"Snapshot %v: %v sent %v to %v at time %v before its marker",
+							snapshotId, evt.src, msg, evt.dest, sim.logger.firstEpoch+epoch)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Collect a snapshot like `CollectSnapshot`, after checking that it was started
+// by the given server. This does not select among several snapshots: the ID
+// alone identifies the snapshot, see `StartSnapshot`, and the initiator is only
+// checked against it. Use `SnapshotsByInitiator` to find the snapshots a server
+// started.
+func (sim *Simulator) CollectSnapshotFrom(snapshotId int, initiator string) (*SnapshotState, error) {
+	actual, ok := sim.initiatorOf(snapshotId)
+	if !ok {
+		return nil, newError(ErrUnknownSnapshot, "Snapshot %v has not been started", snapshotId)
+	}
+	if actual != initiator {
+		return nil, fmt.Errorf("Snapshot %v was started by %v, not %v", snapshotId, actual, initiator)
+	}
+	return sim.CollectSnapshot(snapshotId), nil
+}
+
+// Collect the merged state of a snapshot if it has completed on all servers
+// and their states have reached the simulator, see `TreeAggregation`. Unlike
+// `CollectSnapshot`, this never blocks: it returns false if the snapshot is
+// still in progress, so any number of overlapping snapshots can be polled from
+// the goroutine driving the simulation. It must not be used on a snapshot that
+// another goroutine is collecting with `CollectSnapshot` at the same time.
+func (sim *Simulator) TryCollectSnapshot(snapshotId int) (*SnapshotState, bool) {
+	if snap, ok := sim.collected.Load(snapshotId); ok {
+		return snap, true
+	}
+	collector, ok := sim.collectors[snapshotId]
+	if !ok || sim.finishedMap[snapshotId] < sim.numParticipants(snapshotId) || !collector.complete() {
+		return nil, false
+	}
+	return sim.CollectSnapshot(snapshotId), true
+}
+
+// Return the initiator of the given snapshot, if it was started, including
+// snapshots the simulator released once collected
+func (sim *Simulator) initiatorOf(snapshotId int) (string, bool) {
+	if snap, ok := sim.collected.Load(snapshotId); ok {
+		return snap.initiator, true
+	}
+	initiator, ok := sim.initiators[snapshotId]
+	return initiator, ok
+}
+
+// Return the sorted IDs of the snapshots started so far, including censuses
+func (sim *Simulator) SnapshotIds() []int {
+	started := make(map[int]bool)
+	for snapshotId := range sim.collectors {
+		started[snapshotId] = true
+	}
+	sim.collected.Range(func(snapshotId int, _ *SnapshotState) bool {
+		started[snapshotId] = true
+		return true
+	})
+	ids := make([]int, 0, len(started))
+	for snapshotId := range started {
+		ids = append(ids, snapshotId)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Return the events logged so far, one per line, prefixed with their time step
+func (sim *Simulator) EventLog() []string {
+	return sim.logger.Lines()
+}
+
+// Return the logger of the simulation, e.g. to export its events with
+// `Logger.WriteJSONL` or `Logger.WriteOTLP`
+func (sim *Simulator) Logger() *Logger {
+	return sim.logger
+}
+
+// Return the sorted IDs of the collected snapshots started by the given server
+func (sim *Simulator) SnapshotsByInitiator(initiator string) []int {
+	ids := make([]int, 0)
+	sim.collected.Range(func(snapshotId int, snap *SnapshotState) bool {
+		if snap.initiator == initiator {
+			ids = append(ids, snapshotId)
+		}
+		return true
+	})
+	sort.Ints(ids)
+	return ids
+}
+
+// Return the chain of servers along which markers for the given snapshot took
+// the longest to propagate, starting at the initiator. The chain ends with the
+// last marker delivered, which determines when the snapshot completes; each
+// earlier hop is the marker that caused the next server to start its snapshot.
+func (sim *Simulator) MarkerCriticalPath(snapshotId int) []string {
+	parent := make(map[string]string) // key = server ID, value = src of first marker
+	initiator, _ := sim.initiatorOf(snapshotId)
+	lastSrc := ""
+	lastDest := ""
+	for _, events := range sim.logger.events {
+		for _, logEvent := range events {
+			evt, ok := logEvent.event.(ReceivedMessageEvent)
+			if !ok {
+				continue
+			}
+			if carriesMarker(evt.message, snapshotId) {
+				if _, ok := parent[evt.dest]; !ok && evt.dest != initiator {
+					parent[evt.dest] = evt.src
+				}
+				lastSrc = evt.src
+				lastDest = evt.dest
+			}
+		}
+	}
+	if lastDest == "" {
+		return []string{}
+	}
+	path := []string{lastDest}
+	for serverId := lastSrc; ; {
+		path = append([]string{serverId}, path...)
+		next, ok := parent[serverId]
+		if !ok || len(path) > len(sim.servers) {
+			break
+		}
+		serverId = next
+	}
+	return path
+}
+
+// Return the merged state of a snapshot that has already been collected
+func (sim *Simulator) collectedSnapshot(snapshotId int) (*SnapshotState, error) {
+	snap, ok := sim.collected.Load(snapshotId)
+	if !ok {
+		return nil, fmt.Errorf("Snapshot %v has not been collected", snapshotId)
+	}
+	return snap, nil
+}
+
+// Verify that a collected snapshot recorded the state of every directed link
+// between the servers taking part in it, reporting the channels that are
+// missing otherwise
+func (sim *Simulator) AssertChannelCoverage(snapshotId int) error {
+	snap, err := sim.collectedSnapshot(snapshotId)
+	if err != nil {
+		return err
+	}
+	summary := snap.ChannelSummary()
+	missing := make([]string, 0)
+	for _, serverId := range getSortedKeys(snap.completion) {
+		server, ok := sim.servers[serverId]
+		if !ok {
+			continue
+		}
+		for _, dest := range getSortedKeys(server.outboundLinks) {
+			if _, ok := snap.completion[dest]; !ok {
+				continue
 			}
-			for _, v := range rec.messages {
-				msg = append(msg, v)
+			channel := ChannelId{serverId, dest}
+			if _, ok := summary[channel]; !ok {
+				missing = append(missing, channel.String())
 			}
-			cnt++
-			if cnt == len(sim.servers) {
-				snap := SnapshotState{snapshotId, tk, msg}
-				return &snap
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Snapshot %v is missing channels: %v",
+			snapshotId, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Run all the consistency checks available on a collected snapshot: every server
+// recorded its tokens, every channel was recorded, no markers were recorded as
+// channel state and the recorded tokens add up to the expected total.
+func (sim *Simulator) ValidateSnapshot(snapshotId int, expectedTotal int) error {
+	snap, err := sim.collectedSnapshot(snapshotId)
+	if err != nil {
+		return err
+	}
+	if snap.id != snapshotId {
+		return fmt.Errorf("Snapshot %v was collected with ID %v", snapshotId, snap.id)
+	}
+	if crashed := snap.CrashedServers(); len(crashed) > 0 {
+		return fmt.Errorf("Snapshot %v excludes crashed servers: %v",
+			snapshotId, strings.Join(crashed, ", "))
+	}
+	for _, serverId := range getSortedKeys(snap.completion) {
+		if _, ok := snap.tokens[serverId]; !ok {
+			return fmt.Errorf("Snapshot %v has no tokens recorded for %v", snapshotId, serverId)
+		}
+	}
+	if err := sim.AssertChannelCoverage(snapshotId); err != nil {
+		return err
+	}
+	if err := sim.AssertNoDoubleCounting(snapshotId); err != nil {
+		return err
+	}
+	total := 0
+	for _, tokens := range snap.tokens {
+		total += tokens
+	}
+	for _, msg := range snap.messages {
+		switch m := msg.message.(type) {
+		case TokenMessage:
+			if m.tokenType == DefaultTokenType {
+				total += m.numTokens
 			}
+		case MarkerMessage:
+			return fmt.Errorf("Snapshot %v recorded %v on channel %v -> %v",
+				snapshotId, m, msg.src, msg.dest)
+		}
+	}
+	for channel := range snap.aggregated {
+		total += snap.aggregatedTokens(channel, DefaultTokenType)
+	}
+	if total != expectedTotal {
+		return fmt.Errorf("Snapshot %v: expected %v tokens, snapshot has %v",
+			snapshotId, expectedTotal, total)
+	}
+	return nil
+}
+
+// Validate each of the given concurrent snapshots independently, and verify that
+// none of them shares or overwrote the local state recorded for another one.
+// Only the local states of the servers that took part in a snapshot and whose
+// state it includes are compared, and snapshots released since they were
+// collected are only validated.
+func (sim *Simulator) AssertConcurrentConsistency(ids []int, expectedTotal int) error {
+	seen := make(map[*SnapshotState]int)
+	for _, snapshotId := range ids {
+		if err := sim.ValidateSnapshot(snapshotId, expectedTotal); err != nil {
+			return err
+		}
+		collector, ok := sim.collectors[snapshotId]
+		if !ok {
+			continue
+		}
+		snap, _ := sim.collected.Load(snapshotId)
+		for _, serverId := range collector.participantIds() {
+			server, ok := sim.servers[serverId]
+			if !ok || !snap.completion[serverId] || server.isPruned(snapshotId) {
+				continue
+			}
+			local := server.snapshot[snapshotId]
+			if local == nil || local.id != snapshotId {
+				return fmt.Errorf("Snapshot %v: %v has no local state of its own",
+					snapshotId, serverId)
+			}
+			if other, ok := seen[local]; ok {
+				return fmt.Errorf("Snapshots %v and %v share the local state of %v",
+					other, snapshotId, serverId)
+			}
+			seen[local] = snapshotId
+		}
+	}
+	return nil
+}
+
+// Return the sorted IDs of the snapshots that are still in progress but have
+// not sent or received any markers in the last `afterSteps` time steps
+func (sim *Simulator) StalledSnapshots(afterSteps int) []int {
+	recent := sim.logger.EventsBetween(sim.time-afterSteps+1, sim.time)
+	stalled := make([]int, 0)
+	for snapshotId := range sim.collectors {
+		if sim.finishedMap[snapshotId] >= sim.numParticipants(snapshotId) {
+			continue
+		}
+		progressed := false
+		for _, logEvent := range recent {
+			switch evt := logEvent.event.(type) {
+			case SentMessageEvent:
+				progressed = progressed || carriesMarker(evt.message, snapshotId)
+			case ReceivedMessageEvent:
+				progressed = progressed || carriesMarker(evt.message, snapshotId)
+			}
+		}
+		if !progressed {
+			stalled = append(stalled, snapshotId)
+		}
+	}
+	sort.Ints(stalled)
+	return stalled
+}
+
+// Run the scenario twice on fresh simulators created with `NewSimulatorWithSeed`
+// from the same seed, and compare the event logs of the two runs.
+// Return true if the logs match, or false along with a description of the first
+// divergence otherwise.
+func CompareRuns(scenario func(*Simulator), seed int64) (bool, string) {
+	runs := make([][]string, 0)
+	for i := 0; i < 2; i++ {
+		sim := NewSimulatorWithSeed(seed)
+		scenario(sim)
+		runs = append(runs, sim.logger.Lines())
+	}
+	first, second := runs[0], runs[1]
+	for i := 0; i < len(first) || i < len(second); i++ {
+		line1, line2 := "<end of log>", "<end of log>"
+		if i < len(first) {
+			line1 = first[i]
+		}
+		if i < len(second) {
+			line2 = second[i]
+		}
+		if line1 != line2 {
+			return false, fmt.Sprintf("Event %v differs:\n\t%v\n\t%v", i, line1, line2)
 		}
 	}
+	return true, ""
 }
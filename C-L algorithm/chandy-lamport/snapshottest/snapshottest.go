@@ -0,0 +1,104 @@
+// Package snapshottest provides helpers for tests of code built on the
+// snapshot simulator, so that each test file does not have to re-implement the
+// verification of the snapshots it takes.
+//
+// A table-driven test typically loads a topology, takes a snapshot and checks it:
+//
+//	sim, _ := chandy_lamport.LoadTopologyWithSeed("3nodes.top", 1)
+//	snap := snapshottest.TakeSnapshot(t, sim, "N1")
+//	snapshottest.AssertTokenConservation(t, sim, snap)
+//	snapshottest.AssertGolden(t, "testdata/3nodes.golden.json", snap)
+package snapshottest
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	chandy_lamport "chandy-lamport"
+)
+
+// Environment variable that makes `AssertGolden` write the golden files
+// instead of comparing against them, e.g. UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// Start a snapshot on the given server, run the simulation until all the
+// messages are delivered and return the collected snapshot
+func TakeSnapshot(t testing.TB, sim *chandy_lamport.Simulator, initiator string) *chandy_lamport.SnapshotState {
+	t.Helper()
+	if err := sim.StartSnapshot(initiator); err != nil {
+		t.Fatalf("Failed to start a snapshot on %v: %v\n", initiator, err)
+		return nil
+	}
+	ids := sim.SnapshotIds()
+	snapshotId := ids[len(ids)-1]
+	sim.Drain()
+	snap, ok := sim.TryCollectSnapshot(snapshotId)
+	if !ok {
+		t.Fatalf("Snapshot %v started on %v did not complete\n", snapshotId, initiator)
+		return nil
+	}
+	return snap
+}
+
+// Fail the test if the two snapshots recorded different tokens on a server or
+// different messages on a channel
+func AssertSnapshotEquals(t testing.TB, want, got *chandy_lamport.SnapshotState) {
+	t.Helper()
+	if diff := chandy_lamport.DiffSnapshots(want, got); !diff.Empty() {
+		t.Fatalf("Snapshots differ:\n%v\n", diff)
+	}
+}
+
+// Fail the test if the snapshot is not the one collected by the simulation
+// under its ID, or if it does not pass `Simulator.ValidateSnapshot` with the
+// tokens the system started with, of the default type and of every other type
+func AssertTokenConservation(t testing.TB, sim *chandy_lamport.Simulator, snap *chandy_lamport.SnapshotState) {
+	t.Helper()
+	collected, ok := sim.TryCollectSnapshot(snap.Id())
+	if !ok {
+		t.Fatalf("Snapshot %v was not collected by the simulation\n", snap.Id())
+		return
+	}
+	if diff := chandy_lamport.DiffSnapshots(collected, snap); !diff.Empty() {
+		t.Fatalf("Snapshot %v differs from the one collected by the simulation:\n%v\n", snap.Id(), diff)
+		return
+	}
+	if err := sim.ValidateSnapshot(snap.Id(), sim.InitialTokens()); err != nil {
+		t.Fatalf("%v\n", err)
+		return
+	}
+	if err := sim.ValidateSnapshotTypes(snap.Id(), sim.InitialTypedTokens()); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+}
+
+// Fail the test if the snapshot differs from the one stored as JSON in the
+// golden file. If the `UpdateGoldenEnv` environment variable is set, the
+// snapshot is written to the golden file instead.
+func AssertGolden(t testing.TB, fileName string, snap *chandy_lamport.SnapshotState) {
+	t.Helper()
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		b, err := json.MarshalIndent(snap, "", "  ")
+		if err == nil {
+			err = os.WriteFile(fileName, append(b, '\n'), 0644)
+		}
+		if err != nil {
+			t.Fatalf("Failed to update %v: %v\n", fileName, err)
+		}
+		return
+	}
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Failed to read %v: %v\n", fileName, err)
+		return
+	}
+	want := &chandy_lamport.SnapshotState{}
+	if err := json.Unmarshal(b, want); err != nil {
+		t.Fatalf("Failed to parse %v: %v\n", fileName, err)
+		return
+	}
+	if diff := chandy_lamport.DiffSnapshots(want, snap); !diff.Empty() {
+		t.Fatalf("Snapshot differs from %v (set %v=1 to update it):\n%v\n", fileName, UpdateGoldenEnv, diff)
+	}
+}
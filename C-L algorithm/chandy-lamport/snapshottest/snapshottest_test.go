@@ -0,0 +1,86 @@
+package snapshottest
+
+import (
+	"fmt"
+	"testing"
+
+	chandy_lamport "chandy-lamport"
+)
+
+// A test that records its failure instead of stopping
+type failureRecorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *failureRecorder) Helper() {}
+
+func (r *failureRecorder) Fatalf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+}
+
+func loadTopology(t *testing.T) *chandy_lamport.Simulator {
+	sim, err := chandy_lamport.LoadTopologyWithSeed("../test_data/3nodes.top", 8053172852482175524)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+// Send tokens and wait for them to be delivered
+func send(t *testing.T, sim *chandy_lamport.Simulator, src, dest string, numTokens int) {
+	if err := sim.RunScenario(chandy_lamport.NewScenario().Send(0, src, dest, numTokens)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	tests := []struct {
+		src, dest string
+		tokens    int
+		initiator string
+		golden    string
+	}{
+		{"N1", "N2", 3, "N1", "testdata/3nodes-N1.golden.json"},
+		{"N2", "N3", 2, "N3", "testdata/3nodes-N3.golden.json"},
+	}
+	for _, test := range tests {
+		sim := loadTopology(t)
+		send(t, sim, test.src, test.dest, test.tokens)
+		snap := TakeSnapshot(t, sim, test.initiator)
+		AssertTokenConservation(t, sim, snap)
+		AssertGolden(t, test.golden, snap)
+		AssertSnapshotEquals(t, snap, snap)
+	}
+}
+
+func TestHelpersReportFailures(t *testing.T) {
+	sim := loadTopology(t)
+	before := TakeSnapshot(t, sim, "N1")
+	send(t, sim, "N1", "N2", 3)
+	after := TakeSnapshot(t, sim, "N1")
+
+	r := &failureRecorder{TB: t}
+	AssertSnapshotEquals(r, before, after)
+	if r.failure == "" {
+		t.Fatalf("Expected different snapshots to fail the comparison\n")
+	}
+	// Compare against the golden file even when updating the others
+	t.Setenv(UpdateGoldenEnv, "")
+	r = &failureRecorder{TB: t}
+	AssertGolden(r, "testdata/3nodes-N3.golden.json", after)
+	if r.failure == "" {
+		t.Fatalf("Expected a different snapshot to fail the golden comparison\n")
+	}
+	// A snapshot that is not the one collected under its ID is rejected
+	r = &failureRecorder{TB: t}
+	AssertTokenConservation(r, loadTopology(t), after)
+	if r.failure == "" {
+		t.Fatalf("Expected an uncollected snapshot to fail the conservation check\n")
+	}
+	r = &failureRecorder{TB: t}
+	TakeSnapshot(r, sim, "N9")
+	if r.failure == "" {
+		t.Fatalf("Expected starting a snapshot on an unknown server to fail\n")
+	}
+}
@@ -0,0 +1,45 @@
+package chandy_lamport
+
+import "strconv"
+
+// The local state of the application running on a server, as recorded by a
+// snapshot. By default, the state of a server is its number of tokens, but any
+// state can be captured, e.g. a key-value store, by setting an implementation
+// with `Server.SetApplicationState`.
+type ApplicationState interface {
+	// Return an encoding of the current state. This is called when the server
+	// records its local state, and the result must not change afterwards.
+	Snapshot() []byte
+}
+
+// The built-in application state: the number of tokens held by a server, in
+// decimal
+type tokenState struct {
+	server *Server
+}
+
+func (s tokenState) Snapshot() []byte {
+	return []byte(strconv.Itoa(s.server.Tokens))
+}
+
+// Capture the given state instead of the number of tokens when this server
+// records its local state. Tokens are still recorded in the snapshot. A nil
+// state restores the default.
+func (server *Server) SetApplicationState(state ApplicationState) {
+	server.state = state
+}
+
+// Return the application state recorded by this server
+func (server *Server) applicationState() ApplicationState {
+	if server.state == nil {
+		return tokenState{server}
+	}
+	return server.state
+}
+
+// Return the application state recorded by a server in this snapshot, or false
+// if the server did not record its state
+func (s *SnapshotState) ApplicationState(serverId string) ([]byte, bool) {
+	state, ok := s.appState[serverId]
+	return state, ok
+}
@@ -0,0 +1,59 @@
+package chandy_lamport
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// A key-value store recorded as sorted "key=value" lines
+type kvState map[string]string
+
+func (s kvState) Snapshot() []byte {
+	lines := make([]string, 0)
+	for k, v := range s {
+		lines = append(lines, k+"="+v)
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func TestApplicationState(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	store := kvState{"a": "1"}
+	sim.servers["N2"].SetApplicationState(store)
+	sim.StartSnapshot("N1")
+	store["b"] = "2"
+	sim.Drain()
+	store["c"] = "3"
+	snap := sim.CollectSnapshot(0)
+
+	if state, _ := snap.ApplicationState("N1"); string(state) != "10" {
+		t.Fatalf("Expected N1 to record its 10 tokens, got %q\n", state)
+	}
+	if state, _ := snap.ApplicationState("N2"); string(state) != "a=1\nb=2" {
+		t.Fatalf("Expected N2 to record its store when it received the marker, got %q\n", state)
+	}
+	if snap.tokens["N2"] != 3 {
+		t.Fatalf("Expected N2 to still record its 3 tokens, got %v\n", snap.tokens["N2"])
+	}
+	if _, ok := snap.ApplicationState("N4"); ok {
+		t.Fatalf("Expected no state for an unknown server\n")
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SnapshotState
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := decoded.ApplicationState("N2"); string(state) != "a=1\nb=2" {
+		t.Fatalf("Expected the state of N2 to survive a JSON round trip, got %q\n", state)
+	}
+}
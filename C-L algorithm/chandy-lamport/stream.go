@@ -0,0 +1,80 @@
+package chandy_lamport
+
+// The contribution of a server to a snapshot, as streamed by `StreamSnapshot`
+type ServerSnapshotPart struct {
+	SnapshotId int
+	ServerId   string
+	// The local state recorded by the server, or the one recorded in its place
+	// if it crashed before completing the snapshot
+	State *SnapshotState
+	// Time step at which the state reached the simulator, or at which the
+	// stream was opened for the states that reached it before
+	Time int
+	// Number of parts streamed so far, this one included, and number of
+	// servers taking part in the snapshot at that time
+	Reported     int
+	Participants int
+}
+
+// Return a channel that yields the local state of every server taking part in
+// the snapshot as it reaches the simulator, so that partial progress can be
+// shown without waiting for `CollectSnapshot`. The states reported before the
+// call are yielded first, and the channel is closed once every participant
+// has reported. The channel holds as many parts as there are participants, so
+// the simulation only blocks on it if servers join the snapshot and the parts
+// are not read. The channel is closed right away if the snapshot is unknown,
+// or was released when it was collected. This must be called from the
+// goroutine driving the simulation, but the channel can be read from any.
+func (sim *Simulator) StreamSnapshot(snapshotId int) <-chan ServerSnapshotPart {
+	c, ok := sim.collectors[snapshotId]
+	if !ok {
+		stream := make(chan ServerSnapshotPart)
+		close(stream)
+		return stream
+	}
+	c.lock.Lock()
+	stream := make(chan ServerSnapshotPart, len(c.participants))
+	for i, snap := range c.records {
+		stream <- ServerSnapshotPart{snapshotId, reportingServer(snap), snap, sim.time, i + 1, len(c.participants)}
+	}
+	if len(c.records) >= len(c.participants) {
+		close(stream)
+	} else {
+		c.streams = append(c.streams, stream)
+	}
+	c.lock.Unlock()
+	return stream
+}
+
+// Hand a local state over to the collector of the snapshot and to the streams
+// of the snapshot
+func (sim *Simulator) collect(snapshotId int, c *snapshotCollector, snap *SnapshotState) {
+	if !c.report(snap) {
+		return
+	}
+	c.lock.Lock()
+	streams := c.streams
+	part := ServerSnapshotPart{snapshotId, reportingServer(snap), snap, sim.time, len(c.records), len(c.participants)}
+	done := len(c.records) >= len(c.participants)
+	if done {
+		c.streams = nil
+	}
+	c.lock.Unlock()
+	for _, stream := range streams {
+		stream <- part
+		if done {
+			close(stream)
+		}
+	}
+}
+
+// Return the ID of the server a local state was recorded by, or in place of
+func reportingServer(snap *SnapshotState) string {
+	if len(snap.crashed) > 0 {
+		return snap.crashed[0]
+	}
+	for serverId := range snap.tokens {
+		return serverId
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStreamSnapshot(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	sim.StartSnapshot("N1")
+	stream := sim.StreamSnapshot(0)
+	parts := make(chan []ServerSnapshotPart)
+	go func() {
+		received := make([]ServerSnapshotPart, 0)
+		for part := range stream {
+			received = append(received, part)
+		}
+		parts <- received
+	}()
+	sim.Drain()
+	received := <-parts
+	if len(received) != 8 {
+		t.Fatalf("Expected a part per server, got %v\n", len(received))
+	}
+	serverIds := make([]string, 0)
+	for i, part := range received {
+		if part.Reported != i+1 || part.Participants != 8 || part.SnapshotId != 0 || part.State == nil {
+			t.Fatalf("Expected part %v of 8, got %+v\n", i+1, part)
+		}
+		if i > 0 && part.Time < received[i-1].Time {
+			t.Fatalf("Expected the parts in the order the servers completed the snapshot\n")
+		}
+		serverIds = append(serverIds, part.ServerId)
+	}
+	sort.Strings(serverIds)
+	if expected := sim.sortedServerIds(); !reflect.DeepEqual(serverIds, expected) {
+		t.Fatalf("Expected the parts of %v, got %v\n", expected, serverIds)
+	}
+}
+
+func TestStreamSnapshotReplay(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.CrashServer("N3")
+	sim.StartSnapshot("N1")
+	sim.servers["N1"].SetSnapshotDeadline(0, 10)
+	sim.servers["N2"].SetSnapshotDeadline(0, 10)
+	for i := 0; i < 20; i++ {
+		sim.Tick()
+	}
+	// The states reported before the stream was opened are replayed
+	received := make(map[string]bool)
+	for part := range sim.StreamSnapshot(0) {
+		received[part.ServerId] = true
+	}
+	if len(received) != 3 || !received["N3"] {
+		t.Fatalf("Expected a part per server, including the crashed N3, got %v\n", received)
+	}
+	if _, ok := <-sim.StreamSnapshot(5); ok {
+		t.Fatalf("Expected the stream of an unknown snapshot to be closed\n")
+	}
+}
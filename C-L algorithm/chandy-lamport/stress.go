@@ -0,0 +1,107 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Start a snapshot on the given server under the next free snapshot ID and
+// return that ID, or -1 if the snapshot could not be started, e.g. because the
+// server does not exist or has crashed. IDs increase with every snapshot
+// started, so any number of snapshots can run at the same time without the
+// caller keeping track of them. An empty server ID is resolved by the
+// initiator policy, as with `StartSnapshot`.
+func (sim *Simulator) StartSnapshotAuto(serverId string) int {
+	snapshotId, err := sim.startSnapshot(serverId)
+	if err != nil {
+		return -1
+	}
+	return snapshotId
+}
+
+// The markers delivered on every channel, by snapshot, see `EnableIsolationChecks`
+type markerLedger struct {
+	// Snapshots started before the ledger was kept are not checked against it
+	firstSnapshotId int
+	delivered       map[int]map[ChannelId]int
+}
+
+// Verify after every tick that the marker state of each snapshot only reflects
+// markers of that snapshot, see `CheckSnapshotIsolation`. The simulation panics
+// as soon as the check fails. This is meant for runs with many concurrent
+// snapshots, e.g. started with `StartSnapshotAuto`, where batched or
+// piggybacked markers could otherwise be credited to the wrong snapshot.
+func (sim *Simulator) EnableIsolationChecks() {
+	if sim.markers == nil {
+		sim.markers = &markerLedger{sim.nextSnapshotId, make(map[int]map[ChannelId]int)}
+	}
+}
+
+// Count the markers carried by a message delivered on a channel, by snapshot
+func (sim *Simulator) recordMarkers(message interface{}, src, dest string) {
+	if sim.markers == nil {
+		return
+	}
+	for _, snapshotId := range markerSnapshotIds(message) {
+		if sim.markers.delivered[snapshotId] == nil {
+			sim.markers.delivered[snapshotId] = make(map[ChannelId]int)
+		}
+		sim.markers.delivered[snapshotId][ChannelId{src, dest}]++
+	}
+}
+
+// Return true if no marker of the snapshot was delivered on the channel, as
+// far as the ledger knows
+func (ledger *markerLedger) missing(snapshotId int, channel ChannelId) bool {
+	if ledger == nil || snapshotId < ledger.firstSnapshotId {
+		return false
+	}
+	return ledger.delivered[snapshotId][channel] == 0
+}
+
+// Return an error describing the first server whose bookkeeping for a snapshot
+// bleeds into another one: state recorded under the ID of a snapshot that was
+// never started, a local state filed under the wrong ID, or a channel closed
+// for a snapshot without a marker of that snapshot arriving on it. The markers
+// are only checked against the ones delivered, for the snapshots started after
+// `EnableIsolationChecks`.
+func (sim *Simulator) CheckSnapshotIsolation() error {
+	for _, serverId := range sim.sortedServerIds() {
+		server := sim.servers[serverId]
+		snapshotIds := make([]int, 0)
+		for snapshotId := range server.receivedSnapshot {
+			snapshotIds = append(snapshotIds, snapshotId)
+		}
+		sort.Ints(snapshotIds)
+		for _, snapshotId := range snapshotIds {
+			if snapshotId < 0 || snapshotId >= sim.nextSnapshotId {
+				return fmt.Errorf("Server %v has state for snapshot %v, which was never started",
+					serverId, snapshotId)
+			}
+			if snap, ok := server.snapshot[snapshotId]; ok && snap.id != snapshotId {
+				return fmt.Errorf("Server %v filed the local state of snapshot %v under snapshot %v",
+					serverId, snap.id, snapshotId)
+			}
+			for _, src := range getSortedKeys(server.inReceivedMarker[snapshotId]) {
+				if !server.inReceivedMarker[snapshotId][src] {
+					continue
+				}
+				if _, ok := server.markerArrival[snapshotId][src]; !ok {
+					return fmt.Errorf("Server %v closed the channel from %v for snapshot %v without a marker arrival",
+						serverId, src, snapshotId)
+				}
+				if sim.markers.missing(snapshotId, ChannelId{src, serverId}) {
+					return fmt.Errorf("Server %v closed the channel from %v for snapshot %v, but no marker of that snapshot was delivered on it",
+						serverId, src, snapshotId)
+				}
+			}
+		}
+		for snapshotId := range server.inReceivedMarker {
+			if !server.receivedSnapshot[snapshotId] {
+				return fmt.Errorf("Server %v tracks markers of snapshot %v, which it has not started",
+					serverId, snapshotId)
+			}
+		}
+	}
+	return nil
+}
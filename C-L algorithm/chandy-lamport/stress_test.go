@@ -0,0 +1,124 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStartSnapshotAuto(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	for i, serverId := range []string{"N1", "N2", "N1"} {
+		if snapshotId := sim.StartSnapshotAuto(serverId); snapshotId != i {
+			t.Fatalf("Expected snapshot %v to be started on %v, got %v\n", i, serverId, snapshotId)
+		}
+	}
+	if snapshotId := sim.StartSnapshotAuto("N4"); snapshotId != -1 {
+		t.Fatalf("Expected no snapshot on an unknown server, got %v\n", snapshotId)
+	}
+	if snapshotId := sim.StartSnapshotAuto("N3"); snapshotId != 3 {
+		t.Fatalf("Expected a failed start not to use up an ID, got %v\n", snapshotId)
+	}
+	sim.Drain()
+	for snapshotId := 0; snapshotId < 4; snapshotId++ {
+		sim.CollectSnapshot(snapshotId)
+		if err := sim.ValidateSnapshot(snapshotId, sim.InitialTokens()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestManyConcurrentSnapshots(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	sim.CoalesceMarkers = true
+	sim.EnableInvariantChecks()
+	sim.EnableIsolationChecks()
+	serverIds := sim.sortedServerIds()
+	snapshotIds := make([]int, 0)
+	for i := 0; i < 48; i++ {
+		if src := serverIds[rand.Intn(len(serverIds))]; sim.servers[src].Tokens > 0 {
+			sim.InjectEvent(PassTokenEvent{src, sim.servers[src].neighborIds()[0], 1})
+		}
+		snapshotIds = append(snapshotIds, sim.StartSnapshotAuto(serverIds[rand.Intn(len(serverIds))]))
+		if i%3 == 0 {
+			sim.Tick()
+		}
+	}
+	inProgress := 0
+	for _, snapshotId := range snapshotIds {
+		if sim.snapshotInProgress(snapshotId) {
+			inProgress++
+		}
+	}
+	if inProgress < 24 {
+		t.Fatalf("Expected dozens of snapshots in progress at once, got %v\n", inProgress)
+	}
+	sim.Drain()
+	for i, snapshotId := range snapshotIds {
+		if snapshotId != i {
+			t.Fatalf("Expected snapshot IDs to increase from 0, got %v\n", snapshotIds)
+		}
+		sim.CollectSnapshot(snapshotId)
+		if err := sim.ValidateSnapshot(snapshotId, sim.InitialTokens()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotIsolationLeak(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.EnableIsolationChecks()
+	sim.StartSnapshotAuto("N1")
+	sim.Drain()
+	sim.StartSnapshotAuto("N3")
+	if err := sim.CheckSnapshotIsolation(); err != nil {
+		t.Fatal(err)
+	}
+	// Credit the marker of snapshot 0 from N1 to snapshot 1 as well
+	server := sim.servers["N2"]
+	server.recordLocalState(1)
+	server.closeChannel(1, "N1")
+	err := sim.CheckSnapshotIsolation()
+	if err == nil || !strings.Contains(err.Error(), "no marker of that snapshot") {
+		t.Fatalf("Expected the channel closed without a marker to be reported, got %v\n", err)
+	}
+	delete(server.inReceivedMarker[1], "N1")
+	server.snapshot[1] = server.snapshot[0]
+	err = sim.CheckSnapshotIsolation()
+	if err == nil || !strings.Contains(err.Error(), "under snapshot 1") {
+		t.Fatalf("Expected the state filed under the wrong ID to be reported, got %v\n", err)
+	}
+}
+
+func TestIsolationChecksSaved(t *testing.T) {
+	sim := NewSimulatorWithSeed(8053172852482175524)
+	readTopology("8nodes.top", sim)
+	sim.EnableIsolationChecks()
+	sim.StartSnapshotAuto("N1")
+	sim.Tick()
+	sim.Tick()
+	var buf bytes.Buffer
+	if err := sim.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSimulator(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.markers, sim.markers) {
+		t.Fatalf("Expected the markers delivered so far to be saved, got %+v instead of %+v\n",
+			loaded.markers, sim.markers)
+	}
+	loaded.Drain()
+	if err := loaded.CheckSnapshotIsolation(); err != nil {
+		t.Fatal(err)
+	}
+}
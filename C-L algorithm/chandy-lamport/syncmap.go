@@ -4,34 +4,32 @@ import "sync"
 
 // An implementation of a map that synchronizes read and write accesses.
 // Note: This class intentionally adopts the interface of `sync.Map`,
-// which is introduced in Go 1.9+ but not available before that.
-// This provides a simplified version of the same class without
-// requiring the user to upgrade their Go installation.
-type SyncMap struct {
-	internalMap map[interface{}]interface{}
+// but with typed keys and values, so that callers need no type assertions.
+type SyncMap[K comparable, V any] struct {
+	internalMap map[K]V
 	lock sync.RWMutex
 }
 
-func NewSyncMap() *SyncMap {
-	m := SyncMap{}
-	m.internalMap = make(map[interface{}]interface{})
+func NewSyncMap[K comparable, V any]() *SyncMap[K, V] {
+	m := SyncMap[K, V]{}
+	m.internalMap = make(map[K]V)
 	return &m
 }
 
-func (m *SyncMap) Load(key interface{}) (value interface{}, ok bool) {
+func (m *SyncMap[K, V]) Load(key K) (value V, ok bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 	value, ok = m.internalMap[key]
 	return
 }
 
-func (m *SyncMap) Store(key, value interface{}) {
+func (m *SyncMap[K, V]) Store(key K, value V) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.internalMap[key] = value
 }
 
-func (m *SyncMap) LoadOrStore(key, value interface{}) (interface{}, bool) {
+func (m *SyncMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	existingValue, ok := m.internalMap[key]
@@ -42,13 +40,13 @@ func (m *SyncMap) LoadOrStore(key, value interface{}) (interface{}, bool) {
 	return value, false
 }
 
-func (m *SyncMap) Delete(key interface{}) {
+func (m *SyncMap[K, V]) Delete(key K) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	delete(m.internalMap, key)
 }
 
-func (m *SyncMap) Range(f func(key, value interface{}) bool) {
+func (m *SyncMap[K, V]) Range(f func(key K, value V) bool) {
 	m.lock.RLock()
 	for k, v := range m.internalMap {
 		if !f(k, v) {
@@ -0,0 +1,67 @@
+package chandy_lamport
+
+import "fmt"
+
+// ==========================================================
+//  Termination detection, built on top of the snapshot layer
+// ==========================================================
+//
+// The computation has terminated once every server is idle and no application
+// message is in flight: servers only send in response to messages or injected
+// events, so nothing can happen anymore. Since this is a stable property, it
+// holds from the moment it holds in a consistent global state, which is exactly
+// what a snapshot records. A round of detection takes a snapshot and checks it;
+// rounds are repeated until one of them finds the computation terminated.
+
+// Max number of ticks a single round of detection waits for its snapshot
+const terminationRoundTicks = 1000
+
+// Run the simulation until the computation has terminated, and return the
+// snapshot that shows it: it records no message in flight, while the servers
+// were idle and no event was left to inject. A server is idle unless it mints
+// tokens, see `Server.SetGenerationRate`, in which case the computation never
+// terminates and an error is returned. Like `Drain`, this never returns if the
+// servers keep passing tokens to each other forever.
+func (sim *Simulator) DetectTermination() (*SnapshotState, error) {
+	for {
+		initiator := ""
+		for _, serverId := range getSortedKeys(sim.servers) {
+			server := sim.servers[serverId]
+			if server.crashed {
+				return nil, newError(ErrServerCrashed,
+					"Cannot detect termination while %v has crashed", serverId)
+			}
+			if server.generationRate > 0 {
+				return nil, fmt.Errorf("Server %v mints tokens, so the computation never terminates",
+					serverId)
+			}
+			if initiator == "" {
+				initiator = serverId
+			}
+		}
+		if initiator == "" {
+			return nil, fmt.Errorf("Cannot detect termination without servers")
+		}
+		// Events left to inject will make the servers active again
+		scheduled := len(sim.schedule) > 0
+		snapshotId := sim.nextSnapshotId
+		if err := sim.StartSnapshot(initiator); err != nil {
+			return nil, err
+		}
+		snap, err := sim.CollectSnapshotWithTimeout(snapshotId, terminationRoundTicks)
+		if err != nil {
+			return nil, err
+		}
+		if !scheduled && snap.quiescent() {
+			return snap, nil
+		}
+		sim.Tick()
+	}
+}
+
+// Return true if the snapshot records every channel completely, and records no
+// message on any of them
+func (s *SnapshotState) quiescent() bool {
+	return len(s.messages) == 0 && len(s.aggregated) == 0 && len(s.overflow) == 0 &&
+		len(s.unknownChannels) == 0
+}
@@ -0,0 +1,40 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestDetectTermination(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	// Tokens keep moving for a while: N2 passes what it receives on to N3
+	sim.servers["N2"].SetForwarding("N3")
+	for i := 0; i < 5; i++ {
+		sim.InjectEvent(PassTokenEvent{"N1", "N2", 1})
+	}
+	sim.ScheduleEvent(30, PassTokenEvent{"N1", "N2", 2})
+	snap, err := sim.DetectTermination()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.time < 30 {
+		t.Fatalf("Expected the scheduled event to delay termination, detected at time %v\n", sim.time)
+	}
+	if inFlight := sim.AllInFlight(); len(inFlight) != 0 {
+		t.Fatalf("Expected no message in flight after termination, got %v\n", inFlight)
+	}
+	if snap.tokens["N3"] != 7 || sim.servers["N3"].Tokens != 7 {
+		t.Fatalf("Expected N3 to end up with the 7 forwarded tokens, got %v\n", snap.tokens)
+	}
+}
+
+func TestDetectTerminationNeverTerminates(t *testing.T) {
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.servers["N1"].SetGenerationRate(1)
+	if _, err := sim.DetectTermination(); err == nil {
+		t.Fatalf("Expected an error for a server that mints tokens\n")
+	}
+}
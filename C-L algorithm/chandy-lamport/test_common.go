@@ -67,6 +67,13 @@ func readTopology(fileName string, sim *Simulator) {
 	}
 }
 
+// Return the link between two servers, terminating if it does not exist
+func mustGetLink(sim *Simulator, src, dest string) *Link {
+	link, err := sim.GetLink(src, dest)
+	checkError(err)
+	return link
+}
+
 // Read the events from a ".events" file and inject the events into the simulator.
 // The expected format of the file is as follows:
 // 	- "tick N" indicates N time steps has elapsed (default N = 1)
@@ -96,12 +103,16 @@ func injectEvents(fileName string, sim *Simulator) []*SnapshotState {
 			dest := parts[2]
 			tokens, err := strconv.Atoi(parts[3])
 			checkError(err)
-			sim.InjectEvent(PassTokenEvent{src, dest, tokens})
+			if err := sim.InjectEvent(PassTokenEvent{src, dest, tokens}); err != nil {
+				log.Fatal(err)
+			}
 		case "snapshot":
 			numSnapshots++
 			serverId := parts[1]
 			snapshotId := sim.nextSnapshotId
-			sim.InjectEvent(SnapshotEvent{serverId})
+			if err := sim.InjectEvent(SnapshotEvent{serverId}); err != nil {
+				log.Fatal(err)
+			}
 			go func(id int) {
 				getSnapshots <- sim.CollectSnapshot(id)
 			}(snapshotId)
@@ -148,7 +159,7 @@ func injectEvents(fileName string, sim *Simulator) []*SnapshotState {
 func readSnapshot(fileName string) *SnapshotState {
 	b, err := ioutil.ReadFile(path.Join(testDir, fileName))
 	checkError(err)
-	snapshot := SnapshotState{0, make(map[string]int), make([]*SnapshotMessage, 0)}
+	snapshot := SnapshotState{0, make(map[string]int), make([]*SnapshotMessage, 0), nil, nil, "", nil, nil, nil, nil, nil, nil, nil, nil}
 	lines := strings.FieldsFunc(string(b), func(r rune) bool { return r == '\n' })
 	for _, line := range lines {
 		// Ignore comments
@@ -180,12 +191,12 @@ func readSnapshot(fileName string) *SnapshotState {
 				}
 				numTokens, err := strconv.Atoi(matches[0])
 				checkError(err)
-				message = TokenMessage{numTokens}
+				message = TokenMessage{numTokens: numTokens}
 			} else {
 				log.Fatal("Unknown message: ", messageString)
 			}
 			snapshot.messages =
-				append(snapshot.messages, &SnapshotMessage{src, dest, message})
+				append(snapshot.messages, &SnapshotMessage{src, dest, message, 0, 0})
 		}
 	}
 	return &snapshot
@@ -264,8 +275,11 @@ func assertEqual(expected, actual *SnapshotState) {
 		if !ok2 {
 			actualMessages[am.dest] = make([]*SnapshotMessage, 0)
 		}
+		// Expected snapshots do not record the Lamport clocks of the messages
+		unclocked := *am
+		unclocked.lamport = 0
 		expectedMessages[em.dest] = append(expectedMessages[em.dest], em)
-		actualMessages[am.dest] = append(actualMessages[am.dest], am)
+		actualMessages[am.dest] = append(actualMessages[am.dest], &unclocked)
 	}
 	// Test message order per destination
 	for dest := range expectedMessages {
@@ -309,7 +323,9 @@ func checkTokens(sim *Simulator, snapshots []*SnapshotState) {
 		for _, message := range snap.messages {
 			switch msg := message.message.(type) {
 			case TokenMessage:
-				snapTokens += msg.numTokens
+				if msg.tokenType == DefaultTokenType {
+					snapTokens += msg.numTokens
+				}
 			}
 		}
 		if expectedTokens != snapTokens {
@@ -0,0 +1,139 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The servers of a system, with the number of tokens each of them started with,
+// and the unidirectional links between them
+type Topology struct {
+	tokens map[string]int // key = server ID
+	links  []ChannelId
+}
+
+func NewTopology() *Topology {
+	return &Topology{make(map[string]int), make([]ChannelId, 0)}
+}
+
+// Add a server to the topology with the specified number of starting tokens
+func (t *Topology) AddServer(id string, tokens int) {
+	t.tokens[id] = tokens
+}
+
+// Add a unidirectional link between two servers
+func (t *Topology) AddLink(src, dest string) {
+	t.links = append(t.links, ChannelId{src, dest})
+}
+
+// Return the sorted IDs of the servers in the topology
+func (t *Topology) Servers() []string {
+	return getSortedKeys(t.tokens)
+}
+
+// Return the links of the topology, in the order they were added
+func (t *Topology) Links() []ChannelId {
+	return append([]ChannelId{}, t.links...)
+}
+
+// Return the total number of tokens the servers started with
+func (t *Topology) TotalTokens() int {
+	total := 0
+	for _, tokens := range t.tokens {
+		total += tokens
+	}
+	return total
+}
+
+// Return the current topology of the simulation. Each server is listed with the
+// tokens it started with, so that the total matches `InitialTokens`.
+func (sim *Simulator) Topology() *Topology {
+	t := NewTopology()
+	for _, serverId := range getSortedKeys(sim.servers) {
+		t.AddServer(serverId, sim.startingTokens[serverId])
+		for _, dest := range getSortedKeys(sim.servers[serverId].outboundLinks) {
+			t.AddLink(serverId, dest)
+		}
+	}
+	return t
+}
+
+// Verify that a snapshot is a consistent cut of a system with the given topology:
+//   - every server recorded a non-negative number of tokens, and no other
+//     server did
+//   - every link of the topology was recorded as a channel, and messages were
+//     only recorded on these channels
+//   - no marker was recorded as channel state
+//   - the tokens recorded on the servers and channels add up to the total the
+//     servers started with. Since tokens are neither created nor destroyed, a
+//     larger total means that some message was recorded both in a channel and
+//     at its destination, and a smaller one that some message was missed.
+func VerifySnapshot(snap *SnapshotState, topology *Topology) error {
+	for _, serverId := range topology.Servers() {
+		if _, ok := snap.tokens[serverId]; !ok {
+			return fmt.Errorf("Snapshot %v has no tokens recorded for %v", snap.id, serverId)
+		}
+	}
+	for _, serverId := range getSortedKeys(snap.tokens) {
+		if _, ok := topology.tokens[serverId]; !ok {
+			return fmt.Errorf("Snapshot %v recorded tokens for unknown server %v", snap.id, serverId)
+		}
+	}
+	if negative := snap.HasNegativeBalances(); len(negative) > 0 {
+		return fmt.Errorf("Snapshot %v recorded negative balances for %v",
+			snap.id, strings.Join(negative, ", "))
+	}
+	recorded := make(map[ChannelId]bool)
+	for _, channel := range snap.channels {
+		recorded[channel] = true
+	}
+	for _, channel := range snap.unknownChannels {
+		recorded[channel] = true
+	}
+	missing := make([]string, 0)
+	for _, channel := range topology.links {
+		if !recorded[channel] {
+			missing = append(missing, channel.String())
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Snapshot %v is missing channels: %v",
+			snap.id, strings.Join(missing, ", "))
+	}
+	total := 0
+	for _, tokens := range snap.tokens {
+		total += tokens
+	}
+	for _, msg := range snap.messages {
+		channel := ChannelId{msg.src, msg.dest}
+		if !recorded[channel] {
+			return fmt.Errorf("Snapshot %v recorded %v on unrecorded channel %v",
+				snap.id, msg.message, channel)
+		}
+		switch m := msg.message.(type) {
+		case TokenMessage:
+			if m.tokenType == DefaultTokenType {
+				total += m.numTokens
+			}
+		case MarkerMessage:
+			return fmt.Errorf("Snapshot %v recorded %v on channel %v", snap.id, m, channel)
+		}
+	}
+	for _, channel := range snap.aggregatedChannels() {
+		if !recorded[channel] {
+			return fmt.Errorf("Snapshot %v recorded %v on unrecorded channel %v",
+				snap.id, *snap.aggregated[channel], channel)
+		}
+		total += snap.aggregatedTokens(channel, DefaultTokenType)
+	}
+	if expected := topology.TotalTokens(); total > expected {
+		return fmt.Errorf("Snapshot %v: expected %v tokens, snapshot has %v, so some tokens were counted twice",
+			snap.id, expected, total)
+	} else if total < expected {
+		return fmt.Errorf("Snapshot %v: expected %v tokens, snapshot has %v, so some tokens were missed",
+			snap.id, expected, total)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestVerifySnapshot(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	snaps := injectEvents("3nodes-bidirectional-messages.events", sim)
+	topology := sim.Topology()
+	if topology.TotalTokens() != sim.InitialTokens() {
+		t.Fatalf("Expected the topology to hold %v tokens, got %v\n",
+			sim.InitialTokens(), topology.TotalTokens())
+	}
+	for _, snap := range snaps {
+		if err := VerifySnapshot(snap, topology); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	extended := sim.Topology()
+	extended.AddLink("N3", "N4")
+	if err := VerifySnapshot(snaps[0], extended); err == nil || !strings.Contains(err.Error(), "N3 -> N4") {
+		t.Fatalf("Expected an error for a missing channel, got %v\n", err)
+	}
+
+	snap := snaps[0]
+	snap.messages = append(snap.messages, &SnapshotMessage{"N1", "N2", TokenMessage{numTokens: 1}, 0, 0})
+	if err := VerifySnapshot(snap, topology); err == nil || !strings.Contains(err.Error(), "twice") {
+		t.Fatalf("Expected an error for a message counted twice, got %v\n", err)
+	}
+	snap.messages[len(snap.messages)-1].message = MarkerMessage{snap.id}
+	if err := VerifySnapshot(snap, topology); err == nil || !strings.Contains(err.Error(), "marker") {
+		t.Fatalf("Expected an error for a recorded marker, got %v\n", err)
+	}
+}
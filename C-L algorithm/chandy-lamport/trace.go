@@ -0,0 +1,230 @@
+package chandy_lamport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Start writing a trace of the simulation to the given writer, from which
+// `ReplayTrace` reconstructs the exact same simulation. This must be called
+// once the servers and links are set up, before any events are injected.
+// The trace has one entry per line:
+//   - "server [id] [tokens]" and "link [src] [dest] [ordering] [loss]" for the
+//     topology, where the link is listed with its `OrderingPolicy` and the
+//     probability set with `InjectLoss`
+//   - "send [src] [dest] [tokens]", "snapshot [serverId]" and "tick" for the
+//     tokens passed with `InjectEvent`, the snapshots started and the ticks
+//   - "rand [value]" for every value drawn from the source of randomness
+//   - "event [quoted line]" for every event logged, which replays check
+//
+// Other changes made to the simulation, e.g. by `InjectInFlight`, `CrashServer`,
+// `RemoveLink`, `SetLatencyModel` or `InjectDuplication`, are not recorded.
+func (sim *Simulator) RecordTrace(w io.Writer) error {
+	for _, serverId := range getSortedKeys(sim.servers) {
+		if _, err := fmt.Fprintf(w, "server %v %v\n", serverId, sim.servers[serverId].Tokens); err != nil {
+			return err
+		}
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		for _, dest := range getSortedKeys(sim.servers[serverId].outboundLinks) {
+			link := sim.servers[serverId].outboundLinks[dest]
+			if _, err := fmt.Fprintf(w, "link %v %v %d %v\n",
+				serverId, dest, link.ordering, link.lossProbability); err != nil {
+				return err
+			}
+		}
+	}
+	sim.trace = w
+	sim.logger.trace = w
+	sim.random = rand.New(&tracedSource{sim.random, w})
+	return nil
+}
+
+// A source of randomness that writes every value it produces to a trace
+type tracedSource struct {
+	random *rand.Rand
+	trace  io.Writer
+}
+
+func (s *tracedSource) Int63() int64 {
+	value := s.random.Int63()
+	fmt.Fprintf(s.trace, "rand %v\n", value)
+	return value
+}
+
+func (s *tracedSource) Seed(seed int64) {
+	s.random.Seed(seed)
+}
+
+// A source of randomness that produces the values read from a trace
+type replayedSource struct {
+	values []int64
+}
+
+func (s *replayedSource) Int63() int64 {
+	if len(s.values) == 0 {
+		// The replay is diverging, which is reported by the events that follow
+		return 0
+	}
+	value := s.values[0]
+	s.values = s.values[1:]
+	return value
+}
+
+func (s *replayedSource) Seed(seed int64) {}
+
+// Reconstruct a simulation from a trace written by `RecordTrace`, by setting up
+// the same topology and running the same inputs with the same random values.
+// Every event logged by the replay is checked against the event logged at the
+// same point in the trace, and the first divergence is returned as an error.
+func ReplayTrace(r io.Reader) (*Simulator, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sim := NewSimulator()
+	source := replayedSource{make([]int64, 0)}
+	sim.random = rand.New(&source)
+	expected := make([]string, 0)  // events logged in the trace
+	expectedLine := make([]int, 0) // line of each of these events
+	for i, line := range lines {
+		parts := strings.SplitN(line, " ", 2)
+		switch parts[0] {
+		case "rand":
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("Trace line %v: missing random value: %v", i+1, line)
+			}
+			value, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Trace line %v: %v", i+1, err)
+			}
+			source.values = append(source.values, value)
+		case "event":
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("Trace line %v: missing event: %v", i+1, line)
+			}
+			event, err := strconv.Unquote(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Trace line %v: %v", i+1, err)
+			}
+			expected = append(expected, event)
+			expectedLine = append(expectedLine, i+1)
+		}
+	}
+
+	replayed := eventCollector{make([]string, 0)}
+	sim.logger.trace = &replayed
+	checked := 0
+	for i, line := range lines {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		lineError := func(format string, args ...interface{}) error {
+			return fmt.Errorf("Trace line %v: %v: %v", i+1, fmt.Sprintf(format, args...), line)
+		}
+		switch parts[0] {
+		case "server":
+			if len(parts) != 3 {
+				return nil, lineError("expected a server ID and a number of tokens")
+			}
+			tokens, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return nil, lineError("invalid number of tokens")
+			}
+			sim.AddServer(parts[1], tokens)
+		case "link":
+			if len(parts) != 5 {
+				return nil, lineError("expected a source, a destination, an ordering and a loss probability")
+			}
+			if _, ok := sim.servers[parts[1]]; !ok {
+				return nil, lineError("unknown server %v", parts[1])
+			}
+			if _, ok := sim.servers[parts[2]]; !ok {
+				return nil, lineError("unknown server %v", parts[2])
+			}
+			ordering, err := strconv.Atoi(parts[3])
+			if err != nil {
+				return nil, lineError("invalid ordering")
+			}
+			sim.AddForwardLink(parts[1], parts[2])
+			link, err := sim.GetLink(parts[1], parts[2])
+			if err != nil {
+				return nil, lineError("%v", err)
+			}
+			link.SetOrdering(OrderingPolicy(ordering))
+			loss, err := strconv.ParseFloat(parts[4], 64)
+			if err != nil {
+				return nil, lineError("invalid loss probability")
+			}
+			if err := sim.InjectLoss(parts[1], parts[2], loss); err != nil {
+				return nil, lineError("%v", err)
+			}
+		case "send":
+			if len(parts) != 4 {
+				return nil, lineError("expected a source, a destination and a number of tokens")
+			}
+			tokens, err := strconv.Atoi(parts[3])
+			if err != nil {
+				return nil, lineError("invalid number of tokens")
+			}
+			if err := sim.InjectEvent(PassTokenEvent{parts[1], parts[2], tokens}); err != nil {
+				return nil, lineError("%v", err)
+			}
+		case "snapshot":
+			if len(parts) != 2 {
+				return nil, lineError("expected a server ID")
+			}
+			if err := sim.StartSnapshot(parts[1]); err != nil {
+				return nil, lineError("%v", err)
+			}
+		case "tick":
+			sim.Tick()
+		case "rand", "event":
+		default:
+			return nil, lineError("unknown entry %v", parts[0])
+		}
+		// Check the events logged by this entry against the trace
+		for ; checked < len(replayed.events); checked++ {
+			event := replayed.events[checked]
+			if checked >= len(expected) {
+				return nil, fmt.Errorf("Replay logged an event missing from the trace: %q", event)
+			}
+			if event != expected[checked] {
+				return nil, fmt.Errorf("Trace line %v: expected event %q, replay logged %q",
+					expectedLine[checked], expected[checked], event)
+			}
+		}
+	}
+	if checked < len(expected) {
+		return nil, fmt.Errorf("Trace line %v: expected event %q, replay did not log it",
+			expectedLine[checked], expected[checked])
+	}
+	sim.logger.trace = nil
+	return sim, nil
+}
+
+// Collects the events a logger writes to its trace
+type eventCollector struct {
+	events []string
+}
+
+func (c *eventCollector) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(strings.TrimPrefix(string(p), "event "), "\n")
+	event, err := strconv.Unquote(line)
+	if err != nil {
+		return 0, err
+	}
+	c.events = append(c.events, event)
+	return len(p), nil
+}
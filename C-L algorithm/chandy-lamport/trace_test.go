@@ -0,0 +1,43 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplayTrace(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	mustGetLink(sim, "N1", "N2").SetOrdering(RandomReorder)
+	var trace bytes.Buffer
+	if err := sim.RecordTrace(&trace); err != nil {
+		t.Fatal(err)
+	}
+	injectEvents("3nodes-bidirectional-messages.events", sim)
+
+	// The replay must not depend on the global source of randomness
+	rand.Seed(1)
+	replay, err := ReplayTrace(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replay.logger.Lines(), sim.logger.Lines()) {
+		t.Fatalf("Expected the replay to log the same events as the recorded run\n")
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		if replay.servers[serverId].Tokens != sim.servers[serverId].Tokens {
+			t.Fatalf("Expected %v to hold %v tokens after the replay, got %v\n",
+				serverId, sim.servers[serverId].Tokens, replay.servers[serverId].Tokens)
+		}
+	}
+
+	tampered := strings.Replace(trace.String(), "send N1 N2 1", "send N1 N2 2", 1)
+	if _, err := ReplayTrace(strings.NewReader(tampered)); err == nil ||
+		!strings.Contains(err.Error(), "expected event") {
+		t.Fatalf("Expected the replay of a tampered trace to diverge, got %v\n", err)
+	}
+}
@@ -0,0 +1,39 @@
+package chandy_lamport
+
+// Start a snapshot the first time a server satisfies the given condition, e.g.
+//
+//	sim.SnapshotWhen(func(server *Server) bool { return server.Tokens > 100 })
+//
+// models a checkpoint taken by the application rather than scheduled from the
+// outside. The condition is checked on every server that has not crashed, in
+// sorted ID order, at the end of every tick, and the first server found to
+// satisfy it initiates the snapshot. The condition is dropped once it fires.
+func (sim *Simulator) SnapshotWhen(pred func(*Server) bool) {
+	sim.triggers = append(sim.triggers, pred)
+}
+
+// Start a snapshot for each pending condition that a server now satisfies
+func (sim *Simulator) checkSnapshotTriggers() {
+	if len(sim.triggers) == 0 {
+		return
+	}
+	pending := make([]func(*Server) bool, 0, len(sim.triggers))
+	for _, pred := range sim.triggers {
+		fired := false
+		for _, serverId := range sim.sortedServerIds() {
+			server := sim.servers[serverId]
+			if !server.crashed && pred(server) {
+				if err := sim.StartSnapshot(serverId); err != nil {
+					sim.slogger.Error("Failed to start triggered snapshot",
+						"time", sim.time, "server", serverId, "err", err)
+				}
+				fired = true
+				break
+			}
+		}
+		if !fired {
+			pending = append(pending, pred)
+		}
+	}
+	sim.triggers = pending
+}
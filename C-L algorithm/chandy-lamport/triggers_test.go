@@ -0,0 +1,35 @@
+package chandy_lamport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSnapshotWhen(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.SnapshotWhen(func(server *Server) bool { return server.Id != "N1" && server.Tokens >= 5 })
+	sim.Tick()
+	if len(sim.SnapshotIds()) != 0 {
+		t.Fatalf("Expected no snapshot before the condition holds\n")
+	}
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 4})
+	sim.Drain()
+	if ids := sim.SnapshotIds(); len(ids) != 1 || sim.initiators[0] != "N2" {
+		t.Fatalf("Expected a single snapshot started by N2, got %v started by %q\n", ids, sim.initiators[0])
+	}
+	snap := sim.CollectSnapshot(0)
+	if snap.tokens["N2"] < 5 {
+		t.Fatalf("Expected N2 to record the tokens that triggered the snapshot, got %v\n", snap.tokens)
+	}
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+	// The condition only fires once
+	sim.InjectEvent(PassTokenEvent{"N1", "N3", 6})
+	sim.Drain()
+	if ids := sim.SnapshotIds(); len(ids) != 1 {
+		t.Fatalf("Expected the condition to be dropped once it fired, got snapshots %v\n", ids)
+	}
+}
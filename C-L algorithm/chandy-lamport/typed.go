@@ -0,0 +1,135 @@
+package chandy_lamport
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The type of the tokens held in `Server.Tokens` and sent with `SendTokens`.
+// Servers can also hold tokens of other types, e.g. different currencies, which
+// are sent with `SendTypedTokens`. Each type is conserved on its own: a snapshot
+// records the tokens of every type held by the servers and in flight on the
+// channels, and `ValidateSnapshotTypes` checks the total of each type.
+const DefaultTokenType = ""
+
+// Return the number of tokens of the given type held by this server
+func (server *Server) TokensOf(tokenType string) int {
+	if tokenType == DefaultTokenType {
+		return server.Tokens
+	}
+	return server.typedTokens[tokenType]
+}
+
+// Send a number of tokens of the given type to a neighbor attached to this server
+func (server *Server) SendTypedTokens(tokenType string, numTokens int, dest string) error {
+	return server.sendTokenMessage(TokenMessage{numTokens: numTokens, tokenType: tokenType}, dest)
+}
+
+func (server *Server) addTokens(tokenType string, numTokens int) {
+	if tokenType == DefaultTokenType {
+		server.Tokens += numTokens
+		return
+	}
+	server.typedTokens[tokenType] += numTokens
+}
+
+// Return a copy of the number of tokens of each other type than the default
+// one held by this server, to be recorded in a snapshot
+func (server *Server) typedTokenCounts() map[string]int {
+	return copyTokenCounts(server.typedTokens)
+}
+
+func copyTokenCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int)
+	for tokenType, numTokens := range counts {
+		copied[tokenType] = numTokens
+	}
+	return copied
+}
+
+// Give a server tokens of a type other than the default one before the
+// simulation starts, like the tokens given to servers by `AddServer`
+func (sim *Simulator) AddTypedTokens(serverId, tokenType string, numTokens int) error {
+	server, ok := sim.servers[serverId]
+	if !ok {
+		return newError(ErrUnknownServer, "Server %v does not exist", serverId)
+	}
+	if tokenType == DefaultTokenType {
+		return fmt.Errorf("Tokens of the default type are given to servers by AddServer")
+	}
+	server.addTokens(tokenType, numTokens)
+	sim.initialTypedTokens[tokenType] += numTokens
+	return nil
+}
+
+// Return the number of tokens of each other type than the default one given to
+// the servers, see `AddTypedTokens`
+func (sim *Simulator) InitialTypedTokens() map[string]int {
+	return copyTokenCounts(sim.initialTypedTokens)
+}
+
+// Return the number of tokens of each other type than the default one recorded
+// by the snapshot, on the servers and on the channels
+func (s *SnapshotState) TypedTokens() map[string]int {
+	totals := make(map[string]int)
+	for _, counts := range s.typed {
+		for tokenType, numTokens := range counts {
+			totals[tokenType] += numTokens
+		}
+	}
+	for _, msg := range s.messages {
+		if token, ok := msg.message.(TokenMessage); ok && token.tokenType != DefaultTokenType {
+			totals[token.tokenType] += token.numTokens
+		}
+	}
+	for _, a := range s.aggregated {
+		for tokenType, numTokens := range a.tokens {
+			if tokenType != DefaultTokenType {
+				totals[tokenType] += numTokens
+			}
+		}
+	}
+	return totals
+}
+
+// Return the number of tokens of the given type recorded by the snapshot on
+// each server
+func (s *SnapshotState) TokensOf(tokenType string) map[string]int {
+	if tokenType == DefaultTokenType {
+		return copyTokenCounts(s.tokens)
+	}
+	tokens := make(map[string]int)
+	for serverId, counts := range s.typed {
+		tokens[serverId] = counts[tokenType]
+	}
+	return tokens
+}
+
+// Verify that a collected snapshot records the expected total of every type of
+// tokens other than the default one, which `ValidateSnapshot` checks.
+// Like `ValidateSnapshot`, this assumes that no tokens were lost, since the
+// tokens of the other types dropped on lossy links are not counted.
+func (sim *Simulator) ValidateSnapshotTypes(snapshotId int, expected map[string]int) error {
+	snap, err := sim.collectedSnapshot(snapshotId)
+	if err != nil {
+		return err
+	}
+	totals := snap.TypedTokens()
+	tokenTypes := make([]string, 0)
+	for tokenType := range expected {
+		tokenTypes = append(tokenTypes, tokenType)
+	}
+	for tokenType := range totals {
+		if _, ok := expected[tokenType]; !ok {
+			tokenTypes = append(tokenTypes, tokenType)
+		}
+	}
+	sort.Strings(tokenTypes)
+	for _, tokenType := range tokenTypes {
+		if totals[tokenType] != expected[tokenType] {
+			return fmt.Errorf("Snapshot %v: expected %v tokens of type %v, snapshot has %v",
+				snapshotId, expected[tokenType], tokenType, totals[tokenType])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package chandy_lamport
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestTypedTokens(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	for _, err := range []error{
+		sim.AddTypedTokens("N1", "gold", 5),
+		sim.AddTypedTokens("N2", "silver", 4),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sim.servers["N1"].SendTypedTokens("gold", 2, "N2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.servers["N2"].SendTypedTokens("silver", 3, "N3"); err != nil {
+		t.Fatal(err)
+	}
+	sim.InjectEvent(PassTokenEvent{"N1", "N3", 2})
+	sim.StartSnapshot("N3")
+	if err := sim.servers["N1"].SendTypedTokens("gold", 1, "N3"); err != nil {
+		t.Fatal(err)
+	}
+	sim.Drain()
+	snap := sim.CollectSnapshot(0)
+	// Each type is conserved on its own
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.ValidateSnapshotTypes(0, sim.InitialTypedTokens()); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"gold": 5, "silver": 4}
+	if totals := snap.TypedTokens(); !reflect.DeepEqual(totals, expected) {
+		t.Fatalf("Expected the snapshot to record %v, got %v\n", expected, totals)
+	}
+	if n := sim.servers["N1"].TokensOf("gold"); n != 2 {
+		t.Fatalf("Expected N1 to hold 2 gold tokens, got %v\n", n)
+	}
+	if n := sim.servers["N3"].TokensOf("silver"); n != 3 {
+		t.Fatalf("Expected N3 to hold 3 silver tokens, got %v\n", n)
+	}
+	if err := sim.ValidateSnapshotTypes(0, map[string]int{"gold": 5}); err == nil {
+		t.Fatalf("Expected the silver tokens to be reported\n")
+	}
+}
+
+func TestTypedTokensErrors(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	// N1 has default tokens, but none of the other type
+	err := sim.servers["N1"].SendTypedTokens("gold", 1, "N2")
+	if !errors.Is(err, ErrInsufficientTokens) {
+		t.Fatalf("Expected ErrInsufficientTokens, got %v\n", err)
+	}
+	if err := sim.AddTypedTokens("N4", "gold", 1); !errors.Is(err, ErrUnknownServer) {
+		t.Fatalf("Expected ErrUnknownServer, got %v\n", err)
+	}
+	if err := sim.AddTypedTokens("N1", DefaultTokenType, 1); err == nil {
+		t.Fatalf("Expected default tokens to be rejected\n")
+	}
+}
+
+func TestTypedTokensRoundTrip(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	sim.AddTypedTokens("N1", "gold", 5)
+	sim.servers["N1"].SendTypedTokens("gold", 2, "N2")
+	sim.StartSnapshot("N2")
+	sim.Drain()
+	snap := sim.CollectSnapshot(0)
+	data, err := snap.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SnapshotState
+	if err := decoded.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.TypedTokens(), snap.TypedTokens()) {
+		t.Fatalf("Expected %v after a round trip, got %v\n", snap.TypedTokens(), decoded.TypedTokens())
+	}
+}
@@ -0,0 +1,255 @@
+package chandy_lamport
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// A live view of the simulation in the browser, see `ServeVisualizer`.
+// After every tick, the simulation renders a frame with the servers, the
+// messages in flight and the progress of the snapshots, and the visualizer
+// streams it to every open page as a server-sent event. Server-sent events are
+// used instead of websockets since they only need the standard library and
+// the stream only flows from the simulation to the page.
+type Visualizer struct {
+	lock        sync.Mutex
+	frame       []byte // latest frame, as JSON
+	subscribers map[chan []byte]bool
+	listener    net.Listener
+	server      *http.Server
+	// Stop the simulation from rendering frames, see `Close`
+	detach func()
+}
+
+// A frame of the visualization at the end of a tick
+type visualFrame struct {
+	Time     int             `json:"time"`
+	Servers  []visualServer  `json:"servers"`
+	Links    []channelWire   `json:"links"`
+	Messages []visualMessage `json:"messages"`
+}
+
+type visualServer struct {
+	Id     string `json:"id"`
+	Tokens int    `json:"tokens"`
+	// IDs of the snapshots this server is recording, and has completed
+	Recording []int `json:"recording"`
+	Completed []int `json:"completed"`
+}
+
+type visualMessage struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+	// "token" or "marker"
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+	// How far along the link the message is drawn, from 0 to 1
+	Progress float64 `json:"progress"`
+}
+
+// Start serving the visualization on the given address, e.g. "localhost:8080".
+// Open the address in a browser and run the simulation: every tick is shown as
+// it happens. Ticking too fast for the browser skips frames.
+func (sim *Simulator) ServeVisualizer(addr string) (*Visualizer, error) {
+	if sim.visualizer != nil {
+		return nil, fmt.Errorf("The visualizer is already served on %v", sim.visualizer.Addr())
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	v := &Visualizer{subscribers: make(map[chan []byte]bool), listener: listener}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, visualizerPage)
+	})
+	mux.HandleFunc("/events", v.serveEvents)
+	v.server = &http.Server{Handler: mux}
+	v.detach = func() {
+		if sim.visualizer == v {
+			sim.visualizer = nil
+		}
+	}
+	go v.server.Serve(listener)
+	sim.visualizer = v
+	sim.refreshVisualizer()
+	return v, nil
+}
+
+// Return the address the visualization is served on
+func (v *Visualizer) Addr() string {
+	return v.listener.Addr().String()
+}
+
+// Stop serving the visualization. The simulation no longer renders frames,
+// and the visualization can be served again with `ServeVisualizer`. Close it
+// from the goroutine driving the simulation.
+func (v *Visualizer) Close() error {
+	v.detach()
+	return v.server.Close()
+}
+
+// Stream the frames to a page, starting with the latest one
+func (v *Visualizer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	frames := make(chan []byte, 64)
+	v.lock.Lock()
+	v.subscribers[frames] = true
+	latest := v.frame
+	v.lock.Unlock()
+	defer func() {
+		v.lock.Lock()
+		delete(v.subscribers, frames)
+		v.lock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "data: %s\n\n", latest)
+	flusher.Flush()
+	for {
+		select {
+		case frame := <-frames:
+			fmt.Fprintf(w, "data: %s\n\n", frame)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Render the frame of the current tick and send it to every open page
+func (sim *Simulator) refreshVisualizer() {
+	if sim.visualizer == nil {
+		return
+	}
+	frame, err := json.Marshal(sim.visualFrame())
+	if err != nil {
+		sim.slogger.Error("Failed to render the visualization", "err", err)
+		return
+	}
+	v := sim.visualizer
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.frame = frame
+	for frames := range v.subscribers {
+		select {
+		case frames <- frame:
+		default:
+			// The page is behind, it will catch up with a later frame
+		}
+	}
+}
+
+func (sim *Simulator) visualFrame() visualFrame {
+	frame := visualFrame{
+		Time:     sim.time,
+		Servers:  make([]visualServer, 0),
+		Links:    make([]channelWire, 0),
+		Messages: make([]visualMessage, 0),
+	}
+	for _, serverId := range getSortedKeys(sim.servers) {
+		server := sim.servers[serverId]
+		s := visualServer{serverId, server.Tokens, make([]int, 0), make([]int, 0)}
+		for _, snapshotId := range sim.SnapshotIds() {
+			if server.completedSnapshot[snapshotId] {
+				s.Completed = append(s.Completed, snapshotId)
+			} else if server.receivedSnapshot[snapshotId] {
+				s.Recording = append(s.Recording, snapshotId)
+			}
+		}
+		frame.Servers = append(frame.Servers, s)
+		for _, dest := range getSortedKeys(server.outboundLinks) {
+			frame.Links = append(frame.Links, channelWire{Src: serverId, Dest: dest})
+		}
+	}
+	for _, info := range sim.AllInFlight() {
+		m := visualMessage{Src: info.src, Dest: info.dest, Kind: "marker", Label: fmt.Sprint(info.message)}
+		if _, ok := tokenMessage(info.message); ok {
+			m.Kind = "token"
+		}
+		// Messages get closer to their destination as their receive time nears,
+		// and wait next to it if they cannot be delivered yet
+		m.Progress = 1
+		if delay := info.receiveTime - info.sendTime; delay > 0 {
+			m.Progress = math.Min(float64(sim.time-info.sendTime)/float64(delay), 1)
+		}
+		frame.Messages = append(frame.Messages, m)
+	}
+	return frame
+}
+
+// The page of the visualization: servers are laid out on a circle, tokens are
+// drawn as blue dots and markers as red ones along the links, and servers turn
+// orange while recording a snapshot and green once they completed it.
+const visualizerPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Chandy-Lamport snapshots</title>
+<style>
+body { font-family: sans-serif; }
+.link { stroke: #bbb; stroke-width: 1.5; marker-end: url(#arrow); }
+.server { fill: #eee; stroke: #333; }
+.server.recording { fill: #fc8; }
+.server.completed { fill: #9d9; }
+.token { fill: #36c; }
+.marker { fill: #c33; }
+circle.message { transition: cx 0.3s, cy 0.3s; }
+</style>
+</head>
+<body>
+<h3>Time <span id="time">0</span></h3>
+<svg id="graph" width="640" height="640" viewBox="-320 -320 640 640">
+<defs><marker id="arrow" viewBox="0 0 10 10" refX="28" refY="5" markerWidth="6"
+markerHeight="6" orient="auto"><path d="M 0 0 L 10 5 L 0 10 z" fill="#bbb"/></marker></defs>
+</svg>
+<script>
+const svg = document.getElementById("graph");
+const ns = "http://www.w3.org/2000/svg";
+function el(name, attrs, text) {
+  const e = document.createElementNS(ns, name);
+  for (const k in attrs) e.setAttribute(k, attrs[k]);
+  if (text !== undefined) e.textContent = text;
+  svg.appendChild(e);
+  return e;
+}
+function render(frame) {
+  document.getElementById("time").textContent = frame.time;
+  svg.querySelectorAll(".drawn").forEach(e => e.remove());
+  const pos = {};
+  frame.servers.forEach((s, i) => {
+    const angle = 2 * Math.PI * i / frame.servers.length - Math.PI / 2;
+    pos[s.id] = [250 * Math.cos(angle), 250 * Math.sin(angle)];
+  });
+  frame.links.forEach(l => {
+    const [x1, y1] = pos[l.src], [x2, y2] = pos[l.dest];
+    el("line", {class: "link drawn", x1: x1, y1: y1, x2: x2, y2: y2});
+  });
+  frame.servers.forEach(s => {
+    const [x, y] = pos[s.id];
+    const state = s.recording.length ? " recording" : s.completed.length ? " completed" : "";
+    el("circle", {class: "server drawn" + state, cx: x, cy: y, r: 24});
+    el("text", {class: "drawn", x: x, y: y + 5, "text-anchor": "middle"}, s.id);
+    el("text", {class: "drawn", x: x, y: y + 42, "text-anchor": "middle"}, s.tokens + " token(s)");
+  });
+  frame.messages.forEach(m => {
+    const [x1, y1] = pos[m.src], [x2, y2] = pos[m.dest];
+    const x = x1 + (x2 - x1) * m.progress, y = y1 + (y2 - y1) * m.progress;
+    el("circle", {class: "message drawn " + m.kind, cx: x, cy: y, r: 6}).appendChild(
+      document.createElementNS(ns, "title")).textContent = m.label;
+  });
+}
+new EventSource("/events").onmessage = e => render(JSON.parse(e.data));
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,104 @@
+package chandy_lamport
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServeVisualizer(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	v, err := sim.ServeVisualizer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	resp, err := http.Get("http://" + v.Addr() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), `new EventSource("/events")`) {
+		t.Fatalf("Expected the page to subscribe to the events\n")
+	}
+
+	resp, err = http.Get("http://" + v.Addr() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	nextFrame := func() visualFrame {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				var frame visualFrame
+				if err := json.Unmarshal([]byte(line[len("data: "):]), &frame); err != nil {
+					t.Fatal(err)
+				}
+				return frame
+			}
+		}
+	}
+	if frame := nextFrame(); frame.Time != 0 || len(frame.Servers) != 3 || len(frame.Links) != 6 {
+		t.Fatalf("Expected the initial frame with 3 servers and 6 links, got %+v\n", frame)
+	}
+
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	sim.StartSnapshot("N1")
+	sim.Tick()
+	frame := nextFrame()
+	if frame.Time != 1 || len(frame.Servers[0].Recording) != 1 {
+		t.Fatalf("Expected N1 to be recording at time 1, got %+v\n", frame)
+	}
+	kinds := make(map[string]bool)
+	for _, m := range frame.Messages {
+		kinds[m.Kind] = true
+		if m.Progress < 0 || m.Progress > 1 {
+			t.Fatalf("Expected the progress of %v to be between 0 and 1\n", m)
+		}
+	}
+	if !kinds["marker"] {
+		t.Fatalf("Expected markers in flight, got %v\n", frame.Messages)
+	}
+}
+
+func TestVisualizerProgress(t *testing.T) {
+	sim := NewSimulatorWithSeed(8053172852482175524)
+	readTopology("3nodes.top", sim)
+	mustGetLink(sim, "N1", "N2").SetLatencyModel(NewConstantLatency(8))
+	sim.InjectEvent(PassTokenEvent{"N1", "N2", 3})
+	for i := 0; i < 2; i++ {
+		sim.Tick()
+	}
+	frame := sim.visualFrame()
+	if len(frame.Messages) != 1 || frame.Messages[0].Progress != 0.25 {
+		t.Fatalf("Expected the token to be a quarter of the way after 2 of 8 ticks, got %+v\n",
+			frame.Messages)
+	}
+
+	v, err := sim.ServeVisualizer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+	if sim.visualizer != nil {
+		t.Fatalf("Expected the simulation to stop rendering frames once closed\n")
+	}
+	v, err = sim.ServeVisualizer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+}
@@ -0,0 +1,213 @@
+package chandy_lamport
+
+import "math/rand"
+
+// A source of token traffic, which the simulator asks at every tick for the
+// token transfers to inject, see `SetWorkload`. Built-in workloads draw their
+// decisions from the source of randomness of the simulator, so runs are
+// deterministic for a given seed.
+type Workload interface {
+	Transfers(sim *Simulator) []Transfer
+}
+
+// Tokens sent by a workload from a server to one of its neighbors
+type Transfer struct {
+	Src    string
+	Dest   string
+	Tokens int
+}
+
+// Drive token traffic with the given workload, which is asked for transfers at
+// the end of every tick. Transfers that cannot be injected, e.g. because the
+// sender does not hold enough tokens or has crashed, are skipped. A nil
+// workload stops the traffic, which `Drain` would otherwise wait for forever.
+func (sim *Simulator) SetWorkload(workload Workload) {
+	sim.workload = workload
+}
+
+// Inject the transfers of the workload for this tick
+func (sim *Simulator) runWorkload() {
+	if sim.workload == nil {
+		return
+	}
+	for _, transfer := range sim.workload.Transfers(sim) {
+		event := PassTokenEvent{transfer.Src, transfer.Dest, transfer.Tokens}
+		if err := sim.InjectEvent(event); err != nil {
+			sim.slogger.Debug("Skipped transfer of the workload",
+				"time", sim.time, "src", transfer.Src, "dest", transfer.Dest, "tokens", transfer.Tokens, "err", err)
+		}
+	}
+}
+
+// The tokens each server that has not crashed can send during a tick, by server
+// ID, for the servers with tokens and links to send them on
+func (sim *Simulator) workloadBalances() map[string]int {
+	balances := make(map[string]int)
+	for _, serverId := range sim.aliveServerIds() {
+		server := sim.servers[serverId]
+		if server.Tokens > 0 && len(server.outboundLinks) > 0 {
+			balances[serverId] = server.Tokens
+		}
+	}
+	return balances
+}
+
+// Return the number of transfers of a tick for an average rate: the integer
+// part of the rate, plus one with the probability of its fractional part
+func transfersPerTick(rate float64, random *rand.Rand) int {
+	n := int(rate)
+	if random.Float64() < rate-float64(n) {
+		n++
+	}
+	return n
+}
+
+// Draw a transfer of up to maxTokens tokens from the server to one of its
+// neighbors, and take the tokens off its balance
+func (sim *Simulator) drawTransfer(src string, maxTokens int, balances map[string]int) Transfer {
+	neighbors := sim.servers[src].neighborIds()
+	return sim.drawTransferTo(src, neighbors[sim.random.Intn(len(neighbors))], maxTokens, balances)
+}
+
+// Draw a transfer of up to maxTokens tokens from the server to the given
+// neighbor, and take the tokens off its balance
+func (sim *Simulator) drawTransferTo(src, dest string, maxTokens int, balances map[string]int) Transfer {
+	if balances[src] < maxTokens {
+		maxTokens = balances[src]
+	}
+	tokens := 1 + sim.random.Intn(maxTokens)
+	balances[src] -= tokens
+	if balances[src] == 0 {
+		delete(balances, src)
+	}
+	return Transfer{src, dest, tokens}
+}
+
+// Transfers between random neighbors: every tick, `rate` transfers on average,
+// each from a server drawn uniformly among the ones holding tokens to one of
+// its neighbors, of between 1 and maxTokens tokens
+type UniformWorkload struct {
+	rate      float64
+	maxTokens int
+}
+
+func NewUniformWorkload(rate float64, maxTokens int) UniformWorkload {
+	if maxTokens < 1 {
+		maxTokens = 1
+	}
+	return UniformWorkload{rate, maxTokens}
+}
+
+func (w UniformWorkload) Transfers(sim *Simulator) []Transfer {
+	balances := sim.workloadBalances()
+	transfers := make([]Transfer, 0)
+	for n := transfersPerTick(w.rate, sim.random); n > 0 && len(balances) > 0; n-- {
+		senders := getSortedKeys(balances)
+		src := senders[sim.random.Intn(len(senders))]
+		transfers = append(transfers, sim.drawTransfer(src, w.maxTokens, balances))
+	}
+	return transfers
+}
+
+// Transfers like `UniformWorkload`, except that each transfer involves the
+// hotspot server with the given probability: one of the servers linked to the
+// hotspot sends it tokens, or the hotspot sends tokens to a neighbor once none
+// of these has tokens left. The links of the hotspot carry most of the traffic.
+type HotspotWorkload struct {
+	uniform UniformWorkload
+	hotspot string
+	bias    float64
+}
+
+func NewHotspotWorkload(hotspot string, bias, rate float64, maxTokens int) HotspotWorkload {
+	return HotspotWorkload{NewUniformWorkload(rate, maxTokens), hotspot, bias}
+}
+
+func (w HotspotWorkload) Transfers(sim *Simulator) []Transfer {
+	hotspot, ok := sim.servers[w.hotspot]
+	if !ok {
+		return w.uniform.Transfers(sim)
+	}
+	balances := sim.workloadBalances()
+	transfers := make([]Transfer, 0)
+	for n := transfersPerTick(w.uniform.rate, sim.random); n > 0 && len(balances) > 0; n-- {
+		if sim.random.Float64() >= w.bias {
+			senders := getSortedKeys(balances)
+			src := senders[sim.random.Intn(len(senders))]
+			transfers = append(transfers, sim.drawTransfer(src, w.uniform.maxTokens, balances))
+			continue
+		}
+		feeders := make([]string, 0)
+		for _, src := range getSortedKeys(hotspot.inboundLinks) {
+			if balances[src] > 0 {
+				feeders = append(feeders, src)
+			}
+		}
+		if len(feeders) > 0 {
+			src := feeders[sim.random.Intn(len(feeders))]
+			transfers = append(transfers, sim.drawTransferTo(src, w.hotspot, w.uniform.maxTokens, balances))
+		} else if balances[w.hotspot] > 0 {
+			transfers = append(transfers, sim.drawTransfer(w.hotspot, w.uniform.maxTokens, balances))
+		}
+	}
+	return transfers
+}
+
+// Tokens circulating around the network: every tick, each server that holds
+// tokens passes up to the given number of them to its successor, the neighbor
+// with the next ID after its own, or with the lowest ID if there is none. On a
+// ring numbered in order, the tokens go round the ring.
+type RingWorkload struct {
+	tokens int
+}
+
+func NewRingWorkload(tokens int) RingWorkload {
+	if tokens < 1 {
+		tokens = 1
+	}
+	return RingWorkload{tokens}
+}
+
+func (w RingWorkload) Transfers(sim *Simulator) []Transfer {
+	balances := sim.workloadBalances()
+	transfers := make([]Transfer, 0)
+	for _, src := range getSortedKeys(balances) {
+		neighbors := sim.servers[src].neighborIds()
+		dest := neighbors[0]
+		for _, neighbor := range neighbors {
+			if neighbor > src {
+				dest = neighbor
+				break
+			}
+		}
+		tokens := w.tokens
+		if balances[src] < tokens {
+			tokens = balances[src]
+		}
+		transfers = append(transfers, Transfer{src, dest, tokens})
+	}
+	return transfers
+}
+
+// The traffic of another workload in bursts: the workload only runs during the
+// first `burst` ticks of every period of `period` ticks, and the network is
+// quiet for the rest of the period. Combined with a high rate, bursts fill the
+// links while snapshots are in progress.
+type BurstyWorkload struct {
+	workload      Workload
+	period, burst int
+}
+
+func NewBurstyWorkload(workload Workload, period, burst int) BurstyWorkload {
+	if period < 1 {
+		period = 1
+	}
+	return BurstyWorkload{workload, period, burst}
+}
+
+func (w BurstyWorkload) Transfers(sim *Simulator) []Transfer {
+	if (sim.time-1)%w.period >= w.burst {
+		return nil
+	}
+	return w.workload.Transfers(sim)
+}
@@ -0,0 +1,131 @@
+package chandy_lamport
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Return the token messages delivered so far, as the channels they were delivered on
+func deliveredTokens(sim *Simulator) []ChannelId {
+	channels := make([]ChannelId, 0)
+	for _, events := range sim.logger.events {
+		for _, event := range events {
+			if received, ok := event.event.(ReceivedMessageEvent); ok {
+				if _, isToken := tokenMessage(received.message); isToken {
+					channels = append(channels, ChannelId{received.src, received.dest})
+				}
+			}
+		}
+	}
+	return channels
+}
+
+func TestUniformWorkload(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	sim.EnableInvariantChecks()
+	sim.SetWorkload(NewUniformWorkload(1.5, 3))
+	for i := 0; i < 10; i++ {
+		sim.Tick()
+	}
+	sim.StartSnapshot("N6")
+	for i := 0; i < 20; i++ {
+		sim.Tick()
+	}
+	sim.SetWorkload(nil)
+	sim.Drain()
+	if sent := len(deliveredTokens(sim)); sent < 30 {
+		t.Fatalf("Expected 1.5 transfers per tick, got %v in 30 ticks\n", sent)
+	}
+	sim.CollectSnapshot(0)
+	if err := sim.ValidateSnapshot(0, sim.InitialTokens()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHotspotWorkload(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	sim.SetWorkload(NewHotspotWorkload("N4", 1, 2, 5))
+	for i := 0; i < 30; i++ {
+		sim.Tick()
+	}
+	sim.SetWorkload(nil)
+	sim.Drain()
+	channels := deliveredTokens(sim)
+	if len(channels) == 0 {
+		t.Fatalf("Expected the workload to send tokens\n")
+	}
+	for _, channel := range channels {
+		if channel.src != "N4" && channel.dest != "N4" {
+			t.Fatalf("Expected every transfer to involve the hotspot, got %v\n", channel)
+		}
+	}
+	if total := sim.TotalTokens(); total != sim.InitialTokens() {
+		t.Fatalf("Expected %v tokens after the workload, got %v\n", sim.InitialTokens(), total)
+	}
+}
+
+func TestRingWorkload(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("8nodes.top", sim)
+	transfers := NewRingWorkload(2).Transfers(sim)
+	expected := []Transfer{{"N1", "N2", 2}, {"N2", "N3", 2}, {"N3", "N4", 2}, {"N4", "N5", 2}}
+	if !reflect.DeepEqual(transfers, expected) {
+		t.Fatalf("Expected the tokens to be passed to the successors, got %v\n", transfers)
+	}
+	sim.SetWorkload(NewRingWorkload(2))
+	for i := 0; i < 40; i++ {
+		sim.Tick()
+	}
+	successors := map[ChannelId]bool{
+		{"N1", "N2"}: true, {"N2", "N3"}: true, {"N3", "N4"}: true, {"N4", "N5"}: true,
+		{"N5", "N6"}: true, {"N6", "N7"}: true, {"N7", "N8"}: true, {"N8", "N5"}: true,
+	}
+	aroundSquare := false
+	for _, channel := range deliveredTokens(sim) {
+		if !successors[channel] {
+			t.Fatalf("Expected the tokens to be passed to the successors only, got %v\n", channel)
+		}
+		aroundSquare = aroundSquare || channel == ChannelId{"N8", "N5"}
+	}
+	if !aroundSquare {
+		t.Fatalf("Expected the tokens to circulate around the square\n")
+	}
+}
+
+// A workload that records the time steps it is asked for transfers at
+type recordingWorkload struct {
+	ticks *[]int
+}
+
+func (w recordingWorkload) Transfers(sim *Simulator) []Transfer {
+	*w.ticks = append(*w.ticks, sim.time)
+	return nil
+}
+
+func TestBurstyWorkload(t *testing.T) {
+	rand.Seed(8053172852482175524)
+	sim := NewSimulator()
+	readTopology("3nodes.top", sim)
+	ticks := make([]int, 0)
+	sim.SetWorkload(NewBurstyWorkload(recordingWorkload{&ticks}, 5, 2))
+	for i := 0; i < 12; i++ {
+		sim.Tick()
+	}
+	if expected := []int{1, 2, 6, 7, 11, 12}; !reflect.DeepEqual(ticks, expected) {
+		t.Fatalf("Expected the workload to run at %v, got %v\n", expected, ticks)
+	}
+	var buf bytes.Buffer
+	sim = NewSimulatorWithSeed(1)
+	readTopology("3nodes.top", sim)
+	sim.SetWorkload(NewRingWorkload(1))
+	if err := sim.Save(&buf); err == nil {
+		t.Fatalf("Expected the workload not to be saved\n")
+	}
+}